@@ -7,6 +7,7 @@ require (
 	github.com/fyne-io/terminal v0.0.0-20250805210206-f3224d514e14
 	github.com/ispapp/psshclient/pkg/codeditor v0.0.0-20250901234925-0775ca92d2d4
 	github.com/mattn/go-sqlite3 v1.14.32
+	github.com/pkg/sftp v1.13.10
 	golang.org/x/crypto v0.41.0
 )
 
@@ -34,6 +35,7 @@ require (
 	github.com/hack-pad/safejs v0.1.1 // indirect
 	github.com/jeandeaual/go-locale v0.0.0-20250612000132-0ef82f21eade // indirect
 	github.com/jsummers/gobmp v0.0.0-20230614200233-a9de23ed2e25 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646 // indirect
 	github.com/nicksnyder/go-i18n/v2 v2.6.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
@@ -156,15 +156,20 @@ func UpdateDeviceCredentials(index int, username, password string) {
 
 // UpdateDeviceConnection updates device connection status both in memory and database
 func UpdateDeviceConnection(index int, connected bool) {
+	status := "Disconnected"
+	if connected {
+		status = "Connected"
+	}
+	UpdateDeviceConnectionStatus(index, connected, status)
+}
+
+// UpdateDeviceConnectionStatus updates device connection status with a custom status text
+func UpdateDeviceConnectionStatus(index int, connected bool, status string) {
 	if index >= 0 && index < DeviceList.Length() {
 		if deviceObj, err := DeviceList.GetValue(index); err == nil {
 			if device, ok := deviceObj.(scanner.Device); ok {
 				device.Connected = connected
-				if connected {
-					device.Status = "Connected"
-				} else {
-					device.Status = "Disconnected"
-				}
+				device.Status = status
 				DeviceList.SetValue(index, device)
 
 				// Update in database if available
@@ -178,6 +183,25 @@ func UpdateDeviceConnection(index int, connected bool) {
 	}
 }
 
+// UpdateDeviceHostKey updates the stored host key fingerprint both in memory and database
+func UpdateDeviceHostKey(index int, fingerprint string) {
+	if index >= 0 && index < DeviceList.Length() {
+		if deviceObj, err := DeviceList.GetValue(index); err == nil {
+			if device, ok := deviceObj.(scanner.Device); ok {
+				device.HostKey = fingerprint
+				DeviceList.SetValue(index, device)
+
+				// Update in database if available
+				if DB != nil {
+					if err := DB.UpdateDeviceHostKey(device.IP, fingerprint); err != nil {
+						log.Printf("Failed to update host key for %s in database: %v", device.IP, err)
+					}
+				}
+			}
+		}
+	}
+}
+
 // SaveDevicesToDB saves all current devices to database
 func SaveDevicesToDB() {
 	if DB == nil {
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ispapp/psshclient/internal/database"
+	"github.com/ispapp/psshclient/internal/settings"
+	"github.com/ispapp/psshclient/pkg/pssh"
+)
+
+// StartRecording records a terminal session when session recording is on in the
+// settings, indexing it in the database. It is installed as pssh.StartRecording.
+func StartRecording(conn *pssh.SSHConnection, cols, rows int) *pssh.Recording {
+	if settings.Current == nil || !settings.Current.RecordSessions {
+		return nil
+	}
+
+	host := conn.Config.Host
+	title := fmt.Sprintf("%s (%s)", host, conn.Config.Username)
+	name := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(host)
+	path := filepath.Join(settings.Current.RecordingsDir,
+		fmt.Sprintf("%s_%s.cast", name, time.Now().Format("20060102-150405.000")))
+
+	recording, err := pssh.CreateRecording(path, host, title, cols, rows)
+	if err != nil {
+		log.Printf("Failed to record session on %s: %v", host, err)
+		return nil
+	}
+
+	if DB == nil {
+		return recording
+	}
+	id, err := DB.AddRecording(database.SessionRecording{
+		DeviceIP:  host,
+		Title:     title,
+		Path:      path,
+		StartedAt: recording.Started,
+	})
+	if err != nil {
+		log.Printf("Failed to index recording %s: %v", path, err)
+		return recording
+	}
+	recording.OnClose = func(r *pssh.Recording) {
+		if err := DB.FinishRecording(id, r.Duration()); err != nil {
+			log.Printf("Failed to update recording %s: %v", r.Path, err)
+		}
+	}
+	return recording
+}
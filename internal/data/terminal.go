@@ -0,0 +1,34 @@
+package data
+
+import (
+	"github.com/ispapp/psshclient/internal/settings"
+	"github.com/ispapp/psshclient/pkg/pssh"
+)
+
+// TerminalOptions returns the terminal options of the settings, the defaults of
+// devices without their own. It is installed with pssh.SetDefaultTerminalOptions.
+func TerminalOptions() (pssh.TerminalOptions, error) {
+	options := pssh.DefaultTerminalOptions()
+	if settings.Current == nil {
+		return options, nil
+	}
+
+	modes, err := pssh.ParseTerminalModes(settings.Current.TerminalModes)
+	if err != nil {
+		return options, err
+	}
+	env, err := pssh.ParseTerminalEnv(settings.Current.TerminalEnv)
+	if err != nil {
+		return options, err
+	}
+	return pssh.TerminalOptions{
+		Type:     settings.Current.TerminalType,
+		Rows:     settings.Current.TerminalRows,
+		Cols:     settings.Current.TerminalCols,
+		Modes:    modes,
+		Env:      env,
+		Font:     settings.Current.TerminalFont,
+		FontSize: float32(settings.Current.TerminalFontSize),
+		Theme:    settings.Current.TerminalTheme,
+	}, nil
+}
@@ -112,8 +112,8 @@ func (db *DB) initSchema() error {
 // SaveDevice saves or updates a device in the database
 func (db *DB) SaveDevice(device scanner.Device) error {
 	query := `
-	INSERT INTO devices (ip, hostname, port22, port23, ssh_port, status, username, password, connected, last_seen, updated_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	INSERT INTO devices (ip, hostname, port22, port23, ssh_port, status, username, password, connected, host_key, key_path, jump_host, transport, terminal_options, become, become_password, last_seen, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	ON CONFLICT(ip) DO UPDATE SET
 		hostname = excluded.hostname,
 		port22 = excluded.port22,
@@ -123,12 +123,19 @@ func (db *DB) SaveDevice(device scanner.Device) error {
 		username = excluded.username,
 		password = excluded.password,
 		connected = excluded.connected,
+		host_key = excluded.host_key,
+		key_path = excluded.key_path,
+		jump_host = excluded.jump_host,
+		transport = excluded.transport,
+		terminal_options = excluded.terminal_options,
+		become = excluded.become,
+		become_password = excluded.become_password,
 		last_seen = CURRENT_TIMESTAMP,
 		updated_at = CURRENT_TIMESTAMP
 	`
 
 	_, err := db.conn.Exec(query, device.IP, device.Hostname, device.SSHStatus, device.TELNETStatus, device.SSHPort,
-		device.Status, device.Username, device.Password, device.Connected)
+		device.Status, device.Username, device.Password, device.Connected, device.HostKey, device.KeyPath, device.JumpHost, device.Transport, device.TerminalOptions, device.Become, device.BecomePassword)
 	return err
 }
 
@@ -141,8 +148,8 @@ func (db *DB) SaveDevices(devices []scanner.Device) error {
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`
-	INSERT INTO devices (ip, hostname, port22, port23, ssh_port, status, username, password, connected, last_seen, updated_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	INSERT INTO devices (ip, hostname, port22, port23, ssh_port, status, username, password, connected, host_key, key_path, jump_host, transport, terminal_options, become, become_password, last_seen, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	ON CONFLICT(ip) DO UPDATE SET
 		hostname = excluded.hostname,
 		port22 = excluded.port22,
@@ -152,6 +159,13 @@ func (db *DB) SaveDevices(devices []scanner.Device) error {
 		username = excluded.username,
 		password = excluded.password,
 		connected = excluded.connected,
+		host_key = excluded.host_key,
+		key_path = excluded.key_path,
+		jump_host = excluded.jump_host,
+		transport = excluded.transport,
+		terminal_options = excluded.terminal_options,
+		become = excluded.become,
+		become_password = excluded.become_password,
 		last_seen = CURRENT_TIMESTAMP,
 		updated_at = CURRENT_TIMESTAMP
 	`)
@@ -162,7 +176,7 @@ func (db *DB) SaveDevices(devices []scanner.Device) error {
 
 	for _, device := range devices {
 		_, err := stmt.Exec(device.IP, device.Hostname, device.SSHStatus, device.TELNETStatus, device.SSHPort,
-			device.Status, device.Username, device.Password, device.Connected)
+			device.Status, device.Username, device.Password, device.Connected, device.HostKey, device.KeyPath, device.JumpHost, device.Transport, device.TerminalOptions, device.Become, device.BecomePassword)
 		if err != nil {
 			return fmt.Errorf("failed to save device %s: %v", device.IP, err)
 		}
@@ -174,7 +188,7 @@ func (db *DB) SaveDevices(devices []scanner.Device) error {
 // LoadDevices loads all devices from the database
 func (db *DB) LoadDevices() ([]scanner.Device, error) {
 	query := `
-	SELECT ip, hostname, port22, port23, ssh_port, status, username, password, connected
+	SELECT ip, hostname, port22, port23, ssh_port, status, username, password, connected, host_key, key_path, jump_host, transport, terminal_options, become, become_password
 	FROM devices
 	ORDER BY last_seen DESC, ip ASC
 	`
@@ -189,7 +203,7 @@ func (db *DB) LoadDevices() ([]scanner.Device, error) {
 	for rows.Next() {
 		var device scanner.Device
 		err := rows.Scan(&device.IP, &device.Hostname, &device.SSHStatus, &device.TELNETStatus, &device.SSHPort,
-			&device.Status, &device.Username, &device.Password, &device.Connected)
+			&device.Status, &device.Username, &device.Password, &device.Connected, &device.HostKey, &device.KeyPath, &device.JumpHost, &device.Transport, &device.TerminalOptions, &device.Become, &device.BecomePassword)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan device row: %v", err)
 		}
@@ -206,7 +220,7 @@ func (db *DB) LoadDevices() ([]scanner.Device, error) {
 // LoadRecentDevices loads devices seen within the last specified duration
 func (db *DB) LoadRecentDevices(since time.Duration) ([]scanner.Device, error) {
 	query := `
-	SELECT ip, hostname, port22, port23, ssh_port, status, username, password, connected
+	SELECT ip, hostname, port22, port23, ssh_port, status, username, password, connected, host_key, key_path, jump_host, transport, terminal_options, become, become_password
 	FROM devices
 	WHERE last_seen > datetime('now', '-' || ? || ' seconds')
 	ORDER BY last_seen DESC, ip ASC
@@ -222,7 +236,7 @@ func (db *DB) LoadRecentDevices(since time.Duration) ([]scanner.Device, error) {
 	for rows.Next() {
 		var device scanner.Device
 		err := rows.Scan(&device.IP, &device.Hostname, &device.SSHStatus, &device.TELNETStatus, &device.SSHPort,
-			&device.Status, &device.Username, &device.Password, &device.Connected)
+			&device.Status, &device.Username, &device.Password, &device.Connected, &device.HostKey, &device.KeyPath, &device.JumpHost, &device.Transport, &device.TerminalOptions, &device.Become, &device.BecomePassword)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan device row: %v", err)
 		}
@@ -385,6 +399,110 @@ func (db *DB) UpdateDeviceCredentials(ip, username, password string) error {
 	return nil
 }
 
+// UpdateDeviceHostKey updates the stored SSH host key fingerprint for a device
+func (db *DB) UpdateDeviceHostKey(ip, fingerprint string) error {
+	query := `
+	UPDATE devices 
+	SET host_key = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE ip = ?
+	`
+
+	result, err := db.conn.Exec(query, fingerprint, ip)
+	if err != nil {
+		return fmt.Errorf("failed to update device host key: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("device with IP %s not found", ip)
+	}
+
+	return nil
+}
+
+// SessionRecording is the index entry of a recorded terminal session
+type SessionRecording struct {
+	ID        int64
+	DeviceIP  string
+	Title     string
+	Path      string // asciicast v2 file
+	StartedAt time.Time
+	Duration  time.Duration // Zero while the session is still running
+}
+
+// AddRecording indexes a recording and returns its ID
+func (db *DB) AddRecording(recording SessionRecording) (int64, error) {
+	query := `
+	INSERT INTO recordings (device_ip, title, path, started_at, duration_ms)
+	VALUES (?, ?, ?, ?, ?)
+	`
+
+	result, err := db.conn.Exec(query, recording.DeviceIP, recording.Title, recording.Path,
+		recording.StartedAt, recording.Duration.Milliseconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to add recording: %v", err)
+	}
+	return result.LastInsertId()
+}
+
+// FinishRecording stores the duration of a recording once its session ended
+func (db *DB) FinishRecording(id int64, duration time.Duration) error {
+	_, err := db.conn.Exec("UPDATE recordings SET duration_ms = ? WHERE id = ?", duration.Milliseconds(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update recording: %v", err)
+	}
+	return nil
+}
+
+// LoadRecordings returns the recordings of a device, or of all devices when
+// deviceIP is empty, newest first
+func (db *DB) LoadRecordings(deviceIP string) ([]SessionRecording, error) {
+	query := `
+	SELECT id, device_ip, title, path, started_at, duration_ms
+	FROM recordings
+	WHERE ? = '' OR device_ip = ?
+	ORDER BY started_at DESC
+	`
+
+	rows, err := db.conn.Query(query, deviceIP, deviceIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recordings: %v", err)
+	}
+	defer rows.Close()
+
+	var recordings []SessionRecording
+	for rows.Next() {
+		var recording SessionRecording
+		var durationMs int64
+		err := rows.Scan(&recording.ID, &recording.DeviceIP, &recording.Title, &recording.Path,
+			&recording.StartedAt, &durationMs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan recording row: %v", err)
+		}
+		recording.Duration = time.Duration(durationMs) * time.Millisecond
+		recordings = append(recordings, recording)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recording rows: %v", err)
+	}
+
+	return recordings, nil
+}
+
+// DeleteRecording removes a recording from the index, the file is left to the caller
+func (db *DB) DeleteRecording(id int64) error {
+	_, err := db.conn.Exec("DELETE FROM recordings WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete recording: %v", err)
+	}
+	return nil
+}
+
 // migrationVersion returns the current database schema version
 func (db *DB) migrationVersion() (int, error) {
 	var version int
@@ -395,10 +513,10 @@ func (db *DB) migrationVersion() (int, error) {
 	return version, nil
 }
 
-// setMigrationVersion sets the database schema version
-func (db *DB) setMigrationVersion(version int) error {
+// setMigrationVersion sets the database schema version within a migration
+func setMigrationVersion(tx *sql.Tx, version int) error {
 	query := fmt.Sprintf("PRAGMA user_version = %d", version)
-	_, err := db.conn.Exec(query)
+	_, err := tx.Exec(query)
 	if err != nil {
 		return fmt.Errorf("failed to set database version: %v", err)
 	}
@@ -413,7 +531,7 @@ func (db *DB) runMigrations() error {
 	}
 
 	// Current target version
-	targetVersion := 1
+	targetVersion := 8
 
 	if currentVersion >= targetVersion {
 		return nil // No migration needed
@@ -425,18 +543,60 @@ func (db *DB) runMigrations() error {
 	migrations := []string{
 		// Version 1: Initial schema (already created in initSchema)
 		"",
+		// Version 2: SSH host key fingerprint per device
+		"ALTER TABLE devices ADD COLUMN host_key TEXT NOT NULL DEFAULT ''",
+		// Version 3: Private key file per device
+		"ALTER TABLE devices ADD COLUMN key_path TEXT NOT NULL DEFAULT ''",
+		// Version 4: Jump host chain (ProxyJump) per device
+		"ALTER TABLE devices ADD COLUMN jump_host TEXT NOT NULL DEFAULT ''",
+		// Version 5: Transport per device (RouterOS API)
+		"ALTER TABLE devices ADD COLUMN transport TEXT NOT NULL DEFAULT ''",
+		// Version 6: Index of recorded terminal sessions
+		`CREATE TABLE IF NOT EXISTS recordings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_ip TEXT NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			path TEXT NOT NULL,
+			started_at DATETIME NOT NULL,
+			duration_ms INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_recordings_device ON recordings(device_ip, started_at)`,
+		// Version 7: Terminal options per device (pty and font overrides)
+		"ALTER TABLE devices ADD COLUMN terminal_options TEXT NOT NULL DEFAULT ''",
+		// Version 8: Privilege escalation per device, stored with the credentials
+		`ALTER TABLE devices ADD COLUMN become TEXT NOT NULL DEFAULT '';
+		ALTER TABLE devices ADD COLUMN become_password TEXT NOT NULL DEFAULT ''`,
 	}
 
 	for i := currentVersion; i < targetVersion; i++ {
-		if i < len(migrations) && migrations[i] != "" {
-			fmt.Printf("Running migration %d\n", i+1)
-			_, err := db.conn.Exec(migrations[i])
-			if err != nil {
-				return fmt.Errorf("migration %d failed: %v", i+1, err)
-			}
+		migration := ""
+		if i < len(migrations) {
+			migration = migrations[i]
+		}
+		if err := db.migrate(i+1, migration); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// Update version
-	return db.setMigrationVersion(targetVersion)
+// migrate runs the migration to version and records the version in one
+// transaction, so a failed migration leaves the database as it was before
+func (db *DB) migrate(version int, migration string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %v", version, err)
+	}
+	defer tx.Rollback()
+
+	if migration != "" {
+		fmt.Printf("Running migration %d\n", version)
+		if _, err := tx.Exec(migration); err != nil {
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+	}
+	if err := setMigrationVersion(tx, version); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
@@ -44,12 +44,17 @@ func ShowCSVExportDialog(parent fyne.Window) {
 
 		// Write device data
 		for _, device := range devices {
+			// An unset port stays empty, so importing the file keeps it unset
+			sshPort := ""
+			if device.SSHPort != 0 {
+				sshPort = strconv.Itoa(device.SSHPort)
+			}
 			record := []string{
 				device.IP,
 				device.Hostname,
 				device.Username,
 				device.Password,
-				strconv.Itoa(device.SSHPort),
+				sshPort,
 				device.Status,
 			}
 			if err := csvWriter.Write(record); err != nil {
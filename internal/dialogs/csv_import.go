@@ -113,7 +113,11 @@ func ShowCSVImportDialog(parent fyne.Window) {
 					case 1: // IP
 						label.SetText(device.Device.IP)
 					case 2: // Username
-						label.SetText(device.Device.Username)
+						if device.Device.Username == "" && settings.Current != nil {
+							label.SetText(settings.Current.DefaultSSHUsername)
+						} else {
+							label.SetText(device.Device.Username)
+						}
 					case 3: // Port
 						label.SetText(device.OriginalPort)
 					case 4: // Service
@@ -257,19 +261,20 @@ func validateCSVRecord(record []string, rowNum int) CSVDevice {
 	username := strings.TrimSpace(record[1])
 	password := strings.TrimSpace(record[2])
 
-	var port int = 22 // Default SSH port
+	var port int // Left unset, ~/.ssh/config or the default SSH port applies
+	var portSet bool
 	var service string = "ssh"
 	var originalPort string = "22"
 
-	// Use settings default port if available
+	// Show the settings default port for rows without one
 	if settings.Current != nil {
-		port = settings.Current.DefaultSSHPort
 		originalPort = settings.Current.GetDefaultSSHPortString()
 	}
 
 	// Parse optional port field
 	if len(record) > 3 && strings.TrimSpace(record[3]) != "" {
 		originalPort = strings.TrimSpace(record[3])
+		portSet = true
 		if p, err := strconv.Atoi(originalPort); err == nil {
 			port = p
 		}
@@ -303,15 +308,11 @@ func validateCSVRecord(record []string, rowNum int) CSVDevice {
 		}
 	}
 
-	// Validate username (use default if empty)
-	if username == "" {
-		if settings.Current != nil && settings.Current.DefaultSSHUsername != "" {
-			username = settings.Current.DefaultSSHUsername
-		} else {
-			csvDevice.Valid = false
-			csvDevice.Error = "Empty username and no default set"
-			return csvDevice
-		}
+	// Validate username, left empty the default username applies when connecting
+	if username == "" && (settings.Current == nil || settings.Current.DefaultSSHUsername == "") {
+		csvDevice.Valid = false
+		csvDevice.Error = "Empty username and no default set"
+		return csvDevice
 	}
 
 	// Validate password (use default if empty)
@@ -326,7 +327,7 @@ func validateCSVRecord(record []string, rowNum int) CSVDevice {
 	}
 
 	// Validate port
-	if port <= 0 || port > 65535 {
+	if portSet && (port <= 0 || port > 65535) {
 		csvDevice.Valid = false
 		csvDevice.Error = fmt.Sprintf("Invalid port number: %d", port)
 		return csvDevice
@@ -0,0 +1,395 @@
+package dialogs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/ispapp/psshclient/internal/windows"
+	"github.com/ispapp/psshclient/pkg/pssh"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// fileBrowser is the state of an SFTP file browser window
+type fileBrowser struct {
+	client    *pssh.SFTPClient
+	window    fyne.Window
+	dir       string
+	files     []pssh.RemoteFile
+	selected  int
+	list      *widget.List
+	pathEntry *widget.Entry
+	status    *widget.Label
+	transfers *fyne.Container
+	cancels   map[*fileTransfer]context.CancelFunc
+	mutex     sync.Mutex
+}
+
+// fileTransfer is one upload or download shown in the transfers list
+type fileTransfer struct {
+	upload     bool
+	localPath  string
+	remotePath string
+	progress   *widget.ProgressBar
+	status     *widget.Label
+	action     *widget.Button
+}
+
+// ShowFileBrowser opens an SFTP file browser for a connected device. Files can be
+// uploaded with the Upload button or by dropping them on the window.
+func ShowFileBrowser(conn *pssh.SSHConnection, parent fyne.Window) {
+	go func() {
+		client, err := conn.OpenSFTP()
+		if err != nil {
+			fyne.Do(func() {
+				dialog.ShowError(fmt.Errorf("%s: %v", conn.Config.Host, err), parent)
+			})
+			return
+		}
+		dir, err := client.Getwd()
+		if err != nil {
+			dir = "/"
+		}
+
+		fyne.Do(func() {
+			info, err := windows.WinManager.NewWindow("Files - "+conn.Config.Host, "sftp")
+			if err != nil {
+				fmt.Printf("Failed to create window: %v\n", err)
+				client.Close()
+				return
+			}
+
+			browser := &fileBrowser{
+				client:   client,
+				window:   info.Window,
+				selected: -1,
+				cancels:  make(map[*fileTransfer]context.CancelFunc),
+			}
+			browser.window.SetContent(browser.build())
+			browser.window.SetOnDropped(func(_ fyne.Position, uris []fyne.URI) {
+				for _, uri := range uris {
+					browser.upload(uri.Path())
+				}
+			})
+			browser.window.SetOnClosed(browser.close)
+			browser.window.Resize(fyne.NewSize(800, 600))
+			browser.window.Show()
+			browser.open(dir)
+		})
+	}()
+}
+
+// build creates the window content
+func (b *fileBrowser) build() fyne.CanvasObject {
+	b.pathEntry = widget.NewEntry()
+	b.pathEntry.OnSubmitted = b.open
+	b.status = widget.NewLabel("")
+
+	b.list = widget.NewList(
+		func() int {
+			return len(b.files)
+		},
+		func() fyne.CanvasObject {
+			return container.NewBorder(nil, nil, widget.NewIcon(theme.FileIcon()), widget.NewLabel(""), widget.NewLabel(""))
+		},
+		func(id widget.ListItemID, object fyne.CanvasObject) {
+			if id >= len(b.files) {
+				return
+			}
+			file := b.files[id]
+			row := object.(*fyne.Container)
+			name := row.Objects[0].(*widget.Label)
+			icon := row.Objects[1].(*widget.Icon)
+			details := row.Objects[2].(*widget.Label)
+
+			name.SetText(file.Name)
+			if file.IsDir {
+				icon.SetResource(theme.FolderIcon())
+				details.SetText(file.ModTime.Format("2006-01-02 15:04"))
+			} else {
+				icon.SetResource(theme.FileIcon())
+				details.SetText(formatSize(file.Size) + "  " + file.ModTime.Format("2006-01-02 15:04"))
+			}
+		},
+	)
+	b.list.OnSelected = func(id widget.ListItemID) {
+		b.selected = id
+	}
+
+	upBtn := widget.NewButtonWithIcon("", theme.MoveUpIcon(), func() {
+		b.open(path.Dir(b.dir))
+	})
+	refreshBtn := widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), func() {
+		b.open(b.dir)
+	})
+	openBtn := widget.NewButtonWithIcon("Open", theme.FolderOpenIcon(), func() {
+		if file, ok := b.selection(); ok && file.IsDir {
+			b.open(file.Path)
+		}
+	})
+	newFolderBtn := widget.NewButtonWithIcon("New Folder", theme.FolderNewIcon(), b.showNewFolderDialog)
+	uploadBtn := widget.NewButtonWithIcon("Upload", theme.UploadIcon(), func() {
+		fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, b.window)
+				return
+			}
+			if reader == nil {
+				return // User cancelled
+			}
+			reader.Close()
+			b.upload(reader.URI().Path())
+		}, b.window)
+		fileDialog.Show()
+	})
+	downloadBtn := widget.NewButtonWithIcon("Download", theme.DownloadIcon(), func() {
+		file, ok := b.selection()
+		if !ok || file.IsDir {
+			return
+		}
+		fileDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, b.window)
+				return
+			}
+			if writer == nil {
+				return // User cancelled
+			}
+			writer.Close()
+			b.startTransfer(&fileTransfer{localPath: writer.URI().Path(), remotePath: file.Path}, false)
+		}, b.window)
+		fileDialog.SetFileName(file.Name)
+		fileDialog.Show()
+	})
+	renameBtn := widget.NewButtonWithIcon("Rename", theme.DocumentCreateIcon(), b.showRenameDialog)
+	deleteBtn := widget.NewButtonWithIcon("Delete", theme.DeleteIcon(), b.showDeleteDialog)
+
+	toolbar := container.NewBorder(nil, nil,
+		container.NewHBox(upBtn, refreshBtn),
+		nil,
+		b.pathEntry,
+	)
+	actions := container.NewHBox(openBtn, newFolderBtn, uploadBtn, downloadBtn, renameBtn, deleteBtn)
+
+	b.transfers = container.NewVBox()
+	transfersScroll := container.NewVScroll(b.transfers)
+	transfersScroll.SetMinSize(fyne.NewSize(0, 120))
+
+	return container.NewBorder(
+		container.NewVBox(toolbar, actions),
+		container.NewVBox(widget.NewLabel("Transfers (drop files on the window to upload):"), transfersScroll, b.status),
+		nil, nil,
+		b.list,
+	)
+}
+
+// open lists a remote directory
+func (b *fileBrowser) open(dir string) {
+	b.status.SetText("Loading " + dir + "...")
+	go func() {
+		files, err := b.client.List(dir)
+		fyne.Do(func() {
+			if err != nil {
+				b.status.SetText(err.Error())
+				return
+			}
+			b.dir = dir
+			b.files = files
+			b.selected = -1
+			b.pathEntry.SetText(dir)
+			b.status.SetText(fmt.Sprintf("%d items", len(files)))
+			b.list.UnselectAll()
+			b.list.Refresh()
+		})
+	}()
+}
+
+// selection returns the selected entry
+func (b *fileBrowser) selection() (pssh.RemoteFile, bool) {
+	if b.selected < 0 || b.selected >= len(b.files) {
+		return pssh.RemoteFile{}, false
+	}
+	return b.files[b.selected], true
+}
+
+// upload copies a local file into the current directory
+func (b *fileBrowser) upload(localPath string) {
+	remotePath := path.Join(b.dir, filepath.Base(localPath))
+	b.startTransfer(&fileTransfer{upload: true, localPath: localPath, remotePath: remotePath}, false)
+}
+
+// startTransfer runs a transfer in the background, adding it to the transfers list
+// the first time. Failed or cancelled transfers can be resumed from where they stopped.
+func (b *fileBrowser) startTransfer(transfer *fileTransfer, resume bool) {
+	if transfer.progress == nil {
+		direction, name := "↓", path.Base(transfer.remotePath)
+		if transfer.upload {
+			direction = "↑"
+		}
+		transfer.progress = widget.NewProgressBar()
+		transfer.status = widget.NewLabel("")
+		transfer.action = widget.NewButton("", nil)
+		b.transfers.Add(container.NewBorder(nil, nil,
+			widget.NewLabel(direction+" "+name),
+			container.NewHBox(transfer.status, transfer.action),
+			transfer.progress,
+		))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.mutex.Lock()
+	b.cancels[transfer] = cancel
+	b.mutex.Unlock()
+
+	transfer.status.SetText("transferring")
+	transfer.action.SetText("Cancel")
+	transfer.action.SetIcon(theme.CancelIcon())
+	transfer.action.OnTapped = cancel
+
+	lastPercent := -1
+	options := pssh.TransferOptions{
+		Resume: resume,
+		Progress: func(done, total int64) {
+			percent := 100
+			if total > 0 {
+				percent = int(done * 100 / total)
+			}
+			if percent == lastPercent {
+				return
+			}
+			lastPercent = percent
+			fyne.Do(func() {
+				transfer.progress.SetValue(float64(percent) / 100)
+			})
+		},
+	}
+
+	go func() {
+		var err error
+		if transfer.upload {
+			err = b.client.Put(ctx, transfer.localPath, transfer.remotePath, options)
+		} else {
+			err = b.client.Get(ctx, transfer.remotePath, transfer.localPath, options)
+		}
+
+		b.mutex.Lock()
+		delete(b.cancels, transfer)
+		b.mutex.Unlock()
+		cancel()
+
+		fyne.Do(func() {
+			switch {
+			case err == nil:
+				transfer.status.SetText("done")
+				transfer.action.Hide()
+				if transfer.upload {
+					b.open(b.dir)
+				}
+				return
+			case errors.Is(err, context.Canceled):
+				transfer.status.SetText("cancelled")
+			default:
+				transfer.status.SetText("failed")
+				b.status.SetText(err.Error())
+			}
+			transfer.action.SetText("Resume")
+			transfer.action.SetIcon(theme.MediaReplayIcon())
+			transfer.action.OnTapped = func() {
+				b.startTransfer(transfer, true)
+			}
+		})
+	}()
+}
+
+// showNewFolderDialog asks for the name of a directory to create
+func (b *fileBrowser) showNewFolderDialog() {
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder("Folder name")
+	dialog.ShowCustomConfirm("New Folder", "Create", "Cancel", entry, func(confirmed bool) {
+		if !confirmed || entry.Text == "" {
+			return
+		}
+		b.run(func() error {
+			return b.client.Mkdir(path.Join(b.dir, entry.Text))
+		})
+	}, b.window)
+}
+
+// showRenameDialog asks for the new name of the selected entry
+func (b *fileBrowser) showRenameDialog() {
+	file, ok := b.selection()
+	if !ok {
+		return
+	}
+	entry := widget.NewEntry()
+	entry.SetText(file.Name)
+	dialog.ShowCustomConfirm("Rename "+file.Name, "Rename", "Cancel", entry, func(confirmed bool) {
+		if !confirmed || entry.Text == "" || entry.Text == file.Name {
+			return
+		}
+		b.run(func() error {
+			return b.client.Rename(file.Path, path.Join(b.dir, entry.Text))
+		})
+	}, b.window)
+}
+
+// showDeleteDialog asks before deleting the selected entry
+func (b *fileBrowser) showDeleteDialog() {
+	file, ok := b.selection()
+	if !ok {
+		return
+	}
+	dialog.ShowConfirm("Delete", fmt.Sprintf("Delete %s from the device?", file.Path), func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		b.run(func() error {
+			return b.client.Remove(file.Path)
+		})
+	}, b.window)
+}
+
+// run performs a remote operation in the background and reloads the directory
+func (b *fileBrowser) run(operation func() error) {
+	go func() {
+		err := operation()
+		fyne.Do(func() {
+			if err != nil {
+				dialog.ShowError(err, b.window)
+			}
+			b.open(b.dir)
+		})
+	}()
+}
+
+// close stops running transfers and ends the SFTP session
+func (b *fileBrowser) close() {
+	b.mutex.Lock()
+	for _, cancel := range b.cancels {
+		cancel()
+	}
+	b.mutex.Unlock()
+	b.client.Close()
+}
+
+// formatSize formats a file size for display
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -3,6 +3,8 @@ package dialogs
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ispapp/psshclient/internal/data"
@@ -10,6 +12,7 @@ import (
 	"github.com/ispapp/psshclient/internal/settings"
 	"github.com/ispapp/psshclient/internal/windows"
 	"github.com/ispapp/psshclient/pkg/goneighbors"
+	"github.com/ispapp/psshclient/pkg/pssh"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -17,8 +20,9 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
-// ShowNeighborDiscoveryDialog shows the neighbor discovery dialog with minimal design
-func ShowNeighborDiscoveryDialog(wins *windows.WindowManager) {
+// ShowNeighborDiscoveryDialog shows the neighbor discovery dialog with minimal design.
+// runScript opens the script runner for MAC-Telnet connections to selected neighbors.
+func ShowNeighborDiscoveryDialog(wins *windows.WindowManager, runScript func([]*pssh.SSHConnection)) {
 	fyne.Do(func() {
 		// Create interval input
 		intervalEntry := widget.NewEntry()
@@ -63,18 +67,17 @@ func ShowNeighborDiscoveryDialog(wins *windows.WindowManager) {
 		updateTable := func(neighbors []goneighbors.Neighbor) {
 			// Maintain fixed positions by not replacing the entire list
 			// Instead, add new neighbors to the end and preserve existing ones
-			existingIPs := make(map[string]int) // IP -> index mapping
+			existingKeys := make(map[string]int) // IP or MAC -> index mapping
 			for i, existing := range discoveredNeighbors {
-				existingIPs[existing.IPAddress] = i
+				existingKeys[neighborKey(existing)] = i
 			}
 
 			// Add new neighbors that aren't already in the list
 			for _, neighbor := range neighbors {
-				if _, exists := existingIPs[neighbor.IPAddress]; !exists {
+				if index, exists := existingKeys[neighborKey(neighbor)]; !exists {
 					discoveredNeighbors = append(discoveredNeighbors, neighbor)
 				} else {
 					// Update existing neighbor with new data while preserving position
-					index := existingIPs[neighbor.IPAddress]
 					discoveredNeighbors[index] = neighbor
 				}
 			}
@@ -252,9 +255,8 @@ func ShowNeighborDiscoveryDialog(wins *windows.WindowManager) {
 							Hostname:  neighbor.Identity,
 							SSHStatus: true, // Assume devices have SSH
 							// TELNETStatus: true,                                      // Assume devices have Telnet
-							SSHPort:   settings.Current.DefaultSSHPort, // Default SSH port
+							// Port and username are left unset, ~/.ssh/config or the defaults apply
 							Status:    string(neighbor.Protocol),
-							Username:  settings.Current.DefaultSSHUsername,
 							Password:  settings.Current.DefaultSSHPassword,
 							Connected: false,
 						}
@@ -276,6 +278,40 @@ func ShowNeighborDiscoveryDialog(wins *windows.WindowManager) {
 			}
 		})
 
+		// MAC-Telnet reaches selected MikroTik neighbors by MAC address, also
+		// when they have no IP address
+		withMACTelnet := func(open func(connections []*pssh.SSHConnection)) {
+			var macs []string
+			for index, selected := range selectedNeighbors {
+				if selected && index < len(discoveredNeighbors) && discoveredNeighbors[index].MACAddress != "" {
+					macs = append(macs, discoveredNeighbors[index].MACAddress)
+				}
+			}
+			if len(macs) == 0 {
+				statusLabel.SetText("No neighbors with a MAC address selected")
+				return
+			}
+			showMACTelnetLogin(wins, macs, func(connections []*pssh.SSHConnection, errs []string) {
+				if len(errs) > 0 {
+					dialog.ShowError(fmt.Errorf("%s", strings.Join(errs, "\n")), wins.GetMainWindow())
+				}
+				statusLabel.SetText(fmt.Sprintf("Logged in to %d of %d neighbor(s) over MAC-Telnet", len(connections), len(macs)))
+				if len(connections) > 0 {
+					open(connections)
+				}
+			})
+		}
+		macTerminalBtn := widget.NewButton("MAC-Telnet Terminal", func() {
+			withMACTelnet(func(connections []*pssh.SSHConnection) {
+				if err := pssh.OpenMultipleTerminals(connections); err != nil {
+					dialog.ShowError(err, wins.GetMainWindow())
+				}
+			})
+		})
+		macScriptBtn := widget.NewButton("MAC-Telnet Script", func() {
+			withMACTelnet(runScript)
+		})
+
 		// Select all / Deselect all buttons
 		selectAllBtn = widget.NewButton("Select All", func() {
 			for i := range discoveredNeighbors {
@@ -305,6 +341,8 @@ func ShowNeighborDiscoveryDialog(wins *windows.WindowManager) {
 			deselectAllBtn,
 			widget.NewSeparator(),
 			addSelectedBtn,
+			macTerminalBtn,
+			macScriptBtn,
 		)
 
 		content := container.NewBorder(
@@ -334,3 +372,68 @@ func ShowNeighborDiscoveryDialog(wins *windows.WindowManager) {
 		win.Window.RequestFocus()
 	})
 }
+
+// neighborKey identifies a neighbor in the table, by IP address or by MAC address
+// for devices without one
+func neighborKey(neighbor goneighbors.Neighbor) string {
+	if neighbor.IPAddress != "" {
+		return neighbor.IPAddress
+	}
+	return neighbor.MACAddress
+}
+
+// showMACTelnetLogin asks for credentials, prefilled with the SSH defaults, and
+// logs in to the devices with the given MAC addresses in the background
+func showMACTelnetLogin(wins *windows.WindowManager, macs []string, done func(connections []*pssh.SSHConnection, errs []string)) {
+	usernameEntry := widget.NewEntry()
+	usernameEntry.SetText(settings.Current.DefaultSSHUsername)
+	passwordEntry := widget.NewPasswordEntry()
+	passwordEntry.SetText(settings.Current.DefaultSSHPassword)
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Username", usernameEntry),
+		widget.NewFormItem("Password", passwordEntry),
+	}
+	title := fmt.Sprintf("MAC-Telnet Login (%d)", len(macs))
+	dialog.ShowForm(title, "Connect", "Cancel", items, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		username, password := usernameEntry.Text, passwordEntry.Text
+
+		go func() {
+			var connections []*pssh.SSHConnection
+			var errs []string
+			var mutex sync.Mutex
+			var wg sync.WaitGroup
+			for _, mac := range macs {
+				wg.Add(1)
+				go func(mac string) {
+					defer wg.Done()
+					config := pssh.ConnectionConfig{
+						Host:     mac,
+						Username: username,
+						Password: password,
+						Timeout:  settings.Current.GetConnectionTimeout(),
+						Protocol: pssh.ProtocolMACTelnet,
+					}
+					conn := pssh.NewSSHConnection(config)
+					err := conn.Connect()
+
+					mutex.Lock()
+					defer mutex.Unlock()
+					if err != nil {
+						errs = append(errs, err.Error())
+						return
+					}
+					connections = append(connections, conn)
+				}(mac)
+			}
+			wg.Wait()
+
+			fyne.Do(func() {
+				done(connections, errs)
+			})
+		}()
+	}, wins.GetMainWindow())
+}
@@ -23,6 +23,14 @@ type Device struct {
 	Username     string // SSH username
 	Password     string // SSH password
 	Connected    bool   // SSH connection status
+	HostKey      string // SSH host key fingerprint (SHA256) seen on first contact
+	KeyPath      string // Optional SSH private key file
+	JumpHost     string // Optional jump hosts, e.g. "admin@bastion:22,core-router"
+	Transport    string // Optional: "api" or "api-ssl" to use the RouterOS API instead of SSH or telnet
+
+	TerminalOptions string // Optional terminal overrides, e.g. "term=vt100; size=132x43; font-size=14"
+	Become          string // Optional privilege escalation: "sudo", "su" or "enable"
+	BecomePassword  string // Optional password asked by sudo, su or enable
 }
 
 // PortResult represents the structure that gomap returns for each port
@@ -116,22 +124,23 @@ func ScanSubnet(ctx context.Context, subnet string, progressCallback func(string
 				IP:       currentIP,
 				Hostname: result.Hostname,
 				Status:   "Up",
-				Username: settings.Current.DefaultSSHUsername,
 				Password: settings.Current.DefaultSSHPassword,
 			}
 
 			// Check which ports are open
 			hasOpenPort := false
+			defaultPortOpen := false
 			for _, portResult := range result.Results {
 				if portResult.State {
 					hasOpenPort = true
-					// Check for the default SSH port from settings
+					// Check for the default SSH port from settings, which is left unset on the device
 					if portResult.Port == settings.Current.DefaultSSHPort {
 						device.SSHStatus = true
-						device.SSHPort = settings.Current.DefaultSSHPort
+						device.SSHPort = 0
+						defaultPortOpen = true
 					} else if portResult.Port == 22 { // Also check for standard SSH port
 						device.SSHStatus = true
-						if device.SSHPort == 0 { // Don't override default from settings
+						if !defaultPortOpen { // Don't override default from settings
 							device.SSHPort = 22
 						}
 					}
@@ -273,7 +282,6 @@ func ScanSingleHost(ctx context.Context, ip string) (*Device, error) {
 	device := &Device{
 		IP:       ip,
 		Status:   "Down",
-		Username: settings.Current.DefaultSSHUsername,
 		Password: settings.Current.DefaultSSHPassword,
 	}
 
@@ -304,14 +312,16 @@ func ScanSingleHost(ctx context.Context, ip string) (*Device, error) {
 		device.Status = "Up"
 
 		// Check which ports are open
+		defaultPortOpen := false
 		for _, portResult := range result.Results {
 			if portResult.State {
 				if portResult.Port == settings.Current.DefaultSSHPort {
 					device.SSHStatus = true
-					device.SSHPort = settings.Current.DefaultSSHPort
+					device.SSHPort = 0
+					defaultPortOpen = true
 				} else if portResult.Port == 22 {
 					device.SSHStatus = true
-					if device.SSHPort == 0 {
+					if !defaultPortOpen {
 						device.SSHPort = 22
 					}
 				}
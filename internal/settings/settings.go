@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"time"
 )
@@ -15,10 +16,22 @@ type AppSettings struct {
 	DefaultSSHPort    int `json:"default_ssh_port"`
 	DefaultTelnetPort int `json:"default_telnet_port"`
 	ConnectionTimeout int `json:"connection_timeout_seconds"`
+	MaxParallelHosts  int `json:"max_parallel_hosts"` // Hosts connected to or running scripts at once
+
+	// Connection Health
+	KeepaliveInterval  int  `json:"keepalive_interval_seconds"` // 0 disables keepalives
+	KeepaliveMaxMissed int  `json:"keepalive_max_missed"`
+	AutoReconnect      bool `json:"auto_reconnect"`
 
 	// SSH Default Credentials
 	DefaultSSHUsername string `json:"default_ssh_username"`
 	DefaultSSHPassword string `json:"default_ssh_password"`
+	UseSSHAgent        bool   `json:"use_ssh_agent"`
+	SSHConfigPath      string `json:"ssh_config_path"` // OpenSSH client config, empty to ignore it
+
+	// SSH Host Key Verification
+	HostKeyPolicy  string `json:"host_key_policy"` // strict, tofu or ignore
+	KnownHostsPath string `json:"known_hosts_path"`
 
 	// Database Settings
 	DatabasePath    string `json:"database_path"`
@@ -26,10 +39,27 @@ type AppSettings struct {
 	CleanupOldDays  int    `json:"cleanup_old_days"`
 
 	// Terminal Settings
+	TerminalType     string `json:"terminal_type"` // TERM requested with the pty
 	TerminalRows     int    `json:"terminal_rows"`
 	TerminalCols     int    `json:"terminal_cols"`
-	TerminalFont     string `json:"terminal_font"`
+	TerminalModes    string `json:"terminal_modes"` // e.g. "ECHO=1 TTY_OP_ISPEED=14400"
+	TerminalEnv      string `json:"terminal_env"`   // e.g. "LANG=en_US.UTF-8"
+	TerminalFont     string `json:"terminal_font"`  // "monospace" or a TrueType font file
 	TerminalFontSize int    `json:"terminal_font_size"`
+	TerminalTheme    string `json:"terminal_theme"`   // dark, light or empty to follow the application
+	ScrollbackLines  int    `json:"scrollback_lines"` // output lines kept for search and transcripts
+
+	// Session Logs
+	LogSessions    bool   `json:"log_sessions"`
+	SessionLogsDir string `json:"session_logs_dir"` // per-host log files of terminal output
+
+	// Session Recording
+	RecordSessions bool   `json:"record_sessions"`
+	RecordingsDir  string `json:"recordings_dir"` // asciicast files of recorded terminal sessions
+
+	// Broadcast Input
+	ConfirmMultilinePaste bool     `json:"confirm_multiline_paste"`
+	DangerousCommands     []string `json:"dangerous_commands"` // regular expressions, confirmed before broadcast
 
 	// Scanning Settings
 	ScanTimeout        int   `json:"scan_timeout_seconds"`
@@ -46,16 +76,32 @@ type AppSettings struct {
 func DefaultSettings() *AppSettings {
 	homeDir, _ := os.UserHomeDir()
 	defaultDBPath := filepath.Join(homeDir, ".ispappclient", "devices.db")
+	defaultKnownHostsPath := filepath.Join(homeDir, ".ispappclient", "known_hosts")
+	defaultSSHConfigPath := filepath.Join(homeDir, ".ssh", "config")
+	defaultRecordingsDir := filepath.Join(homeDir, ".ispappclient", "recordings")
+	defaultSessionLogsDir := filepath.Join(homeDir, ".ispappclient", "logs")
 
 	return &AppSettings{
 		// Network Settings
 		DefaultSSHPort:    22,
 		DefaultTelnetPort: 23,
 		ConnectionTimeout: 30,
+		MaxParallelHosts:  20,
+
+		// Connection Health
+		KeepaliveInterval:  15,
+		KeepaliveMaxMissed: 3,
+		AutoReconnect:      true,
 
 		// SSH Default Credentials
 		DefaultSSHUsername: "admin",
 		DefaultSSHPassword: "",
+		UseSSHAgent:        true,
+		SSHConfigPath:      defaultSSHConfigPath,
+
+		// SSH Host Key Verification
+		HostKeyPolicy:  "tofu",
+		KnownHostsPath: defaultKnownHostsPath,
 
 		// Database Settings
 		DatabasePath:    defaultDBPath,
@@ -63,10 +109,31 @@ func DefaultSettings() *AppSettings {
 		CleanupOldDays:  30,
 
 		// Terminal Settings
+		TerminalType:     "xterm-256color",
 		TerminalRows:     24,
 		TerminalCols:     80,
+		TerminalModes:    "ECHO=1 TTY_OP_ISPEED=14400 TTY_OP_OSPEED=14400",
 		TerminalFont:     "monospace",
 		TerminalFontSize: 12,
+		ScrollbackLines:  10000,
+
+		// Session Logs
+		LogSessions:    false,
+		SessionLogsDir: defaultSessionLogsDir,
+
+		// Session Recording
+		RecordSessions: false,
+		RecordingsDir:  defaultRecordingsDir,
+
+		// Broadcast Input
+		ConfirmMultilinePaste: true,
+		DangerousCommands: []string{
+			`/system reset-configuration`,
+			`\breboot\b`,
+			`\bshutdown\b`,
+			`\brm\s+-\w*[rR]`,
+			`\bmkfs`,
+		},
 
 		// Scanning Settings
 		ScanTimeout:        10,
@@ -159,6 +226,11 @@ func (s *AppSettings) GetConnectionTimeout() time.Duration {
 	return time.Duration(s.ConnectionTimeout) * time.Second
 }
 
+// GetKeepaliveInterval returns keepalive interval as time.Duration
+func (s *AppSettings) GetKeepaliveInterval() time.Duration {
+	return time.Duration(s.KeepaliveInterval) * time.Second
+}
+
 // GetScanTimeout returns scan timeout as time.Duration
 func (s *AppSettings) GetScanTimeout() time.Duration {
 	return time.Duration(s.ScanTimeout) * time.Second
@@ -185,6 +257,24 @@ func (s *AppSettings) Validate() []string {
 		errors = append(errors, "Connection timeout must be greater than 0")
 	}
 
+	if s.KeepaliveInterval < 0 {
+		errors = append(errors, "Keepalive interval cannot be negative")
+	}
+
+	if s.KeepaliveMaxMissed <= 0 {
+		errors = append(errors, "Missed keepalives must be greater than 0")
+	}
+
+	switch s.HostKeyPolicy {
+	case "strict", "tofu", "ignore":
+	default:
+		errors = append(errors, "Host key policy must be strict, tofu or ignore")
+	}
+
+	if s.MaxParallelHosts <= 0 {
+		errors = append(errors, "Max parallel hosts must be greater than 0")
+	}
+
 	if s.TerminalRows <= 0 {
 		errors = append(errors, "Terminal rows must be greater than 0")
 	}
@@ -193,6 +283,36 @@ func (s *AppSettings) Validate() []string {
 		errors = append(errors, "Terminal columns must be greater than 0")
 	}
 
+	if s.TerminalType == "" {
+		errors = append(errors, "Terminal type is required")
+	}
+
+	if s.TerminalFontSize <= 0 {
+		errors = append(errors, "Terminal font size must be greater than 0")
+	}
+
+	if s.TerminalTheme != "" && s.TerminalTheme != "dark" && s.TerminalTheme != "light" {
+		errors = append(errors, "Terminal theme must be dark, light or empty")
+	}
+
+	if s.ScrollbackLines <= 0 {
+		errors = append(errors, "Scrollback lines must be greater than 0")
+	}
+
+	if s.LogSessions && s.SessionLogsDir == "" {
+		errors = append(errors, "Session logs folder is required to log sessions")
+	}
+
+	if s.RecordSessions && s.RecordingsDir == "" {
+		errors = append(errors, "Recordings folder is required to record sessions")
+	}
+
+	for _, pattern := range s.DangerousCommands {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errors = append(errors, fmt.Sprintf("Invalid dangerous command pattern %q: %v", pattern, err))
+		}
+	}
+
 	if s.ScanTimeout <= 0 {
 		errors = append(errors, "Scan timeout must be greater than 0")
 	}
@@ -244,6 +364,45 @@ func (s *AppSettings) SetConnectionTimeoutString(value string) error {
 	return nil
 }
 
+func (s *AppSettings) GetKeepaliveIntervalString() string {
+	return strconv.Itoa(s.KeepaliveInterval)
+}
+
+func (s *AppSettings) SetKeepaliveIntervalString(value string) error {
+	interval, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	s.KeepaliveInterval = interval
+	return nil
+}
+
+func (s *AppSettings) GetMaxParallelHostsString() string {
+	return strconv.Itoa(s.MaxParallelHosts)
+}
+
+func (s *AppSettings) SetMaxParallelHostsString(value string) error {
+	hosts, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	s.MaxParallelHosts = hosts
+	return nil
+}
+
+func (s *AppSettings) GetKeepaliveMaxMissedString() string {
+	return strconv.Itoa(s.KeepaliveMaxMissed)
+}
+
+func (s *AppSettings) SetKeepaliveMaxMissedString(value string) error {
+	missed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	s.KeepaliveMaxMissed = missed
+	return nil
+}
+
 func (s *AppSettings) GetTerminalRowsString() string {
 	return strconv.Itoa(s.TerminalRows)
 }
@@ -257,6 +416,28 @@ func (s *AppSettings) SetTerminalRowsString(value string) error {
 	return nil
 }
 
+func (s *AppSettings) GetScrollbackLinesString() string {
+	return strconv.Itoa(s.ScrollbackLines)
+}
+
+func (s *AppSettings) SetScrollbackLinesString(value string) error {
+	lines, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	s.ScrollbackLines = lines
+	return nil
+}
+
+// GetSessionLogDir returns the folder of the session logs, empty when sessions
+// are not logged
+func (s *AppSettings) GetSessionLogDir() string {
+	if !s.LogSessions {
+		return ""
+	}
+	return s.SessionLogsDir
+}
+
 func (s *AppSettings) GetTerminalColsString() string {
 	return strconv.Itoa(s.TerminalCols)
 }
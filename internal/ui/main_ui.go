@@ -8,6 +8,7 @@ import (
 	"github.com/ispapp/psshclient/internal/settings"
 	"github.com/ispapp/psshclient/internal/widgets"
 	"github.com/ispapp/psshclient/internal/windows"
+	"github.com/ispapp/psshclient/pkg/pssh"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -21,6 +22,17 @@ func NewMainUI(app fyne.App) fyne.Window {
 		log.Printf("Failed to initialize settings: %v", err)
 		// Continue with defaults
 	}
+	pssh.SetSSHConfigPath(settings.Current.SSHConfigPath)
+	pssh.StartRecording = data.StartRecording
+	pssh.SetConfirmMultilinePaste(settings.Current.ConfirmMultilinePaste)
+	pssh.SetDangerousCommands(settings.Current.DangerousCommands)
+	pssh.SetScrollbackLines(settings.Current.ScrollbackLines)
+	pssh.SetSessionLogDir(settings.Current.GetSessionLogDir())
+	if options, err := data.TerminalOptions(); err != nil {
+		log.Printf("Ignoring invalid terminal settings: %v", err)
+	} else {
+		pssh.SetDefaultTerminalOptions(options)
+	}
 
 	// Initialize global data bindings
 	data.Init()
@@ -52,7 +64,9 @@ func NewMainUI(app fyne.App) fyne.Window {
 		}),
 		fyne.NewMenuItem("Neighbor Discovery", func() {
 			actionLabel.SetText("Selected: Neighbor Discovery")
-			dialogs.ShowNeighborDiscoveryDialog(winmanager)
+			dialogs.ShowNeighborDiscoveryDialog(winmanager, func(connections []*pssh.SSHConnection) {
+				widgets.ShowScriptRunner(connections, MainWindow, app)
+			})
 		}),
 		fyne.NewMenuItem("Start Syn Scan", func() {
 			actionLabel.SetText("Selected: Start Syn Scan")
@@ -69,6 +83,13 @@ func NewMainUI(app fyne.App) fyne.Window {
 		fyne.NewMenuItem("Export CSV", func() {
 			dialogs.ShowCSVExportDialog(MainWindow)
 		}),
+		fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("Terminal Workspace", func() {
+			pssh.ShowWorkspace()
+		}),
+		fyne.NewMenuItem("Session Recordings", func() {
+			widgets.ShowRecordingsWindow()
+		}),
 	)
 
 	// Create help menu
@@ -94,6 +115,8 @@ func NewMainUI(app fyne.App) fyne.Window {
 	MainWindow.SetPadded(true)
 	// Set up cleanup when the main window closes
 	MainWindow.SetOnClosed(func() {
+		// End terminal sessions so their recordings are complete
+		pssh.CloseTerminals()
 		// Save current devices to database before closing
 		data.SaveDevicesToDB()
 		// Close database connection
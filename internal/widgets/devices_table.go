@@ -1,18 +1,24 @@
 package widgets
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ispapp/psshclient/internal/data"
+	"github.com/ispapp/psshclient/internal/dialogs"
 	"github.com/ispapp/psshclient/internal/scanner"
 	"github.com/ispapp/psshclient/internal/settings"
 	"github.com/ispapp/psshclient/internal/windows"
 	"github.com/ispapp/psshclient/pkg/pssh"
+	"github.com/ispapp/psshclient/pkg/routeros"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -37,8 +43,8 @@ func AutoReconnectDevices(sshManager *pssh.SSHManager, parentWindow fyne.Window)
 			if deviceObj, err := data.DeviceList.GetValue(i); err == nil {
 				if device, ok := deviceObj.(scanner.Device); ok {
 					// Check if device was loaded from DB with connected status and has credentials
-					if device.Status == "Loaded (Disconnected)" && device.SSHStatus &&
-						device.Username != "" && device.Password != "" {
+					if device.Status == "Loaded (Disconnected)" && canConnect(device) &&
+						deviceUsername(device) != "" && (device.Password != "" || device.KeyPath != "") {
 						connectableDevices = append(connectableDevices, struct {
 							device scanner.Device
 							index  int
@@ -60,20 +66,11 @@ func AutoReconnectDevices(sshManager *pssh.SSHManager, parentWindow fyne.Window)
 			device := item.device
 			deviceIndex := item.index
 
-			sshPort := device.SSHPort
-			if sshPort == 0 {
-				sshPort = settings.Current.DefaultSSHPort
-			}
-
-			config := pssh.ConnectionConfig{
-				Host:     device.IP,
-				Port:     sshPort,
-				Username: device.Username,
-				Password: device.Password,
-				Timeout:  settings.Current.GetConnectionTimeout(),
-			}
+			config := newDeviceConnectionConfig(device, parentWindow)
 
 			fmt.Printf("Auto-reconnecting to %s...\n", device.IP)
+			sshManager.SetKeepalive(keepaliveConfigFromSettings())
+			sshManager.SetMaxConcurrency(settings.Current.MaxParallelHosts)
 			resultChan := sshManager.ConnectMultiple([]pssh.ConnectionConfig{config})
 
 			// Process the result
@@ -81,10 +78,14 @@ func AutoReconnectDevices(sshManager *pssh.SSHManager, parentWindow fyne.Window)
 				if result.Error != nil {
 					fmt.Printf("Auto-reconnection failed for %s: %v\n", device.IP, result.Error)
 					device.Status = "Auto-reconnect failed"
+					if pssh.IsHostKeyMismatch(result.Error) {
+						device.Status = "Host key mismatch"
+					}
 				} else if result.Connection.Connected {
 					fmt.Printf("Successfully auto-reconnected to %s\n", device.IP)
 					device.Connected = true
 					device.Status = "Auto-reconnected"
+					device.HostKey = result.Connection.HostKeyFingerprint
 				} else {
 					fmt.Printf("Auto-reconnection to %s reported as not connected\n", device.IP)
 					device.Status = "Auto-reconnect failed"
@@ -110,11 +111,14 @@ func CreateDevicesTable() *fyne.Container {
 // CreateDevicesTableWithWindow creates a table widget with SSH functionality
 func CreateDevicesTableWithWindow(parentWindow fyne.Window, app fyne.App) *fyne.Container {
 	// Create table headers
-	headers := []string{"Select", "IP Address", "Hostname", "SSH", "SSH Port", "Username", "Password", "Status", "Actions"}
+	headers := []string{"Select", "IP Address", "Hostname", "SSH", "SSH Port", "Username", "Password", "Key File", "Jump Host", "Status", "Actions"}
 
 	// Track selected devices and SSH manager
 	selectedDevices := make(map[int]bool)
 	sshManager := pssh.NewSSHManager()
+	sshManager.SetKeepalive(keepaliveConfigFromSettings())
+	sshManager.SetMaxConcurrency(settings.Current.MaxParallelHosts)
+	sshManager.OnHealthChange(updateDeviceHealth)
 	var selectionMutex sync.Mutex
 
 	// Create table widget
@@ -161,34 +165,45 @@ func CreateDevicesTableWithWindow(parentWindow fyne.Window, app fyne.App) *fyne.
 								label.SetText(device.Hostname)
 
 							case 3: // SSH Status
-								if device.SSHStatus {
-									if device.Connected {
-										label.SetText("✓ Connected")
-									} else {
-										label.SetText("✓ Available")
-									}
-								} else {
+								switch {
+								case usesAPI(device) && device.Connected:
+									label.SetText("✓ API")
+								case usesAPI(device):
+									label.SetText("API")
+								case device.SSHStatus && device.Connected:
+									label.SetText("✓ Connected")
+								case device.SSHStatus:
+									label.SetText("✓ Available")
+								case device.TELNETStatus && device.Connected:
+									label.SetText("✓ Telnet")
+								case device.TELNETStatus:
+									label.SetText("Telnet only")
+								default:
 									label.SetText("✗ Closed")
 								}
 							case 4: // SSH Port
-								if device.SSHStatus {
+								if usesAPI(device) {
+									label.SetText(fmt.Sprintf("%d", apiPort(device)))
+								} else if device.SSHStatus {
 									if device.SSHPort == 0 {
 										device.SSHPort = settings.Current.DefaultSSHPort
 									}
 									label.SetText(fmt.Sprintf("%d", device.SSHPort))
+								} else if device.TELNETStatus {
+									label.SetText(fmt.Sprintf("%d", settings.Current.DefaultTelnetPort))
 								} else {
 									label.SetText("-")
 								}
 
 							case 5: // Username
-								if device.SSHStatus {
-									label.SetText(device.Username)
+								if canConnect(device) {
+									label.SetText(deviceUsername(device))
 								} else {
 									label.SetText("-")
 								}
 
 							case 6: // Password
-								if device.SSHStatus {
+								if canConnect(device) {
 									if device.Password != "" {
 										label.SetText("●●●●●●")
 									} else {
@@ -198,11 +213,29 @@ func CreateDevicesTableWithWindow(parentWindow fyne.Window, app fyne.App) *fyne.
 									label.SetText("-")
 								}
 
-							case 7: // Overall Status
-								label.SetText(device.Status)
+							case 7: // Private key file
+								if device.SSHStatus {
+									if device.KeyPath != "" {
+										label.SetText(filepath.Base(device.KeyPath))
+									} else {
+										label.SetText("")
+									}
+								} else {
+									label.SetText("-")
+								}
 
-							case 8: // Actions
+							case 8: // Jump host chain
 								if device.SSHStatus {
+									label.SetText(device.JumpHost)
+								} else {
+									label.SetText("-")
+								}
+
+							case 9: // Overall Status
+								label.SetText(device.Status)
+
+							case 10: // Actions
+								if canConnect(device) {
 									if device.Connected {
 										label.SetText("🔌 Disconnect")
 									} else {
@@ -240,7 +273,7 @@ func CreateDevicesTableWithWindow(parentWindow fyne.Window, app fyne.App) *fyne.
 			case 5: // Username column - show entry dialog
 				if deviceIndex < data.DeviceList.Length() {
 					if deviceObj, err := data.DeviceList.GetValue(deviceIndex); err == nil {
-						if device, ok := deviceObj.(scanner.Device); ok && device.SSHStatus {
+						if device, ok := deviceObj.(scanner.Device); ok && canConnect(device) {
 							showUsernameDialog(deviceIndex, device.Username, parentWindow, table)
 						}
 					}
@@ -248,15 +281,31 @@ func CreateDevicesTableWithWindow(parentWindow fyne.Window, app fyne.App) *fyne.
 			case 6: // Password column - show entry dialog
 				if deviceIndex < data.DeviceList.Length() {
 					if deviceObj, err := data.DeviceList.GetValue(deviceIndex); err == nil {
-						if device, ok := deviceObj.(scanner.Device); ok && device.SSHStatus {
+						if device, ok := deviceObj.(scanner.Device); ok && canConnect(device) {
 							showPasswordDialog(deviceIndex, device.Password, parentWindow, table)
 						}
 					}
 				}
-			case 8: // Actions column - connect/disconnect
+			case 7: // Key File column - show key selection dialog
+				if deviceIndex < data.DeviceList.Length() {
+					if deviceObj, err := data.DeviceList.GetValue(deviceIndex); err == nil {
+						if device, ok := deviceObj.(scanner.Device); ok && device.SSHStatus {
+							showKeyPathDialog(deviceIndex, device.KeyPath, parentWindow, table)
+						}
+					}
+				}
+			case 8: // Jump Host column - show jump host dialog
 				if deviceIndex < data.DeviceList.Length() {
 					if deviceObj, err := data.DeviceList.GetValue(deviceIndex); err == nil {
 						if device, ok := deviceObj.(scanner.Device); ok && device.SSHStatus {
+							showJumpHostDialog([]int{deviceIndex}, device.JumpHost, parentWindow, table)
+						}
+					}
+				}
+			case 10: // Actions column - connect/disconnect
+				if deviceIndex < data.DeviceList.Length() {
+					if deviceObj, err := data.DeviceList.GetValue(deviceIndex); err == nil {
+						if device, ok := deviceObj.(scanner.Device); ok && canConnect(device) {
 							connectToDevice(deviceIndex, sshManager, parentWindow, table)
 						}
 					}
@@ -266,15 +315,17 @@ func CreateDevicesTableWithWindow(parentWindow fyne.Window, app fyne.App) *fyne.
 	}
 
 	// Set column widths for better layout
-	table.SetColumnWidth(0, 60)  // Select checkbox
-	table.SetColumnWidth(1, 160) // IP Address
-	table.SetColumnWidth(2, 160) // Hostname
-	table.SetColumnWidth(3, 100) // SSH Status
-	table.SetColumnWidth(4, 80)  // SSH Port
-	table.SetColumnWidth(5, 100) // Username
-	table.SetColumnWidth(6, 100) // Password
-	table.SetColumnWidth(7, 160) // Status
-	table.SetColumnWidth(8, 100) // Actions
+	table.SetColumnWidth(0, 60)   // Select checkbox
+	table.SetColumnWidth(1, 160)  // IP Address
+	table.SetColumnWidth(2, 160)  // Hostname
+	table.SetColumnWidth(3, 100)  // SSH Status
+	table.SetColumnWidth(4, 80)   // SSH Port
+	table.SetColumnWidth(5, 100)  // Username
+	table.SetColumnWidth(6, 100)  // Password
+	table.SetColumnWidth(7, 120)  // Key File
+	table.SetColumnWidth(8, 140)  // Jump Host
+	table.SetColumnWidth(9, 160)  // Status
+	table.SetColumnWidth(10, 100) // Actions
 
 	// Listen for changes to the device list
 	data.DeviceList.AddListener(binding.NewDataListener(func() {
@@ -287,7 +338,7 @@ func CreateDevicesTableWithWindow(parentWindow fyne.Window, app fyne.App) *fyne.
 	// Create SSH control buttons
 	var sshControls *fyne.Container
 	if parentWindow != nil && app != nil {
-		sshControls = createSSHControls(selectedDevices, sshManager, parentWindow, app)
+		sshControls = createSSHControls(selectedDevices, sshManager, parentWindow, app, table)
 	}
 
 	// Update status label when device list changes
@@ -353,7 +404,7 @@ func getSSHDeviceCount() int {
 }
 
 // createSSHControls creates SSH control buttons
-func createSSHControls(selectedDevices map[int]bool, sshManager *pssh.SSHManager, parentWindow fyne.Window, app fyne.App) *fyne.Container {
+func createSSHControls(selectedDevices map[int]bool, sshManager *pssh.SSHManager, parentWindow fyne.Window, app fyne.App, table *widget.Table) *fyne.Container {
 	// Multi-Device SSH Terminal button using new terminal widget
 	sshTerminalBtn := widget.NewButtonWithIcon("Terminal", theme.ComputerIcon(), func() {
 		var connections []*pssh.SSHConnection
@@ -430,6 +481,141 @@ func createSSHControls(selectedDevices map[int]bool, sshManager *pssh.SSHManager
 		showScriptDialog(connections, parentWindow, app)
 	})
 
+	// Files button - opens an SFTP file browser for each selected connected device
+	filesBtn := widget.NewButtonWithIcon("Files", theme.StorageIcon(), func() {
+		connections := selectedConnections(selectedDevices, sshManager)
+		if len(connections) == 0 {
+			dialog.ShowInformation("No SSH Connections",
+				"Please connect to and select devices first.", parentWindow)
+			return
+		}
+
+		for _, conn := range connections {
+			dialogs.ShowFileBrowser(conn, parentWindow)
+		}
+	})
+
+	// Push and Pull buttons - copy a file to or from all selected connected devices
+	pushFileBtn := widget.NewButtonWithIcon("Push File", theme.UploadIcon(), func() {
+		connections := selectedConnections(selectedDevices, sshManager)
+		if len(connections) == 0 {
+			dialog.ShowInformation("No SSH Connections",
+				"Please connect to and select devices first.", parentWindow)
+			return
+		}
+		showFileTransferDialog(connections, true, parentWindow)
+	})
+	pullFileBtn := widget.NewButtonWithIcon("Pull File", theme.DownloadIcon(), func() {
+		connections := selectedConnections(selectedDevices, sshManager)
+		if len(connections) == 0 {
+			dialog.ShowInformation("No SSH Connections",
+				"Please connect to and select devices first.", parentWindow)
+			return
+		}
+		showFileTransferDialog(connections, false, parentWindow)
+	})
+
+	// Tunnels button - manages port forwards through the selected connected devices
+	tunnelsBtn := widget.NewButtonWithIcon("Tunnels", theme.MailReplyIcon(), func() {
+		showTunnelsWindow(selectedConnections(selectedDevices, sshManager), parentWindow)
+	})
+
+	// Jump Host button - sets the bastion for all selected devices at once
+	jumpHostBtn := widget.NewButtonWithIcon("Jump Host", theme.MailForwardIcon(), func() {
+		var indexes []int
+		currentJumpHost := ""
+		for deviceIndex, selected := range selectedDevices {
+			if !selected || deviceIndex >= data.DeviceList.Length() {
+				continue
+			}
+			if deviceObj, err := data.DeviceList.GetValue(deviceIndex); err == nil {
+				if device, ok := deviceObj.(scanner.Device); ok && device.SSHStatus {
+					indexes = append(indexes, deviceIndex)
+					currentJumpHost = device.JumpHost
+				}
+			}
+		}
+
+		if len(indexes) == 0 {
+			dialog.ShowInformation("No Selection", "Please select the devices that are reached through the jump host.", parentWindow)
+			return
+		}
+
+		showJumpHostDialog(indexes, currentJumpHost, parentWindow, table)
+	})
+
+	// Transport button - switches the selected devices between SSH/telnet and the RouterOS API
+	transportBtn := widget.NewButtonWithIcon("Transport", theme.SettingsIcon(), func() {
+		var indexes []int
+		currentTransport := ""
+		for deviceIndex, selected := range selectedDevices {
+			if !selected || deviceIndex >= data.DeviceList.Length() {
+				continue
+			}
+			if deviceObj, err := data.DeviceList.GetValue(deviceIndex); err == nil {
+				if device, ok := deviceObj.(scanner.Device); ok {
+					indexes = append(indexes, deviceIndex)
+					currentTransport = device.Transport
+				}
+			}
+		}
+
+		if len(indexes) == 0 {
+			dialog.ShowInformation("No Selection", "Please select the devices to change the transport of.", parentWindow)
+			return
+		}
+
+		showTransportDialog(indexes, currentTransport, parentWindow, table)
+	})
+
+	// Terminal button - sets the pty and appearance of the terminals of the selected devices
+	terminalOptionsBtn := widget.NewButtonWithIcon("Terminal", theme.ComputerIcon(), func() {
+		var indexes []int
+		currentOptions := ""
+		for deviceIndex, selected := range selectedDevices {
+			if !selected || deviceIndex >= data.DeviceList.Length() {
+				continue
+			}
+			if deviceObj, err := data.DeviceList.GetValue(deviceIndex); err == nil {
+				if device, ok := deviceObj.(scanner.Device); ok {
+					indexes = append(indexes, deviceIndex)
+					currentOptions = device.TerminalOptions
+				}
+			}
+		}
+
+		if len(indexes) == 0 {
+			dialog.ShowInformation("No Selection", "Please select the devices to set the terminal options of.", parentWindow)
+			return
+		}
+
+		showTerminalOptionsDialog(indexes, currentOptions, parentWindow, table)
+	})
+
+	// Become button - sets how the selected devices gain privileges for commands and shells
+	becomeBtn := widget.NewButtonWithIcon("Become", theme.AccountIcon(), func() {
+		var indexes []int
+		var current scanner.Device
+		for deviceIndex, selected := range selectedDevices {
+			if !selected || deviceIndex >= data.DeviceList.Length() {
+				continue
+			}
+			if deviceObj, err := data.DeviceList.GetValue(deviceIndex); err == nil {
+				if device, ok := deviceObj.(scanner.Device); ok {
+					indexes = append(indexes, deviceIndex)
+					current = device
+				}
+			}
+		}
+
+		if len(indexes) == 0 {
+			dialog.ShowInformation("No Selection", "Please select the devices to set the privilege escalation of.", parentWindow)
+			return
+		}
+
+		showBecomeDialog(indexes, current.Become, current.BecomePassword, parentWindow, table)
+	})
+
 	// Select All SSH button
 	selectAllSSHBtn := widget.NewButtonWithIcon("Select All", theme.ConfirmIcon(), func() {
 		// Clear current selection
@@ -556,6 +742,14 @@ func createSSHControls(selectedDevices map[int]bool, sshManager *pssh.SSHManager
 		widget.NewSeparator(),
 		sshTerminalBtn,
 		runScriptBtn,
+		filesBtn,
+		pushFileBtn,
+		pullFileBtn,
+		tunnelsBtn,
+		jumpHostBtn,
+		transportBtn,
+		terminalOptionsBtn,
+		becomeBtn,
 	)
 
 	// Combine both sections with a separator
@@ -750,6 +944,12 @@ func createScriptAutofillSection(scriptInput *widget.Entry, parentWindow fyne.Wi
 	return autofillSection
 }
 
+// ShowScriptRunner opens the script runner for connections made outside the
+// devices table, such as MAC-Telnet sessions from neighbor discovery
+func ShowScriptRunner(connections []*pssh.SSHConnection, parent fyne.Window, app fyne.App) {
+	showScriptDialog(connections, parent, app)
+}
+
 // showScriptDialog shows a dialog to run a script on multiple devices
 func showScriptDialog(connections []*pssh.SSHConnection, parent fyne.Window, app fyne.App) {
 	scriptInput := widget.NewMultiLineEntry()
@@ -801,72 +1001,195 @@ func showScriptDialog(connections []*pssh.SSHConnection, parent fyne.Window, app
 	outputScroll := container.NewVScroll(outputBox)
 	outputScroll.SetMinSize(fyne.NewSize(600, 300))
 
-	var runBtn *widget.Button
+	windRunScript, err := windows.WinManager.NewWindow("Run Script", "run_scripts")
+	if err != nil {
+		fmt.Printf("Failed to create window: %v\n", err)
+		return
+	}
+
+	// The timeout applies to each host on its own, not to the whole run
+	timeoutEntry := widget.NewEntry()
+	timeoutEntry.SetPlaceHolder("none")
+
+	executorOptions, readExecutorOptions := newExecutorOptions(connections)
+
+	// Expect sequences answer the prompts of interactive commands
+	expectCheck := widget.NewCheck("Expect sequence", func(checked bool) {
+		if checked {
+			scriptInput.SetPlaceHolder("expect />\\s*$/\nsend /system reboot\nexpect Reboot, yes? [y/N]:\nsend \"y\"\n\nSteps: send TEXT, send \"raw\\r\", expect TEXT, expect /regex/, timeout 30s; captured groups as $1 or ${name}")
+		} else {
+			scriptInput.SetPlaceHolder("Enter script to run on all selected devices...")
+		}
+	})
+
+	// Results of the last run can be shown per host or grouped by identical output
+	var lastResults []*pssh.CommandResult
+	var lastHostOutputs fyne.CanvasObject
+	groupCheck := widget.NewCheck("Group identical output", nil)
+	groupModeSelect := widget.NewSelect([]string{groupExact, groupVolatile, groupNumbers}, nil)
+	groupModeSelect.SetSelected(groupVolatile)
+	showResults := func() {
+		if lastResults == nil {
+			return
+		}
+		if groupCheck.Checked {
+			groups := pssh.GroupOutputs(lastResults, outputNormalizer(groupModeSelect.Selected))
+			outputBox.Objects[1] = newOutputGroupsView(groups)
+		} else {
+			outputBox.Objects[1] = lastHostOutputs
+		}
+		outputBox.Refresh()
+	}
+	groupCheck.OnChanged = func(bool) { showResults() }
+	groupModeSelect.OnChanged = func(string) { showResults() }
+
+	var runBtn, stopBtn *widget.Button
+	stopBtn = widget.NewButtonWithIcon("Stop", theme.MediaStopIcon(), nil)
+	stopBtn.Importance = widget.DangerImportance
+	stopBtn.Disable()
+
 	runBtn = widget.NewButton("Run Script", func() {
 		script := scriptInput.Text
 		if script == "" {
 			return
 		}
 
+		var timeout time.Duration
+		if text := strings.TrimSpace(timeoutEntry.Text); text != "" {
+			seconds, err := strconv.Atoi(text)
+			if err != nil || seconds < 0 {
+				dialog.ShowError(fmt.Errorf("timeout must be a number of seconds"), parent)
+				return
+			}
+			timeout = time.Duration(seconds) * time.Second
+		}
+
+		executorConfig, err := readExecutorOptions()
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		executorConfig.HostTimeout = timeout
+
+		var expectScript pssh.ExpectScript
+		if expectCheck.Checked {
+			if expectScript, err = pssh.ParseExpectScript(script); err != nil {
+				dialog.ShowError(err, parent)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		// Stop aborts the hosts still running, finished hosts keep their results
+		stopBtn.OnTapped = func() {
+			stopBtn.Disable()
+			cancel()
+		}
+
 		runBtn.Disable()
+		stopBtn.Enable()
+		lastResults = nil
 		outputBox.RemoveAll()
-		progress := widget.NewProgressBarInfinite()
+		progress := widget.NewProgressBar()
+		progress.Max = float64(len(connections))
 		outputBox.Add(progress)
 
-		go fyne.Do(func() {
-			var results []string
-			var mu sync.Mutex
-			var wg sync.WaitGroup
-
-			for _, conn := range connections {
-				wg.Add(1)
-				go func(c *pssh.SSHConnection) {
-					defer wg.Done()
-					output, err := c.RunCommand(script)
-					var resultText string
-					if err != nil {
-						resultText = fmt.Sprintf("--- ERROR on %s ---\n%s\n", c.Config.Host, err.Error())
-					} else {
-						resultText = fmt.Sprintf("--- Output from %s ---\n%s\n", c.Config.Host, output)
-					}
-					mu.Lock()
-					results = append(results, resultText)
-					mu.Unlock()
-				}(conn)
+		// One card per host, streaming its output while the command runs
+		sorted := sortedConnections(connections)
+		views := make([]*hostOutputView, len(sorted))
+		hostOutputs := widget.NewAccordion()
+		hostOutputs.MultiOpen = true
+		for i, conn := range sorted {
+			views[i] = newHostOutputView(conn.Config.Host, windRunScript.Window)
+			hostOutputs.Append(views[i].item)
+		}
+		if len(views) == 1 {
+			hostOutputs.Open(0)
+		}
+		outputBox.Add(hostOutputs)
+
+		refreshViews := func() {
+			for _, view := range views {
+				view.refresh()
 			}
-			wg.Wait()
-
-			// UI updates can be done directly, but need to be refreshed
-			runBtn.Enable()
-			outputBox.RemoveAll()
-			var fullOutput string
-			for _, res := range results {
-				fullOutput += res + "\n"
+			hostOutputs.Refresh()
+		}
+
+		// Output is shown in batches so chatty hosts do not flood the UI thread
+		finished := make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(250 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-finished:
+					return
+				case <-ticker.C:
+					fyne.Do(refreshViews)
+				}
 			}
-			// Use a single label with wrapped text for the full output
-			outputLabel := widget.NewLabel(fullOutput)
-			outputLabel.Wrapping = fyne.TextWrapWord
-			outputBox.Add(outputLabel)
-			outputBox.Refresh()
-		})
+		}()
+
+		go func() {
+			defer cancel()
+			var done int32
+			run := func(callbacks pssh.CommandCallbacks) ([]*pssh.CommandResult, error) {
+				if expectScript != nil {
+					return pssh.RunExpectMultiple(ctx, sorted, expectScript, executorConfig, callbacks)
+				}
+				return pssh.RunCommandMultiple(ctx, sorted, script, executorConfig, callbacks)
+			}
+			results, aborted := run(pssh.CommandCallbacks{
+				OnStart: func(index int) {
+					views[index].start()
+				},
+				OnOutput: func(index int, line pssh.OutputLine) {
+					views[index].addLine(line)
+				},
+				OnResult: func(index int, result *pssh.CommandResult) {
+					views[index].finish(result)
+					count := atomic.AddInt32(&done, 1)
+					fyne.Do(func() {
+						progress.SetValue(float64(count))
+					})
+				},
+			})
+			close(finished)
+
+			// Skipped hosts never reported a result
+			for i, result := range results {
+				views[i].finish(result)
+			}
+
+			fyne.Do(func() {
+				runBtn.Enable()
+				stopBtn.Disable()
+				refreshViews()
+				outputBox.Objects[0] = newCommandSummary(results, aborted)
+				lastResults = results
+				lastHostOutputs = hostOutputs
+				showResults()
+				outputBox.Refresh()
+			})
+		}()
 	})
 
 	content := container.NewVBox(
 		container.NewHBox(
 			widget.NewLabel("Enter script:"),
 			toggleAutofillBtn,
+			expectCheck,
 		),
 		autofillSection,
 		scriptInput,
-		runBtn,
-		widget.NewLabel("Output:"),
+		executorOptions,
+		container.NewBorder(nil, nil, nil,
+			container.NewHBox(widget.NewLabel("Timeout per host (s):"), timeoutEntry, stopBtn),
+			runBtn,
+		),
+		container.NewHBox(widget.NewLabel("Output:"), groupCheck, groupModeSelect),
 		outputScroll,
 	)
-	windRunScript, err := windows.WinManager.NewWindow("Run Script", "run_scripts")
-	if err != nil {
-		fmt.Printf("Failed to create window: %v\n", err)
-		return
-	}
 	windRunScript.Window.SetContent(content)
 	windRunScript.Window.Resize(fyne.NewSize(800, 700)) // Increased size to accommodate autofill section
 	windRunScript.Window.Show()
@@ -876,7 +1199,7 @@ func showScriptDialog(connections []*pssh.SSHConnection, parent fyne.Window, app
 func showUsernameDialog(deviceIndex int, currentUsername string, parent fyne.Window, table *widget.Table) {
 	entry := widget.NewEntry()
 	entry.SetText(currentUsername)
-	entry.SetPlaceHolder("Enter username")
+	entry.SetPlaceHolder(fmt.Sprintf("Default: %s", settings.Current.DefaultSSHUsername))
 
 	dialog.ShowCustomConfirm("Enter Username", "OK", "Cancel", entry, func(confirmed bool) {
 		if confirmed {
@@ -889,11 +1212,11 @@ func showUsernameDialog(deviceIndex int, currentUsername string, parent fyne.Win
 // showSSHPortDialog shows a dialog to enter the SSH port for a device
 func showSSHPortDialog(deviceIndex int, currentPort int, parent fyne.Window, table *widget.Table) {
 	entry := widget.NewEntry()
-	if currentPort == 0 {
-		currentPort = settings.Current.DefaultSSHPort
+	if currentPort != 0 {
+		entry.SetText(fmt.Sprintf("%d", currentPort))
 	}
-	entry.SetText(fmt.Sprintf("%d", currentPort))
-	entry.SetPlaceHolder("Enter SSH port")
+	// Left empty, ~/.ssh/config or the default port applies
+	entry.SetPlaceHolder(fmt.Sprintf("Default: %d", settings.Current.DefaultSSHPort))
 
 	dialog.ShowCustomConfirm("Enter SSH Port", "OK", "Cancel", entry, func(confirmed bool) {
 		if confirmed {
@@ -917,6 +1240,177 @@ func showPasswordDialog(deviceIndex int, currentPassword string, parent fyne.Win
 	}, parent)
 }
 
+// showKeyPathDialog shows a dialog to choose the private key file for a device
+func showKeyPathDialog(deviceIndex int, currentPath string, parent fyne.Window, table *widget.Table) {
+	entry := widget.NewEntry()
+	entry.SetText(currentPath)
+	entry.SetPlaceHolder("Leave empty to use password, ssh-agent or default keys")
+
+	browseBtn := widget.NewButton("Browse", func() {
+		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, parent)
+				return
+			}
+			if reader == nil {
+				return // User cancelled
+			}
+			defer reader.Close()
+			entry.SetText(reader.URI().Path())
+		}, parent)
+	})
+
+	content := container.NewBorder(nil, nil, nil, browseBtn, entry)
+	dialog.ShowCustomConfirm("Private Key File", "OK", "Cancel", content, func(confirmed bool) {
+		if confirmed {
+			updateDeviceField(deviceIndex, "keypath", entry.Text)
+			table.Refresh()
+		}
+	}, parent)
+}
+
+// showJumpHostDialog shows a dialog to set the jump hosts used to reach one or more devices
+func showJumpHostDialog(deviceIndexes []int, currentJumpHost string, parent fyne.Window, table *widget.Table) {
+	entry := widget.NewEntry()
+	entry.SetText(currentJumpHost)
+	entry.SetPlaceHolder("user@bastion:22,core-router (empty for direct)")
+
+	title := "Jump Host"
+	if len(deviceIndexes) > 1 {
+		title = fmt.Sprintf("Jump Host (%d devices)", len(deviceIndexes))
+	}
+
+	content := container.NewVBox(
+		entry,
+		widget.NewLabel("Hops are tried in order. Hops without a user reuse the device credentials."),
+	)
+	dialog.ShowCustomConfirm(title, "OK", "Cancel", content, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		if _, err := pssh.ParseJumpHosts(entry.Text, settings.Current.DefaultSSHPort); err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		for _, deviceIndex := range deviceIndexes {
+			updateDeviceField(deviceIndex, "jumphost", entry.Text)
+		}
+		table.Refresh()
+	}, parent)
+}
+
+// transportOptions maps the transport select options to device transports
+var transportOptions = map[string]string{
+	"SSH / Telnet":       "",
+	"RouterOS API":       string(pssh.ProtocolAPI),
+	"RouterOS API (TLS)": string(pssh.ProtocolAPITLS),
+}
+
+// showTransportDialog shows a dialog to choose how one or more devices are reached.
+// The change applies on the next connect.
+func showTransportDialog(deviceIndexes []int, currentTransport string, parent fyne.Window, table *widget.Table) {
+	transportSelect := widget.NewSelect([]string{"SSH / Telnet", "RouterOS API", "RouterOS API (TLS)"}, nil)
+	for option, transport := range transportOptions {
+		if transport == currentTransport {
+			transportSelect.SetSelected(option)
+		}
+	}
+
+	title := "Transport"
+	if len(deviceIndexes) > 1 {
+		title = fmt.Sprintf("Transport (%d devices)", len(deviceIndexes))
+	}
+
+	content := container.NewVBox(
+		transportSelect,
+		widget.NewLabel(fmt.Sprintf("The API (port %d, TLS %d) returns script results as records.\nIt has no terminal, file transfers or tunnels. Reconnect to apply.",
+			routeros.DefaultPort, routeros.DefaultTLSPort)),
+	)
+	dialog.ShowCustomConfirm(title, "OK", "Cancel", content, func(confirmed bool) {
+		if !confirmed || transportSelect.Selected == "" {
+			return
+		}
+		for _, deviceIndex := range deviceIndexes {
+			updateDeviceField(deviceIndex, "transport", transportOptions[transportSelect.Selected])
+		}
+		table.Refresh()
+	}, parent)
+}
+
+// showTerminalOptionsDialog shows a dialog to set the terminal options of one or more
+// devices, which override the terminal settings
+func showTerminalOptionsDialog(deviceIndexes []int, currentOptions string, parent fyne.Window, table *widget.Table) {
+	entry := widget.NewEntry()
+	entry.SetText(currentOptions)
+	entry.SetPlaceHolder("term=vt100; size=132x43; modes=ICRNL=0; env=LANG=C.UTF-8; font-size=14; theme=light")
+
+	title := "Terminal Options"
+	if len(deviceIndexes) > 1 {
+		title = fmt.Sprintf("Terminal Options (%d devices)", len(deviceIndexes))
+	}
+
+	content := container.NewVBox(
+		entry,
+		widget.NewLabel("Options left out are taken from the terminal settings, modes and env are added to them.\nThe pty options apply to new terminals, font and theme to open ones too."),
+	)
+	dialog.ShowCustomConfirm(title, "OK", "Cancel", content, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		if _, err := pssh.ParseTerminalOptions(entry.Text); err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		for _, deviceIndex := range deviceIndexes {
+			updateDeviceField(deviceIndex, "terminal", entry.Text)
+		}
+		table.Refresh()
+	}, parent)
+}
+
+// becomeOptions maps the become select options to device become methods
+var becomeOptions = map[string]string{
+	"None":   string(pssh.BecomeNone),
+	"sudo":   string(pssh.BecomeSudo),
+	"su":     string(pssh.BecomeSu),
+	"enable": string(pssh.BecomeEnable),
+}
+
+// showBecomeDialog shows a dialog to set how one or more devices gain privileges and
+// the password asked for it. The change applies on the next connect.
+func showBecomeDialog(deviceIndexes []int, currentBecome, currentPassword string, parent fyne.Window, table *widget.Table) {
+	becomeSelect := widget.NewSelect([]string{"None", "sudo", "su", "enable"}, nil)
+	for option, become := range becomeOptions {
+		if become == currentBecome {
+			becomeSelect.SetSelected(option)
+		}
+	}
+	passwordEntry := widget.NewPasswordEntry()
+	passwordEntry.SetText(currentPassword)
+	passwordEntry.SetPlaceHolder("Become password (empty if none is asked)")
+
+	title := "Privilege Escalation"
+	if len(deviceIndexes) > 1 {
+		title = fmt.Sprintf("Privilege Escalation (%d devices)", len(deviceIndexes))
+	}
+
+	content := container.NewVBox(
+		becomeSelect,
+		passwordEntry,
+		widget.NewLabel("sudo asks for the login password, su for the root password and enable for the enable secret.\nCommands and terminals run escalated, failures show as escalation errors. Reconnect to apply."),
+	)
+	dialog.ShowCustomConfirm(title, "OK", "Cancel", content, func(confirmed bool) {
+		if !confirmed || becomeSelect.Selected == "" {
+			return
+		}
+		for _, deviceIndex := range deviceIndexes {
+			updateDeviceField(deviceIndex, "become", becomeOptions[becomeSelect.Selected])
+			updateDeviceField(deviceIndex, "becomepassword", passwordEntry.Text)
+		}
+		table.Refresh()
+	}, parent)
+}
+
 // updateDeviceField updates a specific field of a device in the device list
 func updateDeviceField(deviceIndex int, field, value string) {
 	if deviceIndex < data.DeviceList.Length() {
@@ -929,8 +1423,29 @@ func updateDeviceField(deviceIndex int, field, value string) {
 				case "password":
 					device.Password = value
 					data.UpdateDevice(deviceIndex, device)
+				case "keypath":
+					device.KeyPath = value
+					data.UpdateDevice(deviceIndex, device)
+				case "jumphost":
+					device.JumpHost = strings.TrimSpace(value)
+					data.UpdateDevice(deviceIndex, device)
+				case "transport":
+					device.Transport = value
+					data.UpdateDevice(deviceIndex, device)
+				case "terminal":
+					device.TerminalOptions = strings.TrimSpace(value)
+					data.UpdateDevice(deviceIndex, device)
+				case "become":
+					device.Become = value
+					data.UpdateDevice(deviceIndex, device)
+				case "becomepassword":
+					device.BecomePassword = value
+					data.UpdateDevice(deviceIndex, device)
 				case "sshport":
-					if port, err := strconv.Atoi(value); err == nil {
+					if value = strings.TrimSpace(value); value == "" {
+						device.SSHPort = 0
+						data.UpdateDevice(deviceIndex, device)
+					} else if port, err := strconv.Atoi(value); err == nil {
 						device.SSHPort = port
 						data.UpdateDevice(deviceIndex, device)
 					}
@@ -948,6 +1463,7 @@ func connectToDevice(deviceIndex int, sshManager *pssh.SSHManager, parentWindow
 				if device.Connected {
 					// Disconnect
 					fmt.Printf("Disconnecting from %s...\n", device.IP)
+					forwardRegistry.StopHost(device.IP)
 					if conn, exists := sshManager.GetConnection(device.IP); exists {
 						err := conn.Close()
 						if err != nil {
@@ -963,45 +1479,55 @@ func connectToDevice(deviceIndex int, sshManager *pssh.SSHManager, parentWindow
 					data.UpdateDevice(deviceIndex, device)
 				} else {
 					// Connect
-					if device.Username == "" || device.Password == "" {
-						dialog.ShowError(fmt.Errorf("username and password are required"), parentWindow)
+					config := newDeviceConnectionConfig(device, parentWindow)
+					if config.Username == "" {
+						dialog.ShowError(fmt.Errorf("username is required"), parentWindow)
 						return
 					}
-
-					sshPort := device.SSHPort
-					if sshPort == 0 {
-						sshPort = settings.Current.DefaultSSHPort
-					}
-
-					config := pssh.ConnectionConfig{
-						Host:     device.IP,
-						Port:     sshPort,
-						Username: device.Username,
-						Password: device.Password,
-						Timeout:  settings.Current.GetConnectionTimeout(),
+					if config.Protocol != pssh.ProtocolTelnet && config.Password == "" && config.KeyPath == "" && !config.UseAgent {
+						dialog.ShowError(fmt.Errorf("a password, key file or ssh-agent is required"), parentWindow)
+						return
 					}
 
-					// Use the manager to connect (which stores the connection)
-					fmt.Printf("Attempting to connect to %s...\n", device.IP)
-					resultChan := sshManager.ConnectMultiple([]pssh.ConnectionConfig{config})
-
-					// Process the result
-					for result := range resultChan {
-						if result.Error != nil {
-							fmt.Printf("Connection failed for %s: %v\n", device.IP, result.Error)
-							dialog.ShowError(fmt.Errorf("failed to connect to %s: %v", device.IP, result.Error), parentWindow)
-							return
-						}
+					// Connect in the background so host key prompts can be answered on the UI thread
+					go func() {
+						defer fyne.Do(table.Refresh)
+
+						// Use the manager to connect (which stores the connection)
+						fmt.Printf("Attempting to connect to %s...\n", device.IP)
+						sshManager.SetKeepalive(keepaliveConfigFromSettings())
+						sshManager.SetMaxConcurrency(settings.Current.MaxParallelHosts)
+						resultChan := sshManager.ConnectMultiple([]pssh.ConnectionConfig{config})
+
+						// Process the result
+						for result := range resultChan {
+							if result.Error != nil {
+								fmt.Printf("Connection failed for %s: %v\n", device.IP, result.Error)
+								if pssh.IsHostKeyMismatch(result.Error) {
+									device.Status = "Host key mismatch"
+									data.UpdateDevice(deviceIndex, device)
+									fyne.Do(func() {
+										showHostKeyMismatchDialog(deviceIndex, config, result.Error, parentWindow)
+									})
+									return
+								}
+								fyne.Do(func() {
+									dialog.ShowError(fmt.Errorf("failed to connect to %s: %v", device.IP, result.Error), parentWindow)
+								})
+								return
+							}
 
-						if result.Connection.Connected {
-							fmt.Printf("Successfully connected to %s\n", device.IP)
-							device.Connected = true
-							device.Status = "Connected"
-							data.UpdateDevice(deviceIndex, device)
-						} else {
-							fmt.Printf("Connection to %s reported as not connected\n", device.IP)
+							if result.Connection.Connected {
+								fmt.Printf("Successfully connected to %s\n", device.IP)
+								device.Connected = true
+								device.Status = "Connected"
+								device.HostKey = result.Connection.HostKeyFingerprint
+								data.UpdateDevice(deviceIndex, device)
+							} else {
+								fmt.Printf("Connection to %s reported as not connected\n", device.IP)
+							}
 						}
-					}
+					}()
 				}
 				table.Refresh()
 			}
@@ -1009,6 +1535,181 @@ func connectToDevice(deviceIndex int, sshManager *pssh.SSHManager, parentWindow
 	}
 }
 
+// newDeviceConnectionConfig builds the SSH connection configuration for a device.
+// Values set on the device win over ~/.ssh/config, which wins over the application defaults.
+func newDeviceConnectionConfig(device scanner.Device, parentWindow fyne.Window) pssh.ConnectionConfig {
+	if usesAPI(device) {
+		return pssh.ConnectionConfig{
+			Host:     device.IP,
+			Port:     apiPort(device),
+			Username: deviceUsername(device),
+			Password: device.Password,
+			Timeout:  settings.Current.GetConnectionTimeout(),
+			Protocol: pssh.Protocol(device.Transport),
+		}
+	}
+	terminalOptions, err := pssh.ParseTerminalOptions(device.TerminalOptions)
+	if err != nil {
+		fmt.Printf("Warning: ignoring invalid terminal options for %s: %v\n", device.IP, err)
+		terminalOptions = pssh.TerminalOptions{}
+	}
+	if usesTelnet(device) {
+		return pssh.ConnectionConfig{
+			Host:     device.IP,
+			Port:     settings.Current.DefaultTelnetPort,
+			Username: deviceUsername(device),
+			Password: device.Password,
+			Timeout:  settings.Current.GetConnectionTimeout(),
+			Protocol: pssh.ProtocolTelnet,
+			Terminal: terminalOptions,
+
+			Become:         pssh.BecomeMethod(device.Become),
+			BecomePassword: device.BecomePassword,
+		}
+	}
+
+	// A port or username left unset on the device comes from ~/.ssh/config or the defaults
+	config := pssh.ConnectionConfig{
+		Host:               device.IP,
+		Port:               device.SSHPort,
+		Username:           device.Username,
+		Password:           device.Password,
+		KeyPath:            device.KeyPath,
+		UseAgent:           settings.Current.UseSSHAgent,
+		HostKeyPolicy:      pssh.HostKeyPolicy(settings.Current.HostKeyPolicy),
+		KnownHostsFile:     settings.Current.KnownHostsPath,
+		HostKeyFingerprint: device.HostKey,
+		Terminal:           terminalOptions,
+		Become:             pssh.BecomeMethod(device.Become),
+		BecomePassword:     device.BecomePassword,
+	}
+
+	if device.JumpHost != "" {
+		via, err := pssh.ParseJumpHosts(device.JumpHost, 0)
+		if err != nil {
+			fmt.Printf("Warning: ignoring invalid jump host for %s: %v\n", device.IP, err)
+		}
+		config.Via = via
+	}
+
+	pssh.ApplySSHConfig(&config)
+
+	if config.Port == 0 {
+		config.Port = settings.Current.DefaultSSHPort
+	}
+	if config.Username == "" {
+		config.Username = settings.Current.DefaultSSHUsername
+	}
+	if config.Timeout == 0 {
+		config.Timeout = settings.Current.GetConnectionTimeout()
+	}
+
+	if parentWindow != nil {
+		config.HostKeyPrompt = pssh.NewHostKeyPrompt(parentWindow)
+		config.PassphrasePrompt = pssh.NewPassphrasePrompt(parentWindow)
+	}
+
+	return config
+}
+
+// deviceUsername returns the username set on the device, or the default username
+func deviceUsername(device scanner.Device) string {
+	if device.Username == "" {
+		return settings.Current.DefaultSSHUsername
+	}
+	return device.Username
+}
+
+// canConnect reports whether the device can be reached over SSH, telnet or the RouterOS API
+func canConnect(device scanner.Device) bool {
+	return device.SSHStatus || device.TELNETStatus || usesAPI(device)
+}
+
+// usesTelnet reports whether the device can only be reached over telnet
+func usesTelnet(device scanner.Device) bool {
+	return !device.SSHStatus && device.TELNETStatus && !usesAPI(device)
+}
+
+// usesAPI reports whether the device is set to use the RouterOS API
+func usesAPI(device scanner.Device) bool {
+	return device.Transport == string(pssh.ProtocolAPI) || device.Transport == string(pssh.ProtocolAPITLS)
+}
+
+// apiPort returns the port of the RouterOS API service a device uses
+func apiPort(device scanner.Device) int {
+	if device.Transport == string(pssh.ProtocolAPITLS) {
+		return routeros.DefaultTLSPort
+	}
+	return routeros.DefaultPort
+}
+
+// selectedConnections returns the manager connections of the selected connected devices
+func selectedConnections(selectedDevices map[int]bool, sshManager *pssh.SSHManager) []*pssh.SSHConnection {
+	var connections []*pssh.SSHConnection
+	for deviceIndex, selected := range selectedDevices {
+		if !selected || deviceIndex >= data.DeviceList.Length() {
+			continue
+		}
+		if deviceObj, err := data.DeviceList.GetValue(deviceIndex); err == nil {
+			if device, ok := deviceObj.(scanner.Device); ok && device.Connected {
+				if conn, exists := sshManager.GetConnection(device.IP); exists {
+					connections = append(connections, conn)
+				}
+			}
+		}
+	}
+	return connections
+}
+
+// keepaliveConfigFromSettings builds the connection health monitoring from the settings
+func keepaliveConfigFromSettings() pssh.KeepaliveConfig {
+	config := pssh.DefaultKeepaliveConfig()
+	config.Interval = settings.Current.GetKeepaliveInterval()
+	config.MaxMissed = settings.Current.KeepaliveMaxMissed
+	config.Reconnect = settings.Current.AutoReconnect
+	return config
+}
+
+// updateDeviceHealth reflects connection health changes in the devices table
+func updateDeviceHealth(event pssh.HealthEvent) {
+	_, deviceIndex, found := data.GetDeviceByIP(event.Host)
+	if !found {
+		return
+	}
+
+	switch event.State {
+	case pssh.HealthConnected:
+		data.UpdateDeviceConnection(deviceIndex, true)
+	case pssh.HealthDegraded:
+		data.UpdateDeviceConnectionStatus(deviceIndex, true, "Degraded (no keepalive reply)")
+	case pssh.HealthLost:
+		data.UpdateDeviceConnectionStatus(deviceIndex, false, "Connection lost")
+	case pssh.HealthReconnecting:
+		data.UpdateDeviceConnectionStatus(deviceIndex, false, fmt.Sprintf("Reconnecting (attempt %d)", event.Attempt))
+	}
+}
+
+// showHostKeyMismatchDialog reports a changed host key and offers to forget the stored one
+func showHostKeyMismatchDialog(deviceIndex int, config pssh.ConnectionConfig, err error, parent fyne.Window) {
+	message := fmt.Sprintf("%v\n\nThe connection has been refused. If the device was replaced or reinstalled on purpose,\n"+
+		"forget the stored key and connect again to review and trust the new one.", err)
+
+	confirm := dialog.NewConfirm("Host Key Changed", message, func(forget bool) {
+		if !forget {
+			return
+		}
+
+		if err := pssh.RemoveKnownHost(config.KnownHostsFile, config.Host, config.Port); err != nil {
+			dialog.ShowError(fmt.Errorf("failed to remove known host entry: %v", err), parent)
+			return
+		}
+		data.UpdateDeviceHostKey(deviceIndex, "")
+	}, parent)
+	confirm.SetConfirmText("Forget Key")
+	confirm.SetDismissText("Close")
+	confirm.Show()
+}
+
 // removeSelectedDevices removes selected devices from the device list and database
 func removeSelectedDevices(selectedDevices map[int]bool) {
 	// Get all current devices
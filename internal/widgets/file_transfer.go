@@ -0,0 +1,252 @@
+package widgets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ispapp/psshclient/internal/windows"
+	"github.com/ispapp/psshclient/pkg/pssh"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// transferRow shows the progress of one host in the file transfer window
+type transferRow struct {
+	progress *widget.ProgressBar
+	status   *widget.Label
+}
+
+// showFileTransferDialog opens a window pushing a local file to, or pulling a remote
+// file from, all given connections
+func showFileTransferDialog(connections []*pssh.SSHConnection, push bool, parent fyne.Window) {
+	title := "Pull File"
+	if push {
+		title = "Push File"
+	}
+	win, err := windows.WinManager.NewWindow(title, "file_transfer")
+	if err != nil {
+		fmt.Printf("Failed to create window: %v\n", err)
+		return
+	}
+
+	localEntry := widget.NewEntry()
+	remoteEntry := widget.NewEntry()
+	var localLabel string
+	var browseBtn *widget.Button
+	if push {
+		localLabel = "Local file:"
+		remoteEntry.SetPlaceHolder("Remote path, e.g. /flash/firmware.npk")
+		browseBtn = widget.NewButtonWithIcon("Browse", theme.FolderOpenIcon(), func() {
+			fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+				if err != nil {
+					dialog.ShowError(err, win.Window)
+					return
+				}
+				if reader == nil {
+					return // User cancelled
+				}
+				reader.Close()
+				localEntry.SetText(reader.URI().Path())
+				if remoteEntry.Text == "" {
+					remoteEntry.SetText(filepath.Base(reader.URI().Path()))
+				}
+			}, win.Window)
+			fileDialog.Show()
+		})
+	} else {
+		localLabel = "Local folder:"
+		remoteEntry.SetPlaceHolder("Remote path, e.g. /flash/backup.rsc")
+		localEntry.SetPlaceHolder("One subfolder per host is created here")
+		browseBtn = widget.NewButtonWithIcon("Browse", theme.FolderOpenIcon(), func() {
+			folderDialog := dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+				if err != nil {
+					dialog.ShowError(err, win.Window)
+					return
+				}
+				if uri == nil {
+					return // User cancelled
+				}
+				localEntry.SetText(uri.Path())
+			}, win.Window)
+			folderDialog.Show()
+		})
+	}
+
+	executorOptions, readExecutorOptions := newExecutorOptions(connections)
+
+	// One row per host with its progress
+	sorted := sortedConnections(connections)
+	rows := make([]transferRow, len(sorted))
+	hostRows := container.NewVBox()
+	for i, conn := range sorted {
+		rows[i] = transferRow{progress: widget.NewProgressBar(), status: widget.NewLabel("waiting")}
+		hostRows.Add(container.NewBorder(nil, nil,
+			widget.NewLabel(conn.Config.Host), rows[i].status,
+			rows[i].progress,
+		))
+	}
+	hostScroll := container.NewVScroll(hostRows)
+	hostScroll.SetMinSize(fyne.NewSize(600, 250))
+	summary := widget.NewLabel("")
+	summary.Wrapping = fyne.TextWrapWord
+
+	var startBtn, stopBtn *widget.Button
+	stopBtn = widget.NewButtonWithIcon("Stop", theme.MediaStopIcon(), nil)
+	stopBtn.Importance = widget.DangerImportance
+	stopBtn.Disable()
+
+	startBtn = widget.NewButtonWithIcon(title, theme.MediaPlayIcon(), func() {
+		localPath := strings.TrimSpace(localEntry.Text)
+		remotePath := strings.TrimSpace(remoteEntry.Text)
+		if localPath == "" || remotePath == "" {
+			dialog.ShowError(fmt.Errorf("both the local and the remote path are required"), win.Window)
+			return
+		}
+		executorConfig, err := readExecutorOptions()
+		if err != nil {
+			dialog.ShowError(err, win.Window)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopBtn.OnTapped = func() {
+			stopBtn.Disable()
+			cancel()
+		}
+		startBtn.Disable()
+		stopBtn.Enable()
+		summary.SetText("")
+		for _, row := range rows {
+			row.progress.SetValue(0)
+			row.status.SetText("waiting")
+		}
+
+		// Progress is reported per chunk, only whole percents are shown
+		percents := make([]int, len(rows))
+		callbacks := pssh.TransferCallbacks{
+			OnStart: func(index int) {
+				fyne.Do(func() {
+					rows[index].status.SetText("transferring")
+				})
+			},
+			OnProgress: func(index int, done, total int64) {
+				percent := 100
+				if total > 0 {
+					percent = int(done * 100 / total)
+				}
+				if percent == percents[index] {
+					return
+				}
+				percents[index] = percent
+				fyne.Do(func() {
+					rows[index].progress.SetValue(float64(percent) / 100)
+				})
+			},
+			OnResult: func(index int, result *pssh.TransferResult) {
+				fyne.Do(func() {
+					rows[index].status.SetText(transferStatus(result))
+				})
+			},
+		}
+
+		go func() {
+			defer cancel()
+			var results []*pssh.TransferResult
+			var aborted error
+			if push {
+				results, aborted = pssh.PushFile(ctx, sorted, localPath, remotePath, executorConfig, callbacks)
+			} else {
+				results, aborted = pssh.PullFile(ctx, sorted, remotePath, localPath, executorConfig, callbacks)
+			}
+
+			fyne.Do(func() {
+				startBtn.Enable()
+				stopBtn.Disable()
+				for i, result := range results {
+					if errors.Is(result.Error, pssh.ErrSkipped) {
+						rows[i].status.SetText(transferStatus(result))
+					}
+				}
+				summary.SetText(transferSummary(results, aborted))
+			})
+		}()
+	})
+
+	content := container.NewBorder(
+		container.NewVBox(
+			container.NewBorder(nil, nil, widget.NewLabel(localLabel), browseBtn, localEntry),
+			container.NewBorder(nil, nil, widget.NewLabel("Remote path:"), nil, remoteEntry),
+			executorOptions,
+			container.NewHBox(startBtn, stopBtn),
+		),
+		summary,
+		nil, nil,
+		hostScroll,
+	)
+
+	win.Window.SetContent(content)
+	win.Window.Resize(fyne.NewSize(750, 550))
+	win.Window.Show()
+}
+
+// transferStatus describes how the transfer with one host ended
+func transferStatus(result *pssh.TransferResult) string {
+	switch {
+	case errors.Is(result.Error, pssh.ErrSkipped):
+		return "– skipped"
+	case result.Error != nil:
+		return "✗ " + result.Error.Error()
+	case result.Verified:
+		return fmt.Sprintf("✓ verified, %s in %s", formatTransferSize(result.Bytes), result.Duration().Round(time.Millisecond))
+	default:
+		return fmt.Sprintf("✓ %s in %s, sha256 %s", formatTransferSize(result.Bytes), result.Duration().Round(time.Millisecond), result.Checksum[:12])
+	}
+}
+
+// transferSummary counts the hosts that succeeded and lists the failures
+func transferSummary(results []*pssh.TransferResult, aborted error) string {
+	succeeded, skipped := 0, 0
+	var failures []string
+	for _, result := range results {
+		switch {
+		case result.Error == nil:
+			succeeded++
+		case errors.Is(result.Error, pssh.ErrSkipped):
+			skipped++
+		default:
+			failures = append(failures, fmt.Sprintf("%s: %v", result.Host, result.Error))
+		}
+	}
+
+	text := fmt.Sprintf("%d of %d hosts succeeded, %d failed", succeeded, len(results), len(failures))
+	if skipped > 0 {
+		text += fmt.Sprintf(", %d skipped", skipped)
+	}
+	if aborted != nil {
+		text += fmt.Sprintf(" (%v)", aborted)
+	}
+	if len(failures) > 0 {
+		text += "\n\nFailures:\n" + strings.Join(failures, "\n")
+	}
+	return text
+}
+
+// formatTransferSize formats a byte count for display
+func formatTransferSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
@@ -0,0 +1,296 @@
+package widgets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ispapp/psshclient/internal/data"
+	"github.com/ispapp/psshclient/internal/database"
+	"github.com/ispapp/psshclient/internal/windows"
+	"github.com/ispapp/psshclient/pkg/pssh"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/fyne-io/terminal"
+)
+
+// allDevices is the device filter option showing every recording
+const allDevices = "All devices"
+
+// replaySpeeds maps the speed select options to replay speeds
+var replaySpeeds = map[string]float64{"0.5x": 0.5, "1x": 1, "2x": 2, "4x": 4, "8x": 8}
+
+// ShowRecordingsWindow lists the recorded terminal sessions, newest first, and
+// replays the selected one
+func ShowRecordingsWindow() {
+	win, err := windows.WinManager.NewWindow("Session Recordings", "recordings")
+	if err != nil {
+		fmt.Printf("Failed to create window: %v\n", err)
+		return
+	}
+	if data.DB == nil {
+		win.Window.SetContent(widget.NewLabel("The database is not available, recordings cannot be listed."))
+		win.Window.Show()
+		return
+	}
+
+	var recordings []database.SessionRecording
+	selected := -1
+
+	deviceSelect := widget.NewSelect([]string{allDevices}, nil)
+	list := widget.NewList(
+		func() int {
+			return len(recordings)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("")
+		},
+		func(id widget.ListItemID, object fyne.CanvasObject) {
+			recording := recordings[id]
+			duration := "recording…"
+			if recording.Duration > 0 {
+				duration = recording.Duration.Round(time.Second).String()
+			}
+			object.(*widget.Label).SetText(fmt.Sprintf("%s   %s   %s",
+				recording.StartedAt.Local().Format("2006-01-02 15:04:05"), recording.Title, duration))
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		selected = id
+	}
+
+	refresh := func() {
+		device := deviceSelect.Selected
+		if device == allDevices {
+			device = ""
+		}
+		loaded, err := data.DB.LoadRecordings(device)
+		if err != nil {
+			dialog.ShowError(err, win.Window)
+			return
+		}
+		recordings = loaded
+		selected = -1
+		list.UnselectAll()
+		list.Refresh()
+
+		if device == "" {
+			devices := []string{allDevices}
+			seen := make(map[string]bool)
+			for _, recording := range loaded {
+				if !seen[recording.DeviceIP] {
+					seen[recording.DeviceIP] = true
+					devices = append(devices, recording.DeviceIP)
+				}
+			}
+			sort.Strings(devices[1:])
+			deviceSelect.Options = devices
+			deviceSelect.Refresh()
+		}
+	}
+	deviceSelect.OnChanged = func(string) {
+		refresh()
+	}
+
+	playBtn := widget.NewButtonWithIcon("Play", theme.MediaPlayIcon(), func() {
+		if selected < 0 || selected >= len(recordings) {
+			return
+		}
+		showRecordingPlayer(recordings[selected], win.Window)
+	})
+	deleteBtn := widget.NewButtonWithIcon("Delete", theme.DeleteIcon(), func() {
+		if selected < 0 || selected >= len(recordings) {
+			return
+		}
+		recording := recordings[selected]
+		dialog.ShowConfirm("Delete Recording",
+			fmt.Sprintf("Delete the recording of %s started %s?", recording.Title,
+				recording.StartedAt.Local().Format("2006-01-02 15:04:05")),
+			func(confirmed bool) {
+				if !confirmed {
+					return
+				}
+				if err := data.DB.DeleteRecording(recording.ID); err != nil {
+					dialog.ShowError(err, win.Window)
+					return
+				}
+				if err := os.Remove(recording.Path); err != nil && !os.IsNotExist(err) {
+					dialog.ShowError(fmt.Errorf("failed to delete %s: %v", recording.Path, err), win.Window)
+				}
+				refresh()
+			}, win.Window)
+	})
+	refreshBtn := widget.NewButtonWithIcon("Refresh", theme.ViewRefreshIcon(), refresh)
+
+	deviceSelect.SetSelected(allDevices)
+
+	toolbar := container.NewHBox(widget.NewLabel("Device:"), deviceSelect, playBtn, deleteBtn, refreshBtn)
+	win.Window.SetContent(container.NewBorder(toolbar, nil, nil, nil, list))
+	win.Window.Resize(fyne.NewSize(700, 450))
+	win.Window.Show()
+}
+
+// showRecordingPlayer replays a recording in a terminal, with pause, speed and an
+// option to shorten long pauses
+func showRecordingPlayer(recording database.SessionRecording, parent fyne.Window) {
+	file, err := os.Open(recording.Path)
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("failed to open recording: %v", err), parent)
+		return
+	}
+	header, events, err := pssh.ReadAsciicast(file)
+	file.Close()
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("failed to read recording: %v", err), parent)
+		return
+	}
+
+	win, err := windows.WinManager.NewWindow(fmt.Sprintf("Replay — %s", recording.Title), "player")
+	if err != nil {
+		fmt.Printf("Failed to create window: %v\n", err)
+		return
+	}
+
+	// The terminal reads the replayed output from a pipe, what it would send to
+	// the device is dropped
+	output, input := io.Pipe()
+	t := terminal.New()
+	go t.RunWithConnection(discardWriter{}, output)
+
+	// The terminal has the size of the recorded one, resize events change it
+	cols, rows := header.Width, header.Height
+	screen := container.NewGridWrap(pssh.TerminalSize(cols, rows), t)
+	scroll := container.NewScroll(screen)
+
+	var total float64
+	if len(events) > 0 {
+		total = events[len(events)-1].Time
+	}
+	status := widget.NewLabel("")
+	position := 0
+	showPosition := func() {
+		var elapsed float64
+		if position > 0 {
+			elapsed = events[position-1].Time
+		}
+		status.SetText(fmt.Sprintf("%s / %s   %dx%d",
+			time.Duration(elapsed*float64(time.Second)).Round(time.Second),
+			time.Duration(total*float64(time.Second)).Round(time.Second),
+			cols, rows))
+	}
+	showPosition()
+	resize := func(newCols, newRows int) {
+		cols, rows = newCols, newRows
+		screen.Layout = layout.NewGridWrapLayout(pssh.TerminalSize(cols, rows))
+		screen.Refresh()
+		scroll.Refresh()
+		showPosition()
+	}
+
+	speedSelect := widget.NewSelect([]string{"0.5x", "1x", "2x", "4x", "8x"}, nil)
+	speedSelect.SetSelected("1x")
+	skipIdleCheck := widget.NewCheck("Shorten pauses", nil)
+	skipIdleCheck.SetChecked(true)
+
+	// Only touched on the UI thread; running stays set until the replay goroutine
+	// has reported where it stopped
+	var cancel context.CancelFunc
+	var running, resume bool
+	var playBtn *widget.Button
+	stop := func() {
+		if cancel != nil {
+			cancel()
+		}
+	}
+	var play func()
+	play = func() {
+		if running {
+			return
+		}
+		running = true
+		restart := position >= len(events)
+		if restart {
+			position = 0
+			resize(header.Width, header.Height)
+		}
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		maxIdle := time.Duration(0)
+		if skipIdleCheck.Checked {
+			maxIdle = 2 * time.Second
+		}
+		playBtn.SetText("Pause")
+		playBtn.SetIcon(theme.MediaPauseIcon())
+
+		start, speed := position, replaySpeeds[speedSelect.Selected]
+		go func() {
+			if restart {
+				// Start over on a reset screen
+				io.WriteString(input, "\x1bc")
+			}
+			played, _ := pssh.ReplayAsciicast(ctx, events[start:], input, speed, maxIdle, func(cols, rows int) {
+				fyne.DoAndWait(func() {
+					resize(cols, rows)
+				})
+			})
+			fyne.Do(func() {
+				position = start + played
+				running, cancel = false, nil
+				showPosition()
+				playBtn.SetText("Play")
+				playBtn.SetIcon(theme.MediaPlayIcon())
+				if resume {
+					resume = false
+					play()
+				}
+			})
+		}()
+	}
+	playBtn = widget.NewButtonWithIcon("Play", theme.MediaPlayIcon(), func() {
+		if running {
+			resume = false
+			stop()
+			return
+		}
+		play()
+	})
+	speedSelect.OnChanged = func(string) {
+		if running {
+			// Go on at the new speed once the replay has stopped
+			resume = true
+			stop()
+		}
+	}
+
+	win.Window.SetOnClosed(func() {
+		resume = false
+		stop()
+		input.Close()
+	})
+
+	controls := container.NewHBox(playBtn, widget.NewLabel("Speed:"), speedSelect, skipIdleCheck, status)
+	win.Window.SetContent(container.NewBorder(nil, controls, nil, nil, scroll))
+	size := screen.MinSize()
+	win.Window.Resize(fyne.NewSize(max(size.Width, controls.MinSize().Width), size.Height+controls.MinSize().Height).AddWidthHeight(theme.Padding()*4, theme.Padding()*4))
+	win.Window.Show()
+	play()
+}
+
+// discardWriter drops what the replay terminal sends
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discardWriter) Close() error {
+	return nil
+}
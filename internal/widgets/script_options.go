@@ -0,0 +1,79 @@
+package widgets
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ispapp/psshclient/internal/settings"
+	"github.com/ispapp/psshclient/pkg/pssh"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const noCanary = "None"
+
+// newExecutorOptions creates the fan-out options of the Run Script window and a
+// function reading them into an executor configuration
+func newExecutorOptions(connections []*pssh.SSHConnection) (fyne.CanvasObject, func() (pssh.ExecutorConfig, error)) {
+	// Left empty, the limit in the settings at the time the script starts applies
+	parallelEntry := widget.NewEntry()
+	parallelEntry.SetPlaceHolder("from settings")
+
+	batchEntry := widget.NewEntry()
+	batchEntry.SetPlaceHolder("all, e.g. 10 or 10%")
+
+	canaryOptions := []string{noCanary}
+	for _, conn := range connections {
+		canaryOptions = append(canaryOptions, conn.Config.Host)
+	}
+	canarySelect := widget.NewSelect(canaryOptions, nil)
+	canarySelect.SetSelected(noCanary)
+
+	abortEntry := widget.NewEntry()
+	abortEntry.SetPlaceHolder("never")
+
+	form := container.NewGridWithColumns(4,
+		widget.NewLabel("Max parallel hosts:"), parallelEntry,
+		widget.NewLabel("Batch size:"), batchEntry,
+		widget.NewLabel("Canary host:"), canarySelect,
+		widget.NewLabel("Stop after failures:"), abortEntry,
+	)
+	options := widget.NewAccordion(widget.NewAccordionItem("Execution Options", form))
+
+	read := func() (pssh.ExecutorConfig, error) {
+		var config pssh.ExecutorConfig
+
+		config.MaxConcurrency = settings.Current.MaxParallelHosts
+		if text := strings.TrimSpace(parallelEntry.Text); text != "" {
+			parallel, err := strconv.Atoi(text)
+			if err != nil || parallel <= 0 {
+				return config, fmt.Errorf("max parallel hosts must be a number greater than 0")
+			}
+			config.MaxConcurrency = parallel
+		}
+
+		config.BatchSize = strings.TrimSpace(batchEntry.Text)
+		if _, err := pssh.ParseBatchSize(config.BatchSize, len(connections)); err != nil {
+			return config, err
+		}
+
+		if canarySelect.Selected != noCanary {
+			config.Canary = canarySelect.Selected
+		}
+
+		if text := strings.TrimSpace(abortEntry.Text); text != "" {
+			failures, err := strconv.Atoi(text)
+			if err != nil || failures < 0 {
+				return config, fmt.Errorf("stop after failures must be a number of hosts")
+			}
+			config.AbortAfter = failures
+		}
+
+		return config, nil
+	}
+
+	return options, read
+}
@@ -0,0 +1,350 @@
+package widgets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ispapp/psshclient/internal/windows"
+	"github.com/ispapp/psshclient/pkg/pssh"
+	"github.com/ispapp/psshclient/pkg/routeros"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// commandResultColor returns the theme color used for a host depending on how its command ended
+func commandResultColor(result *pssh.CommandResult) fyne.ThemeColorName {
+	switch {
+	case result.Success():
+		return theme.ColorNameSuccess
+	case result.Error != nil || result.Signal != "":
+		return theme.ColorNameWarning
+	default:
+		return theme.ColorNameError
+	}
+}
+
+// commandResultIcon returns a short status marker for a command result
+func commandResultIcon(result *pssh.CommandResult) string {
+	switch {
+	case result.Success():
+		return "✓"
+	case errors.Is(result.Error, pssh.ErrSkipped):
+		return "–"
+	case errors.Is(result.Error, context.DeadlineExceeded):
+		return "⏱"
+	case result.EscalationFailed():
+		return "🔒"
+	case result.Error != nil:
+		return "⚠"
+	default:
+		return "✗"
+	}
+}
+
+// sortedConnections returns the connections ordered by host
+func sortedConnections(connections []*pssh.SSHConnection) []*pssh.SSHConnection {
+	sorted := append([]*pssh.SSHConnection{}, connections...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Config.Host < sorted[j].Config.Host
+	})
+	return sorted
+}
+
+// newCommandSummary shows how many hosts succeeded, failed or were skipped and why
+// the run was aborted
+func newCommandSummary(results []*pssh.CommandResult, aborted error) fyne.CanvasObject {
+	succeeded, skipped, escalation := 0, 0, 0
+	for _, result := range results {
+		switch {
+		case result.Success():
+			succeeded++
+		case errors.Is(result.Error, pssh.ErrSkipped):
+			skipped++
+		case result.EscalationFailed():
+			escalation++
+		}
+	}
+	failed := len(results) - succeeded - skipped
+
+	colorName := theme.ColorNameSuccess
+	if succeeded < len(results) {
+		colorName = theme.ColorNameError
+	}
+
+	text := fmt.Sprintf("%d of %d hosts succeeded, %d failed", succeeded, len(results), failed)
+	if escalation > 0 {
+		text += fmt.Sprintf(" (%d could not escalate privileges)", escalation)
+	}
+	if skipped > 0 {
+		text += fmt.Sprintf(", %d skipped", skipped)
+	}
+	if aborted != nil {
+		text += fmt.Sprintf(" (%v)", aborted)
+	}
+
+	return widget.NewRichText(&widget.TextSegment{
+		Text: text,
+		Style: widget.RichTextStyle{
+			ColorName: colorName,
+			TextStyle: fyne.TextStyle{Bold: true},
+		},
+	})
+}
+
+// hostOutputView streams the output of one host into a collapsible card. Output is
+// collected from the command goroutines and shown on the next refresh.
+type hostOutputView struct {
+	host       string
+	item       *widget.AccordionItem
+	status     *widget.RichText // How the command ended, colored by outcome
+	text       *widget.RichText
+	recordsBtn *widget.Button // Shown when the host answered over the RouterOS API
+	started    time.Time
+	result     *pssh.CommandResult
+	pending    []pssh.OutputLine
+	output     strings.Builder
+	mutex      sync.Mutex
+}
+
+// newHostOutputView creates the card of a host with buttons to copy or save its output
+func newHostOutputView(host string, window fyne.Window) *hostOutputView {
+	view := &hostOutputView{host: host}
+	view.status = widget.NewRichText()
+	view.status.Hide()
+	view.text = widget.NewRichText()
+	view.text.Wrapping = fyne.TextWrapWord
+
+	copyBtn := widget.NewButtonWithIcon("Copy", theme.ContentCopyIcon(), func() {
+		fyne.CurrentApp().Clipboard().SetContent(view.Output())
+	})
+	saveBtn := widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), func() {
+		saveHostOutput(view.host, view.Output(), window)
+	})
+	view.recordsBtn = widget.NewButtonWithIcon("Records", theme.GridIcon(), func() {
+		view.mutex.Lock()
+		records := view.result.Records
+		view.mutex.Unlock()
+		showRecordsWindow(view.host, records)
+	})
+	view.recordsBtn.Hide()
+
+	view.item = widget.NewAccordionItem(view.title(), container.NewBorder(
+		container.NewVBox(view.status, container.NewHBox(copyBtn, saveBtn, view.recordsBtn)), nil, nil, nil,
+		view.text,
+	))
+	return view
+}
+
+// start marks the host as running
+func (view *hostOutputView) start() {
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+	view.started = time.Now()
+}
+
+// addLine queues a line of output for the next refresh
+func (view *hostOutputView) addLine(line pssh.OutputLine) {
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+	view.pending = append(view.pending, line)
+	view.output.WriteString(line.Text)
+	view.output.WriteString("\n")
+}
+
+// finish records the result of the host
+func (view *hostOutputView) finish(result *pssh.CommandResult) {
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+	view.result = result
+}
+
+// Output returns the output received so far, stdout and stderr in arrival order
+func (view *hostOutputView) Output() string {
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+	return view.output.String()
+}
+
+// refresh shows the queued output and current status, it must run on the UI thread
+func (view *hostOutputView) refresh() {
+	view.mutex.Lock()
+	pending := view.pending
+	view.pending = nil
+	view.item.Title = view.title()
+	result := view.result
+	view.mutex.Unlock()
+
+	if result != nil && !view.status.Visible() {
+		view.status.Segments = []widget.RichTextSegment{&widget.TextSegment{
+			Text: result.Status(),
+			Style: widget.RichTextStyle{
+				ColorName: commandResultColor(result),
+				TextStyle: fyne.TextStyle{Bold: true},
+			},
+		}}
+		view.status.Show()
+	}
+	if result != nil && len(result.Records) > 0 && !view.recordsBtn.Visible() {
+		view.recordsBtn.Show()
+	}
+	if len(pending) == 0 {
+		return
+	}
+	for _, line := range pending {
+		style := widget.RichTextStyleCodeBlock
+		if line.Stderr {
+			style = widget.RichTextStyle{
+				ColorName: theme.ColorNameError,
+				TextStyle: fyne.TextStyle{Monospace: true},
+			}
+		}
+		view.text.Segments = append(view.text.Segments, &widget.TextSegment{Text: line.Text, Style: style})
+	}
+	view.text.Refresh()
+}
+
+// title describes the state of the host, it must be called with the mutex held
+func (view *hostOutputView) title() string {
+	switch {
+	case view.result != nil:
+		return fmt.Sprintf("%s %s — %s — %s — %d B out, %d B err",
+			commandResultIcon(view.result), view.host, view.result.Status(),
+			view.result.Duration().Round(time.Millisecond), view.result.StdoutBytes, view.result.StderrBytes)
+	case !view.started.IsZero():
+		return fmt.Sprintf("⟳ %s — running — %s", view.host, time.Since(view.started).Round(time.Second))
+	default:
+		return fmt.Sprintf("… %s — waiting", view.host)
+	}
+}
+
+// showRecordsWindow shows the replies of RouterOS API commands as a table, with a
+// column for every attribute in the order they first appear
+func showRecordsWindow(host string, records []*routeros.Sentence) {
+	win, err := windows.WinManager.NewWindow(fmt.Sprintf("Records — %s", host), "records")
+	if err != nil {
+		fmt.Printf("Failed to create window: %v\n", err)
+		return
+	}
+
+	var columns []string
+	seen := make(map[string]bool)
+	for _, record := range records {
+		for _, pair := range record.List {
+			if !seen[pair.Key] {
+				seen[pair.Key] = true
+				columns = append(columns, pair.Key)
+			}
+		}
+	}
+
+	table := widget.NewTable(
+		func() (int, int) {
+			return len(records) + 1, len(columns)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("")
+		},
+		func(id widget.TableCellID, object fyne.CanvasObject) {
+			label := object.(*widget.Label)
+			if id.Row == 0 {
+				label.SetText(columns[id.Col])
+				label.TextStyle.Bold = true
+				return
+			}
+			label.SetText(records[id.Row-1].Map[columns[id.Col]])
+			label.TextStyle.Bold = false
+		},
+	)
+	for i := range columns {
+		table.SetColumnWidth(i, 150)
+	}
+
+	win.Window.SetContent(table)
+	win.Window.Resize(fyne.NewSize(800, 400))
+	win.Window.Show()
+}
+
+// saveHostOutput lets the user save the output of one host to a file
+func saveHostOutput(host, output string, window fyne.Window) {
+	fileDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		if writer == nil {
+			return // User cancelled
+		}
+		defer writer.Close()
+
+		if _, err := writer.Write([]byte(output)); err != nil {
+			dialog.ShowError(fmt.Errorf("failed to save output: %v", err), window)
+		}
+	}, window)
+
+	fileDialog.SetFileName(host + ".log")
+	fileDialog.Show()
+}
+
+// Output grouping modes of the Run Script window
+const (
+	groupExact    = "Exact output"
+	groupVolatile = "Mask uptime and timestamps"
+	groupNumbers  = "Mask all numbers"
+)
+
+// outputNormalizer returns the normalizer of a grouping mode
+func outputNormalizer(mode string) pssh.OutputNormalizer {
+	switch mode {
+	case groupVolatile:
+		normalize, err := pssh.NewMaskNormalizer(pssh.VolatileMasks...)
+		if err != nil {
+			fmt.Printf("Failed to compile output masks: %v\n", err)
+			return nil
+		}
+		return normalize
+	case groupNumbers:
+		return pssh.MaskNumbers
+	default:
+		return nil
+	}
+}
+
+// newOutputGroupsView shows one card per group of hosts with identical output and a
+// button copying the whole report
+func newOutputGroupsView(groups []pssh.OutputGroup) fyne.CanvasObject {
+	copyBtn := widget.NewButtonWithIcon("Copy Report", theme.ContentCopyIcon(), func() {
+		fyne.CurrentApp().Clipboard().SetContent(pssh.FormatOutputGroups(groups))
+	})
+
+	cards := widget.NewAccordion()
+	cards.MultiOpen = true
+	for _, group := range groups {
+		output := widget.NewRichText(&widget.TextSegment{
+			Text:  strings.TrimRight(group.Output, "\n"),
+			Style: widget.RichTextStyleCodeBlock,
+		})
+		output.Wrapping = fyne.TextWrapWord
+
+		title := fmt.Sprintf("%s hosts %s (%d) — %s",
+			commandResultIcon(group.Results[0]), pssh.FormatHostList(group.Hosts), len(group.Hosts), group.Status)
+		cards.Append(widget.NewAccordionItem(title, output))
+	}
+	if len(groups) > 0 {
+		cards.Open(0)
+	}
+
+	return container.NewVBox(
+		widget.NewLabel(fmt.Sprintf("%d distinct outputs", len(groups))),
+		copyBtn,
+		cards,
+	)
+}
@@ -2,8 +2,11 @@ package widgets
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/ispapp/psshclient/internal/data"
 	"github.com/ispapp/psshclient/internal/settings"
+	"github.com/ispapp/psshclient/pkg/pssh"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -24,6 +27,21 @@ func CreateSettingsTab(parentWindow fyne.Window) *container.Scroll {
 	timeoutEntry := widget.NewEntry()
 	timeoutEntry.SetText(settings.Current.GetConnectionTimeoutString())
 
+	// Connection Health
+	maxParallelEntry := widget.NewEntry()
+	maxParallelEntry.SetText(settings.Current.GetMaxParallelHostsString())
+
+	keepaliveEntry := widget.NewEntry()
+	keepaliveEntry.SetText(settings.Current.GetKeepaliveIntervalString())
+
+	maxMissedEntry := widget.NewEntry()
+	maxMissedEntry.SetText(settings.Current.GetKeepaliveMaxMissedString())
+
+	autoReconnectCheck := widget.NewCheck("Reconnect lost connections automatically", func(checked bool) {
+		settings.Current.AutoReconnect = checked
+	})
+	autoReconnectCheck.SetChecked(settings.Current.AutoReconnect)
+
 	// SSH Default Credentials
 	usernameEntry := widget.NewEntry()
 	usernameEntry.SetText(settings.Current.DefaultSSHUsername)
@@ -31,6 +49,22 @@ func CreateSettingsTab(parentWindow fyne.Window) *container.Scroll {
 	passwordEntry := widget.NewPasswordEntry()
 	passwordEntry.SetText(settings.Current.DefaultSSHPassword)
 
+	agentCheck := widget.NewCheck("Authenticate with ssh-agent (SSH_AUTH_SOCK)", func(checked bool) {
+		settings.Current.UseSSHAgent = checked
+	})
+	agentCheck.SetChecked(settings.Current.UseSSHAgent)
+
+	sshConfigEntry := widget.NewEntry()
+	sshConfigEntry.SetText(settings.Current.SSHConfigPath)
+	sshConfigEntry.SetPlaceHolder("Leave empty to ignore ~/.ssh/config")
+
+	// SSH Host Key Verification
+	hostKeyPolicySelect := widget.NewSelect([]string{"strict", "tofu", "ignore"}, nil)
+	hostKeyPolicySelect.SetSelected(settings.Current.HostKeyPolicy)
+
+	knownHostsEntry := widget.NewEntry()
+	knownHostsEntry.SetText(settings.Current.KnownHostsPath)
+
 	// Database Settings
 	dbPathEntry := widget.NewEntry()
 	dbPathEntry.SetText(settings.Current.DatabasePath)
@@ -63,18 +97,64 @@ func CreateSettingsTab(parentWindow fyne.Window) *container.Scroll {
 	cleanupDaysEntry.SetText(settings.Current.GetCleanupOldDaysString())
 
 	// Terminal Settings
+	termTypeEntry := widget.NewEntry()
+	termTypeEntry.SetText(settings.Current.TerminalType)
+
 	termRowsEntry := widget.NewEntry()
 	termRowsEntry.SetText(settings.Current.GetTerminalRowsString())
 
 	termColsEntry := widget.NewEntry()
 	termColsEntry.SetText(settings.Current.GetTerminalColsString())
 
+	termModesEntry := widget.NewEntry()
+	termModesEntry.SetText(settings.Current.TerminalModes)
+	termModesEntry.SetPlaceHolder("ECHO=1 TTY_OP_ISPEED=14400")
+
+	termEnvEntry := widget.NewEntry()
+	termEnvEntry.SetText(settings.Current.TerminalEnv)
+	termEnvEntry.SetPlaceHolder("LANG=en_US.UTF-8")
+
 	termFontEntry := widget.NewEntry()
 	termFontEntry.SetText(settings.Current.TerminalFont)
+	termFontEntry.SetPlaceHolder("monospace or a .ttf file")
+
+	termThemeSelect := widget.NewSelect([]string{"Follow application", "dark", "light"}, nil)
+	termThemeSelect.SetSelected(terminalThemeOption(settings.Current.TerminalTheme))
 
 	termFontSizeEntry := widget.NewEntry()
 	termFontSizeEntry.SetText(settings.Current.GetTerminalFontSizeString())
 
+	scrollbackEntry := widget.NewEntry()
+	scrollbackEntry.SetText(settings.Current.GetScrollbackLinesString())
+
+	// Session Logs
+	logCheck := widget.NewCheck("Log terminal output per host (timestamped plain text)", func(checked bool) {
+		settings.Current.LogSessions = checked
+	})
+	logCheck.SetChecked(settings.Current.LogSessions)
+
+	logsDirEntry := widget.NewEntry()
+	logsDirEntry.SetText(settings.Current.SessionLogsDir)
+
+	// Session Recording
+	recordCheck := widget.NewCheck("Record terminal sessions (asciicast v2)", func(checked bool) {
+		settings.Current.RecordSessions = checked
+	})
+	recordCheck.SetChecked(settings.Current.RecordSessions)
+
+	recordingsDirEntry := widget.NewEntry()
+	recordingsDirEntry.SetText(settings.Current.RecordingsDir)
+
+	// Broadcast Input
+	confirmPasteCheck := widget.NewCheck("Confirm multi-line pastes sent to several hosts", func(checked bool) {
+		settings.Current.ConfirmMultilinePaste = checked
+	})
+	confirmPasteCheck.SetChecked(settings.Current.ConfirmMultilinePaste)
+
+	dangerousEntry := widget.NewMultiLineEntry()
+	dangerousEntry.SetText(strings.Join(settings.Current.DangerousCommands, "\n"))
+	dangerousEntry.SetMinRowsVisible(4)
+
 	// Scanning Settings
 	scanTimeoutEntry := widget.NewEntry()
 	scanTimeoutEntry.SetText(settings.Current.GetScanTimeoutString())
@@ -100,14 +180,31 @@ func CreateSettingsTab(parentWindow fyne.Window) *container.Scroll {
 			errors = append(errors, "Invalid connection timeout: "+err.Error())
 		}
 
+		if err := settings.Current.SetMaxParallelHostsString(maxParallelEntry.Text); err != nil {
+			errors = append(errors, "Invalid max parallel hosts: "+err.Error())
+		}
+
+		if err := settings.Current.SetKeepaliveIntervalString(keepaliveEntry.Text); err != nil {
+			errors = append(errors, "Invalid keepalive interval: "+err.Error())
+		}
+
+		if err := settings.Current.SetKeepaliveMaxMissedString(maxMissedEntry.Text); err != nil {
+			errors = append(errors, "Invalid missed keepalives: "+err.Error())
+		}
+
 		settings.Current.DefaultSSHUsername = usernameEntry.Text
 		settings.Current.DefaultSSHPassword = passwordEntry.Text
+		settings.Current.SSHConfigPath = sshConfigEntry.Text
+		settings.Current.HostKeyPolicy = hostKeyPolicySelect.Selected
+		settings.Current.KnownHostsPath = knownHostsEntry.Text
 		settings.Current.DatabasePath = dbPathEntry.Text
 
 		if err := settings.Current.SetCleanupOldDaysString(cleanupDaysEntry.Text); err != nil {
 			errors = append(errors, "Invalid cleanup days: "+err.Error())
 		}
 
+		settings.Current.TerminalType = termTypeEntry.Text
+
 		if err := settings.Current.SetTerminalRowsString(termRowsEntry.Text); err != nil {
 			errors = append(errors, "Invalid terminal rows: "+err.Error())
 		}
@@ -116,12 +213,34 @@ func CreateSettingsTab(parentWindow fyne.Window) *container.Scroll {
 			errors = append(errors, "Invalid terminal columns: "+err.Error())
 		}
 
+		settings.Current.TerminalModes = termModesEntry.Text
+		settings.Current.TerminalEnv = termEnvEntry.Text
 		settings.Current.TerminalFont = termFontEntry.Text
+		settings.Current.TerminalTheme = ""
+		if termThemeSelect.SelectedIndex() > 0 {
+			settings.Current.TerminalTheme = termThemeSelect.Selected
+		}
 
 		if err := settings.Current.SetTerminalFontSizeString(termFontSizeEntry.Text); err != nil {
 			errors = append(errors, "Invalid terminal font size: "+err.Error())
 		}
 
+		if err := settings.Current.SetScrollbackLinesString(scrollbackEntry.Text); err != nil {
+			errors = append(errors, "Invalid scrollback lines: "+err.Error())
+		}
+
+		settings.Current.SessionLogsDir = logsDirEntry.Text
+
+		settings.Current.RecordingsDir = recordingsDirEntry.Text
+
+		var patterns []string
+		for _, pattern := range strings.Split(dangerousEntry.Text, "\n") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+		settings.Current.DangerousCommands = patterns
+
 		if err := settings.Current.SetScanTimeoutString(scanTimeoutEntry.Text); err != nil {
 			errors = append(errors, "Invalid scan timeout: "+err.Error())
 		}
@@ -134,6 +253,11 @@ func CreateSettingsTab(parentWindow fyne.Window) *container.Scroll {
 		validationErrors := settings.Current.Validate()
 		errors = append(errors, validationErrors...)
 
+		terminalOptions, err := data.TerminalOptions()
+		if err != nil {
+			errors = append(errors, "Invalid terminal settings: "+err.Error())
+		}
+
 		if len(errors) > 0 {
 			errorMsg := "Please fix the following errors:\n\n"
 			for _, err := range errors {
@@ -143,6 +267,14 @@ func CreateSettingsTab(parentWindow fyne.Window) *container.Scroll {
 			return
 		}
 
+		pssh.SetSSHConfigPath(settings.Current.SSHConfigPath)
+		pssh.SetConfirmMultilinePaste(settings.Current.ConfirmMultilinePaste)
+		pssh.SetDangerousCommands(settings.Current.DangerousCommands)
+		pssh.SetScrollbackLines(settings.Current.ScrollbackLines)
+		pssh.SetSessionLogDir(settings.Current.GetSessionLogDir())
+		pssh.SetDefaultTerminalOptions(terminalOptions)
+		pssh.ApplyTerminalAppearance()
+
 		// Save settings
 		if err := settings.Save(); err != nil {
 			dialog.ShowError(fmt.Errorf("failed to save settings: %v", err), parentWindow)
@@ -166,15 +298,34 @@ func CreateSettingsTab(parentWindow fyne.Window) *container.Scroll {
 					sshPortEntry.SetText(settings.Current.GetDefaultSSHPortString())
 					telnetPortEntry.SetText(settings.Current.GetDefaultTelnetPortString())
 					timeoutEntry.SetText(settings.Current.GetConnectionTimeoutString())
+					maxParallelEntry.SetText(settings.Current.GetMaxParallelHostsString())
+					keepaliveEntry.SetText(settings.Current.GetKeepaliveIntervalString())
+					maxMissedEntry.SetText(settings.Current.GetKeepaliveMaxMissedString())
+					autoReconnectCheck.SetChecked(settings.Current.AutoReconnect)
 					usernameEntry.SetText(settings.Current.DefaultSSHUsername)
 					passwordEntry.SetText(settings.Current.DefaultSSHPassword)
+					agentCheck.SetChecked(settings.Current.UseSSHAgent)
+					sshConfigEntry.SetText(settings.Current.SSHConfigPath)
+					hostKeyPolicySelect.SetSelected(settings.Current.HostKeyPolicy)
+					knownHostsEntry.SetText(settings.Current.KnownHostsPath)
 					dbPathEntry.SetText(settings.Current.DatabasePath)
 					autoSaveCheck.SetChecked(settings.Current.AutoSaveDevices)
 					cleanupDaysEntry.SetText(settings.Current.GetCleanupOldDaysString())
+					termTypeEntry.SetText(settings.Current.TerminalType)
 					termRowsEntry.SetText(settings.Current.GetTerminalRowsString())
 					termColsEntry.SetText(settings.Current.GetTerminalColsString())
+					termModesEntry.SetText(settings.Current.TerminalModes)
+					termEnvEntry.SetText(settings.Current.TerminalEnv)
 					termFontEntry.SetText(settings.Current.TerminalFont)
+					termThemeSelect.SetSelected(terminalThemeOption(settings.Current.TerminalTheme))
 					termFontSizeEntry.SetText(settings.Current.GetTerminalFontSizeString())
+					scrollbackEntry.SetText(settings.Current.GetScrollbackLinesString())
+					logCheck.SetChecked(settings.Current.LogSessions)
+					logsDirEntry.SetText(settings.Current.SessionLogsDir)
+					recordCheck.SetChecked(settings.Current.RecordSessions)
+					recordingsDirEntry.SetText(settings.Current.RecordingsDir)
+					confirmPasteCheck.SetChecked(settings.Current.ConfirmMultilinePaste)
+					dangerousEntry.SetText(strings.Join(settings.Current.DangerousCommands, "\n"))
 					scanTimeoutEntry.SetText(settings.Current.GetScanTimeoutString())
 					maxScansEntry.SetText(settings.Current.GetMaxConcurrentScansString())
 
@@ -189,13 +340,29 @@ func CreateSettingsTab(parentWindow fyne.Window) *container.Scroll {
 			widget.NewLabel("Default SSH Port:"), sshPortEntry,
 			widget.NewLabel("Default Telnet Port:"), telnetPortEntry,
 			widget.NewLabel("Connection Timeout (seconds):"), timeoutEntry,
+			widget.NewLabel("Max Parallel Hosts:"), maxParallelEntry,
+		)),
+		widget.NewCard("Connection Health", "", container.NewVBox(
+			container.NewGridWithColumns(2,
+				widget.NewLabel("Keepalive Interval (seconds, 0 = off):"), keepaliveEntry,
+				widget.NewLabel("Missed Keepalives Before Lost:"), maxMissedEntry,
+			),
+			autoReconnectCheck,
 		)),
 	)
 
 	sshSection := container.NewVBox(
-		widget.NewCard("SSH Default Credentials", "", container.NewGridWithColumns(2,
-			widget.NewLabel("Default Username:"), usernameEntry,
-			widget.NewLabel("Default Password:"), passwordEntry,
+		widget.NewCard("SSH Default Credentials", "", container.NewVBox(
+			container.NewGridWithColumns(2,
+				widget.NewLabel("Default Username:"), usernameEntry,
+				widget.NewLabel("Default Password:"), passwordEntry,
+				widget.NewLabel("OpenSSH Config File:"), sshConfigEntry,
+			),
+			agentCheck,
+		)),
+		widget.NewCard("SSH Host Key Verification", "", container.NewGridWithColumns(2,
+			widget.NewLabel("Host Key Policy:"), hostKeyPolicySelect,
+			widget.NewLabel("Known Hosts File:"), knownHostsEntry,
 		)),
 	)
 
@@ -210,11 +377,33 @@ func CreateSettingsTab(parentWindow fyne.Window) *container.Scroll {
 	)
 
 	terminalSection := container.NewVBox(
-		widget.NewCard("Terminal Settings", "", container.NewGridWithColumns(2,
+		widget.NewCard("Terminal Settings", "Devices can override these in the devices table", container.NewGridWithColumns(2,
+			widget.NewLabel("Terminal Type (TERM):"), termTypeEntry,
 			widget.NewLabel("Default Rows:"), termRowsEntry,
 			widget.NewLabel("Default Columns:"), termColsEntry,
-			widget.NewLabel("Font Family:"), termFontEntry,
+			widget.NewLabel("Terminal Modes:"), termModesEntry,
+			widget.NewLabel("Environment Variables:"), termEnvEntry,
+			widget.NewLabel("Font:"), termFontEntry,
 			widget.NewLabel("Font Size:"), termFontSizeEntry,
+			widget.NewLabel("Colors:"), termThemeSelect,
+			widget.NewLabel("Scrollback Lines:"), scrollbackEntry,
+		)),
+		widget.NewCard("Session Logs", "", container.NewVBox(
+			logCheck,
+			container.NewGridWithColumns(2,
+				widget.NewLabel("Logs Folder:"), logsDirEntry,
+			),
+		)),
+		widget.NewCard("Session Recording", "", container.NewVBox(
+			recordCheck,
+			container.NewGridWithColumns(2,
+				widget.NewLabel("Recordings Folder:"), recordingsDirEntry,
+			),
+		)),
+		widget.NewCard("Broadcast Input", "Input sent to several hosts at once", container.NewVBox(
+			confirmPasteCheck,
+			widget.NewLabel("Commands always confirmed (regular expressions, one per line):"),
+			dangerousEntry,
 		)),
 	)
 
@@ -245,3 +434,11 @@ func CreateSettingsTab(parentWindow fyne.Window) *container.Scroll {
 
 	return container.NewScroll(content)
 }
+
+// terminalThemeOption returns the colors select option of a terminal theme
+func terminalThemeOption(terminalTheme string) string {
+	if terminalTheme == "" {
+		return "Follow application"
+	}
+	return terminalTheme
+}
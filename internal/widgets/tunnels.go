@@ -0,0 +1,177 @@
+package widgets
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ispapp/psshclient/internal/windows"
+	"github.com/ispapp/psshclient/pkg/pssh"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// forwardRegistry holds the port forwards of all connected devices
+var forwardRegistry = pssh.NewForwardRegistry()
+
+// forwardKinds maps the kind select options to forward kinds
+var forwardKinds = map[string]pssh.ForwardKind{
+	"Local (-L)":         pssh.ForwardLocal,
+	"Remote (-R)":        pssh.ForwardRemote,
+	"Dynamic SOCKS (-D)": pssh.ForwardDynamic,
+}
+
+// showTunnelsWindow opens a window listing the active port forwards, with a form
+// to start new ones through the given connections
+func showTunnelsWindow(connections []*pssh.SSHConnection, parent fyne.Window) {
+	win, err := windows.WinManager.NewWindow("Tunnels", "tunnels")
+	if err != nil {
+		fmt.Printf("Failed to create window: %v\n", err)
+		return
+	}
+
+	sorted := sortedConnections(connections)
+	hosts := make([]string, len(sorted))
+	for i, conn := range sorted {
+		hosts[i] = conn.Config.Host
+	}
+
+	deviceSelect := widget.NewSelect(hosts, nil)
+	if len(hosts) > 0 {
+		deviceSelect.SetSelectedIndex(0)
+	}
+	specEntry := widget.NewEntry()
+	kindSelect := widget.NewSelect([]string{"Local (-L)", "Remote (-R)", "Dynamic SOCKS (-D)"}, func(kind string) {
+		if forwardKinds[kind] == pssh.ForwardDynamic {
+			specEntry.SetPlaceHolder("[bind_address:]port, e.g. 1080")
+		} else {
+			specEntry.SetPlaceHolder("[bind_address:]port:host:hostport, e.g. 8080:192.168.88.1:80")
+		}
+	})
+	kindSelect.SetSelected("Local (-L)")
+
+	forwards := forwardRegistry.List()
+	var refresh func()
+	list := widget.NewList(
+		func() int {
+			return len(forwards)
+		},
+		func() fyne.CanvasObject {
+			stopBtn := widget.NewButtonWithIcon("Stop", theme.MediaStopIcon(), nil)
+			return container.NewBorder(nil, nil,
+				widget.NewLabel(""),
+				container.NewHBox(widget.NewLabel(""), stopBtn),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, object fyne.CanvasObject) {
+			if id >= len(forwards) {
+				return
+			}
+			forward := forwards[id]
+			row := object.(*fyne.Container)
+			spec := row.Objects[0].(*widget.Label)
+			device := row.Objects[1].(*widget.Label)
+			right := row.Objects[2].(*fyne.Container)
+			stats := right.Objects[0].(*widget.Label)
+			stopBtn := right.Objects[1].(*widget.Button)
+
+			device.SetText(forward.Host)
+			spec.SetText(forwardDescription(forward))
+			stats.SetText(forwardStats(forward))
+			stopBtn.OnTapped = func() {
+				forwardRegistry.Stop(forward.ID)
+				refresh()
+			}
+		},
+	)
+	refresh = func() {
+		forwards = forwardRegistry.List()
+		list.Refresh()
+	}
+
+	startBtn := widget.NewButtonWithIcon("Start", theme.ContentAddIcon(), func() {
+		if deviceSelect.SelectedIndex() < 0 {
+			dialog.ShowError(fmt.Errorf("select a connected device first"), win.Window)
+			return
+		}
+		spec, err := pssh.ParseForward(forwardKinds[kindSelect.Selected], specEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, win.Window)
+			return
+		}
+		forward, err := forwardRegistry.Start(sorted[deviceSelect.SelectedIndex()], spec)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("%s: %v", hosts[deviceSelect.SelectedIndex()], err), win.Window)
+			return
+		}
+		fmt.Printf("Started forward %s via %s on %s\n", spec, forward.Host, forward.Addr())
+		specEntry.SetText("")
+		refresh()
+	})
+	stopAllBtn := widget.NewButtonWithIcon("Stop All", theme.CancelIcon(), func() {
+		dialog.ShowConfirm("Stop All", "Stop every tunnel of every device?", func(confirmed bool) {
+			if confirmed {
+				forwardRegistry.StopAll()
+				refresh()
+			}
+		}, win.Window)
+	})
+	stopAllBtn.Importance = widget.DangerImportance
+
+	// Byte counters change constantly, refresh them while the window is open
+	ticker := time.NewTicker(time.Second)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fyne.Do(refresh)
+			case <-done:
+				return
+			}
+		}
+	}()
+	win.Window.SetOnClosed(func() { close(done) })
+
+	form := container.NewBorder(nil, nil,
+		container.NewHBox(deviceSelect, kindSelect),
+		startBtn,
+		specEntry,
+	)
+	content := container.NewBorder(
+		container.NewVBox(form, widget.NewSeparator()),
+		container.NewHBox(stopAllBtn),
+		nil, nil,
+		list,
+	)
+
+	win.Window.SetContent(content)
+	win.Window.Resize(fyne.NewSize(850, 450))
+	win.Window.Show()
+}
+
+// forwardDescription shows where a forward listens and where it connects to
+func forwardDescription(forward *pssh.Forward) string {
+	switch forward.Spec.Kind {
+	case pssh.ForwardLocal:
+		return fmt.Sprintf("-L %s → %s", forward.Addr(), forward.Spec.TargetAddress)
+	case pssh.ForwardRemote:
+		return fmt.Sprintf("-R %s (on device) → %s", forward.Addr(), forward.Spec.TargetAddress)
+	default:
+		return fmt.Sprintf("-D %s (SOCKS5)", forward.Addr())
+	}
+}
+
+// forwardStats shows the traffic and open connections of a forward
+func forwardStats(forward *pssh.Forward) string {
+	if err := forward.Err(); err != nil {
+		return "✗ " + err.Error()
+	}
+	sent, received, active := forward.Stats()
+	return fmt.Sprintf("↑ %s  ↓ %s  %d open", formatTransferSize(sent), formatTransferSize(received), active)
+}
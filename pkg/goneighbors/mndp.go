@@ -162,7 +162,6 @@ func (d *MNDPDiscoverer) parseMNDPPacket(data []byte, sourceAddr *net.UDPAddr) *
 	}
 
 	neighbor := &Neighbor{
-		IPAddress:    sourceAddr.IP.String(),
 		Protocol:     ProtocolMNDP,
 		SSHPort:      22,
 		HasSSH:       true,
@@ -171,6 +170,12 @@ func (d *MNDPDiscoverer) parseMNDPPacket(data []byte, sourceAddr *net.UDPAddr) *
 		Platform:     "MikroTik",
 	}
 
+	// Devices without an IP address announce themselves from 0.0.0.0, they are
+	// told apart by their MAC address and reached over MAC-Telnet
+	if !sourceAddr.IP.IsUnspecified() {
+		neighbor.IPAddress = sourceAddr.IP.String()
+	}
+
 	// Process TLV fields
 	for tag, tlv := range msg.Fields {
 		d.processTLV(tag, tlv.Value, neighbor)
@@ -0,0 +1,222 @@
+package pssh
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OutputNormalizer rewrites command output before results are compared, so that
+// values expected to differ between hosts do not split groups
+type OutputNormalizer func(output string) string
+
+// VolatileMasks match values that change on every run: uptimes, clock times and dates
+var VolatileMasks = []string{
+	`(?i)\buptime:\s*\S+`,               // RouterOS "uptime: 1w2d3h4m5s"
+	`(?i)\bup\s+\d+\s+(days?|min)\b`,    // Linux "up 3 days"
+	`\b(\d+w)?(\d+d)?(\d+h)?\d+m\d+s\b`, // RouterOS durations
+	// 14:05 and 14:05:33, but not within MAC or IPv6 addresses or after an IPv4 address
+	`(?:^|[^\w:.])(?P<mask>\d{1,2}:\d{2}(?::\d{2})?)(?:[^\w:]|$)`,
+	`\b\d{4}-\d{2}-\d{2}\b`,        // 2024-01-02
+	`(?i)\b[a-z]{3}/\d{2}/\d{4}\b`, // jan/02/2024
+}
+
+// NewMaskNormalizer returns a normalizer replacing every match of the regular
+// expressions with "<masked>". Of an expression with a group named "mask" only
+// the group is replaced, the rest of the match tells where it may be.
+func NewMaskNormalizer(patterns ...string) (OutputNormalizer, error) {
+	expressions := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask %q: %v", pattern, err)
+		}
+		expressions = append(expressions, expression)
+	}
+
+	return func(output string) string {
+		for _, expression := range expressions {
+			output = maskMatches(expression, output)
+		}
+		return output
+	}, nil
+}
+
+// maskMatches replaces the matches of expression, or their "mask" group, with
+// "<masked>". Matches sharing the text between them are masked in a second pass.
+func maskMatches(expression *regexp.Regexp, output string) string {
+	group := expression.SubexpIndex("mask")
+	if group < 0 {
+		return expression.ReplaceAllString(output, "<masked>")
+	}
+	for pass := 0; pass < 2; pass++ {
+		var masked strings.Builder
+		last := 0
+		for _, match := range expression.FindAllStringSubmatchIndex(output, -1) {
+			start, end := match[2*group], match[2*group+1]
+			if start < 0 {
+				continue
+			}
+			masked.WriteString(output[last:start])
+			masked.WriteString("<masked>")
+			last = end
+		}
+		masked.WriteString(output[last:])
+		output = masked.String()
+	}
+	return output
+}
+
+// numberPattern matches runs of digits for MaskNumbers
+var numberPattern = regexp.MustCompile(`\d+`)
+
+// MaskNumbers replaces every number in the output with "#"
+func MaskNumbers(output string) string {
+	return numberPattern.ReplaceAllString(output, "#")
+}
+
+// OutputGroup is a set of hosts whose commands ended the same way with the same output
+type OutputGroup struct {
+	Hosts   []string
+	Output  string // Output of the first host of the group, not normalized
+	Status  string // Status of the first host of the group, not normalized
+	Results []*CommandResult
+}
+
+// GroupOutputs groups results by identical output and status, like dshbak -c.
+// normalize, if not nil, is applied to the output and the status before comparing;
+// the name of the host is taken out of its status, e.g. of a connection error.
+// Groups are ordered by size, largest first.
+func GroupOutputs(results []*CommandResult, normalize OutputNormalizer) []OutputGroup {
+	var groups []OutputGroup
+	index := make(map[string]int)
+
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		output := result.Output()
+		key := output
+		status := result.Status()
+		if result.Host != "" {
+			status = strings.ReplaceAll(status, result.Host, "<host>")
+		}
+		if normalize != nil {
+			key = normalize(output)
+			status = normalize(status)
+		}
+		key = status + "\x00" + key
+
+		i, exists := index[key]
+		if !exists {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, OutputGroup{Output: output, Status: result.Status()})
+		}
+		groups[i].Hosts = append(groups[i].Hosts, result.Host)
+		groups[i].Results = append(groups[i].Results, result)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Hosts) > len(groups[j].Hosts)
+	})
+	return groups
+}
+
+// FormatOutputGroups formats groups as a dshbak style report
+func FormatOutputGroups(groups []OutputGroup) string {
+	var report strings.Builder
+	for _, group := range groups {
+		separator := strings.Repeat("-", 16)
+		fmt.Fprintf(&report, "%s\nhosts %s (%d) — %s\n%s\n", separator, FormatHostList(group.Hosts), len(group.Hosts), group.Status, separator)
+		report.WriteString(group.Output)
+		if group.Output != "" && !strings.HasSuffix(group.Output, "\n") {
+			report.WriteString("\n")
+		}
+	}
+	return report.String()
+}
+
+// hostPattern splits a host name around its last number
+var hostPattern = regexp.MustCompile(`^(.*?)(\d+)(\D*)$`)
+
+// FormatHostList folds host names that differ only in their last number into ranges,
+// e.g. "10.0.0.1,10.0.0.2,10.0.0.3,10.0.0.7" becomes "10.0.0.[1-3,7]"
+func FormatHostList(hosts []string) string {
+	type hostNumber struct {
+		value  int
+		digits string // As written, to keep zero padding
+	}
+	type hostRange struct {
+		prefix, suffix string
+		numbers        []hostNumber
+	}
+
+	var ranges []*hostRange
+	index := make(map[string]*hostRange)
+	var plain []string
+
+	for _, host := range hosts {
+		match := hostPattern.FindStringSubmatch(host)
+		if match == nil {
+			plain = append(plain, host)
+			continue
+		}
+		number, err := strconv.Atoi(match[2])
+		if err != nil {
+			plain = append(plain, host)
+			continue
+		}
+
+		key := match[1] + "\x00" + match[3]
+		group, exists := index[key]
+		if !exists {
+			group = &hostRange{prefix: match[1], suffix: match[3]}
+			index[key] = group
+			ranges = append(ranges, group)
+		}
+		group.numbers = append(group.numbers, hostNumber{value: number, digits: match[2]})
+	}
+
+	// Numbers continue a span when they follow it and are padded the same way
+	padded := func(number hostNumber) bool {
+		return len(number.digits) > 1 && number.digits[0] == '0'
+	}
+	follows := func(previous, next hostNumber) bool {
+		if next.value > previous.value+1 {
+			return false
+		}
+		return len(previous.digits) == len(next.digits) || (!padded(previous) && !padded(next))
+	}
+
+	var parts []string
+	for _, group := range ranges {
+		sort.SliceStable(group.numbers, func(i, j int) bool {
+			return group.numbers[i].value < group.numbers[j].value
+		})
+
+		if len(group.numbers) == 1 {
+			parts = append(parts, group.prefix+group.numbers[0].digits+group.suffix)
+			continue
+		}
+
+		var spans []string
+		for start := 0; start < len(group.numbers); {
+			end := start
+			for end+1 < len(group.numbers) && follows(group.numbers[end], group.numbers[end+1]) {
+				end++
+			}
+			if group.numbers[start].value == group.numbers[end].value {
+				spans = append(spans, group.numbers[start].digits)
+			} else {
+				spans = append(spans, group.numbers[start].digits+"-"+group.numbers[end].digits)
+			}
+			start = end + 1
+		}
+		parts = append(parts, group.prefix+"["+strings.Join(spans, ",")+"]"+group.suffix)
+	}
+
+	return strings.Join(append(parts, plain...), ",")
+}
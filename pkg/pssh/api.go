@@ -0,0 +1,160 @@
+package pssh
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ispapp/psshclient/pkg/routeros"
+	"golang.org/x/crypto/ssh"
+)
+
+// usesAPI reports whether the config reaches the device over the RouterOS API
+func (config ConnectionConfig) usesAPI() bool {
+	return config.Protocol == ProtocolAPI || config.Protocol == ProtocolAPITLS
+}
+
+// connectAPI logs in to the RouterOS API and keeps the connection for commands.
+// The caller must hold the write lock.
+func (conn *SSHConnection) connectAPI() error {
+	config := conn.Config
+	if config.Port == 0 {
+		config.Port = routeros.DefaultPort
+		if config.Protocol == ProtocolAPITLS {
+			config.Port = routeros.DefaultTLSPort
+		}
+	}
+
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	var tlsConfig *tls.Config
+	var fingerprint string
+	if config.Protocol == ProtocolAPITLS {
+		// api-ssl runs with the self-signed certificate of the device, so its key
+		// is verified like a host key instead of the certificate chain
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify:    true,
+			VerifyPeerCertificate: apiCertificateCallback(config, &fingerprint),
+		}
+	}
+	client, err := routeros.Dial(ctx, config.address(), tlsConfig)
+	if err == nil {
+		if err = client.Login(ctx, config.Username, config.Password); err != nil {
+			client.Close()
+		}
+	}
+	if err != nil {
+		conn.Error = err
+		conn.Connected = false
+		return err
+	}
+
+	conn.api = client
+	conn.Connected = true
+	conn.Error = nil
+	conn.HostKeyFingerprint = fingerprint
+	return nil
+}
+
+// apiCertificateCallback checks the key of the api-ssl certificate with the host
+// key policy of config: against the pinned fingerprint and known_hosts, trusting
+// it on first use. The fingerprint of the key is stored in seen.
+func apiCertificateCallback(config ConnectionConfig, seen *string) func([][]byte, [][]*x509.Certificate) error {
+	callback := hostKeyCallback(config, true, seen)
+	address := config.address()
+	remote := &net.TCPAddr{IP: net.ParseIP(config.Host), Port: config.Port}
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("%s presented no certificate", address)
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("invalid certificate of %s: %w", address, err)
+		}
+		key, err := ssh.NewPublicKey(cert.PublicKey)
+		if err != nil {
+			return fmt.Errorf("unsupported certificate key of %s: %w", address, err)
+		}
+		return callback(address, remote, key)
+	}
+}
+
+// API returns the RouterOS API client of a connection made with ProtocolAPI or
+// ProtocolAPITLS, nil otherwise
+func (conn *SSHConnection) API() *routeros.Client {
+	conn.mutex.RLock()
+	defer conn.mutex.RUnlock()
+	return conn.api
+}
+
+// runAPICommand runs each line of a script as an API command, stopping at the first
+// one the device refuses. Replies are returned as records and written to stdout as
+// one line of attributes per record; refusals are written to stderr.
+func (conn *SSHConnection) runAPICommand(ctx context.Context, client *routeros.Client, script string, stdout, stderr io.Writer) ([]*routeros.Sentence, error) {
+	timeout := conn.Config.CommandTimeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var records []*routeros.Sentence
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words, err := routeros.ParseCommand(line)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s\n", err)
+			return records, &routeros.DeviceError{Message: err.Error()}
+		}
+
+		reply, err := client.Run(ctx, words...)
+		if reply != nil {
+			for _, sentence := range reply.Re {
+				records = append(records, sentence)
+				fmt.Fprintln(stdout, formatAPIAttributes(sentence.List))
+			}
+			if reply.Done != nil && len(reply.Done.List) > 0 {
+				fmt.Fprintln(stdout, formatAPIAttributes(reply.Done.List))
+			}
+		}
+
+		var deviceErr *routeros.DeviceError
+		switch {
+		case errors.As(err, &deviceErr):
+			fmt.Fprintf(stderr, "failure: %s\n", deviceErr.Message)
+			return records, err
+		case ctx.Err() != nil:
+			return records, commandCancelled(ctx.Err(), timeout)
+		case err != nil:
+			return records, fmt.Errorf("failed to run command: %w", err)
+		}
+	}
+	return records, nil
+}
+
+// formatAPIAttributes shows a reply like the terse print of the console
+func formatAPIAttributes(pairs []routeros.Pair) string {
+	parts := make([]string, len(pairs))
+	for i, pair := range pairs {
+		value := pair.Value
+		if value == "" || strings.ContainsAny(value, " \t\"") {
+			value = strconv.Quote(value)
+		}
+		parts[i] = pair.Key + "=" + value
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,153 @@
+package pssh
+
+import (
+	"fmt"
+	"image/color"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"github.com/fyne-io/terminal"
+)
+
+// terminalTheme is the theme of a terminal: the application theme with the font,
+// text size and colors of the terminal options of its device
+type terminalTheme struct {
+	device   TerminalOptions
+	font     fyne.Resource
+	fontSize float32
+	variant  fyne.ThemeVariant
+	forced   bool // Whether variant replaces the one of the application
+}
+
+func newTerminalTheme(device TerminalOptions) *terminalTheme {
+	th := &terminalTheme{device: device}
+	th.update()
+	return th
+}
+
+// update takes the font and colors from the current DefaultTerminalOptions
+func (th *terminalTheme) update() {
+	options := DefaultTerminalOptions().Override(th.device)
+	th.font = loadTerminalFont(options.Font)
+	th.fontSize = options.FontSize
+	switch options.Theme {
+	case "dark":
+		th.variant, th.forced = theme.VariantDark, true
+	case "light":
+		th.variant, th.forced = theme.VariantLight, true
+	default:
+		th.forced = false
+	}
+}
+
+func (th *terminalTheme) base() fyne.Theme {
+	return fyne.CurrentApp().Settings().Theme()
+}
+
+func (th *terminalTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) color.Color {
+	if th.forced {
+		variant = th.variant
+	}
+	return th.base().Color(name, variant)
+}
+
+func (th *terminalTheme) Font(style fyne.TextStyle) fyne.Resource {
+	if style.Monospace && th.font != nil {
+		return th.font
+	}
+	return th.base().Font(style)
+}
+
+func (th *terminalTheme) Icon(name fyne.ThemeIconName) fyne.Resource {
+	return th.base().Icon(name)
+}
+
+func (th *terminalTheme) Size(name fyne.ThemeSizeName) float32 {
+	if name == theme.SizeNameText && th.fontSize > 0 {
+		return th.fontSize
+	}
+	return th.base().Size(name)
+}
+
+// terminalFonts caches the loaded font files, nil for files that failed to load
+var (
+	terminalFonts     = map[string]fyne.Resource{}
+	terminalFontMutex sync.Mutex
+)
+
+// loadTerminalFont loads a TrueType font file, nil for the font of the theme
+func loadTerminalFont(path string) fyne.Resource {
+	if path == "" || path == "monospace" {
+		return nil
+	}
+	terminalFontMutex.Lock()
+	defer terminalFontMutex.Unlock()
+	if font, ok := terminalFonts[path]; ok {
+		return font
+	}
+	font, err := fyne.LoadResourceFromPath(path)
+	if err != nil {
+		fmt.Printf("Failed to load terminal font %s: %v\n", path, err)
+		font = nil
+	}
+	terminalFonts[path] = font
+	return font
+}
+
+// styleTerminal shows t with the font and theme of the terminal options of its
+// device, they follow later changes of DefaultTerminalOptions
+func styleTerminal(t *terminal.Terminal, device TerminalOptions) *container.ThemeOverride {
+	return container.NewThemeOverride(t, newTerminalTheme(device))
+}
+
+// terminalSize returns the size of a terminal with the rows and columns of options
+func terminalSize(options TerminalOptions) fyne.Size {
+	rows, cols, size := options.Rows, options.Cols, options.FontSize
+	if rows <= 0 || cols <= 0 {
+		rows, cols = 24, 80
+	}
+	if size <= 0 {
+		size = theme.TextSize()
+	}
+	cell := fyne.MeasureText("M", size, fyne.TextStyle{Monospace: true})
+	return fyne.NewSize(float32(cols)*cell.Width, float32(rows)*cell.Height)
+}
+
+// TerminalSize returns the size of a terminal with cols columns and rows rows in
+// the default font
+func TerminalSize(cols, rows int) fyne.Size {
+	return terminalSize(TerminalOptions{Rows: rows, Cols: cols})
+}
+
+// ApplyTerminalAppearance restyles the open terminals after the font or theme in
+// DefaultTerminalOptions changed. It must be called from the UI thread.
+func ApplyTerminalAppearance() {
+	for _, view := range openViews {
+		restyleTerminals(view.body)
+	}
+}
+
+// restyleTerminals updates the terminals found in object
+func restyleTerminals(object fyne.CanvasObject) {
+	switch object := object.(type) {
+	case *container.ThemeOverride:
+		if th, ok := object.Theme.(*terminalTheme); ok {
+			th.update()
+			object.Refresh()
+			// The terminal takes the rows and columns fitting the new font
+			object.Content.Resize(object.Content.Size())
+		}
+		restyleTerminals(object.Content)
+	case *fyne.Container:
+		for _, child := range object.Objects {
+			restyleTerminals(child)
+		}
+	case *container.Split:
+		restyleTerminals(object.Leading)
+		restyleTerminals(object.Trailing)
+	case *container.Scroll:
+		restyleTerminals(object.Content)
+	}
+}
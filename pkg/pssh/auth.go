@@ -0,0 +1,221 @@
+package pssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// PassphrasePromptFunc is asked for the passphrase of an encrypted private key
+type PassphrasePromptFunc func(keyPath string) (string, error)
+
+// defaultKeyPaths are tried when no private key is configured
+var defaultKeyPaths = []string{
+	"~/.ssh/id_rsa",
+	"~/.ssh/id_ed25519",
+	"~/.ssh/id_ecdsa",
+}
+
+var (
+	// passphraseCache remembers passphrases of unlocked key files for the session
+	passphraseCache sync.Map
+	// passphraseMutex serializes passphrase prompts so a key is only asked for once
+	passphraseMutex sync.Mutex
+)
+
+// buildAuthMethods builds the authentication methods for a config in order of preference.
+// The returned cleanup function releases the ssh-agent connection and must be called
+// once the handshake has finished.
+func buildAuthMethods(config ConnectionConfig) ([]ssh.AuthMethod, func(), error) {
+	var authMethods []ssh.AuthMethod
+	cleanup := func() {}
+
+	// The SSH client only tries the first "publickey" method it is given,
+	// so every key source is folded into a single signer list.
+	var signers []ssh.Signer
+	explicitKey := len(config.PrivateKey) > 0 || config.KeyPath != ""
+
+	// 1. Private key authentication (if provided)
+	if len(config.PrivateKey) > 0 {
+		signer, err := parsePrivateKey(config.PrivateKey, "", config, true)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("failed to parse private key: %v", err)
+		}
+		signers = append(signers, signer)
+	}
+
+	if config.KeyPath != "" {
+		signer, err := loadPrivateKeyFromFile(config.KeyPath, config, true)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("failed to load private key %s: %v", config.KeyPath, err)
+		}
+		signers = append(signers, signer)
+	}
+
+	// 2. Keys held by ssh-agent
+	var agentClient agent.ExtendedAgent
+	if config.UseAgent {
+		client, agentConn, err := dialAgent()
+		if err != nil {
+			fmt.Printf("Warning: ssh-agent not available: %v\n", err)
+		} else {
+			agentClient = client
+			cleanup = func() { agentConn.Close() }
+		}
+	}
+
+	// 3. Common default keys if no specific key provided (never prompts for a passphrase)
+	var defaultSigners []ssh.Signer
+	if !explicitKey {
+		for _, keyPath := range defaultKeyPaths {
+			if signer, err := loadPrivateKeyFromFile(keyPath, config, false); err == nil {
+				defaultSigners = append(defaultSigners, signer)
+			}
+		}
+	}
+
+	publicKeys := ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		all := append([]ssh.Signer{}, signers...)
+		if agentClient != nil {
+			if agentSigners, err := agentClient.Signers(); err == nil {
+				all = append(all, agentSigners...)
+			} else {
+				fmt.Printf("Warning: failed to list ssh-agent keys: %v\n", err)
+			}
+		}
+		return append(all, defaultSigners...), nil
+	})
+
+	// Explicit keys and the agent take precedence over passwords,
+	// default keys are only tried after password authentication.
+	if len(signers) > 0 || agentClient != nil {
+		authMethods = append(authMethods, publicKeys)
+	}
+
+	// 4. Password authentication (if provided)
+	if config.Password != "" {
+		authMethods = append(authMethods, ssh.Password(config.Password))
+	}
+
+	// 5. Keyboard-interactive authentication (for systems that require it)
+	if config.Password != "" {
+		authMethods = append(authMethods, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range answers {
+				answers[i] = config.Password
+			}
+			return answers, nil
+		}))
+	}
+
+	if len(signers) == 0 && agentClient == nil && len(defaultSigners) > 0 {
+		authMethods = append(authMethods, publicKeys)
+	}
+
+	return authMethods, cleanup, nil
+}
+
+// dialAgent connects to the ssh-agent listening on SSH_AUTH_SOCK
+func dialAgent() (agent.ExtendedAgent, net.Conn, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, fmt.Errorf("SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to ssh-agent: %v", err)
+	}
+
+	return agent.NewClient(conn), conn, nil
+}
+
+// parsePrivateKey parses a PEM encoded private key, decrypting it if needed.
+// The passphrase comes from the config, the session cache or, if prompt is set, the config's prompt.
+func parsePrivateKey(keyBytes []byte, keyPath string, config ConnectionConfig, prompt bool) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	var missingErr *ssh.PassphraseMissingError
+	if !errors.As(err, &missingErr) {
+		return signer, err
+	}
+
+	if config.Passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(config.Passphrase))
+	}
+
+	if keyPath != "" {
+		if cached, ok := passphraseCache.Load(keyPath); ok {
+			if signer, err := ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(cached.(string))); err == nil {
+				return signer, nil
+			}
+		}
+	}
+
+	if !prompt || config.PassphrasePrompt == nil {
+		return nil, fmt.Errorf("private key is encrypted and no passphrase was provided")
+	}
+
+	passphraseMutex.Lock()
+	defer passphraseMutex.Unlock()
+
+	// Another connection may have unlocked the same key while we were waiting
+	if keyPath != "" {
+		if cached, ok := passphraseCache.Load(keyPath); ok {
+			if signer, err := ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(cached.(string))); err == nil {
+				return signer, nil
+			}
+		}
+	}
+
+	passphrase, err := config.PassphrasePrompt(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key: %v", err)
+	}
+
+	if keyPath != "" {
+		passphraseCache.Store(keyPath, passphrase)
+	}
+	return signer, nil
+}
+
+// loadPrivateKeyFromFile loads a private key from a file path
+func loadPrivateKeyFromFile(keyPath string, config ConnectionConfig, prompt bool) (ssh.Signer, error) {
+	keyPath, err := expandHomePath(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the private key file
+	keyBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the private key
+	return parsePrivateKey(keyBytes, keyPath, config, prompt)
+}
+
+// expandHomePath expands a leading ~ to the user's home directory
+func expandHomePath(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, path[1:]), nil
+}
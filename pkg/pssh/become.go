@@ -0,0 +1,403 @@
+package pssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// BecomeMethod is how commands and shells gain privileges on a device
+type BecomeMethod string
+
+const (
+	BecomeNone   BecomeMethod = ""
+	BecomeSudo   BecomeMethod = "sudo"
+	BecomeSu     BecomeMethod = "su"
+	BecomeEnable BecomeMethod = "enable" // Privileged EXEC mode of Cisco-style CLIs
+)
+
+// becomeCommands start a privileged shell at the prompt of an interactive shell
+var becomeCommands = map[BecomeMethod]string{
+	BecomeSudo:   "sudo -i",
+	BecomeSu:     "su -",
+	BecomeEnable: "enable",
+}
+
+var (
+	becomePasswordPrompt = regexp.MustCompile(`(?i)(password|secret)( for [^:]*)?\s*:\s*$`)
+	becomeDenied         = regexp.MustCompile(`(?i)(incorrect password|authentication failure|sorry, try again|not in the sudoers|not allowed to|access denied|bad secrets|password required|permission denied)`)
+	sudoDenied           = regexp.MustCompile(`(?i)^(sudo: .*(password|not allowed|terminal is required|unknown user)|.* is not in the sudoers file|sorry, user .* is not allowed)`)
+)
+
+// sudoPrompt is the password prompt sudo prints for commands run with sudoCommand,
+// it tells the prompt apart from the output of the command
+const sudoPrompt = "[psshclient-become] password: "
+
+// sudoStarted is printed to stderr once sudo runs the command, so stdin can be
+// closed when sudo did not ask for the password, e.g. with NOPASSWD or cached
+// credentials, and what follows is not taken for a refusal of sudo
+const sudoStarted = "[psshclient-become] started\n"
+
+// EscalationError reports that sudo, su or enable did not grant privileges, so
+// the command was not run
+type EscalationError struct {
+	Method BecomeMethod
+	Reason string
+}
+
+func (e *EscalationError) Error() string {
+	return fmt.Sprintf("escalation with %s failed: %s", e.Method, e.Reason)
+}
+
+// IsEscalationError reports whether err is caused by a failed privilege escalation
+func IsEscalationError(err error) bool {
+	var escalationErr *EscalationError
+	return errors.As(err, &escalationErr)
+}
+
+// Validate checks that the method is known
+func (method BecomeMethod) Validate() error {
+	if method != BecomeNone && becomeCommands[method] == "" {
+		return fmt.Errorf("unknown privilege escalation %q, expected sudo, su or enable", method)
+	}
+	return nil
+}
+
+// escalation gains privileges at the prompt of an interactive shell: the command
+// of the method is sent at the first prompt, the password at the password prompt,
+// and the prompt that comes back tells whether it worked
+type escalation struct {
+	method   BecomeMethod
+	password string
+	state    int
+	output   []byte // Printed since the last reply
+	prompt   string // Prompt of the privileged shell once escalated
+}
+
+// States of an escalation
+const (
+	becomeStart    = iota // Waiting for the shell prompt
+	becomeSent            // Command sent
+	becomeAnswered        // Password sent
+	becomeDone
+)
+
+// escalation returns how the config gains privileges in a shell, nil for none
+func (config ConnectionConfig) escalation() *escalation {
+	if becomeCommands[config.Become] == "" {
+		return nil
+	}
+	return &escalation{method: config.Become, password: config.BecomePassword}
+}
+
+// feed takes output of the shell and returns what to send to it. done is set once
+// the escalation is over, err when it failed.
+func (e *escalation) feed(p []byte) (reply string, done bool, err error) {
+	if e.state == becomeDone {
+		return "", true, nil
+	}
+	e.output = append(e.output, p...)
+	if excess := len(e.output) - 4096; excess > 0 {
+		e.output = e.output[excess:]
+	}
+	reply, done, err = e.step(string(e.output))
+	if reply != "" {
+		e.output = e.output[:0]
+	}
+	if done {
+		e.state = becomeDone
+	}
+	return reply, done, err
+}
+
+func (e *escalation) step(output string) (string, bool, error) {
+	prompt := lastLine(output)
+	if e.state == becomeStart {
+		switch {
+		case strings.HasSuffix(prompt, "#"):
+			// Privileged already, e.g. logged in as root or at privilege level 15
+			e.prompt = prompt
+			return "", true, nil
+		case shellPrompt.MatchString(prompt):
+			e.state = becomeSent
+			return becomeCommands[e.method] + "\r", false, nil
+		}
+		return "", false, nil
+	}
+
+	// Refusals are answered with Ctrl+C, in case the password is asked again
+	for _, line := range strings.FieldsFunc(ansiEscape.ReplaceAllString(output, ""), func(r rune) bool { return r == '\r' || r == '\n' }) {
+		if line = strings.TrimSpace(line); becomeDenied.MatchString(line) {
+			return "\x03", true, &EscalationError{Method: e.method, Reason: line}
+		}
+	}
+	switch {
+	case becomePasswordPrompt.MatchString(prompt):
+		if e.state == becomeAnswered {
+			return "\x03", true, &EscalationError{Method: e.method, Reason: "incorrect password"}
+		}
+		if e.password == "" {
+			return "\x03", true, &EscalationError{Method: e.method, Reason: "a password is required"}
+		}
+		e.state = becomeAnswered
+		return e.password + "\r", false, nil
+	case shellPrompt.MatchString(prompt):
+		if strings.HasSuffix(prompt, "#") {
+			e.prompt = prompt
+			return "", true, nil
+		}
+		return "", true, &EscalationError{Method: e.method, Reason: "privileges not granted"}
+	}
+	return "", false, nil
+}
+
+// run escalates a shell logged in with transcript, it returns the prompt of the
+// privileged shell
+func (e *escalation) run(shell shellConn, transcript string) (string, error) {
+	p := []byte(transcript)
+	buf := make([]byte, 4096)
+	for {
+		reply, done, err := e.feed(p)
+		if reply != "" {
+			if _, err := shell.Write([]byte(reply)); err != nil {
+				return "", fmt.Errorf("failed to run command: %w", err)
+			}
+		}
+		if done {
+			return e.prompt, err
+		}
+		n, err := shell.Read(buf)
+		if err != nil && n == 0 {
+			return "", fmt.Errorf("failed to run command: %w", err)
+		}
+		p = buf[:n]
+	}
+}
+
+// becomeReader escalates an interactive shell once its prompt shows, answering
+// the password prompt itself. The output passes through, a failure is reported
+// in the terminal.
+type becomeReader struct {
+	reader     io.Reader
+	stdin      io.Writer
+	escalation *escalation
+	notice     []byte
+}
+
+// becomeShell returns the output of an interactive shell of conn, escalated when
+// a become method is set for the device
+func (conn *SSHConnection) becomeShell(stdin io.Writer, stdout io.Reader) io.Reader {
+	e := conn.Config.escalation()
+	if e == nil {
+		return stdout
+	}
+	return &becomeReader{reader: stdout, stdin: stdin, escalation: e}
+}
+
+func (br *becomeReader) Read(p []byte) (int, error) {
+	if len(br.notice) > 0 {
+		n := copy(p, br.notice)
+		br.notice = br.notice[n:]
+		return n, nil
+	}
+	n, err := br.reader.Read(p)
+	if n > 0 && br.escalation.state != becomeDone {
+		reply, done, failure := br.escalation.feed(p[:n])
+		if reply != "" {
+			br.stdin.Write([]byte(reply))
+		}
+		if done && failure != nil {
+			br.notice = []byte(fmt.Sprintf("\r\n*** %v ***\r\n", failure))
+		}
+	}
+	return n, err
+}
+
+// sudoCommand runs command through sudo, printing sudoStarted before the command
+// runs. With a password, sudo prints sudoPrompt and reads the password from stdin;
+// without one sudo fails rather than asking.
+func sudoCommand(command string, password bool) string {
+	command = fmt.Sprintf("printf %%s %s >&2; %s", shellQuote(sudoStarted), command)
+	if !password {
+		return "sudo -n -- sh -c " + shellQuote(command)
+	}
+	return fmt.Sprintf("sudo -S -p %s -- sh -c %s", shellQuote(sudoPrompt), shellQuote(command))
+}
+
+// shellQuote quotes s as a single argument of a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sudoWriter passes on stderr of a command run with sudoCommand, answering the
+// password prompt of sudo on the way. A second prompt means the password was
+// wrong; stdin is closed after the first, so sudo gives up by itself. When the
+// command starts without a prompt stdin is closed as well, so the command does
+// not wait for input that never comes. Once the command runs, its stderr is
+// passed on as is.
+type sudoWriter struct {
+	writer   io.Writer
+	stdin    io.WriteCloser
+	password string
+	prompts  int
+	closed   bool   // Whether stdin was closed
+	started  bool   // Whether sudoStarted was seen
+	pending  []byte // Output that may be the start of the prompt
+	line     []byte // Current line, to tell why sudo refused
+	err      *EscalationError
+	mutex    sync.Mutex
+}
+
+func (w *sudoWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.started {
+		w.writer.Write(p)
+		return len(p), nil
+	}
+	data := append(w.pending, p...)
+	w.pending = nil
+	for {
+		prompt := strings.Index(string(data), sudoPrompt)
+		started := strings.Index(string(data), sudoStarted)
+		if started >= 0 && (prompt < 0 || started < prompt) {
+			w.pass(data[:started])
+			w.checkLine()
+			w.started = true
+			w.closeStdin()
+			w.writer.Write(data[started+len(sudoStarted):])
+			return len(p), nil
+		}
+		if prompt < 0 {
+			break
+		}
+		w.pass(data[:prompt])
+		data = data[prompt+len(sudoPrompt):]
+		w.prompts++
+		if w.prompts == 1 && !w.closed {
+			io.WriteString(w.stdin, w.password+"\n")
+			w.closeStdin()
+		} else if w.err == nil {
+			w.err = &EscalationError{Method: BecomeSudo, Reason: "incorrect password"}
+		}
+	}
+	// Keep an end that may be the start of the next prompt or of sudoStarted
+	for keep := min(len(data), max(len(sudoPrompt), len(sudoStarted))-1); keep > 0; keep-- {
+		end := string(data[len(data)-keep:])
+		if strings.HasPrefix(sudoPrompt, end) || strings.HasPrefix(sudoStarted, end) {
+			w.pending = append([]byte(nil), data[len(data)-keep:]...)
+			data = data[:len(data)-keep]
+			break
+		}
+	}
+	w.pass(data)
+	return len(p), nil
+}
+
+// closeStdin ends the input of sudo and the command, once
+func (w *sudoWriter) closeStdin() {
+	if w.stdin != nil && !w.closed {
+		w.stdin.Close()
+	}
+	w.closed = true
+}
+
+// pass writes output of sudo or the command, looking for refusals of sudo
+func (w *sudoWriter) pass(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	w.writer.Write(data)
+	for _, b := range data {
+		if b != '\n' {
+			w.line = append(w.line, b)
+			continue
+		}
+		w.checkLine()
+	}
+}
+
+// checkLine looks for a refusal of sudo in the current line and starts the next one
+func (w *sudoWriter) checkLine() {
+	if line := strings.TrimSpace(string(w.line)); w.err == nil && !w.started && sudoDenied.MatchString(line) {
+		w.err = &EscalationError{Method: BecomeSudo, Reason: strings.TrimPrefix(line, "sudo: ")}
+	}
+	w.line = w.line[:0]
+}
+
+// flush passes on what was kept back
+func (w *sudoWriter) flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.pass(w.pending)
+	w.pending = nil
+	w.checkLine()
+}
+
+// failure returns why sudo refused, nil when it ran the command
+func (w *sudoWriter) failure() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err == nil {
+		return nil
+	}
+	return w.err
+}
+
+// sshShell is an interactive SSH session used as a shell connection, for commands
+// that run after su or enable in the same shell
+type sshShell struct {
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+}
+
+// sshShellDialer opens shells over client. Nothing is read before the prompt,
+// the escalation waits for it.
+func sshShellDialer(client *ssh.Client) shellDialer {
+	return func(ctx context.Context, termType string, rows, cols int) (shellConn, string, error) {
+		session, err := client.NewSession()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create session: %w", err)
+		}
+		shell := &sshShell{session: session}
+		if err := session.RequestPty(termType, rows, cols, ssh.TerminalModes{ssh.ECHO: 1}); err != nil {
+			session.Close()
+			return nil, "", fmt.Errorf("failed to request pty: %w", err)
+		}
+		if shell.stdin, err = session.StdinPipe(); err == nil {
+			shell.stdout, err = session.StdoutPipe()
+		}
+		if err == nil {
+			err = session.Shell()
+		}
+		if err != nil {
+			session.Close()
+			return nil, "", fmt.Errorf("failed to start shell: %w", err)
+		}
+		return shell, "", nil
+	}
+}
+
+func (s *sshShell) Read(p []byte) (int, error) {
+	return s.stdout.Read(p)
+}
+
+func (s *sshShell) Write(p []byte) (int, error) {
+	return s.stdin.Write(p)
+}
+
+func (s *sshShell) WindowChange(rows, cols int) error {
+	return s.session.WindowChange(rows, cols)
+}
+
+func (s *sshShell) Close() error {
+	return s.session.Close()
+}
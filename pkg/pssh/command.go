@@ -0,0 +1,336 @@
+package pssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ispapp/psshclient/pkg/routeros"
+	"golang.org/x/crypto/ssh"
+)
+
+// CommandResult holds the outcome of a command run on a remote host
+type CommandResult struct {
+	Host        string
+	Command     string
+	Stdout      string
+	Stderr      string
+	ExitCode    int    // -1 when the command did not report an exit status
+	Signal      string // Signal that terminated the command, e.g. "KILL"
+	Started     time.Time
+	Finished    time.Time
+	StdoutBytes int64
+	StderrBytes int64
+	Error       error // Connection or session failure; a non-zero exit is not an error
+
+	// Records holds the replies of commands run over the RouterOS API, one !re
+	// sentence per record with its attributes in the order the device sent them.
+	// Stdout has the same replies as text.
+	Records []*routeros.Sentence
+}
+
+// Duration returns how long the command ran
+func (result *CommandResult) Duration() time.Duration {
+	if result.Finished.IsZero() {
+		return 0
+	}
+	return result.Finished.Sub(result.Started)
+}
+
+// Success reports whether the command ran and exited with status 0
+func (result *CommandResult) Success() bool {
+	return result.Error == nil && result.ExitCode == 0 && result.Signal == ""
+}
+
+// Output returns stdout followed by stderr
+func (result *CommandResult) Output() string {
+	return result.Stdout + result.Stderr
+}
+
+// Status returns a short description of how the command ended
+func (result *CommandResult) Status() string {
+	switch {
+	case result.EscalationFailed():
+		return result.Error.Error()
+	case result.Error != nil:
+		return fmt.Sprintf("error: %v", result.Error)
+	case result.Signal != "":
+		return fmt.Sprintf("killed by signal %s", result.Signal)
+	default:
+		return fmt.Sprintf("exit %d", result.ExitCode)
+	}
+}
+
+// EscalationFailed reports whether sudo, su or enable refused, so the command did not run
+func (result *CommandResult) EscalationFailed() bool {
+	return IsEscalationError(result.Error)
+}
+
+// Cancelled reports whether the command was stopped by cancellation or its timeout
+func (result *CommandResult) Cancelled() bool {
+	return errors.Is(result.Error, context.Canceled) || errors.Is(result.Error, context.DeadlineExceeded)
+}
+
+// cancelGrace is how long a cancelled command may take to close its session
+// before its output is returned without waiting any longer
+const cancelGrace = 2 * time.Second
+
+// countingBuffer collects output and counts the bytes written to it.
+// It is safe for the concurrent stdout and stderr writers of a session.
+type countingBuffer struct {
+	buffer bytes.Buffer
+	count  int64
+	mutex  sync.Mutex
+}
+
+func (buffer *countingBuffer) Write(p []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	n, err := buffer.buffer.Write(p)
+	buffer.count += int64(n)
+	return n, err
+}
+
+func (buffer *countingBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.String()
+}
+
+func (buffer *countingBuffer) Count() int64 {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.count
+}
+
+// OutputLine is a line of output of a running command
+type OutputLine struct {
+	Text   string
+	Stderr bool
+}
+
+// lineWriter passes complete lines to a callback and keeps a partial line until the
+// rest of it arrives
+type lineWriter struct {
+	stderr  bool
+	emit    func(OutputLine)
+	partial []byte
+	mutex   sync.Mutex
+}
+
+func (writer *lineWriter) Write(p []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	writer.partial = append(writer.partial, p...)
+	for {
+		end := bytes.IndexByte(writer.partial, '\n')
+		if end == -1 {
+			break
+		}
+		writer.emit(OutputLine{Text: string(bytes.TrimSuffix(writer.partial[:end], []byte("\r"))), Stderr: writer.stderr})
+		writer.partial = writer.partial[end+1:]
+	}
+	return len(p), nil
+}
+
+// flush passes on the last line of output if it did not end with a newline
+func (writer *lineWriter) flush() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if len(writer.partial) > 0 {
+		writer.emit(OutputLine{Text: string(writer.partial), Stderr: writer.stderr})
+		writer.partial = nil
+	}
+}
+
+// RunCommandContext runs a command on the remote server and returns its combined output.
+// When ctx is done or Config.CommandTimeout passes, the command is killed and the output
+// produced so far is returned along with the cancellation error.
+func (conn *SSHConnection) RunCommandContext(ctx context.Context, command string) (string, error) {
+	var output countingBuffer
+	err := conn.runSession(ctx, command, &output, &output)
+	return output.String(), err
+}
+
+// RunCommandResult runs a command on the remote server and returns its structured result.
+// Output is kept even when the command fails.
+func (conn *SSHConnection) RunCommandResult(command string) *CommandResult {
+	return conn.RunCommandResultContext(context.Background(), command)
+}
+
+// RunCommandResultContext is RunCommandResult with cancellation. A cancelled or timed out
+// command keeps the output produced so far and reports the cause in Error.
+func (conn *SSHConnection) RunCommandResultContext(ctx context.Context, command string) *CommandResult {
+	return conn.RunCommandStream(ctx, command, nil)
+}
+
+// RunCommandStream is RunCommandResultContext that also passes each line of output to
+// onLine as it arrives. onLine is called from the goroutines reading stdout and stderr,
+// which may run at the same time.
+func (conn *SSHConnection) RunCommandStream(ctx context.Context, command string, onLine func(OutputLine)) *CommandResult {
+	result := &CommandResult{
+		Host:     conn.Config.Host,
+		Command:  command,
+		ExitCode: -1,
+		Started:  time.Now(),
+	}
+
+	var stdout, stderr countingBuffer
+	var stdoutWriter, stderrWriter io.Writer = &stdout, &stderr
+	var stdoutLines, stderrLines *lineWriter
+	if onLine != nil {
+		stdoutLines = &lineWriter{emit: onLine}
+		stderrLines = &lineWriter{emit: onLine, stderr: true}
+		stdoutWriter = io.MultiWriter(&stdout, stdoutLines)
+		stderrWriter = io.MultiWriter(&stderr, stderrLines)
+	}
+
+	var err error
+	if client := conn.API(); client != nil {
+		result.Records, err = conn.runAPICommand(ctx, client, command, stdoutWriter, stderrWriter)
+	} else {
+		err = conn.runSession(ctx, command, stdoutWriter, stderrWriter)
+	}
+	if onLine != nil {
+		stdoutLines.flush()
+		stderrLines.flush()
+	}
+	result.Finished = time.Now()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.StdoutBytes = stdout.Count()
+	result.StderrBytes = stderr.Count()
+	applyExitStatus(result, err)
+
+	return result
+}
+
+// runSession runs a command in a new session of the connection. The client is only
+// looked up under the lock, so commands on one connection run concurrently and do not
+// block Close. On cancellation the command is sent SIGKILL and its session closed.
+func (conn *SSHConnection) runSession(ctx context.Context, command string, stdout, stderr io.Writer) error {
+	conn.mutex.RLock()
+	client := conn.Client
+	connected := conn.Connected
+	timeout := conn.Config.CommandTimeout
+	dial := conn.shellDialer()
+	api := conn.api
+	conn.mutex.RUnlock()
+
+	if api != nil {
+		_, err := conn.runAPICommand(ctx, api, command, stdout, stderr)
+		return err
+	}
+	if !connected || (client == nil && dial == nil) {
+		return fmt.Errorf("not connected")
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return commandCancelled(err, timeout)
+	}
+	if dial != nil {
+		return runShell(ctx, dial, command, stdout, timeout, conn.Config.escalation())
+	}
+	// su and enable only last for the shell they are run in
+	become, password := conn.Config.Become, conn.Config.BecomePassword
+	if become == BecomeSu || become == BecomeEnable {
+		return runShell(ctx, sshShellDialer(client), command, stdout, timeout, conn.Config.escalation())
+	}
+
+	// Create a new session for this command
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+	var sudo *sudoWriter
+	if become == BecomeSudo {
+		command = sudoCommand(command, password != "")
+		sudo = &sudoWriter{writer: stderr, password: password}
+		if password != "" {
+			if sudo.stdin, err = session.StdinPipe(); err != nil {
+				return fmt.Errorf("failed to create session: %w", err)
+			}
+		}
+		session.Stderr = sudo
+	}
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("failed to run command: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if sudo != nil {
+			sudo.flush()
+			// sudo exits with 1 when it refuses, the cause tells it from the command failing
+			if failure := sudo.failure(); failure != nil && err != nil {
+				return failure
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to run command: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+	}
+
+	// Not every server supports signals, closing the session stops the command as well
+	session.Signal(ssh.SIGKILL)
+	session.Close()
+	select {
+	case <-done:
+	case <-time.After(cancelGrace):
+	}
+
+	return commandCancelled(ctx.Err(), timeout)
+}
+
+// commandCancelled describes why a command was stopped
+func commandCancelled(err error, timeout time.Duration) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		if timeout > 0 {
+			return fmt.Errorf("command timed out after %v: %w", timeout, err)
+		}
+		return fmt.Errorf("command timed out: %w", err)
+	}
+	return fmt.Errorf("command cancelled: %w", err)
+}
+
+// applyExitStatus records the exit code, signal or failure of a finished session
+func applyExitStatus(result *CommandResult, err error) {
+	var exitErr *ssh.ExitError
+	var missingErr *ssh.ExitMissingError
+	var deviceErr *routeros.DeviceError
+	switch {
+	case err == nil:
+		result.ExitCode = 0
+	case errors.As(err, &deviceErr):
+		result.ExitCode = 1 // Refused by the device, the reason is in stderr
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+		result.Signal = exitErr.Signal()
+	case errors.As(err, &missingErr):
+		result.Error = fmt.Errorf("command ended without an exit status (connection lost?)")
+	default:
+		result.Error = err
+	}
+}
@@ -0,0 +1,220 @@
+package pssh
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// EC-SRP is the password authenticated key exchange RouterOS uses for MAC-Telnet
+// and Winbox logins since 6.43. It runs on Curve25519 in Weierstrass form, points
+// are exchanged as Montgomery x coordinates followed by the parity of y.
+
+// ecPoint is an affine point on the curve, nil is the point at infinity
+type ecPoint struct {
+	x, y *big.Int
+}
+
+// ecsrpCurve holds the curve parameters
+type ecsrpCurve struct {
+	p     *big.Int // Field prime, 2^255 - 19
+	r     *big.Int // Order of the base point
+	montA *big.Int // A of the Montgomery form
+	a     *big.Int // a of the Weierstrass form
+	third *big.Int // A/3, the offset between Montgomery and Weierstrass x
+	g     *ecPoint // Base point, Montgomery x = 9
+}
+
+var ecsrp = newECSRPCurve()
+
+func newECSRPCurve() *ecsrpCurve {
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	r, _ := new(big.Int).SetString("1000000000000000000000000000000014def9dea2f79cd65812631a5cf5d3ed", 16)
+	montA := big.NewInt(486662)
+	inv3 := new(big.Int).ModInverse(big.NewInt(3), p)
+
+	// a = (3 - A²) / 3
+	a := new(big.Int).Mul(montA, montA)
+	a.Sub(big.NewInt(3), a)
+	a.Mul(a, inv3)
+	a.Mod(a, p)
+
+	curve := &ecsrpCurve{
+		p:     p,
+		r:     r,
+		montA: montA,
+		a:     a,
+		third: new(big.Int).Mod(new(big.Int).Mul(montA, inv3), p),
+	}
+	curve.g = curve.liftX(big.NewInt(9), 0)
+	return curve
+}
+
+// add returns P + Q
+func (c *ecsrpCurve) add(p1, p2 *ecPoint) *ecPoint {
+	if p1 == nil {
+		return p2
+	}
+	if p2 == nil {
+		return p1
+	}
+	if p1.x.Cmp(p2.x) == 0 {
+		sum := new(big.Int).Add(p1.y, p2.y)
+		if sum.Mod(sum, c.p).Sign() == 0 {
+			return nil
+		}
+		return c.double(p1)
+	}
+
+	// λ = (y2 - y1) / (x2 - x1)
+	num := new(big.Int).Sub(p2.y, p1.y)
+	den := new(big.Int).Sub(p2.x, p1.x)
+	den.Mod(den, c.p).ModInverse(den, c.p)
+	lambda := num.Mul(num, den)
+	lambda.Mod(lambda, c.p)
+	return c.fromLambda(lambda, p1, p2.x)
+}
+
+// double returns 2P
+func (c *ecsrpCurve) double(p1 *ecPoint) *ecPoint {
+	if p1 == nil || p1.y.Sign() == 0 {
+		return nil
+	}
+
+	// λ = (3x² + a) / 2y
+	num := new(big.Int).Mul(p1.x, p1.x)
+	num.Mul(num, big.NewInt(3)).Add(num, c.a)
+	den := new(big.Int).Lsh(p1.y, 1)
+	den.Mod(den, c.p).ModInverse(den, c.p)
+	lambda := num.Mul(num, den)
+	lambda.Mod(lambda, c.p)
+	return c.fromLambda(lambda, p1, p1.x)
+}
+
+// fromLambda finishes an addition of p1 and a point with x coordinate x2
+func (c *ecsrpCurve) fromLambda(lambda *big.Int, p1 *ecPoint, x2 *big.Int) *ecPoint {
+	x := new(big.Int).Mul(lambda, lambda)
+	x.Sub(x, p1.x).Sub(x, x2).Mod(x, c.p)
+	y := new(big.Int).Sub(p1.x, x)
+	y.Mul(y, lambda).Sub(y, p1.y).Mod(y, c.p)
+	return &ecPoint{x: x, y: y}
+}
+
+// mul returns kP
+func (c *ecsrpCurve) mul(k *big.Int, p1 *ecPoint) *ecPoint {
+	var result *ecPoint
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		result = c.double(result)
+		if k.Bit(i) == 1 {
+			result = c.add(result, p1)
+		}
+	}
+	return result
+}
+
+// liftX returns the point with Montgomery x coordinate x and the given parity of
+// y, or nil when x is not on the curve
+func (c *ecsrpCurve) liftX(x *big.Int, parity uint) *ecPoint {
+	x = new(big.Int).Mod(x, c.p)
+
+	// y² = x³ + Ax² + x
+	x2 := new(big.Int).Mul(x, x)
+	ySquared := new(big.Int).Mul(x2, x)
+	ySquared.Add(ySquared, x2.Mul(x2, c.montA)).Add(ySquared, x).Mod(ySquared, c.p)
+	y := new(big.Int).ModSqrt(ySquared, c.p)
+	if y == nil {
+		return nil
+	}
+	if y.Bit(0) != parity {
+		y.Sub(c.p, y).Mod(y, c.p)
+	}
+
+	xw := x.Add(x, c.third)
+	return &ecPoint{x: xw.Mod(xw, c.p), y: y}
+}
+
+// toMontgomery returns the Montgomery x coordinate of a point and the parity of y
+func (c *ecsrpCurve) toMontgomery(p1 *ecPoint) ([]byte, uint) {
+	if p1 == nil {
+		return make([]byte, 32), 0
+	}
+	x := new(big.Int).Sub(p1.x, c.third)
+	return x.Mod(x, c.p).FillBytes(make([]byte, 32)), p1.y.Bit(0)
+}
+
+// redp1 hashes a value to a curve point
+func (c *ecsrpCurve) redp1(x []byte, parity uint) *ecPoint {
+	digest := sha256.Sum256(x)
+	counter := new(big.Int).SetBytes(digest[:])
+	for {
+		candidate := sha256.Sum256(counter.FillBytes(make([]byte, 32)))
+		if point := c.liftX(new(big.Int).SetBytes(candidate[:]), parity); point != nil {
+			return point
+		}
+		counter.Add(counter, big.NewInt(1))
+	}
+}
+
+// ecsrpValidator returns the private password validator, sha256(salt | sha256(user:pass))
+func ecsrpValidator(username, password string, salt []byte) []byte {
+	inner := sha256.Sum256([]byte(username + ":" + password))
+	validator := sha256.Sum256(append(append([]byte{}, salt...), inner[:]...))
+	return validator[:]
+}
+
+// ecsrpClient is the client side of an EC-SRP login
+type ecsrpClient struct {
+	secret *big.Int
+	public []byte // Montgomery x and parity, 33 bytes
+}
+
+// newECSRPClient creates a random key pair for one login
+func newECSRPClient() (*ecsrpClient, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	client := &ecsrpClient{secret: new(big.Int).SetBytes(secret)}
+	x, parity := ecsrp.toMontgomery(ecsrp.mul(client.secret, ecsrp.g))
+	client.public = append(x, byte(parity))
+	return client, nil
+}
+
+// response computes the password proof from the server public key and salt
+// (49 bytes: Montgomery x, parity, 16 bytes salt)
+func (c *ecsrpClient) response(username, password string, server []byte) ([]byte, error) {
+	if len(server) != 49 {
+		return nil, fmt.Errorf("invalid EC-SRP server key of %d bytes", len(server))
+	}
+	serverX, serverParity, salt := server[:32], uint(server[32]&1), server[33:]
+
+	validator := ecsrp.validatorPoint(username, password, salt)
+	gammaX, _ := ecsrp.toMontgomery(validator.point)
+	serverKey := ecsrp.liftX(new(big.Int).SetBytes(serverX), serverParity)
+	if serverKey == nil {
+		return nil, fmt.Errorf("invalid EC-SRP server key")
+	}
+	// The server added the validator to its key, take it off again
+	serverKey = ecsrp.add(serverKey, ecsrp.redp1(gammaX, 1))
+
+	j := sha256.Sum256(append(append([]byte{}, c.public[:32]...), serverX...))
+	scalar := new(big.Int).Mul(validator.scalar, new(big.Int).SetBytes(j[:]))
+	scalar.Add(scalar, c.secret).Mod(scalar, ecsrp.r)
+	z, _ := ecsrp.toMontgomery(ecsrp.mul(scalar, serverKey))
+
+	proof := sha256.Sum256(append(j[:], z...))
+	return proof[:], nil
+}
+
+// ecsrpPassword is the private validator of a password and its public point
+type ecsrpPassword struct {
+	scalar *big.Int
+	point  *ecPoint
+}
+
+// validatorPoint derives the validator of a password
+func (c *ecsrpCurve) validatorPoint(username, password string, salt []byte) ecsrpPassword {
+	scalar := new(big.Int).SetBytes(ecsrpValidator(username, password, salt))
+	return ecsrpPassword{scalar: scalar, point: c.mul(scalar, c.g)}
+}
@@ -0,0 +1,265 @@
+package pssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSkipped is reported for hosts that never ran because the execution was aborted
+var ErrSkipped = errors.New("skipped: execution aborted")
+
+// ExecutorConfig controls how work fans out over many hosts
+type ExecutorConfig struct {
+	MaxConcurrency int           // Hosts running at the same time, 0 is unlimited
+	BatchSize      string        // Hosts per serial batch as a count ("10") or share ("10%"), empty for one batch
+	Canary         string        // Host run alone before all others, the rest is skipped when it fails
+	AbortAfter     int           // Stop starting hosts once this many have failed, 0 never stops
+	HostTimeout    time.Duration // Time each host may run, 0 is unlimited
+}
+
+// HostTask runs work on the host with the given index and returns its failure, if any
+type HostTask func(ctx context.Context, index int) error
+
+// ExecutionReport summarizes a fan-out run
+type ExecutionReport struct {
+	Errors  []error // Outcome per host in input order, ErrSkipped for hosts that never ran
+	Failed  int     // Hosts that ran and failed
+	Skipped int     // Hosts that never ran
+	Aborted error   // Why hosts were skipped, nil when every host ran
+}
+
+// ParseBatchSize returns the number of hosts per batch described by spec, either a
+// count ("10") or a percentage of total ("25%"). An empty spec is a single batch.
+func ParseBatchSize(spec string, total int) (int, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || total == 0 {
+		return max(total, 1), nil
+	}
+
+	if percent, isPercent := strings.CutSuffix(spec, "%"); isPercent {
+		value, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || value <= 0 || value > 100 {
+			return 0, fmt.Errorf("invalid batch size %q: expected a percentage between 0 and 100", spec)
+		}
+		// Round up so small fleets still make progress, e.g. 10% of 5 hosts is 1
+		return int(math.Ceil(float64(total) * value / 100)), nil
+	}
+
+	size, err := strconv.Atoi(spec)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid batch size %q: expected a number of hosts or a percentage", spec)
+	}
+	return size, nil
+}
+
+// Execute runs task for every host following config. The canary, if set, runs first
+// on its own; the other hosts run in serial batches with at most MaxConcurrency at a
+// time, each with a ctx that ends after HostTimeout. Once the execution is aborted
+// (canary failure, AbortAfter failures or ctx done) running hosts finish and the
+// remaining ones are skipped.
+func Execute(ctx context.Context, hosts []string, config ExecutorConfig, task HostTask) (*ExecutionReport, error) {
+	batchSize, err := ParseBatchSize(config.BatchSize, len(hosts))
+	if err != nil {
+		return nil, err
+	}
+
+	canary := -1
+	if config.Canary != "" {
+		for i, host := range hosts {
+			if host == config.Canary {
+				canary = i
+				break
+			}
+		}
+		if canary == -1 {
+			return nil, fmt.Errorf("canary host %s is not one of the selected hosts", config.Canary)
+		}
+	}
+
+	run := &execution{
+		config: config,
+		task:   task,
+		report: &ExecutionReport{Errors: make([]error, len(hosts))},
+		ran:    make([]bool, len(hosts)),
+	}
+
+	if canary != -1 {
+		run.batch(ctx, []int{canary})
+		if err := run.report.Errors[canary]; err != nil && run.report.Aborted == nil {
+			run.report.Aborted = fmt.Errorf("canary host %s failed: %w", hosts[canary], err)
+		}
+	}
+
+	order := make([]int, 0, len(hosts))
+	for i := range hosts {
+		if i != canary {
+			order = append(order, i)
+		}
+	}
+	for start := 0; start < len(order) && !run.aborted(ctx); start += batchSize {
+		run.batch(ctx, order[start:min(start+batchSize, len(order))])
+	}
+
+	for i, ran := range run.ran {
+		if !ran {
+			run.report.Errors[i] = ErrSkipped
+			run.report.Skipped++
+		}
+	}
+	return run.report, nil
+}
+
+// execution is the state of one Execute call
+type execution struct {
+	config ExecutorConfig
+	task   HostTask
+	report *ExecutionReport
+	ran    []bool
+	mutex  sync.Mutex
+}
+
+// aborted reports whether no more hosts may start, recording why the first time
+func (run *execution) aborted(ctx context.Context) bool {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+
+	if run.report.Aborted == nil && ctx.Err() != nil {
+		run.report.Aborted = fmt.Errorf("execution cancelled: %w", ctx.Err())
+	}
+	return run.report.Aborted != nil
+}
+
+// batch runs the hosts of one batch and waits for all of them
+func (run *execution) batch(ctx context.Context, indexes []int) {
+	limit := len(indexes)
+	if run.config.MaxConcurrency > 0 && run.config.MaxConcurrency < limit {
+		limit = run.config.MaxConcurrency
+	}
+	slots := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for _, index := range indexes {
+		slots <- struct{}{}
+		if run.aborted(ctx) {
+			<-slots
+			break
+		}
+
+		run.mutex.Lock()
+		run.ran[index] = true
+		run.mutex.Unlock()
+
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			hostCtx := ctx
+			if run.config.HostTimeout > 0 {
+				var cancel context.CancelFunc
+				hostCtx, cancel = context.WithTimeout(ctx, run.config.HostTimeout)
+				defer cancel()
+			}
+			run.finish(index, run.task(hostCtx, index))
+		}(index)
+	}
+	wg.Wait()
+}
+
+// finish records the outcome of a host and aborts once too many hosts failed
+func (run *execution) finish(index int, err error) {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+
+	run.report.Errors[index] = err
+	if err == nil {
+		return
+	}
+	run.report.Failed++
+	if run.config.AbortAfter > 0 && run.report.Failed >= run.config.AbortAfter && run.report.Aborted == nil {
+		run.report.Aborted = fmt.Errorf("aborted after %d failed hosts", run.report.Failed)
+	}
+}
+
+// CommandCallbacks report the progress of RunCommandMultiple per connection index.
+// They are called from the worker goroutines and may be nil.
+type CommandCallbacks struct {
+	OnStart  func(index int)
+	OnOutput func(index int, line OutputLine)
+	OnResult func(index int, result *CommandResult)
+}
+
+// RunCommandMultiple runs a command on many connections following config. Hosts that
+// never ran get a result whose Error is ErrSkipped. The returned error tells why the
+// execution was aborted, if it was.
+func RunCommandMultiple(ctx context.Context, connections []*SSHConnection, command string, config ExecutorConfig, callbacks CommandCallbacks) ([]*CommandResult, error) {
+	return runMultiple(ctx, connections, command, config, callbacks, func(ctx context.Context, conn *SSHConnection, onLine func(OutputLine)) *CommandResult {
+		return conn.RunCommandStream(ctx, command, onLine)
+	})
+}
+
+// RunExpectMultiple runs an expect script on many connections following config,
+// like RunCommandMultiple. A script still waiting for a pattern when HostTimeout
+// passes is stopped.
+func RunExpectMultiple(ctx context.Context, connections []*SSHConnection, script ExpectScript, config ExecutorConfig, callbacks CommandCallbacks) ([]*CommandResult, error) {
+	command := fmt.Sprintf("expect script (%d steps)", len(script))
+	return runMultiple(ctx, connections, command, config, callbacks, func(ctx context.Context, conn *SSHConnection, onLine func(OutputLine)) *CommandResult {
+		return conn.RunExpect(ctx, script, onLine)
+	})
+}
+
+// runMultiple runs work on many connections following config, command describes
+// the work in the results of skipped hosts
+func runMultiple(ctx context.Context, connections []*SSHConnection, command string, config ExecutorConfig, callbacks CommandCallbacks,
+	run func(ctx context.Context, conn *SSHConnection, onLine func(OutputLine)) *CommandResult) ([]*CommandResult, error) {
+	hosts := make([]string, len(connections))
+	for i, conn := range connections {
+		hosts[i] = conn.Config.Host
+	}
+
+	results := make([]*CommandResult, len(connections))
+	report, err := Execute(ctx, hosts, config, func(ctx context.Context, index int) error {
+		if callbacks.OnStart != nil {
+			callbacks.OnStart(index)
+		}
+		var onLine func(OutputLine)
+		if callbacks.OnOutput != nil {
+			onLine = func(line OutputLine) {
+				callbacks.OnOutput(index, line)
+			}
+		}
+
+		result := run(ctx, connections[index], onLine)
+		results[index] = result
+		if callbacks.OnResult != nil {
+			callbacks.OnResult(index, result)
+		}
+		if !result.Success() {
+			return fmt.Errorf("%s", result.Status())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i, hostErr := range report.Errors {
+		if hostErr == ErrSkipped {
+			results[i] = &CommandResult{
+				Host:     hosts[i],
+				Command:  command,
+				ExitCode: -1,
+				Started:  now,
+				Finished: now,
+				Error:    ErrSkipped,
+			}
+		}
+	}
+	return results, report.Aborted
+}
@@ -0,0 +1,361 @@
+package pssh
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExpectTimeout is how long an expect step waits for its pattern unless the
+// script sets another timeout
+var ExpectTimeout = 10 * time.Second
+
+// ExpectStep is one step of an expect script: text sent to the device, or a
+// pattern waited for in its output
+type ExpectStep struct {
+	Send    string         // Text sent as is, empty for expect steps
+	Expect  *regexp.Regexp // Pattern waited for, nil for send steps
+	Timeout time.Duration  // How long to wait for Expect, 0 for ExpectTimeout
+	Line    int            // Line of the step in the script
+}
+
+// ExpectScript is a sequence of steps run in an interactive shell
+type ExpectScript []ExpectStep
+
+// ParseExpectScript parses an expect script, one step per line:
+//
+//	expect TEXT       wait for TEXT in the output
+//	expect /REGEX/    wait for a match of REGEX, its groups are captured
+//	send TEXT         send TEXT followed by Enter, "send" alone sends Enter
+//	send "TEXT"       send the quoted string as is, e.g. "y" or "\x03"
+//	timeout DURATION  wait that long in the expect steps that follow, e.g. 30s
+//
+// Sent text may use the groups captured so far as $1 to $9 or ${name}. Blank
+// lines and lines starting with # are skipped.
+func ParseExpectScript(text string) (ExpectScript, error) {
+	var script ExpectScript
+	var timeout time.Duration
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keyword, argument, _ := strings.Cut(line, " ")
+		argument = strings.TrimSpace(argument)
+		step := ExpectStep{Line: i + 1}
+
+		switch strings.ToLower(keyword) {
+		case "send":
+			if strings.HasPrefix(argument, `"`) {
+				unquoted, err := strconv.Unquote(argument)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid quoted text %s", i+1, argument)
+				}
+				step.Send = unquoted
+			} else {
+				step.Send = argument + "\r"
+			}
+		case "expect":
+			if argument == "" {
+				return nil, fmt.Errorf("line %d: expect needs a text or /regex/", i+1)
+			}
+			pattern := regexp.QuoteMeta(argument)
+			if len(argument) > 1 && strings.HasPrefix(argument, "/") && strings.HasSuffix(argument, "/") {
+				pattern = argument[1 : len(argument)-1]
+			}
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid regex: %v", i+1, err)
+			}
+			step.Expect = compiled
+			step.Timeout = timeout
+		case "timeout":
+			duration, err := time.ParseDuration(argument)
+			if err != nil {
+				seconds, atoiErr := strconv.Atoi(argument)
+				duration, err = time.Duration(seconds)*time.Second, atoiErr
+			}
+			if err != nil || duration <= 0 {
+				return nil, fmt.Errorf("line %d: invalid timeout %q, e.g. 30s", i+1, argument)
+			}
+			timeout = duration
+			continue
+		default:
+			return nil, fmt.Errorf("line %d: unknown step %q, expected send, expect or timeout", i+1, keyword)
+		}
+		script = append(script, step)
+	}
+	if len(script) == 0 {
+		return nil, fmt.Errorf("the expect script has no steps")
+	}
+	return script, nil
+}
+
+// Expect sends text to an interactive shell and waits for patterns in its output
+type Expect struct {
+	shell   shellConn
+	chunks  chan []byte
+	readErr error // Why the output ended, set before chunks is closed
+	buffer  []byte
+	output  func([]byte)      // Called with everything the shell prints
+	Vars    map[string]string // Groups captured so far, by number and by name
+	done    chan struct{}
+}
+
+// newExpect starts reading the output of shell, transcript is what the shell
+// printed while logging in
+func newExpect(shell shellConn, transcript string, output func([]byte)) *Expect {
+	e := &Expect{
+		shell:  shell,
+		chunks: make(chan []byte, 16),
+		buffer: []byte(transcript),
+		output: output,
+		Vars:   map[string]string{},
+		done:   make(chan struct{}),
+	}
+	if output != nil && transcript != "" {
+		output([]byte(transcript))
+	}
+	go e.read()
+	return e
+}
+
+func (e *Expect) read() {
+	defer close(e.chunks)
+	for {
+		buf := make([]byte, 4096)
+		n, err := e.shell.Read(buf)
+		if n > 0 {
+			if e.output != nil {
+				e.output(buf[:n])
+			}
+			select {
+			case e.chunks <- buf[:n]:
+			case <-e.done:
+				return
+			}
+		}
+		if err != nil {
+			e.readErr = err
+			return
+		}
+	}
+}
+
+// OpenExpect opens an interactive shell with a pty on the device for expect
+// steps, escalated when a become method is set. output gets everything the
+// shell prints and may be nil.
+func (conn *SSHConnection) OpenExpect(ctx context.Context, output func([]byte)) (*Expect, error) {
+	conn.mutex.RLock()
+	client := conn.Client
+	connected := conn.Connected
+	dial := conn.shellDialer()
+	conn.mutex.RUnlock()
+
+	if conn.Config.usesAPI() {
+		return nil, fmt.Errorf("expect scripts need an interactive shell, which the RouterOS API does not have")
+	}
+	if !connected || (client == nil && dial == nil) {
+		return nil, fmt.Errorf("not connected")
+	}
+	if dial == nil {
+		dial = sshShellDialer(client)
+	}
+
+	// A dumb terminal keeps colors and cursor movements out of the output
+	shell, transcript, err := dial(ctx, "dumb", 24, 200)
+	if err != nil {
+		return nil, err
+	}
+	if become := conn.Config.escalation(); become != nil {
+		// The escalation reads the output itself, so it is passed on here
+		if output != nil && transcript != "" {
+			output([]byte(transcript))
+		}
+		stop := context.AfterFunc(ctx, func() {
+			shell.Close()
+		})
+		_, err := become.run(outputShell{shell, output}, transcript)
+		stop()
+		if err != nil {
+			shell.Close()
+			return nil, err
+		}
+		return newExpect(shell, "", output), nil
+	}
+	return newExpect(shell, transcript, output), nil
+}
+
+// outputShell passes what is read from a shell to output
+type outputShell struct {
+	shellConn
+	output func([]byte)
+}
+
+func (s outputShell) Read(p []byte) (int, error) {
+	n, err := s.shellConn.Read(p)
+	if n > 0 && s.output != nil {
+		s.output(p[:n])
+	}
+	return n, err
+}
+
+// expectVar matches the captured groups used in sent text
+var expectVar = regexp.MustCompile(`\$\{(\w+)\}|\$([0-9])`)
+
+// Send sends text to the shell, with the captured groups filled in. Unknown
+// groups are sent as written, so device variables such as $name stay intact.
+func (e *Expect) Send(text string) error {
+	text = expectVar.ReplaceAllStringFunc(text, func(reference string) string {
+		name := strings.Trim(reference, "${}")
+		if value, ok := e.Vars[name]; ok {
+			return value
+		}
+		return reference
+	})
+	if _, err := e.shell.Write([]byte(text)); err != nil {
+		return fmt.Errorf("failed to send: %w", err)
+	}
+	return nil
+}
+
+// Expect waits until pattern matches the output received since the last match,
+// and returns the match and its groups, which are also kept in Vars. Output
+// before the end of the match is consumed.
+func (e *Expect) Expect(ctx context.Context, pattern *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	if timeout <= 0 {
+		timeout = ExpectTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		if match := pattern.FindSubmatchIndex(e.buffer); match != nil {
+			groups := make([]string, len(match)/2)
+			for i := range groups {
+				if match[2*i] >= 0 {
+					groups[i] = string(e.buffer[match[2*i]:match[2*i+1]])
+				}
+			}
+			e.capture(pattern, groups)
+			e.buffer = append([]byte(nil), e.buffer[match[1]:]...)
+			return groups, nil
+		}
+
+		select {
+		case chunk, ok := <-e.chunks:
+			if !ok {
+				return nil, fmt.Errorf("output ended waiting for /%s/: %v", pattern, e.readErr)
+			}
+			e.buffer = append(e.buffer, chunk...)
+			// Only the recent output is searched, patterns match what was just printed
+			if excess := len(e.buffer) - 64*1024; excess > 0 {
+				e.buffer = e.buffer[excess:]
+			}
+		case <-timer.C:
+			return nil, fmt.Errorf("timed out after %v waiting for /%s/, last output: %q", timeout, pattern, lastLine(string(e.buffer)))
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// capture keeps the groups of a match in Vars, numbered groups of earlier
+// matches are dropped
+func (e *Expect) capture(pattern *regexp.Regexp, groups []string) {
+	for i := 0; i <= 9; i++ {
+		delete(e.Vars, strconv.Itoa(i))
+	}
+	for i, group := range groups {
+		if i <= 9 {
+			e.Vars[strconv.Itoa(i)] = group
+		}
+		if name := pattern.SubexpNames()[i]; name != "" {
+			e.Vars[name] = group
+		}
+	}
+}
+
+// Run runs the steps of script in order and stops at the first failure
+func (e *Expect) Run(ctx context.Context, script ExpectScript) error {
+	for _, step := range script {
+		if step.Expect != nil {
+			if _, err := e.Expect(ctx, step.Expect, step.Timeout); err != nil {
+				return fmt.Errorf("line %d: %w", step.Line, err)
+			}
+			continue
+		}
+		if err := e.Send(step.Send); err != nil {
+			return fmt.Errorf("line %d: %w", step.Line, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the shell
+func (e *Expect) Close() error {
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+	return e.shell.Close()
+}
+
+// RunExpect runs an expect script in a new shell on the device and returns the
+// output as plain text lines, passed to onLine as they arrive. A step that
+// fails, e.g. a pattern that never shows, is reported in Error. When ctx is done,
+// e.g. at the HostTimeout of RunExpectMultiple, or Config.CommandTimeout passes,
+// the script is stopped.
+func (conn *SSHConnection) RunExpect(ctx context.Context, script ExpectScript, onLine func(OutputLine)) *CommandResult {
+	result := &CommandResult{
+		Host:     conn.Config.Host,
+		Command:  fmt.Sprintf("expect script (%d steps)", len(script)),
+		ExitCode: -1,
+		Started:  time.Now(),
+	}
+
+	if timeout := conn.Config.CommandTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var stdout countingBuffer
+	var text plainText
+	output := func(p []byte) {
+		text.feed(p, func(line string) {
+			stdout.Write([]byte(line + "\n"))
+			if onLine != nil {
+				onLine(OutputLine{Text: line})
+			}
+		})
+	}
+
+	e, err := conn.OpenExpect(ctx, output)
+	if err == nil {
+		err = e.Run(ctx, script)
+		e.Close()
+		// The output goroutine is done once the shell is closed
+		for range e.chunks {
+		}
+	}
+	if ctx.Err() != nil {
+		err = commandCancelled(ctx.Err(), conn.Config.CommandTimeout)
+	}
+	if last := text.text(); last != "" {
+		stdout.Write([]byte(last))
+		if onLine != nil {
+			onLine(OutputLine{Text: last})
+		}
+	}
+
+	result.Finished = time.Now()
+	result.Stdout = stdout.String()
+	result.StdoutBytes = stdout.Count()
+	applyExitStatus(result, err)
+	return result
+}
@@ -0,0 +1,114 @@
+package pssh
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// findBar searches the scrollback of a terminal view. The captured output is
+// listed below the terminal, with the current match selected.
+type findBar struct {
+	view      *terminalView
+	entry     *widget.Entry
+	regex     *widget.Check
+	matchCase *widget.Check
+	status    *widget.Label
+	list      *widget.List
+	lines     []string
+	matches   []int
+	current   int // Position of the selected match in matches, -1 when none
+	content   fyne.CanvasObject
+}
+
+func newFindBar(view *terminalView) *findBar {
+	fb := &findBar{view: view, current: -1}
+
+	fb.entry = widget.NewEntry()
+	fb.entry.SetPlaceHolder("Find in output")
+	fb.entry.OnChanged = func(string) {
+		fb.current = -1
+	}
+	// Searching goes back from the latest output
+	fb.entry.OnSubmitted = func(string) {
+		fb.find(false)
+	}
+	fb.regex = widget.NewCheck("Regex", func(bool) {
+		fb.current = -1
+	})
+	fb.matchCase = widget.NewCheck("Match case", func(bool) {
+		fb.current = -1
+	})
+	fb.status = widget.NewLabel("")
+
+	fb.list = widget.NewList(
+		func() int {
+			return len(fb.lines)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.TextStyle = fyne.TextStyle{Monospace: true}
+			return label
+		},
+		func(id widget.ListItemID, object fyne.CanvasObject) {
+			object.(*widget.Label).SetText(fb.lines[id])
+		},
+	)
+
+	prevBtn := widget.NewButtonWithIcon("", theme.MoveUpIcon(), func() { fb.find(false) })
+	nextBtn := widget.NewButtonWithIcon("", theme.MoveDownIcon(), func() { fb.find(true) })
+	closeBtn := widget.NewButtonWithIcon("", theme.CancelIcon(), view.toggleFind)
+	controls := container.NewHBox(fb.regex, fb.matchCase, prevBtn, nextBtn, fb.status, closeBtn)
+	bar := container.NewBorder(nil, nil, nil, controls, fb.entry)
+	fb.content = container.NewBorder(bar, nil, nil, nil, fb.list)
+	return fb
+}
+
+// load shows the current scrollback, scrolled to the latest output
+func (fb *findBar) load() {
+	fb.lines = fb.view.scrollback.Lines()
+	fb.matches = nil
+	fb.current = -1
+	fb.status.SetText("")
+	fb.list.UnselectAll()
+	fb.list.Refresh()
+	fb.list.ScrollToBottom()
+}
+
+// find selects the next match below the current one, or above it
+func (fb *findBar) find(forward bool) {
+	if fb.entry.Text == "" {
+		fb.load()
+		return
+	}
+	pattern, err := compileSearch(fb.entry.Text, fb.regex.Checked, fb.matchCase.Checked)
+	if err != nil {
+		fb.status.SetText(err.Error())
+		return
+	}
+
+	// Go on from the match shown, or from the end of the output
+	line := -1
+	if fb.current >= 0 && fb.current < len(fb.matches) {
+		line = fb.matches[fb.current]
+	}
+	fb.lines = fb.view.scrollback.Lines()
+	fb.matches = findLines(fb.lines, pattern)
+	if line < 0 && !forward {
+		line = len(fb.lines)
+	}
+	fb.list.Refresh()
+
+	fb.current = nextMatch(fb.matches, line, forward)
+	if fb.current < 0 {
+		fb.status.SetText("No matches")
+		fb.list.UnselectAll()
+		return
+	}
+	fb.status.SetText(fmt.Sprintf("%d of %d", fb.current+1, len(fb.matches)))
+	fb.list.Select(fb.matches[fb.current])
+	fb.list.ScrollTo(fb.matches[fb.current])
+}
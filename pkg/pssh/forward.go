@@ -0,0 +1,452 @@
+package pssh
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ForwardKind is the direction of a port forward
+type ForwardKind string
+
+const (
+	ForwardLocal   ForwardKind = "local"   // ssh -L: a local port reaches a host seen from the device
+	ForwardRemote  ForwardKind = "remote"  // ssh -R: a port on the device reaches a host seen from here
+	ForwardDynamic ForwardKind = "dynamic" // ssh -D: a local SOCKS5 proxy connecting through the device
+)
+
+// ForwardSpec describes a port forward
+type ForwardSpec struct {
+	Kind          ForwardKind
+	BindAddress   string // Listening address, local for -L and -D, on the device for -R
+	TargetAddress string // Destination of forwarded connections, unused for -D
+}
+
+// String formats the spec like the OpenSSH command line
+func (spec ForwardSpec) String() string {
+	switch spec.Kind {
+	case ForwardLocal:
+		return fmt.Sprintf("-L %s:%s", spec.BindAddress, spec.TargetAddress)
+	case ForwardRemote:
+		return fmt.Sprintf("-R %s:%s", spec.BindAddress, spec.TargetAddress)
+	default:
+		return fmt.Sprintf("-D %s", spec.BindAddress)
+	}
+}
+
+// ParseForward parses an OpenSSH style forward, "[bind_address:]port:host:hostport"
+// for local and remote forwards and "[bind_address:]port" for dynamic ones.
+// The bind address defaults to 127.0.0.1.
+func ParseForward(kind ForwardKind, spec string) (ForwardSpec, error) {
+	parts := splitForwardSpec(strings.TrimSpace(spec))
+	forward := ForwardSpec{Kind: kind}
+
+	var bind []string
+	switch kind {
+	case ForwardLocal, ForwardRemote:
+		if len(parts) < 3 || len(parts) > 4 {
+			return forward, fmt.Errorf("invalid forward %q: expected [bind_address:]port:host:hostport", spec)
+		}
+		bind = parts[:len(parts)-2]
+		host, port := parts[len(parts)-2], parts[len(parts)-1]
+		if host == "" || !validPort(port, false) {
+			return forward, fmt.Errorf("invalid forward target in %q", spec)
+		}
+		forward.TargetAddress = net.JoinHostPort(host, port)
+	case ForwardDynamic:
+		if len(parts) < 1 || len(parts) > 2 {
+			return forward, fmt.Errorf("invalid forward %q: expected [bind_address:]port", spec)
+		}
+		bind = parts
+	default:
+		return forward, fmt.Errorf("unknown forward kind %q", kind)
+	}
+
+	bindHost, bindPort := "127.0.0.1", bind[len(bind)-1]
+	if len(bind) == 2 && bind[0] != "" {
+		bindHost = bind[0]
+	}
+	if !validPort(bindPort, true) {
+		return forward, fmt.Errorf("invalid listening port in %q", spec)
+	}
+	forward.BindAddress = net.JoinHostPort(bindHost, bindPort)
+	return forward, nil
+}
+
+// splitForwardSpec splits a forward spec at colons outside of [IPv6] brackets
+func splitForwardSpec(spec string) []string {
+	var parts []string
+	var current strings.Builder
+	inBrackets := false
+	for _, r := range spec {
+		switch {
+		case r == '[':
+			inBrackets = true
+		case r == ']':
+			inBrackets = false
+		case r == ':' && !inBrackets:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(parts, current.String())
+}
+
+// validPort reports whether a port number is valid, 0 asks for any free port
+func validPort(port string, allowZero bool) bool {
+	number, err := strconv.Atoi(port)
+	if err != nil || number > 65535 {
+		return false
+	}
+	return number > 0 || (allowZero && number == 0)
+}
+
+// Forward is a running port forward of a connection
+type Forward struct {
+	ID         int
+	Host       string // Device the forward runs through
+	Spec       ForwardSpec
+	Connection *SSHConnection
+	Started    time.Time
+
+	listener net.Listener
+	sent     atomic.Int64 // Bytes from the listening side to the target
+	received atomic.Int64 // Bytes from the target back to the listening side
+	active   atomic.Int32
+	conns    map[net.Conn]struct{}
+	closed   bool
+	err      error
+	mutex    sync.Mutex
+}
+
+// Addr returns the address the forward listens on, with the port chosen for port 0
+func (f *Forward) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// Stats returns the bytes forwarded in each direction and the open connections
+func (f *Forward) Stats() (sent, received int64, active int) {
+	return f.sent.Load(), f.received.Load(), int(f.active.Load())
+}
+
+// Err returns why the forward stopped accepting connections, nil while it runs
+func (f *Forward) Err() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.err
+}
+
+// ForwardRegistry keeps track of the port forwards of all connections
+type ForwardRegistry struct {
+	forwards map[int]*Forward
+	nextID   int
+	mutex    sync.Mutex
+}
+
+// NewForwardRegistry creates an empty registry
+func NewForwardRegistry() *ForwardRegistry {
+	return &ForwardRegistry{forwards: make(map[int]*Forward), nextID: 1}
+}
+
+// Start opens a port forward through the connection
+func (registry *ForwardRegistry) Start(conn *SSHConnection, spec ForwardSpec) (*Forward, error) {
+	conn.mutex.RLock()
+	client := conn.Client
+	connected := conn.Connected
+	conn.mutex.RUnlock()
+	if !conn.Config.usesSSH() {
+		return nil, fmt.Errorf("port forwarding is not available over %s", conn.Config.Protocol)
+	}
+	if !connected || client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	forward := &Forward{
+		Host:       conn.Config.Host,
+		Spec:       spec,
+		Connection: conn,
+		Started:    time.Now(),
+		conns:      make(map[net.Conn]struct{}),
+	}
+
+	var err error
+	switch spec.Kind {
+	case ForwardLocal, ForwardDynamic:
+		forward.listener, err = net.Listen("tcp", spec.BindAddress)
+	case ForwardRemote:
+		forward.listener, err = client.Listen("tcp", spec.BindAddress)
+	default:
+		err = fmt.Errorf("unknown forward kind %q", spec.Kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", spec.BindAddress, err)
+	}
+
+	registry.mutex.Lock()
+	forward.ID = registry.nextID
+	registry.nextID++
+	registry.forwards[forward.ID] = forward
+	registry.mutex.Unlock()
+
+	go forward.serve()
+	return forward, nil
+}
+
+// Stop closes a forward and the connections running through it
+func (registry *ForwardRegistry) Stop(id int) error {
+	registry.mutex.Lock()
+	forward, exists := registry.forwards[id]
+	delete(registry.forwards, id)
+	registry.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("forward %d not found", id)
+	}
+	forward.close()
+	return nil
+}
+
+// StopHost closes every forward running through a device
+func (registry *ForwardRegistry) StopHost(host string) {
+	for _, forward := range registry.List() {
+		if forward.Host == host {
+			registry.Stop(forward.ID)
+		}
+	}
+}
+
+// StopAll closes every forward
+func (registry *ForwardRegistry) StopAll() {
+	for _, forward := range registry.List() {
+		registry.Stop(forward.ID)
+	}
+}
+
+// List returns the forwards ordered by ID
+func (registry *ForwardRegistry) List() []*Forward {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	forwards := make([]*Forward, 0, len(registry.forwards))
+	for _, forward := range registry.forwards {
+		forwards = append(forwards, forward)
+	}
+	sort.Slice(forwards, func(i, j int) bool {
+		return forwards[i].ID < forwards[j].ID
+	})
+	return forwards
+}
+
+// serve accepts connections until the listener is closed
+func (f *Forward) serve() {
+	for {
+		local, err := f.listener.Accept()
+		if err != nil {
+			f.mutex.Lock()
+			if !f.closed {
+				f.err = err
+			}
+			f.mutex.Unlock()
+			return
+		}
+		go f.handle(local)
+	}
+}
+
+// handle forwards one accepted connection
+func (f *Forward) handle(accepted net.Conn) {
+	if !f.track(accepted) {
+		accepted.Close()
+		return
+	}
+	defer f.untrack(accepted)
+
+	var target net.Conn
+	var err error
+	switch f.Spec.Kind {
+	case ForwardLocal:
+		target, err = f.dialRemote(f.Spec.TargetAddress)
+	case ForwardRemote:
+		target, err = net.DialTimeout("tcp", f.Spec.TargetAddress, 30*time.Second)
+	case ForwardDynamic:
+		target, err = f.socks5(accepted)
+	}
+	if err != nil {
+		fmt.Printf("Forward %s via %s: %v\n", f.Spec, f.Host, err)
+		return
+	}
+	if !f.track(target) {
+		target.Close()
+		return
+	}
+	defer f.untrack(target)
+
+	f.pipe(accepted, target)
+}
+
+// dialRemote opens a connection from the device, using its current client so
+// forwards keep working after a reconnect
+func (f *Forward) dialRemote(address string) (net.Conn, error) {
+	f.Connection.mutex.RLock()
+	client := f.Connection.Client
+	f.Connection.mutex.RUnlock()
+	if client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	return client.Dial("tcp", address)
+}
+
+// pipe copies data both ways until either side closes
+func (f *Forward) pipe(accepted, target net.Conn) {
+	f.active.Add(1)
+	defer f.active.Add(-1)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(&countingWriter{writer: target, count: &f.sent}, accepted)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(&countingWriter{writer: accepted, count: &f.received}, target)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// track registers an open connection so close can interrupt it
+func (f *Forward) track(conn net.Conn) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return false
+	}
+	f.conns[conn] = struct{}{}
+	return true
+}
+
+// untrack closes a connection and forgets it
+func (f *Forward) untrack(conn net.Conn) {
+	conn.Close()
+	f.mutex.Lock()
+	delete(f.conns, conn)
+	f.mutex.Unlock()
+}
+
+// close stops the listener and every forwarded connection
+func (f *Forward) close() {
+	f.mutex.Lock()
+	f.closed = true
+	conns := make([]net.Conn, 0, len(f.conns))
+	for conn := range f.conns {
+		conns = append(conns, conn)
+	}
+	f.mutex.Unlock()
+
+	f.listener.Close()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	count  *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count.Add(int64(n))
+	return n, err
+}
+
+// socks5 answers a SOCKS5 CONNECT request (RFC 1928, no authentication) and
+// dials the requested address through the device
+func (f *Forward) socks5(conn net.Conn) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	// Greeting: version, number of methods, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("socks: %v", err)
+	}
+	if header[0] != 5 {
+		return nil, fmt.Errorf("socks: unsupported version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, fmt.Errorf("socks: %v", err)
+	}
+	if !strings.ContainsRune(string(methods), 0) {
+		conn.Write([]byte{5, 0xff})
+		return nil, fmt.Errorf("socks: client requires authentication")
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return nil, fmt.Errorf("socks: %v", err)
+	}
+
+	// Request: version, command, reserved, address type, address, port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return nil, fmt.Errorf("socks: %v", err)
+	}
+	if request[1] != 1 {
+		conn.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, fmt.Errorf("socks: unsupported command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case 1:
+		address := make([]byte, 4)
+		if _, err := io.ReadFull(conn, address); err != nil {
+			return nil, fmt.Errorf("socks: %v", err)
+		}
+		host = net.IP(address).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, fmt.Errorf("socks: %v", err)
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return nil, fmt.Errorf("socks: %v", err)
+		}
+		host = string(name)
+	case 4:
+		address := make([]byte, 16)
+		if _, err := io.ReadFull(conn, address); err != nil {
+			return nil, fmt.Errorf("socks: %v", err)
+		}
+		host = net.IP(address).String()
+	default:
+		conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, fmt.Errorf("socks: unsupported address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, fmt.Errorf("socks: %v", err)
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+	target, err := f.dialRemote(address)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, fmt.Errorf("socks: connect to %s: %v", address, err)
+	}
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		target.Close()
+		return nil, fmt.Errorf("socks: %v", err)
+	}
+	return target, nil
+}
@@ -0,0 +1,243 @@
+package pssh
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// broadcastChecks are what input to several hosts is confirmed for, terminals
+// read them while the settings may change them
+var broadcastChecks = struct {
+	dangerous    []string // Regular expressions of dangerous commands
+	confirmPaste bool     // Whether pastes of several lines are confirmed
+	mutex        sync.RWMutex
+}{confirmPaste: true}
+
+// SetDangerousCommands sets the regular expressions of commands that always need
+// a confirmation before they are sent to several hosts
+func SetDangerousCommands(patterns []string) {
+	broadcastChecks.mutex.Lock()
+	defer broadcastChecks.mutex.Unlock()
+	broadcastChecks.dangerous = append([]string(nil), patterns...)
+}
+
+// DangerousCommands returns the patterns set with SetDangerousCommands
+func DangerousCommands() []string {
+	broadcastChecks.mutex.RLock()
+	defer broadcastChecks.mutex.RUnlock()
+	return append([]string(nil), broadcastChecks.dangerous...)
+}
+
+// SetConfirmMultilinePaste sets whether a paste of several lines is confirmed
+// before it is sent to several hosts
+func SetConfirmMultilinePaste(confirm bool) {
+	broadcastChecks.mutex.Lock()
+	defer broadcastChecks.mutex.Unlock()
+	broadcastChecks.confirmPaste = confirm
+}
+
+// ConfirmMultilinePaste returns the setting of SetConfirmMultilinePaste
+func ConfirmMultilinePaste() bool {
+	broadcastChecks.mutex.RLock()
+	defer broadcastChecks.mutex.RUnlock()
+	return broadcastChecks.confirmPaste
+}
+
+// bracketed paste markers the terminal puts around pasted text
+const (
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// BroadcastRequest is input held back until the user confirms it is sent to
+// several hosts
+type BroadcastRequest struct {
+	Text      string   // Pasted text, or the command line about to be run
+	Hosts     int      // Number of hosts receiving the input
+	Paste     bool     // Whether the input was pasted
+	Dangerous []string // Dangerous commands found in the input
+}
+
+// inputGuard holds back pasted blocks and dangerous commands typed into a
+// terminal sending keystrokes to several hosts, until the user confirms them.
+// It follows what was typed on the current line, so commands recalled from the
+// shell history or completed on the host are only seen as far as they were typed.
+type inputGuard struct {
+	mutex   sync.Mutex
+	line    []rune // Typed on the current line
+	escape  bool   // Within an escape sequence, e.g. a cursor key
+	pending bool   // A confirmation is open, input is dropped meanwhile
+	hosts   func() int
+	confirm func(request BroadcastRequest, done func(confirmed bool))
+}
+
+func newInputGuard(hosts func() int, confirm func(BroadcastRequest, func(bool))) *inputGuard {
+	return &inputGuard{hosts: hosts, confirm: confirm}
+}
+
+// write passes p to send, unless it must be confirmed first
+func (g *inputGuard) write(p []byte, send func([]byte)) {
+	g.mutex.Lock()
+	if g.pending {
+		g.mutex.Unlock()
+		return
+	}
+	broadcast := g.confirm != nil && g.hosts() > 1
+
+	if isPaste(p) {
+		text := strings.TrimSuffix(strings.TrimPrefix(string(p), pasteStart), pasteEnd)
+		// Only the lines ended by the paste are run right away
+		var dangerous []string
+		if end := strings.LastIndexAny(text, "\r\n"); end >= 0 {
+			dangerous = dangerousCommands(string(g.line) + text[:end])
+		}
+		multiline := strings.ContainsAny(strings.TrimRight(text, "\r\n"), "\r\n")
+		if !broadcast || (len(dangerous) == 0 && !(multiline && ConfirmMultilinePaste())) {
+			g.typed([]byte(text))
+			g.mutex.Unlock()
+			send(p)
+			return
+		}
+		g.pending = true
+		g.mutex.Unlock()
+		g.ask(BroadcastRequest{Text: text, Paste: true, Dangerous: dangerous}, func() {
+			g.typed([]byte(text))
+		}, func() {
+			send(p)
+		})
+		return
+	}
+
+	// Keystrokes are sent up to the first Enter running a dangerous command
+	for i, size := 0, 0; i < len(p); i += size {
+		_, size = utf8.DecodeRune(p[i:])
+		if p[i] != '\r' && p[i] != '\n' || g.escape {
+			g.typed(p[i : i+size])
+			continue
+		}
+		line := string(g.line)
+		dangerous := dangerousCommands(line)
+		if !broadcast || len(dangerous) == 0 {
+			g.typed(p[i : i+size])
+			continue
+		}
+		g.pending = true
+		g.mutex.Unlock()
+		if i > 0 {
+			send(p[:i])
+		}
+		rest := p[i:]
+		g.ask(BroadcastRequest{Text: line, Dangerous: dangerous}, func() {
+			g.typed(rest)
+		}, func() {
+			send(rest)
+		})
+		return
+	}
+	g.mutex.Unlock()
+	send(p)
+}
+
+// ask asks for a confirmation of request while the guard is pending. Once
+// confirmed, accept is called with the mutex locked and then send; a cancelled
+// command stays typed on the hosts, without the Enter.
+func (g *inputGuard) ask(request BroadcastRequest, accept func(), send func()) {
+	request.Hosts = g.hosts()
+	g.confirm(request, func(confirmed bool) {
+		g.mutex.Lock()
+		g.pending = false
+		if confirmed {
+			accept()
+		}
+		g.mutex.Unlock()
+		if confirmed {
+			send()
+		}
+	})
+}
+
+// typed follows the current line through keystrokes or pasted text
+func (g *inputGuard) typed(p []byte) {
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		p = p[size:]
+		switch {
+		case g.escape:
+			// CSI and SS3 sequences end with a letter or ~
+			if r != '[' && r != 'O' && (r >= 0x40 && r <= 0x7e) {
+				g.escape = false
+			}
+		case r == 0x1b:
+			g.escape = true
+		case r == '\r' || r == '\n' || r == 0x03 || r == 0x15:
+			// Enter, Ctrl+C and Ctrl+U start a new line
+			g.line = nil
+		case r == 0x7f || r == 0x08:
+			if len(g.line) > 0 {
+				g.line = g.line[:len(g.line)-1]
+			}
+		case r >= 0x20:
+			g.line = append(g.line, r)
+		}
+	}
+}
+
+// isPaste tells pasted text from keystrokes, which arrive one key at a time
+func isPaste(p []byte) bool {
+	if strings.HasPrefix(string(p), pasteStart) {
+		return true
+	}
+	return len(p) > 0 && p[0] != 0x1b && utf8.RuneCount(p) > 1
+}
+
+// dangerousCommands returns the lines of text matching one of the
+// DangerousCommands
+func dangerousCommands(text string) []string {
+	patterns := compileDangerousCommands()
+	var found []string
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\r' || r == '\n' }) {
+		line = strings.TrimSpace(line)
+		for _, pattern := range patterns {
+			if pattern.MatchString(line) {
+				found = append(found, line)
+				break
+			}
+		}
+	}
+	return found
+}
+
+var (
+	dangerousMutex    sync.Mutex
+	dangerousSource   string
+	dangerousCompiled []*regexp.Regexp
+)
+
+// compileDangerousCommands compiles the DangerousCommands again after they
+// changed, invalid patterns are skipped
+func compileDangerousCommands() []*regexp.Regexp {
+	patterns := DangerousCommands()
+	dangerousMutex.Lock()
+	defer dangerousMutex.Unlock()
+	source := strings.Join(patterns, "\n")
+	if source == dangerousSource {
+		return dangerousCompiled
+	}
+	dangerousSource = source
+	dangerousCompiled = nil
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		compiled, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			fmt.Printf("Ignoring invalid dangerous command pattern %q: %v\n", pattern, err)
+			continue
+		}
+		dangerousCompiled = append(dangerousCompiled, compiled)
+	}
+	return dangerousCompiled
+}
@@ -0,0 +1,263 @@
+package pssh
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HealthState describes the liveness of a managed connection
+type HealthState string
+
+const (
+	HealthConnected    HealthState = "connected"    // Keepalives are answered
+	HealthDegraded     HealthState = "degraded"     // Keepalives are being missed
+	HealthLost         HealthState = "lost"         // The connection is gone
+	HealthReconnecting HealthState = "reconnecting" // Waiting for or running a reconnect attempt
+)
+
+// HealthEvent reports a health change of a managed connection
+type HealthEvent struct {
+	Host       string
+	Connection *SSHConnection
+	State      HealthState
+	Previous   HealthState
+	Error      error // Cause of a degraded or lost state, or of the last failed reconnect
+	Attempt    int   // Reconnect attempt, 0 unless reconnecting
+}
+
+// KeepaliveConfig controls connection monitoring in SSHManager
+type KeepaliveConfig struct {
+	Interval    time.Duration // Time between keepalive requests, 0 disables monitoring
+	Timeout     time.Duration // Time to wait for a reply, defaults to Interval
+	MaxMissed   int           // Missed replies before the connection is lost, defaults to 3
+	Reconnect   bool          // Reconnect lost connections with exponential backoff
+	MinBackoff  time.Duration // First reconnect delay, defaults to 2 seconds
+	MaxBackoff  time.Duration // Longest reconnect delay, defaults to 2 minutes
+	MaxAttempts int           // Reconnect attempts before giving up, 0 retries forever
+}
+
+// DefaultKeepaliveConfig returns keepalive settings similar to OpenSSH ServerAliveInterval 15
+func DefaultKeepaliveConfig() KeepaliveConfig {
+	return KeepaliveConfig{
+		Interval:   15 * time.Second,
+		MaxMissed:  3,
+		Reconnect:  true,
+		MinBackoff: 2 * time.Second,
+		MaxBackoff: 2 * time.Minute,
+	}
+}
+
+// withDefaults fills in unset optional values
+func (config KeepaliveConfig) withDefaults() KeepaliveConfig {
+	if config.Timeout <= 0 {
+		config.Timeout = config.Interval
+	}
+	if config.MaxMissed <= 0 {
+		config.MaxMissed = 3
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 2 * time.Second
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = 2 * time.Minute
+	}
+	return config
+}
+
+// SetKeepalive sets the monitoring used for connections made from now on
+func (manager *SSHManager) SetKeepalive(config KeepaliveConfig) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.keepalive = config
+}
+
+// OnHealthChange registers a listener for health changes of managed connections.
+// Listeners are called from the monitoring goroutines.
+func (manager *SSHManager) OnHealthChange(listener func(HealthEvent)) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.listeners = append(manager.listeners, listener)
+}
+
+// Health returns the health state of the connection
+func (conn *SSHConnection) Health() HealthState {
+	conn.mutex.RLock()
+	defer conn.mutex.RUnlock()
+	if conn.health == "" && conn.Connected {
+		return HealthConnected
+	}
+	return conn.health
+}
+
+// monitor starts keepalive monitoring of a connection if it is enabled
+func (manager *SSHManager) monitor(conn *SSHConnection) {
+	manager.mutex.RLock()
+	config := manager.keepalive
+	manager.mutex.RUnlock()
+
+	conn.mutex.Lock()
+	conn.health = HealthConnected
+	// Telnet keeps no connection open to send keepalives on
+	if config.Interval <= 0 || conn.monitorStop != nil || !conn.Config.usesSSH() {
+		conn.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	conn.monitorStop = stop
+	conn.mutex.Unlock()
+
+	go manager.watch(conn, stop, config.withDefaults())
+}
+
+// watch monitors a connection until it is closed, reconnecting it when lost
+func (manager *SSHManager) watch(conn *SSHConnection, stop <-chan struct{}, config KeepaliveConfig) {
+	for {
+		err := manager.probe(conn, stop, config)
+		if isStopped(stop) {
+			return
+		}
+
+		conn.dropClient()
+		manager.setHealth(conn, HealthLost, err, 0)
+		fmt.Printf("Connection to %s lost: %v\n", conn.Config.Host, err)
+
+		if !config.Reconnect || !manager.reconnect(conn, stop, config) {
+			return
+		}
+		manager.setHealth(conn, HealthConnected, nil, 0)
+		fmt.Printf("Reconnected to %s\n", conn.Config.Host)
+	}
+}
+
+// probe sends keepalive requests until the connection is lost or monitoring stops
+func (manager *SSHManager) probe(conn *SSHConnection, stop <-chan struct{}, config KeepaliveConfig) error {
+	conn.mutex.RLock()
+	client := conn.Client
+	conn.mutex.RUnlock()
+	if client == nil {
+		return fmt.Errorf("connection closed")
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.Wait()
+	}()
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-closed:
+			if err == nil {
+				err = fmt.Errorf("closed by remote host")
+			}
+			return fmt.Errorf("connection closed: %v", err)
+		case <-ticker.C:
+			if err := sendKeepalive(client, config.Timeout); err != nil {
+				missed++
+				if missed >= config.MaxMissed {
+					return fmt.Errorf("%d keepalives missed: %v", missed, err)
+				}
+				manager.setHealth(conn, HealthDegraded, err, 0)
+			} else if missed > 0 {
+				missed = 0
+				manager.setHealth(conn, HealthConnected, nil, 0)
+			}
+		}
+	}
+}
+
+// reconnect retries the connection with exponential backoff until it succeeds,
+// the attempts are exhausted or monitoring stops
+func (manager *SSHManager) reconnect(conn *SSHConnection, stop <-chan struct{}, config KeepaliveConfig) bool {
+	backoff := config.MinBackoff
+	var lastErr error
+	for attempt := 1; config.MaxAttempts == 0 || attempt <= config.MaxAttempts; attempt++ {
+		manager.setHealth(conn, HealthReconnecting, lastErr, attempt)
+
+		select {
+		case <-stop:
+			return false
+		case <-time.After(backoff):
+		}
+
+		lastErr = conn.Connect()
+		if isStopped(stop) {
+			// Closed while reconnecting, do not leave the new client behind
+			conn.dropClient()
+			return false
+		}
+		if lastErr == nil {
+			return true
+		}
+
+		backoff *= 2
+		if backoff > config.MaxBackoff {
+			backoff = config.MaxBackoff
+		}
+	}
+
+	manager.setHealth(conn, HealthLost, fmt.Errorf("giving up after %d reconnect attempts: %v", config.MaxAttempts, lastErr), 0)
+	return false
+}
+
+// setHealth records a health state and notifies the listeners. Unchanged states are
+// only reported again for new reconnect attempts.
+func (manager *SSHManager) setHealth(conn *SSHConnection, state HealthState, err error, attempt int) {
+	conn.mutex.Lock()
+	previous := conn.health
+	conn.health = state
+	conn.mutex.Unlock()
+
+	if previous == state && attempt == 0 && err == nil {
+		return
+	}
+
+	manager.mutex.RLock()
+	listeners := append([]func(HealthEvent){}, manager.listeners...)
+	manager.mutex.RUnlock()
+
+	event := HealthEvent{
+		Host:       conn.Config.Host,
+		Connection: conn,
+		State:      state,
+		Previous:   previous,
+		Error:      err,
+		Attempt:    attempt,
+	}
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// sendKeepalive sends an OpenSSH keepalive request and waits for any reply
+func sendKeepalive(client *ssh.Client, timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("no reply within %v", timeout)
+	}
+}
+
+// isStopped reports whether a stop channel has been closed
+func isStopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
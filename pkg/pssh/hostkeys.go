@@ -0,0 +1,281 @@
+package pssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyPolicy controls how server host keys are verified
+type HostKeyPolicy string
+
+const (
+	HostKeyStrict HostKeyPolicy = "strict" // Only accept keys already present in known_hosts
+	HostKeyTOFU   HostKeyPolicy = "tofu"   // Trust and record the key on first contact
+	HostKeyIgnore HostKeyPolicy = "ignore" // Accept any key (insecure)
+)
+
+// HostKeyPromptFunc is asked whether an unknown host key should be trusted
+type HostKeyPromptFunc func(host string, key ssh.PublicKey) bool
+
+// HostKeyMismatchError is returned when a host presents a key different from the stored one
+type HostKeyMismatchError struct {
+	Host     string
+	Expected []string // Fingerprints on record for the host
+	Got      string   // Fingerprint presented by the server
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key verification failed for %s: server presented %s but %s is on record "+
+		"(the device may have been replaced or the connection is being intercepted)",
+		e.Host, e.Got, strings.Join(e.Expected, ", "))
+}
+
+// HostKeyUnknownError is returned when a host key is not trusted
+type HostKeyUnknownError struct {
+	Host        string
+	Fingerprint string
+	Rejected    bool // True if the user declined the key when prompted
+}
+
+func (e *HostKeyUnknownError) Error() string {
+	if e.Rejected {
+		return fmt.Sprintf("host key %s for %s was rejected", e.Fingerprint, e.Host)
+	}
+	return fmt.Sprintf("host key %s for %s is not in known_hosts", e.Fingerprint, e.Host)
+}
+
+// knownHostsMutex serializes prompts and writes to known_hosts files
+var knownHostsMutex sync.Mutex
+
+// DefaultKnownHostsPath returns the OpenSSH known_hosts location of the current user
+func DefaultKnownHostsPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".ssh", "known_hosts")
+}
+
+// IsHostKeyMismatch reports whether err was caused by a changed host key
+func IsHostKeyMismatch(err error) bool {
+	var mismatchErr *HostKeyMismatchError
+	return errors.As(err, &mismatchErr)
+}
+
+// hostKeyCallback builds the host key verification callback for a connection config.
+// When learn is false, unknown keys are accepted but never recorded or prompted for.
+// The presented fingerprint is stored in seen if it is not nil.
+func hostKeyCallback(config ConnectionConfig, learn bool, seen *string) ssh.HostKeyCallback {
+	policy := config.HostKeyPolicy
+	if policy == "" {
+		policy = HostKeyTOFU
+	}
+
+	knownHostsPath := config.KnownHostsFile
+	if knownHostsPath == "" {
+		knownHostsPath = DefaultKnownHostsPath()
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		if seen != nil {
+			*seen = fingerprint
+		}
+
+		if policy == HostKeyIgnore {
+			return nil
+		}
+
+		// A fingerprint pinned on the device record must always match
+		if config.HostKeyFingerprint != "" && config.HostKeyFingerprint != fingerprint {
+			return &HostKeyMismatchError{Host: hostname, Expected: []string{config.HostKeyFingerprint}, Got: fingerprint}
+		}
+
+		knownHostsMutex.Lock()
+		defer knownHostsMutex.Unlock()
+
+		err := checkKnownHosts(knownHostsPath, hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		// Only a known key of the same type was replaced, a key of another type is
+		// just not on record yet
+		var expected []string
+		for _, known := range keyErr.Want {
+			if known.Key.Type() == key.Type() {
+				expected = append(expected, ssh.FingerprintSHA256(known.Key))
+			}
+		}
+		if len(expected) > 0 {
+			return &HostKeyMismatchError{Host: hostname, Expected: expected, Got: fingerprint}
+		}
+
+		// The key is unknown to known_hosts from here on
+		if config.HostKeyFingerprint == "" {
+			if policy == HostKeyStrict {
+				return &HostKeyUnknownError{Host: hostname, Fingerprint: fingerprint}
+			}
+			if !learn {
+				return nil
+			}
+			if config.HostKeyPrompt != nil && !config.HostKeyPrompt(hostname, key) {
+				return &HostKeyUnknownError{Host: hostname, Fingerprint: fingerprint, Rejected: true}
+			}
+		} else if !learn {
+			return nil
+		}
+
+		if err := appendKnownHost(knownHostsPath, hostname, key); err != nil {
+			fmt.Printf("Warning: failed to record host key for %s: %v\n", hostname, err)
+		}
+		return nil
+	}
+}
+
+// knownHostKeyAlgorithms returns the host key algorithms of the keys known_hosts
+// has for address, so the server is asked for a key that can be verified. It is
+// nil when no key is on record and the client defaults apply.
+func knownHostKeyAlgorithms(config ConnectionConfig, address string) []string {
+	if config.HostKeyPolicy == HostKeyIgnore {
+		return nil
+	}
+	knownHostsPath := config.KnownHostsFile
+	if knownHostsPath == "" {
+		knownHostsPath = DefaultKnownHostsPath()
+	}
+
+	knownHostsMutex.Lock()
+	err := checkKnownHosts(knownHostsPath, address, &net.TCPAddr{}, unknownHostKey{})
+	knownHostsMutex.Unlock()
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, known := range keyErr.Want {
+		keyType := known.Key.Type()
+		if seen[keyType] {
+			continue
+		}
+		seen[keyType] = true
+		if keyType == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, keyType)
+	}
+	return algorithms
+}
+
+// unknownHostKey is a key that matches no known_hosts entry, checking it lists the
+// keys on record for a host
+type unknownHostKey struct{}
+
+func (unknownHostKey) Type() string                        { return "" }
+func (unknownHostKey) Marshal() []byte                     { return nil }
+func (unknownHostKey) Verify([]byte, *ssh.Signature) error { return fmt.Errorf("unknown host key") }
+
+// checkKnownHosts verifies a key against a known_hosts file, treating a missing file as empty
+func checkKnownHosts(path, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return &knownhosts.KeyError{}
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return fmt.Errorf("failed to read known hosts file %s: %v", path, err)
+	}
+
+	return callback(hostname, remote, key)
+}
+
+// appendKnownHost appends an OpenSSH-compatible entry for the host to the known_hosts file
+func appendKnownHost(path, hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(knownhosts.Line([]string{hostname}, key) + "\n")
+	return err
+}
+
+// RemoveKnownHost removes all entries for the given host and port from a known_hosts file
+func RemoveKnownHost(path, host string, port int) error {
+	if path == "" {
+		path = DefaultKnownHostsPath()
+	}
+
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	entry := knownhosts.Normalize(net.JoinHostPort(host, fmt.Sprintf("%d", port)))
+
+	var kept []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !knownHostsLineMatches(line, entry) {
+			kept = append(kept, line)
+		}
+	}
+	file.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	content := strings.Join(kept, "\n")
+	if len(kept) > 0 {
+		content += "\n"
+	}
+	return os.WriteFile(path, []byte(content), 0600)
+}
+
+// knownHostsLineMatches reports whether a known_hosts line lists the normalized host entry
+func knownHostsLineMatches(line, entry string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return false
+	}
+
+	hosts := fields[0]
+	if strings.HasPrefix(hosts, "@") {
+		if len(fields) < 2 {
+			return false
+		}
+		hosts = fields[1]
+	}
+
+	for _, host := range strings.Split(hosts, ",") {
+		if host == entry {
+			return true
+		}
+	}
+	return false
+}
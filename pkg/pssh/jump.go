@@ -0,0 +1,236 @@
+package pssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// dialFunc opens the network connection an SSH client runs over
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// bastionClient is a shared SSH client to a jump host
+type bastionClient struct {
+	key    string
+	client *ssh.Client
+	parent *bastionClient // Previous hop of the chain, nil for the first one
+	refs   int
+	ready  chan struct{} // Closed once the dial attempt has finished
+	err    error
+}
+
+// bastionPool shares jump host clients between connections tunnelled through the same chain
+type bastionPool struct {
+	clients map[string]*bastionClient
+	mutex   sync.Mutex
+}
+
+// bastions is the pool used by all connections, so a single client per jump host
+// serves every device behind it (e.g. all hosts of SSHManager.ConnectMultiple)
+var bastions = &bastionPool{clients: make(map[string]*bastionClient)}
+
+// ParseJumpHosts parses an OpenSSH ProxyJump style list such as
+// "admin@bastion:2222,core-router" into jump host configurations.
+// Hops without a port use defaultPort.
+func ParseJumpHosts(spec string, defaultPort int) ([]ConnectionConfig, error) {
+	var hops []ConnectionConfig
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" || strings.EqualFold(part, "none") {
+			continue
+		}
+
+		hop := ConnectionConfig{Port: defaultPort}
+		if at := strings.LastIndex(part, "@"); at != -1 {
+			hop.Username = part[:at]
+			part = part[at+1:]
+		}
+
+		hop.Host = part
+		if host, port, err := net.SplitHostPort(part); err == nil {
+			portNum, err := strconv.Atoi(port)
+			if err != nil || portNum < 1 || portNum > 65535 {
+				return nil, fmt.Errorf("invalid port in jump host %q", part)
+			}
+			hop.Host = host
+			hop.Port = portNum
+		} else {
+			hop.Host = strings.Trim(part, "[]")
+		}
+
+		if hop.Host == "" {
+			return nil, fmt.Errorf("invalid jump host %q", part)
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// FormatJumpHosts formats jump host configurations as an OpenSSH ProxyJump list
+func FormatJumpHosts(hops []ConnectionConfig) string {
+	var parts []string
+	for _, hop := range hops {
+		part := net.JoinHostPort(hop.Host, strconv.Itoa(hop.Port))
+		if hop.Username != "" {
+			part = hop.Username + "@" + part
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+// jumpChain returns the jump hosts of a config with unset fields inherited from it.
+// Hops without a username also reuse the credentials of the target device.
+func jumpChain(config ConnectionConfig) []ConnectionConfig {
+	chain := make([]ConnectionConfig, len(config.Via))
+	for i, hop := range config.Via {
+		if hop.Port == 0 {
+			hop.Port = 22
+		}
+		if hop.Timeout == 0 {
+			hop.Timeout = config.Timeout
+		}
+		if hop.Username == "" {
+			hop.Username = config.Username
+			hop.Password = config.Password
+			hop.PrivateKey = config.PrivateKey
+			hop.KeyPath = config.KeyPath
+			hop.Passphrase = config.Passphrase
+		}
+		if !hop.UseAgent {
+			hop.UseAgent = config.UseAgent
+		}
+		if hop.PassphrasePrompt == nil {
+			hop.PassphrasePrompt = config.PassphrasePrompt
+		}
+		if hop.HostKeyPolicy == "" {
+			hop.HostKeyPolicy = config.HostKeyPolicy
+		}
+		if hop.KnownHostsFile == "" {
+			hop.KnownHostsFile = config.KnownHostsFile
+		}
+		if hop.HostKeyPrompt == nil {
+			hop.HostKeyPrompt = config.HostKeyPrompt
+		}
+		// Nested Via entries are ignored, the chain itself describes the route
+		hop.Via = nil
+		chain[i] = hop
+	}
+	return chain
+}
+
+// chainKey identifies a jump host chain in the bastion pool
+func chainKey(chain []ConnectionConfig) string {
+	parts := make([]string, len(chain))
+	for i, hop := range chain {
+		parts[i] = hop.Username + "@" + hop.address()
+	}
+	return strings.Join(parts, ">")
+}
+
+// acquire returns a connected client to the last hop of the chain, dialing the
+// previous hops as needed. Every successful call must be paired with release.
+func (pool *bastionPool) acquire(chain []ConnectionConfig) (*bastionClient, error) {
+	key := chainKey(chain)
+
+	pool.mutex.Lock()
+	if bastion, exists := pool.clients[key]; exists {
+		bastion.refs++
+		pool.mutex.Unlock()
+
+		<-bastion.ready
+		if bastion.err != nil {
+			pool.release(bastion)
+			return nil, bastion.err
+		}
+		return bastion, nil
+	}
+
+	bastion := &bastionClient{key: key, refs: 1, ready: make(chan struct{})}
+	pool.clients[key] = bastion
+	pool.mutex.Unlock()
+
+	bastion.client, bastion.parent, bastion.err = pool.dial(chain)
+	if bastion.err != nil {
+		// Forget failed attempts so the next connection retries the jump host
+		pool.mutex.Lock()
+		if pool.clients[key] == bastion {
+			delete(pool.clients, key)
+		}
+		pool.mutex.Unlock()
+		close(bastion.ready)
+		pool.release(bastion)
+		return nil, bastion.err
+	}
+	close(bastion.ready)
+
+	// Drop the client from the pool when the jump host goes away
+	go func() {
+		bastion.client.Wait()
+		pool.mutex.Lock()
+		if pool.clients[key] == bastion {
+			delete(pool.clients, key)
+		}
+		pool.mutex.Unlock()
+	}()
+
+	return bastion, nil
+}
+
+// dial connects to the last hop of the chain through the previous ones
+func (pool *bastionPool) dial(chain []ConnectionConfig) (*ssh.Client, *bastionClient, error) {
+	hop := chain[len(chain)-1]
+	address := hop.address()
+
+	var parent *bastionClient
+	var dial dialFunc = directDial
+	if len(chain) > 1 {
+		var err error
+		parent, err = pool.acquire(chain[:len(chain)-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		dial = parent.client.DialContext
+	}
+
+	client, _, err := dialSSH(hop, dial, true)
+	if err != nil {
+		if parent != nil {
+			pool.release(parent)
+		}
+		return nil, nil, fmt.Errorf("jump host %s: %w", address, err)
+	}
+
+	return client, parent, nil
+}
+
+// release drops a reference to a jump host client and closes it once unused
+func (pool *bastionPool) release(bastion *bastionClient) {
+	pool.mutex.Lock()
+	bastion.refs--
+	unused := bastion.refs == 0
+	if unused && pool.clients[bastion.key] == bastion {
+		delete(pool.clients, bastion.key)
+	}
+	pool.mutex.Unlock()
+
+	if !unused || bastion.err != nil {
+		return
+	}
+
+	bastion.client.Close()
+	if bastion.parent != nil {
+		pool.release(bastion.parent)
+	}
+}
+
+// directDial opens a plain TCP connection
+func directDial(ctx context.Context, network, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
@@ -0,0 +1,592 @@
+package pssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MACTelnetPort is the UDP port of the MikroTik MAC-Telnet server
+const MACTelnetPort = 20561
+
+// MAC-Telnet packet types
+const (
+	macTelnetSessionStart = 0
+	macTelnetData         = 1
+	macTelnetAck          = 2
+	macTelnetEnd          = 255
+)
+
+// MAC-Telnet control packet types
+const (
+	macTelnetPlain      = -1 // Terminal data, not a control packet
+	macTelnetBeginAuth  = 0
+	macTelnetPassSalt   = 1
+	macTelnetPassword   = 2
+	macTelnetUsername   = 3
+	macTelnetTermType   = 4
+	macTelnetTermWidth  = 5
+	macTelnetTermHeight = 6
+	macTelnetEndAuth    = 9
+)
+
+const macTelnetHeaderSize = 22
+
+var (
+	macTelnetMagic      = []byte{0x56, 0x34, 0x12, 0xff}
+	macTelnetClientType = []byte{0x00, 0x15}
+
+	// macTelnetRetransmits are the waits for an acknowledgement before a packet
+	// is sent again, about five seconds in total
+	macTelnetRetransmits = []time.Duration{
+		50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+		500 * time.Millisecond, 1 * time.Second, 1 * time.Second, 2 * time.Second,
+	}
+
+	// macTelnetKeepalive is how long the session may be idle before an
+	// acknowledgement is sent to keep it open
+	macTelnetKeepalive = 10 * time.Second
+)
+
+// macTelnetRoute is one way of reaching devices on a local network segment
+type macTelnetRoute struct {
+	addr *net.UDPAddr     // Broadcast address of the interface
+	mac  net.HardwareAddr // MAC address of the interface
+}
+
+// macTelnetRoutes lists the broadcast addresses of the local interfaces. MAC-Telnet
+// packets are broadcast because the device may have no IP address at all.
+var macTelnetRoutes = func() ([]macTelnetRoute, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []macTelnetRoute
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			inet, ok := addr.(*net.IPNet)
+			if !ok || inet.IP.To4() == nil {
+				continue
+			}
+			broadcast := make(net.IP, 4)
+			binary.BigEndian.PutUint32(broadcast,
+				binary.BigEndian.Uint32(inet.IP.To4())|^binary.BigEndian.Uint32(net.IP(inet.Mask).To4()))
+			routes = append(routes, macTelnetRoute{
+				addr: &net.UDPAddr{IP: broadcast, Port: MACTelnetPort},
+				mac:  iface.HardwareAddr,
+			})
+		}
+	}
+	return routes, nil
+}
+
+// macTelnetControl is a control packet, or terminal data for macTelnetPlain
+type macTelnetControl struct {
+	kind int
+	data []byte
+}
+
+// appendMACTelnetControl encodes a control packet
+func appendMACTelnetControl(buf []byte, kind int, data []byte) []byte {
+	buf = append(buf, macTelnetMagic...)
+	buf = append(buf, byte(kind))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+	return append(buf, data...)
+}
+
+// parseMACTelnetControls splits the payload of a data packet. Anything not
+// starting with the control magic is terminal data up to the end of the packet.
+func parseMACTelnetControls(data []byte) []macTelnetControl {
+	var controls []macTelnetControl
+	for len(data) > 0 {
+		if len(data) < 9 || !bytes.Equal(data[:4], macTelnetMagic) {
+			controls = append(controls, macTelnetControl{kind: macTelnetPlain, data: data})
+			break
+		}
+		kind := int(data[4])
+		length := int(binary.BigEndian.Uint32(data[5:9]))
+		data = data[9:]
+		if length > len(data) {
+			length = len(data)
+		}
+		controls = append(controls, macTelnetControl{kind: kind, data: data[:length]})
+		data = data[length:]
+	}
+	return controls
+}
+
+// macTelnetTermSize encodes the terminal size as the device expects it
+func macTelnetTermSize(rows, cols int) []byte {
+	var payload []byte
+	payload = appendMACTelnetControl(payload, macTelnetTermWidth, binary.LittleEndian.AppendUint16(nil, uint16(cols)))
+	return appendMACTelnetControl(payload, macTelnetTermHeight, binary.LittleEndian.AppendUint16(nil, uint16(rows)))
+}
+
+// MACTelnetConn is a MikroTik MAC-Telnet session. The device is addressed by its
+// MAC address over UDP broadcasts, so it works without IP connectivity on the
+// same network segment. Reads return terminal output after the login.
+type MACTelnetConn struct {
+	conn       *net.UDPConn
+	device     net.HardwareAddr
+	sessionKey uint16
+	termType   string
+	rows       int
+	cols       int
+	routes     []macTelnetRoute
+	route      *macTelnetRoute // The interface the device answered on
+	outCounter uint32
+	inCounter  uint32 // Counter expected for the next data packet
+	received   bool
+	lastSent   time.Time
+	queue      [][]macTelnetControl
+	ready      chan struct{}
+	acks       chan uint32
+	done       chan struct{}
+	err        error
+	pending    []byte
+	closeOnce  sync.Once
+	sendMutex  sync.Mutex // One acknowledged packet in flight at a time
+	mutex      sync.Mutex
+}
+
+// NewMACTelnetConn opens a session to the device with the given MAC address. The
+// terminal type and size are sent with the login.
+func NewMACTelnetConn(device net.HardwareAddr, termType string, rows, cols int) (*MACTelnetConn, error) {
+	routes, err := macTelnetRoutes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no network interface to reach %s", device)
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+
+	c := &MACTelnetConn{
+		conn:       conn,
+		device:     device,
+		sessionKey: uint16(rand.Intn(65535)),
+		termType:   termType,
+		rows:       rows,
+		cols:       cols,
+		routes:     routes,
+		ready:      make(chan struct{}, 1),
+		acks:       make(chan uint32, 16),
+		done:       make(chan struct{}),
+	}
+	go c.receive()
+	go c.keepalive()
+	return c, nil
+}
+
+// packet encodes a packet to the device
+func (c *MACTelnetConn) packet(ptype byte, source net.HardwareAddr, counter uint32, payload []byte) []byte {
+	buf := make([]byte, macTelnetHeaderSize, macTelnetHeaderSize+len(payload))
+	buf[0] = 1
+	buf[1] = ptype
+	copy(buf[2:8], source)
+	copy(buf[8:14], c.device)
+	binary.BigEndian.PutUint16(buf[14:16], c.sessionKey)
+	copy(buf[16:18], macTelnetClientType)
+	binary.BigEndian.PutUint32(buf[18:22], counter)
+	return append(buf, payload...)
+}
+
+// send sends a packet on the interface the device answered on, or on all of them
+// until it did
+func (c *MACTelnetConn) send(ptype byte, counter uint32, payload []byte) error {
+	c.mutex.Lock()
+	routes := c.routes
+	if c.route != nil {
+		routes = []macTelnetRoute{*c.route}
+	}
+	c.lastSent = time.Now()
+	c.mutex.Unlock()
+
+	var lastErr error
+	sent := false
+	for _, route := range routes {
+		if _, err := c.conn.WriteToUDP(c.packet(ptype, route.mac, counter, payload), route.addr); err != nil {
+			lastErr = err
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		return lastErr
+	}
+	return nil
+}
+
+// sendReliable sends a packet and waits until the device acknowledges it,
+// sending it again when the acknowledgement does not come
+func (c *MACTelnetConn) sendReliable(ptype byte, payload []byte) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	counter := c.outCounter
+	want := counter + uint32(len(payload))
+	for {
+		select {
+		case <-c.acks:
+			continue
+		default:
+		}
+		break
+	}
+
+	for _, wait := range macTelnetRetransmits {
+		if err := c.send(ptype, counter, payload); err != nil {
+			return err
+		}
+		timer := time.NewTimer(wait)
+	waiting:
+		for {
+			select {
+			case ack := <-c.acks:
+				if int32(ack-want) >= 0 {
+					timer.Stop()
+					c.outCounter = want
+					return nil
+				}
+			case <-timer.C:
+				break waiting
+			case <-c.done:
+				timer.Stop()
+				return c.closedErr()
+			}
+		}
+	}
+	return fmt.Errorf("no answer from %s", c.device)
+}
+
+// receive handles packets from the device until the session ends
+func (c *MACTelnetConn) receive() {
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				err = io.EOF
+			}
+			c.finish(err)
+			return
+		}
+
+		// Packets from the device have the client type and session key swapped
+		data := buf[:n]
+		if n < macTelnetHeaderSize || data[0] != 1 ||
+			!bytes.Equal(data[2:8], c.device) || binary.BigEndian.Uint16(data[16:18]) != c.sessionKey {
+			continue
+		}
+		if !c.answeredOn(data[8:14]) {
+			continue
+		}
+		counter := binary.BigEndian.Uint32(data[18:22])
+		payload := data[macTelnetHeaderSize:]
+
+		switch data[1] {
+		case macTelnetAck:
+			select {
+			case c.acks <- counter:
+			default:
+			}
+		case macTelnetData:
+			c.send(macTelnetAck, counter+uint32(len(payload)), nil)
+			if c.accept(counter, len(payload)) {
+				c.push(parseMACTelnetControls(append([]byte{}, payload...)))
+			}
+		case macTelnetEnd:
+			c.send(macTelnetEnd, 0, nil)
+			c.finish(io.EOF)
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// answeredOn picks the interface with the given MAC address as the way to the
+// device and reports whether it is one of ours
+func (c *MACTelnetConn) answeredOn(mac []byte) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.route != nil {
+		return bytes.Equal(c.route.mac, mac)
+	}
+	for i := range c.routes {
+		if bytes.Equal(c.routes[i].mac, mac) {
+			c.route = &c.routes[i]
+			return true
+		}
+	}
+	return false
+}
+
+// accept reports whether a data packet is new and not a retransmission
+func (c *MACTelnetConn) accept(counter uint32, length int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.received && int32(counter-c.inCounter) < 0 {
+		return false
+	}
+	c.received = true
+	c.inCounter = counter + uint32(length)
+	return true
+}
+
+// push queues the content of a data packet for the reader
+func (c *MACTelnetConn) push(controls []macTelnetControl) {
+	c.mutex.Lock()
+	c.queue = append(c.queue, controls)
+	c.mutex.Unlock()
+	c.wake()
+}
+
+// finish ends the session with an error for the reader, io.EOF when it was closed
+func (c *MACTelnetConn) finish(err error) {
+	c.mutex.Lock()
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+	c.mutex.Unlock()
+	c.wake()
+}
+
+func (c *MACTelnetConn) wake() {
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (c *MACTelnetConn) closedErr() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err == io.EOF {
+		return net.ErrClosed
+	}
+	return c.err
+}
+
+// next returns the content of the next data packet from the device
+func (c *MACTelnetConn) next(ctx context.Context) ([]macTelnetControl, error) {
+	for {
+		c.mutex.Lock()
+		if len(c.queue) > 0 {
+			controls := c.queue[0]
+			c.queue = c.queue[1:]
+			c.mutex.Unlock()
+			return controls, nil
+		}
+		err := c.err
+		c.mutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-c.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// keepalive acknowledges the last data again when the session is idle, the device
+// drops sessions it has not heard from for a while
+func (c *MACTelnetConn) keepalive() {
+	ticker := time.NewTicker(macTelnetKeepalive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.mutex.Lock()
+			idle := time.Since(c.lastSent) >= macTelnetKeepalive
+			counter := c.inCounter
+			c.mutex.Unlock()
+			if idle {
+				c.send(macTelnetAck, counter, nil)
+			}
+		}
+	}
+}
+
+// Login starts the session and logs in, using EC-SRP or MD5 depending on what
+// the device supports, then waits for the shell prompt. It returns everything
+// the device printed, the last line being the prompt.
+func (c *MACTelnetConn) Login(ctx context.Context, username, password string) (string, error) {
+	if err := c.sendReliable(macTelnetSessionStart, nil); err != nil {
+		return "", fmt.Errorf("mac-telnet session failed: %w", err)
+	}
+
+	client, err := newECSRPClient()
+	if err != nil {
+		return "", fmt.Errorf("mac-telnet login failed: %w", err)
+	}
+	var payload []byte
+	payload = appendMACTelnetControl(payload, macTelnetBeginAuth, nil)
+	payload = appendMACTelnetControl(payload, macTelnetPassSalt, append([]byte(username+"\x00"), client.public...))
+	if err := c.sendReliable(macTelnetData, payload); err != nil {
+		return "", fmt.Errorf("mac-telnet login failed: %w", err)
+	}
+
+	var output strings.Builder
+	loggedIn := false
+	for {
+		controls, err := c.next(ctx)
+		if err != nil {
+			if last := lastLine(strings.TrimRight(output.String(), "\r\n")); err == io.EOF && last != "" {
+				return output.String(), fmt.Errorf("mac-telnet login failed: %s", last)
+			}
+			return output.String(), fmt.Errorf("mac-telnet login failed: %w", err)
+		}
+
+		for _, control := range controls {
+			switch control.kind {
+			case macTelnetPlain:
+				output.Write(control.data)
+			case macTelnetPassSalt:
+				proof, err := macTelnetProof(client, username, password, control.data)
+				if err != nil {
+					return output.String(), fmt.Errorf("mac-telnet login failed: %w", err)
+				}
+				var payload []byte
+				payload = appendMACTelnetControl(payload, macTelnetPassword, proof)
+				payload = appendMACTelnetControl(payload, macTelnetUsername, []byte(username))
+				payload = appendMACTelnetControl(payload, macTelnetTermType, []byte(c.termType))
+				payload = append(payload, macTelnetTermSize(c.rows, c.cols)...)
+				if err := c.sendReliable(macTelnetData, payload); err != nil {
+					return output.String(), fmt.Errorf("mac-telnet login failed: %w", err)
+				}
+			case macTelnetEndAuth:
+				loggedIn = true
+			}
+		}
+
+		if loggedIn && shellPrompt.MatchString(lastLine(output.String())) {
+			return output.String(), nil
+		}
+	}
+}
+
+// macTelnetProof answers the salt of the device: 16 bytes of salt for the MD5
+// login of RouterOS before 6.43, the EC-SRP server key and salt after
+func macTelnetProof(client *ecsrpClient, username, password string, salt []byte) ([]byte, error) {
+	switch len(salt) {
+	case 16:
+		sum := md5.Sum(append(append([]byte{0}, password...), salt...))
+		return append([]byte{0}, sum[:]...), nil
+	case 49:
+		return client.response(username, password, salt)
+	default:
+		return nil, fmt.Errorf("unsupported authentication with %d bytes of salt", len(salt))
+	}
+}
+
+// Read reads terminal output from the device
+func (c *MACTelnetConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		controls, err := c.next(context.Background())
+		if err != nil {
+			return 0, err
+		}
+		for _, control := range controls {
+			if control.kind == macTelnetPlain {
+				c.pending = append(c.pending, control.data...)
+			}
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write sends terminal input to the device
+func (c *MACTelnetConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > 1024 {
+			chunk = chunk[:1024]
+		}
+		if err := c.sendReliable(macTelnetData, chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// WindowChange sends the new terminal size
+func (c *MACTelnetConn) WindowChange(rows, cols int) error {
+	return c.sendReliable(macTelnetData, macTelnetTermSize(rows, cols))
+}
+
+// Close ends the session
+func (c *MACTelnetConn) Close() error {
+	c.closeOnce.Do(func() {
+		select {
+		case <-c.done:
+		default:
+			c.send(macTelnetEnd, 0, nil)
+		}
+		c.conn.Close()
+	})
+	return nil
+}
+
+// dialMACTelnet opens a session to the device whose MAC address is the host of the
+// config and logs in with its credentials
+func dialMACTelnet(ctx context.Context, config ConnectionConfig, termType string, rows, cols int) (*MACTelnetConn, string, error) {
+	device, err := net.ParseMAC(config.Host)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid MAC address %q: %w", config.Host, err)
+	}
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	conn, err := NewMACTelnetConn(device, termType, rows, cols)
+	if err != nil {
+		return nil, "", err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	transcript, err := conn.Login(ctx, config.Username, config.Password)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = fmt.Errorf("mac-telnet login failed: %w", ctx.Err())
+		}
+		return nil, transcript, fmt.Errorf("%s: %w", config.Host, err)
+	}
+	return conn, transcript, nil
+}
@@ -0,0 +1,92 @@
+package pssh
+
+import (
+	"io"
+	"sync"
+)
+
+// tiledInput routes the keystrokes typed in the panes of a tiled terminal to
+// their sessions
+type tiledInput struct {
+	stdins       []io.WriteCloser
+	targets      *inputTargets // Panes receiving keystrokes while synchronized
+	synchronized bool
+	lastPane     int // Pane typed in last, focused again after a click on the bars
+	mutex        sync.Mutex
+}
+
+func newTiledInput(stdins []io.WriteCloser) *tiledInput {
+	return &tiledInput{stdins: stdins, targets: newInputTargets(len(stdins))}
+}
+
+// setSynchronized turns synchronized input across the panes on or off
+func (ti *tiledInput) setSynchronized(synchronized bool) {
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+	ti.synchronized = synchronized
+}
+
+// last returns the pane typed in last
+func (ti *tiledInput) last() int {
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+	return ti.lastPane
+}
+
+// paneInput receives the keystrokes of a pane
+type paneInput struct {
+	input *tiledInput
+	index int
+	guard *inputGuard // Holds back pastes and dangerous commands while synchronized
+}
+
+func (pi *paneInput) Write(p []byte) (int, error) {
+	pi.input.mutex.Lock()
+	pi.input.lastPane = pi.index
+	pi.input.mutex.Unlock()
+
+	pi.guard.write(p, pi.send)
+	return len(p), nil
+}
+
+// send writes p to the session of the pane and, when synchronized, to the other
+// sessions receiving keystrokes
+func (pi *paneInput) send(p []byte) {
+	input := pi.input
+	input.mutex.Lock()
+	synchronized := input.synchronized
+	input.mutex.Unlock()
+
+	input.stdins[pi.index].Write(p)
+	if !synchronized {
+		return
+	}
+	for i, stdin := range input.stdins {
+		if i != pi.index && input.targets.isEnabled(i) {
+			stdin.Write(p)
+		}
+	}
+}
+
+// receivers returns the number of sessions the keystrokes of the pane go to
+func (pi *paneInput) receivers() int {
+	input := pi.input
+	input.mutex.Lock()
+	synchronized := input.synchronized
+	input.mutex.Unlock()
+
+	n := 1
+	if !synchronized {
+		return n
+	}
+	for i := range input.stdins {
+		if i != pi.index && input.targets.isEnabled(i) {
+			n++
+		}
+	}
+	return n
+}
+
+func (pi *paneInput) Close() error {
+	return pi.input.stdins[pi.index].Close()
+}
@@ -1,36 +1,67 @@
 package pssh
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"net"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/ispapp/psshclient/pkg/routeros"
 	"golang.org/x/crypto/ssh"
 )
 
 // ConnectionConfig holds SSH connection configuration
 type ConnectionConfig struct {
 	Host       string
+	HostName   string // Optional: address to dial when it differs from Host (ssh_config HostName)
 	Port       int
 	Username   string
 	Password   string
 	Timeout    time.Duration
 	PrivateKey []byte // Optional: SSH private key for key-based auth
+
+	CommandTimeout time.Duration // Optional: limit for each command run on the connection
+	Protocol       Protocol      // Defaults to ProtocolSSH when empty
+
+	// Key-based authentication
+	KeyPath          string               // Optional: path to a private key file
+	Passphrase       string               // Optional: passphrase for an encrypted private key
+	PassphrasePrompt PassphrasePromptFunc // Optional: asked when an encrypted key has no passphrase
+	UseAgent         bool                 // Also offer keys held by ssh-agent (SSH_AUTH_SOCK)
+
+	// Host key verification
+	HostKeyPolicy      HostKeyPolicy     // Defaults to HostKeyTOFU when empty
+	KnownHostsFile     string            // Optional: known_hosts path (defaults to ~/.ssh/known_hosts)
+	HostKeyFingerprint string            // Optional: SHA256 fingerprint pinned for this host
+	HostKeyPrompt      HostKeyPromptFunc // Optional: asked before trusting a new host key
+
+	// Jump hosts (ProxyJump), dialed in order before the target host.
+	// Unset fields of a hop are inherited from the target configuration.
+	Via []ConnectionConfig
+
+	// Terminal options of the device, unset fields use DefaultTerminalOptions
+	Terminal TerminalOptions
+
+	// Privilege escalation of commands and interactive shells
+	Become         BecomeMethod // Optional: sudo, su or enable
+	BecomePassword string       // Optional: the sudo password, root password or enable secret
 }
 
 // SSHConnection represents an active SSH connection
 type SSHConnection struct {
-	Config    ConnectionConfig
-	Client    *ssh.Client
-	Session   *ssh.Session
-	Connected bool
-	Error     error
-	mutex     sync.RWMutex
+	Config             ConnectionConfig
+	Client             *ssh.Client
+	Session            *ssh.Session
+	Connected          bool
+	Error              error
+	HostKeyFingerprint string         // SHA256 fingerprint presented by the server
+	bastion            *bastionClient // Shared jump host client, nil for direct connections
+	api                *routeros.Client
+	health             HealthState
+	monitorStop        chan struct{} // Closed to stop keepalive monitoring
+	mutex              sync.RWMutex
 }
 
 // ConnectionResult holds the result of a connection attempt
@@ -42,8 +73,11 @@ type ConnectionResult struct {
 
 // SSHManager manages multiple SSH connections
 type SSHManager struct {
-	connections map[string]*SSHConnection
-	mutex       sync.RWMutex
+	connections    map[string]*SSHConnection
+	keepalive      KeepaliveConfig
+	listeners      []func(HealthEvent)
+	maxConcurrency int // Connection attempts running at once, 0 is unlimited
+	mutex          sync.RWMutex
 }
 
 // NewSSHManager creates a new SSH manager
@@ -53,15 +87,25 @@ func NewSSHManager() *SSHManager {
 	}
 }
 
-// NewConnectionConfig creates a new connection configuration
+// NewConnectionConfig creates a new connection configuration.
+// Settings from the OpenSSH client config fill in an empty username, a zero port,
+// the private key, jump hosts and timeout.
 func NewConnectionConfig(host string, port int, username, password string) ConnectionConfig {
-	return ConnectionConfig{
+	config := ConnectionConfig{
 		Host:     host,
 		Port:     port,
 		Username: username,
 		Password: password,
-		Timeout:  30 * time.Second,
 	}
+	ApplySSHConfig(&config)
+
+	if config.Port == 0 {
+		config.Port = 22
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+	return config
 }
 
 // NewConnectionConfigForMikroTik creates a connection config optimized for MikroTik devices
@@ -104,78 +148,100 @@ func (conn *SSHConnection) ConnectWithAllMethods() error {
 	conn.mutex.Lock()
 	defer conn.mutex.Unlock()
 
-	// Create SSH client configuration
-	config := &ssh.ClientConfig{
-		User:            conn.Config.Username,
-		Timeout:         conn.Config.Timeout,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // Note: Use proper host key verification in production
+	if dial := conn.shellDialer(); dial != nil {
+		return conn.connectShell(dial)
+	}
+	if conn.Config.usesAPI() {
+		return conn.connectAPI()
 	}
 
-	// Build authentication methods in order of preference
-	var authMethods []ssh.AuthMethod
-
-	// 1. Private key authentication (if provided)
-	if len(conn.Config.PrivateKey) > 0 {
-		signer, err := ssh.ParsePrivateKey(conn.Config.PrivateKey)
+	// Tunnel through the jump hosts, if any
+	var bastion *bastionClient
+	var dial dialFunc = directDial
+	if len(conn.Config.Via) > 0 {
+		var err error
+		bastion, err = bastions.acquire(jumpChain(conn.Config))
 		if err != nil {
-			fmt.Printf("Warning: Failed to parse private key: %v\n", err)
-		} else {
-			authMethods = append(authMethods, ssh.PublicKeys(signer))
+			conn.Error = fmt.Errorf("failed to connect to %s via %s: %w", conn.Config.Host, FormatJumpHosts(conn.Config.Via), err)
+			conn.Connected = false
+			return conn.Error
 		}
+		dial = bastion.client.DialContext
 	}
 
-	// 2. Password authentication (if provided)
-	if conn.Config.Password != "" {
-		authMethods = append(authMethods, ssh.Password(conn.Config.Password))
+	client, fingerprint, err := dialSSH(conn.Config, dial, true)
+	if err != nil {
+		if bastion != nil {
+			bastions.release(bastion)
+		}
+		conn.Error = err
+		conn.Connected = false
+		return conn.Error
 	}
 
-	// 3. Keyboard-interactive authentication (for systems that require it)
-	if conn.Config.Password != "" {
-		authMethods = append(authMethods, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
-			answers := make([]string, len(questions))
-			for i := range answers {
-				answers[i] = conn.Config.Password
-			}
-			return answers, nil
-		}))
-	}
+	conn.Client = client
+	conn.bastion = bastion
+	conn.Connected = true
+	conn.Error = nil
+	conn.HostKeyFingerprint = fingerprint
+	return nil
+}
 
-	// 4. Try common default keys if no specific key provided
-	if len(conn.Config.PrivateKey) == 0 {
-		// Try to load common SSH keys from default locations
-		commonKeys := []string{
-			"~/.ssh/id_rsa",
-			"~/.ssh/id_ed25519",
-			"~/.ssh/id_ecdsa",
-		}
+// dialSSH opens an SSH client for a single hop over the given dialer.
+// It returns the client and the fingerprint of the host key presented by the server.
+func dialSSH(cfg ConnectionConfig, dial dialFunc, learn bool) (*ssh.Client, string, error) {
+	// Create SSH client configuration
+	var fingerprint string
+	config := &ssh.ClientConfig{
+		User:            cfg.Username,
+		Timeout:         cfg.Timeout,
+		HostKeyCallback: hostKeyCallback(cfg, learn, &fingerprint),
+	}
 
-		for _, keyPath := range commonKeys {
-			if signer, err := loadPrivateKeyFromFile(keyPath); err == nil {
-				authMethods = append(authMethods, ssh.PublicKeys(signer))
-			}
-		}
+	// Build authentication methods in order of preference
+	authMethods, closeAgent, err := buildAuthMethods(cfg)
+	defer closeAgent()
+	if err != nil {
+		return nil, "", err
 	}
 
 	if len(authMethods) == 0 {
-		conn.Error = fmt.Errorf("no authentication methods available")
-		return conn.Error
+		return nil, "", fmt.Errorf("no authentication methods available")
 	}
 
 	config.Auth = authMethods
 
 	// Connect to SSH server
-	address := net.JoinHostPort(conn.Config.Host, fmt.Sprintf("%d", conn.Config.Port))
-	client, err := ssh.Dial("tcp", address, config)
+	address := cfg.address()
+	config.HostKeyAlgorithms = knownHostKeyAlgorithms(cfg, address)
+	ctx := context.Background()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	netConn, err := dial(ctx, "tcp", address)
 	if err != nil {
-		conn.Error = fmt.Errorf("failed to connect to %s: %v", address, err)
-		conn.Connected = false
-		return conn.Error
+		return nil, "", fmt.Errorf("failed to connect to %s: %w", address, err)
 	}
 
-	conn.Client = client
-	conn.Connected = true
-	conn.Error = nil
-	return nil
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, address, config)
+	if err != nil {
+		netConn.Close()
+		return nil, "", fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), fingerprint, nil
+}
+
+// address returns the host:port to dial for the config
+func (config ConnectionConfig) address() string {
+	host := config.Host
+	if config.HostName != "" {
+		host = config.HostName
+	}
+	return net.JoinHostPort(host, fmt.Sprintf("%d", config.Port))
 }
 
 // CreateSession creates a new SSH session
@@ -196,11 +262,36 @@ func (conn *SSHConnection) CreateSession() (*ssh.Session, error) {
 	return session, nil
 }
 
-// Close closes the SSH connection
+// Close closes the SSH connection and stops its keepalive monitoring
 func (conn *SSHConnection) Close() error {
 	conn.mutex.Lock()
 	defer conn.mutex.Unlock()
 
+	if conn.monitorStop != nil {
+		close(conn.monitorStop)
+		conn.monitorStop = nil
+	}
+
+	errs := conn.closeClient()
+	conn.health = ""
+
+	if len(errs) > 0 {
+		return fmt.Errorf("errors closing connection: %v", errs)
+	}
+
+	return nil
+}
+
+// dropClient closes a dead client while keeping the connection monitored
+func (conn *SSHConnection) dropClient() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.closeClient()
+}
+
+// closeClient closes the session, client and jump host of the connection.
+// The caller must hold the write lock.
+func (conn *SSHConnection) closeClient() []error {
 	var errs []error
 
 	if conn.Session != nil {
@@ -217,13 +308,18 @@ func (conn *SSHConnection) Close() error {
 		conn.Client = nil
 	}
 
-	conn.Connected = false
+	if conn.api != nil {
+		conn.api.Close()
+		conn.api = nil
+	}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("errors closing connection: %v", errs)
+	if conn.bastion != nil {
+		bastions.release(conn.bastion)
+		conn.bastion = nil
 	}
 
-	return nil
+	conn.Connected = false
+	return errs
 }
 
 // IsConnected returns whether the connection is active
@@ -233,16 +329,34 @@ func (conn *SSHConnection) IsConnected() bool {
 	return conn.Connected
 }
 
-// ConnectMultiple connects to multiple hosts in parallel
+// SetMaxConcurrency limits how many hosts ConnectMultiple connects to at the same time
+func (manager *SSHManager) SetMaxConcurrency(limit int) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.maxConcurrency = limit
+}
+
+// ConnectMultiple connects to multiple hosts in parallel, at most SetMaxConcurrency at a time
 func (manager *SSHManager) ConnectMultiple(configs []ConnectionConfig) <-chan ConnectionResult {
 	resultChan := make(chan ConnectionResult, len(configs))
 
+	manager.mutex.RLock()
+	limit := manager.maxConcurrency
+	manager.mutex.RUnlock()
+	if limit <= 0 || limit > len(configs) {
+		limit = len(configs)
+	}
+	slots := make(chan struct{}, max(limit, 1))
+
 	var wg sync.WaitGroup
 	for _, config := range configs {
 		wg.Add(1)
 		go func(cfg ConnectionConfig) {
 			defer wg.Done()
 
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
 			conn := &SSHConnection{Config: cfg}
 			err := conn.Connect()
 
@@ -254,8 +368,15 @@ func (manager *SSHManager) ConnectMultiple(configs []ConnectionConfig) <-chan Co
 
 			if err == nil {
 				manager.mutex.Lock()
+				previous := manager.connections[cfg.Host]
 				manager.connections[cfg.Host] = conn
 				manager.mutex.Unlock()
+
+				// A replaced connection must not keep reconnecting in the background
+				if previous != nil && previous != conn {
+					previous.stopMonitoring()
+				}
+				manager.monitor(conn)
 			}
 
 			resultChan <- result
@@ -270,6 +391,16 @@ func (manager *SSHManager) ConnectMultiple(configs []ConnectionConfig) <-chan Co
 	return resultChan
 }
 
+// stopMonitoring stops keepalive monitoring without closing the connection
+func (conn *SSHConnection) stopMonitoring() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.monitorStop != nil {
+		close(conn.monitorStop)
+		conn.monitorStop = nil
+	}
+}
+
 // GetConnection returns a connection by host
 func (manager *SSHManager) GetConnection(host string) (*SSHConnection, bool) {
 	manager.mutex.RLock()
@@ -323,16 +454,24 @@ func TestConnection(host string, port int, timeout time.Duration) error {
 	return nil
 }
 
-// ProbeSSHAuthMethods tests what authentication methods are supported by an SSH server
-func ProbeSSHAuthMethods(host string, port int) ([]string, error) {
+// ProbeSSHAuthMethods tests what authentication methods are supported by the SSH
+// server of a device. Its host key is checked with the known hosts file and policy
+// of the config.
+func ProbeSSHAuthMethods(connConfig ConnectionConfig) ([]string, error) {
+	// Known hosts are still checked while probing, but new keys are never recorded
 	config := &ssh.ClientConfig{
 		User:            "test", // Dummy user to probe auth methods
 		Timeout:         5 * time.Second,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback(connConfig, false, nil),
 		Auth:            []ssh.AuthMethod{}, // No auth methods to trigger method listing
 	}
 
-	address := net.JoinHostPort(host, fmt.Sprintf("%d", port))
+	port := connConfig.Port
+	if port == 0 {
+		port = 22
+	}
+	address := net.JoinHostPort(connConfig.Host, fmt.Sprintf("%d", port))
+	config.HostKeyAlgorithms = knownHostKeyAlgorithms(connConfig, address)
 	_, err := ssh.Dial("tcp", address, config)
 
 	if err != nil {
@@ -350,64 +489,14 @@ func ProbeSSHAuthMethods(host string, port int) ([]string, error) {
 				}
 			}
 		}
-		return nil, fmt.Errorf("failed to probe SSH server: %v", err)
+		return nil, fmt.Errorf("failed to probe SSH server: %w", err)
 	}
 
 	return []string{"none"}, nil // Shouldn't happen with dummy auth
 }
 
-// loadPrivateKeyFromFile loads a private key from a file path
-func loadPrivateKeyFromFile(keyPath string) (ssh.Signer, error) {
-	// Expand ~ to home directory
-	if strings.HasPrefix(keyPath, "~") {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return nil, err
-		}
-		keyPath = filepath.Join(homeDir, keyPath[1:])
-	}
-
-	// Check if file exists
-	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
-		return nil, err
-	}
-
-	// Read the private key file
-	keyBytes, err := ioutil.ReadFile(keyPath)
-	if err != nil {
-		return nil, err
-	}
-
-	// Parse the private key
-	signer, err := ssh.ParsePrivateKey(keyBytes)
-	if err != nil {
-		return nil, err
-	}
-
-	return signer, nil
-}
-
-// RunCommand runs a command on the remote server and returns its output
+// RunCommand runs a command on the remote server and returns its combined output.
+// If the command fails, the output produced so far is returned along with the error.
 func (conn *SSHConnection) RunCommand(command string) (string, error) {
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-
-	if !conn.Connected || conn.Client == nil {
-		return "", fmt.Errorf("not connected")
-	}
-
-	// Create a new session for this command
-	session, err := conn.Client.NewSession()
-	if err != nil {
-		return "", fmt.Errorf("failed to create session: %w", err)
-	}
-	defer session.Close()
-
-	// Run the command and capture the output
-	output, err := session.CombinedOutput(command)
-	if err != nil {
-		return "", fmt.Errorf("failed to run command: %w", err)
-	}
-
-	return string(output), nil
+	return conn.RunCommandContext(context.Background(), command)
 }
@@ -1,8 +1,39 @@
 package pssh
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/ispapp/psshclient/pkg/routeros"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 func TestNewSSHManager(t *testing.T) {
@@ -74,3 +105,2714 @@ func TestTestConnection(t *testing.T) {
 		t.Error("Expected connection test to fail for closed port")
 	}
 }
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer.PublicKey()
+}
+
+func TestHostKeyTOFU(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.88.1"), Port: 22}
+	key := newTestHostKey(t)
+
+	prompted := 0
+	config := ConnectionConfig{
+		HostKeyPolicy:  HostKeyTOFU,
+		KnownHostsFile: knownHosts,
+		HostKeyPrompt: func(host string, key ssh.PublicKey) bool {
+			prompted++
+			return true
+		},
+	}
+
+	var seen string
+	callback := hostKeyCallback(config, true, &seen)
+	if err := callback("192.168.88.1:22", remote, key); err != nil {
+		t.Fatalf("Expected first contact to be trusted, got %v", err)
+	}
+	if prompted != 1 {
+		t.Errorf("Expected 1 prompt, got %d", prompted)
+	}
+	if seen != ssh.FingerprintSHA256(key) {
+		t.Errorf("Expected fingerprint %s, got %s", ssh.FingerprintSHA256(key), seen)
+	}
+
+	// Second contact with the same key must not prompt again
+	if err := callback("192.168.88.1:22", remote, key); err != nil {
+		t.Fatalf("Expected known key to be accepted, got %v", err)
+	}
+	if prompted != 1 {
+		t.Errorf("Expected no additional prompt, got %d prompts", prompted)
+	}
+
+	// A different key for the same host must fail hard
+	err := callback("192.168.88.1:22", remote, newTestHostKey(t))
+	if !IsHostKeyMismatch(err) {
+		t.Fatalf("Expected host key mismatch, got %v", err)
+	}
+
+	if err := RemoveKnownHost(knownHosts, "192.168.88.1", 22); err != nil {
+		t.Fatalf("Failed to remove known host: %v", err)
+	}
+	if err := callback("192.168.88.1:22", remote, newTestHostKey(t)); err != nil {
+		t.Fatalf("Expected new key to be trusted after removal, got %v", err)
+	}
+}
+
+func TestHostKeyStrictAndPinned(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 2222}
+	key := newTestHostKey(t)
+
+	strict := hostKeyCallback(ConnectionConfig{HostKeyPolicy: HostKeyStrict, KnownHostsFile: knownHosts}, true, nil)
+	var unknownErr *HostKeyUnknownError
+	if err := strict("10.0.0.1:2222", remote, key); !errors.As(err, &unknownErr) {
+		t.Fatalf("Expected unknown host error in strict mode, got %v", err)
+	}
+
+	pinned := ConnectionConfig{
+		HostKeyPolicy:      HostKeyStrict,
+		KnownHostsFile:     knownHosts,
+		HostKeyFingerprint: ssh.FingerprintSHA256(key),
+	}
+	if err := hostKeyCallback(pinned, true, nil)("10.0.0.1:2222", remote, key); err != nil {
+		t.Fatalf("Expected pinned key to be accepted, got %v", err)
+	}
+	if err := hostKeyCallback(pinned, true, nil)("10.0.0.1:2222", remote, newTestHostKey(t)); !IsHostKeyMismatch(err) {
+		t.Fatalf("Expected pinned key mismatch, got %v", err)
+	}
+}
+
+func TestEncryptedPrivateKey(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(privateKey, "", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to encrypt key: %v", err)
+	}
+
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	// Without a passphrase source the key must be reported, not silently skipped
+	if _, err := loadPrivateKeyFromFile(keyPath, ConnectionConfig{}, true); err == nil {
+		t.Fatal("Expected encrypted key without passphrase to fail")
+	}
+
+	prompted := 0
+	config := ConnectionConfig{
+		PassphrasePrompt: func(path string) (string, error) {
+			prompted++
+			if path != keyPath {
+				t.Errorf("Expected prompt for %s, got %s", keyPath, path)
+			}
+			return "secret", nil
+		},
+	}
+	if _, err := loadPrivateKeyFromFile(keyPath, config, true); err != nil {
+		t.Fatalf("Expected key to be unlocked, got %v", err)
+	}
+
+	// The passphrase is cached for the rest of the session
+	if _, err := loadPrivateKeyFromFile(keyPath, config, true); err != nil {
+		t.Fatalf("Expected cached passphrase to unlock key, got %v", err)
+	}
+	if prompted != 1 {
+		t.Errorf("Expected 1 prompt, got %d", prompted)
+	}
+
+	methods, cleanup, err := buildAuthMethods(ConnectionConfig{KeyPath: keyPath, Password: "password"})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("Failed to build auth methods: %v", err)
+	}
+	if len(methods) != 3 {
+		t.Errorf("Expected publickey, password and keyboard-interactive methods, got %d", len(methods))
+	}
+}
+
+func TestHostKeyOfAnotherType(t *testing.T) {
+	// A host recorded with its ed25519 key, e.g. by OpenSSH, is not a mismatch when
+	// it presents its ECDSA key
+	server := newTestSSHServer(t)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	address := net.JoinHostPort(server.Host, fmt.Sprintf("%d", server.Port))
+	if err := appendKnownHost(knownHosts, address, server.HostKey); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+
+	config := NewConnectionConfig(server.Host, server.Port, "admin", "secret")
+	config.KnownHostsFile = knownHosts
+	config.HostKeyPolicy = HostKeyStrict
+	strict := hostKeyCallback(config, true, nil)
+	var unknownErr *HostKeyUnknownError
+	if err := strict(address, &net.TCPAddr{}, newTestECDSAKey(t)); !errors.As(err, &unknownErr) {
+		t.Fatalf("Expected a key of another type to be unknown, got %v", err)
+	}
+	if err := strict(address, &net.TCPAddr{}, newTestHostKey(t)); !IsHostKeyMismatch(err) {
+		t.Fatalf("Expected a changed ed25519 key to be a mismatch, got %v", err)
+	}
+
+	// The client asks for the key type on record, so the connection succeeds
+	if algorithms := knownHostKeyAlgorithms(config, address); len(algorithms) != 1 || algorithms[0] != ssh.KeyAlgoED25519 {
+		t.Errorf("Expected ed25519 to be asked for, got %v", algorithms)
+	}
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Expected the recorded ed25519 key to be verified, got %v", err)
+	}
+	conn.Close()
+}
+
+func newTestECDSAKey(t *testing.T) ssh.PublicKey {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to convert key: %v", err)
+	}
+	return key
+}
+
+func TestProbeSSHAuthMethods(t *testing.T) {
+	server := newTestSSHServer(t)
+	config := NewConnectionConfig(server.Host, server.Port, "test", "")
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	config.HostKeyPolicy = HostKeyStrict
+	var unknownErr *HostKeyUnknownError
+	if _, err := ProbeSSHAuthMethods(config); !errors.As(err, &unknownErr) {
+		t.Fatalf("Expected the probe to check known hosts in strict mode, got %v", err)
+	}
+
+	config.HostKeyPolicy = HostKeyTOFU
+	if _, err := ProbeSSHAuthMethods(config); err != nil {
+		t.Fatalf("Failed to probe: %v", err)
+	}
+	if data, _ := os.ReadFile(config.KnownHostsFile); len(data) > 0 {
+		t.Errorf("Expected the probe not to record host keys, got %q", data)
+	}
+}
+
+// testSSHServer is a minimal in-process SSH server accepting password "secret". It
+// offers an ECDSA and an ed25519 host key.
+type testSSHServer struct {
+	Host        string
+	Port        int
+	HostKey     ssh.PublicKey // The ed25519 host key
+	Connections int32         // Number of SSH handshakes completed
+	conns       []net.Conn
+	mutex       sync.Mutex
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", conn.User())
+		},
+	}
+	config.AddHostKey(signer)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	ecdsaSigner, err := ssh.NewSignerFromKey(ecdsaKey)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	config.AddHostKey(ecdsaSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &testSSHServer{
+		Host:    "127.0.0.1",
+		Port:    listener.Addr().(*net.TCPAddr).Port,
+		HostKey: signer.PublicKey(),
+	}
+
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(netConn, config)
+		}
+	}()
+
+	return server
+}
+
+// DropConnections closes every client connection, as a rebooting router would
+func (s *testSSHServer) DropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSSHServer) serve(netConn net.Conn, config *ssh.ServerConfig) {
+	serverConn, chans, reqs, err := ssh.NewServerConn(netConn, config)
+	if err != nil {
+		netConn.Close()
+		return
+	}
+	atomic.AddInt32(&s.Connections, 1)
+	s.mutex.Lock()
+	s.conns = append(s.conns, netConn)
+	s.mutex.Unlock()
+	go serveTestGlobalRequests(serverConn, reqs)
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "direct-tcpip":
+			var target struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprintf("%d", target.Port)))
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				upstream.Close()
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				io.Copy(channel, upstream)
+				channel.Close()
+			}()
+			go func() {
+				io.Copy(upstream, channel)
+				upstream.Close()
+			}()
+		case "session":
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go serveTestSession(channel, requests)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// serveTestGlobalRequests handles remote port forwards: tcpip-forward listens on the
+// requested address and opens a forwarded-tcpip channel for every accepted connection
+func serveTestGlobalRequests(conn ssh.Conn, requests <-chan *ssh.Request) {
+	listeners := make(map[uint32]net.Listener)
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	for request := range requests {
+		var forward struct {
+			Host string
+			Port uint32
+		}
+		if request.Type != "tcpip-forward" && request.Type != "cancel-tcpip-forward" || ssh.Unmarshal(request.Payload, &forward) != nil {
+			request.Reply(false, nil)
+			continue
+		}
+		if request.Type == "cancel-tcpip-forward" {
+			if listener, exists := listeners[forward.Port]; exists {
+				listener.Close()
+				delete(listeners, forward.Port)
+			}
+			request.Reply(true, nil)
+			continue
+		}
+
+		listener, err := net.Listen("tcp", net.JoinHostPort(forward.Host, fmt.Sprintf("%d", forward.Port)))
+		if err != nil {
+			request.Reply(false, nil)
+			continue
+		}
+		port := uint32(listener.Addr().(*net.TCPAddr).Port)
+		listeners[port] = listener
+		request.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+
+		go func(host string) {
+			for {
+				local, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				origin := local.RemoteAddr().(*net.TCPAddr)
+				channel, channelRequests, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+					Host       string
+					Port       uint32
+					OriginHost string
+					OriginPort uint32
+				}{host, port, origin.IP.String(), uint32(origin.Port)}))
+				if err != nil {
+					local.Close()
+					continue
+				}
+				go ssh.DiscardRequests(channelRequests)
+				go func() {
+					io.Copy(channel, local)
+					channel.CloseWrite()
+				}()
+				go func() {
+					io.Copy(local, channel)
+					local.Close()
+				}()
+			}
+		}(forward.Host)
+	}
+}
+
+// serveTestSession serves the sftp subsystem and runs exec requests with a few canned commands:
+// "echo <text>", "fail" (partial output, exit 3), "kill" (SIGKILL), "sleep <duration>" and
+// "sudo" taking the password "sudo-secret"
+func serveTestSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for request := range requests {
+		if request.Type == "subsystem" && string(request.Payload[4:]) == "sftp" {
+			request.Reply(true, nil)
+			go ssh.DiscardRequests(requests)
+			server, err := sftp.NewServer(channel)
+			if err == nil {
+				server.Serve()
+			}
+			return
+		}
+		if request.Type == "shell" {
+			request.Reply(true, nil)
+			go ssh.DiscardRequests(requests)
+			serveTestShell(channel)
+			return
+		}
+		if request.Type != "exec" {
+			request.Reply(request.Type == "pty-req", nil)
+			continue
+		}
+
+		var payload struct{ Command string }
+		ssh.Unmarshal(request.Payload, &payload)
+		request.Reply(true, nil)
+
+		exitStatus := uint32(0)
+		command, argument, _ := strings.Cut(payload.Command, " ")
+		switch command {
+		case "echo":
+			fmt.Fprintln(channel, argument)
+		case "fail":
+			fmt.Fprintln(channel, "partial output")
+			fmt.Fprintln(channel.Stderr(), "something broke")
+			exitStatus = 3
+		case "kill":
+			fmt.Fprint(channel, "killed")
+			channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+				Signal     string
+				CoreDumped bool
+				Error      string
+				Lang       string
+			}{Signal: "KILL"}))
+			return
+		case "sleep":
+			// A signal or the client closing the session interrupts the sleep
+			interrupted := make(chan struct{})
+			go func() {
+				defer close(interrupted)
+				for request := range requests {
+					if request.Type == "signal" {
+						return
+					}
+				}
+			}()
+			duration, _ := time.ParseDuration(argument)
+			select {
+			case <-time.After(duration):
+			case <-interrupted:
+				channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+					Signal     string
+					CoreDumped bool
+					Error      string
+					Lang       string
+				}{Signal: "KILL"}))
+				return
+			}
+		case "sudo":
+			if strings.HasPrefix(argument, "-n ") && !strings.Contains(argument, "cached") {
+				fmt.Fprintln(channel.Stderr(), "sudo: a password is required")
+				exitStatus = 1
+				break
+			}
+			reader := bufio.NewReader(channel)
+			// Credentials cached by an earlier sudo are not asked for again
+			if !strings.Contains(argument, "cached") {
+				fmt.Fprint(channel.Stderr(), sudoPrompt)
+				if password, _ := reader.ReadString('\n'); password != "sudo-secret\n" {
+					fmt.Fprint(channel.Stderr(), "Sorry, try again.\n"+sudoPrompt)
+					reader.ReadString('\n')
+					fmt.Fprintln(channel.Stderr(), "sudo: 1 incorrect password attempt")
+					exitStatus = 1
+					break
+				}
+			}
+			// The command reads stdin until it ends, as cat would
+			fmt.Fprint(channel.Stderr(), sudoStarted)
+			if strings.Contains(argument, "sudoers") {
+				fmt.Fprintln(channel.Stderr(), "deploy is not in the sudoers file")
+				exitStatus = 1
+				break
+			}
+			if input, _ := io.ReadAll(reader); len(input) > 0 {
+				fmt.Fprintf(channel, "read %q\n", input)
+			}
+			fmt.Fprintln(channel, "root")
+		default:
+			fmt.Fprintf(channel.Stderr(), "%s: command not found\n", command)
+			exitStatus = 127
+		}
+
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{exitStatus}))
+		return
+	}
+}
+
+// serveTestShell serves an interactive shell echoing its input like a pty. It runs
+// "echo <text>" and "reboot", which asks for a confirmation.
+func serveTestShell(channel ssh.Channel) {
+	reader := bufio.NewReader(channel)
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\r')
+		line = strings.TrimSpace(line)
+		fmt.Fprint(channel, line+"\r\n")
+		return line, err
+	}
+
+	fmt.Fprint(channel, "Linux test 6.1.0\r\n")
+	for {
+		fmt.Fprint(channel, "admin@test:~$ ")
+		line, err := readLine()
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		switch command {
+		case "echo":
+			fmt.Fprint(channel, argument+"\r\n")
+		case "reboot":
+			fmt.Fprint(channel, "Reboot, yes? [y/N]: ")
+			answer, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			if answer == 'y' {
+				fmt.Fprint(channel, "y\r\nsystem will reboot shortly\r\n")
+			} else {
+				fmt.Fprint(channel, "\r\naction cancelled\r\n")
+			}
+		}
+	}
+}
+
+func TestParseJumpHosts(t *testing.T) {
+	hops, err := ParseJumpHosts("admin@bastion.example.com:2222, core-router,[fd00::1]:22", 22)
+	if err != nil {
+		t.Fatalf("Failed to parse jump hosts: %v", err)
+	}
+	if len(hops) != 3 {
+		t.Fatalf("Expected 3 hops, got %d", len(hops))
+	}
+	if hops[0].Username != "admin" || hops[0].Host != "bastion.example.com" || hops[0].Port != 2222 {
+		t.Errorf("Unexpected first hop: %+v", hops[0])
+	}
+	if hops[1].Username != "" || hops[1].Host != "core-router" || hops[1].Port != 22 {
+		t.Errorf("Unexpected second hop: %+v", hops[1])
+	}
+	if hops[2].Host != "fd00::1" {
+		t.Errorf("Expected IPv6 hop, got %+v", hops[2])
+	}
+	if got := FormatJumpHosts(hops[:2]); got != "admin@bastion.example.com:2222,core-router:22" {
+		t.Errorf("Unexpected formatted jump hosts: %s", got)
+	}
+
+	if _, err := ParseJumpHosts("bastion:notaport", 22); err == nil {
+		t.Error("Expected invalid port to fail")
+	}
+}
+
+func TestConnectViaJumpHost(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	bastion := newTestSSHServer(t)
+	targets := []*testSSHServer{newTestSSHServer(t), newTestSSHServer(t)}
+
+	var configs []ConnectionConfig
+	for _, target := range targets {
+		config := NewConnectionConfig(target.Host, target.Port, "admin", "secret")
+		config.KnownHostsFile = knownHosts
+		// The jump host inherits credentials and host key settings from the target
+		config.Via = []ConnectionConfig{{Host: bastion.Host, Port: bastion.Port}}
+		configs = append(configs, config)
+	}
+
+	var connections []*SSHConnection
+	for result := range NewSSHManager().ConnectMultiple(configs) {
+		if result.Error != nil {
+			t.Fatalf("Failed to connect to %s via jump host: %v", result.Host, result.Error)
+		}
+		connections = append(connections, result.Connection)
+	}
+
+	if got := atomic.LoadInt32(&bastion.Connections); got != 1 {
+		t.Errorf("Expected a single shared jump host connection, got %d", got)
+	}
+	for i, target := range targets {
+		if got := atomic.LoadInt32(&target.Connections); got != 1 {
+			t.Errorf("Expected target %d to be reached once, got %d", i, got)
+		}
+	}
+
+	for _, conn := range connections {
+		if err := conn.Close(); err != nil {
+			t.Fatalf("Failed to close connection: %v", err)
+		}
+	}
+	bastions.mutex.Lock()
+	remaining := len(bastions.clients)
+	bastions.mutex.Unlock()
+	if remaining != 0 {
+		t.Errorf("Expected jump host client to be released, %d still pooled", remaining)
+	}
+}
+
+func TestSSHConfigResolve(t *testing.T) {
+	keyDir := t.TempDir()
+	sshConfig, err := ParseSSHConfig(strings.NewReader(`
+# Customer routers are reached through the core
+Host 10.20.*.* !10.20.0.1
+    User admin
+    Port 2222
+    ProxyJump ops@core
+
+Host core
+    HostName 10.20.0.1
+    User "ops"
+    IdentityFile ` + keyDir + `/%r_key
+
+Match host 10.20.0.1
+    ConnectTimeout=5
+
+Host *
+    User root
+    Port 22
+    IdentityFile ~/.ssh/id_ed25519
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse SSH config: %v", err)
+	}
+
+	router := sshConfig.Resolve("10.20.3.4")
+	if router.User != "admin" || router.Port != 2222 || router.ProxyJump != "ops@core" {
+		t.Errorf("Unexpected router settings: %+v", router)
+	}
+	if len(router.IdentityFiles) != 1 {
+		t.Errorf("Expected the wildcard identity file, got %v", router.IdentityFiles)
+	}
+
+	core := sshConfig.Resolve("core")
+	if core.HostName != "10.20.0.1" || core.User != "ops" || core.Port != 22 {
+		t.Errorf("Unexpected core settings: %+v", core)
+	}
+	if core.ConnectTimeout != 5*time.Second {
+		t.Errorf("Expected Match host on the resolved HostName to set a 5s timeout, got %v", core.ConnectTimeout)
+	}
+	if len(core.IdentityFiles) != 2 || core.IdentityFiles[0] != filepath.Join(keyDir, "ops_key") {
+		t.Errorf("Unexpected core identity files: %v", core.IdentityFiles)
+	}
+
+	// The negated pattern keeps the core itself out of the router block
+	if direct := sshConfig.Resolve("10.20.0.1"); direct.User != "root" || direct.ProxyJump != "" {
+		t.Errorf("Unexpected settings for negated host: %+v", direct)
+	}
+
+	if _, err := ParseSSHConfig(strings.NewReader("Match exec\n")); err == nil {
+		t.Error("Expected Match without argument to fail")
+	}
+}
+
+func TestNewConnectionConfigUsesSSHConfig(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "id_core")
+	if err := os.WriteFile(keyPath, []byte("key"), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	configPath := filepath.Join(dir, "config")
+	content := "Host 10.20.*\n  User admin\n  ProxyJump core\n  ConnectTimeout 7\n" +
+		"Host core\n  HostName 192.0.2.10\n  Port 2200\n  IdentityFile " + keyPath + "\n"
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write SSH config: %v", err)
+	}
+
+	previous := SSHConfigPath()
+	SetSSHConfigPath(configPath)
+	defer SetSSHConfigPath(previous)
+
+	config := NewConnectionConfig("10.20.1.1", 0, "", "password")
+	if config.Username != "admin" || config.Port != 22 || config.Timeout != 7*time.Second {
+		t.Errorf("Unexpected config from SSH config: user=%s port=%d timeout=%v", config.Username, config.Port, config.Timeout)
+	}
+	if len(config.Via) != 1 {
+		t.Fatalf("Expected one jump host, got %d", len(config.Via))
+	}
+	hop := config.Via[0]
+	if hop.Host != "core" || hop.HostName != "192.0.2.10" || hop.Port != 2200 || hop.KeyPath != keyPath {
+		t.Errorf("Unexpected jump host: %+v", hop)
+	}
+
+	// Explicit values win over the SSH config
+	explicit := NewConnectionConfig("10.20.1.1", 2022, "operator", "password")
+	if explicit.Username != "operator" || explicit.Port != 2022 {
+		t.Errorf("Expected explicit user and port to be kept, got %s:%d", explicit.Username, explicit.Port)
+	}
+}
+
+func TestKeepaliveReconnect(t *testing.T) {
+	server := newTestSSHServer(t)
+	config := NewConnectionConfig(server.Host, server.Port, "admin", "secret")
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	manager := NewSSHManager()
+	manager.SetKeepalive(KeepaliveConfig{
+		Interval:   20 * time.Millisecond,
+		MaxMissed:  2,
+		Reconnect:  true,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+	})
+
+	events := make(chan HealthEvent, 16)
+	manager.OnHealthChange(func(event HealthEvent) {
+		events <- event
+	})
+
+	var conn *SSHConnection
+	for result := range manager.ConnectMultiple([]ConnectionConfig{config}) {
+		if result.Error != nil {
+			t.Fatalf("Failed to connect: %v", result.Error)
+		}
+		conn = result.Connection
+	}
+	defer conn.Close()
+
+	server.DropConnections()
+
+	var states []HealthState
+	timeout := time.After(5 * time.Second)
+	for len(states) == 0 || states[len(states)-1] != HealthConnected {
+		select {
+		case event := <-events:
+			states = append(states, event.State)
+		case <-timeout:
+			t.Fatalf("Connection was not re-established, saw states %v", states)
+		}
+	}
+
+	if states[0] != HealthLost || states[1] != HealthReconnecting {
+		t.Errorf("Expected lost and reconnecting before connected, got %v", states)
+	}
+	if !conn.IsConnected() || conn.Health() != HealthConnected {
+		t.Errorf("Expected connection to be healthy again, got %s", conn.Health())
+	}
+	if got := atomic.LoadInt32(&server.Connections); got != 2 {
+		t.Errorf("Expected 2 handshakes, got %d", got)
+	}
+
+	// An explicit close stops monitoring for good
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	if conn.IsConnected() {
+		t.Error("Expected closed connection to stay closed")
+	}
+}
+
+func TestRunCommandResult(t *testing.T) {
+	server := newTestSSHServer(t)
+	config := NewConnectionConfig(server.Host, server.Port, "admin", "secret")
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	result := conn.RunCommandResult("echo hello")
+	if !result.Success() || result.Stdout != "hello\n" || result.StdoutBytes != 6 {
+		t.Errorf("Unexpected result for echo: %+v", result)
+	}
+	if result.Finished.Before(result.Started) {
+		t.Errorf("Expected finish time after start time")
+	}
+
+	result = conn.RunCommandResult("fail")
+	if result.Success() || result.Error != nil {
+		t.Fatalf("Expected a non-zero exit without transport error, got %+v", result)
+	}
+	if result.ExitCode != 3 || result.Stdout != "partial output\n" || result.Stderr != "something broke\n" {
+		t.Errorf("Expected partial output and exit 3, got %+v", result)
+	}
+
+	result = conn.RunCommandResult("kill")
+	if result.Signal != "KILL" || result.Success() {
+		t.Errorf("Expected command killed by signal, got %+v", result)
+	}
+
+	// RunCommand keeps the output of failed commands
+	output, err := conn.RunCommand("fail")
+	if err == nil || !strings.Contains(output, "partial output") {
+		t.Errorf("Expected partial output with an error, got %q, %v", output, err)
+	}
+}
+
+func TestRunCommandContext(t *testing.T) {
+	server := newTestSSHServer(t)
+	config := NewConnectionConfig(server.Host, server.Port, "admin", "secret")
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Cancelling stops a running command without waiting for it
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	started := time.Now()
+	result := conn.RunCommandResultContext(ctx, "sleep 10s")
+	if !result.Cancelled() || !errors.Is(result.Error, context.Canceled) {
+		t.Errorf("Expected a cancelled result, got %+v", result)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Cancellation took %v", elapsed)
+	}
+
+	// Commands on one connection do not wait for each other
+	var wg sync.WaitGroup
+	started = time.Now()
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := conn.RunCommandContext(context.Background(), "sleep 300ms"); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(started); elapsed > 800*time.Millisecond {
+		t.Errorf("Expected concurrent commands, took %v", elapsed)
+	}
+
+	// The per-command timeout of the configuration applies to every command
+	conn.Config.CommandTimeout = 100 * time.Millisecond
+	result = conn.RunCommandResult("sleep 10s")
+	if !errors.Is(result.Error, context.DeadlineExceeded) || !strings.Contains(result.Status(), "timed out") {
+		t.Errorf("Expected a timed out result, got %+v", result)
+	}
+	result = conn.RunCommandResult("echo quick")
+	if !result.Success() {
+		t.Errorf("Expected fast command to succeed, got %+v", result)
+	}
+}
+
+func TestParseBatchSize(t *testing.T) {
+	tests := []struct {
+		spec    string
+		total   int
+		want    int
+		wantErr bool
+	}{
+		{"", 40, 40, false},
+		{"10", 40, 10, false},
+		{"25%", 40, 10, false},
+		{"10%", 5, 1, false},
+		{"0", 40, 0, true},
+		{"150%", 40, 0, true},
+		{"ten", 40, 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseBatchSize(test.spec, test.total)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("ParseBatchSize(%q, %d) = %d, %v; want %d", test.spec, test.total, got, err, test.want)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	hosts := make([]string, 10)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("10.0.0.%d", i+1)
+	}
+
+	// Concurrency stays within the limit and batches run one after the other
+	var running, peak int32
+	var mutex sync.Mutex
+	var order []int
+	report, err := Execute(context.Background(), hosts, ExecutorConfig{MaxConcurrency: 2, BatchSize: "50%"}, func(ctx context.Context, index int) error {
+		now := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if now <= old || atomic.CompareAndSwapInt32(&peak, old, now) {
+				break
+			}
+		}
+		mutex.Lock()
+		order = append(order, index)
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	if err != nil || report.Aborted != nil || report.Failed != 0 || report.Skipped != 0 {
+		t.Fatalf("Unexpected report %+v, %v", report, err)
+	}
+	if peak > 2 {
+		t.Errorf("Expected at most 2 hosts at once, saw %d", peak)
+	}
+	for _, index := range order[:5] {
+		if index >= 5 {
+			t.Errorf("Host %d of the second batch ran before the first batch finished: %v", index, order)
+		}
+	}
+
+	// A failing canary skips every other host
+	calls := 0
+	report, err = Execute(context.Background(), hosts, ExecutorConfig{Canary: "10.0.0.3"}, func(ctx context.Context, index int) error {
+		calls++
+		return fmt.Errorf("broken")
+	})
+	if err != nil || report.Aborted == nil || calls != 1 || report.Skipped != 9 || report.Errors[2] == nil {
+		t.Errorf("Expected only the canary to run, got %d calls and %+v", calls, report)
+	}
+	if !errors.Is(report.Errors[0], ErrSkipped) {
+		t.Errorf("Expected skipped hosts to report ErrSkipped, got %v", report.Errors[0])
+	}
+
+	// The abort threshold stops new hosts from starting
+	report, err = Execute(context.Background(), hosts, ExecutorConfig{MaxConcurrency: 1, AbortAfter: 3}, func(ctx context.Context, index int) error {
+		if index%2 == 0 {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+	if err != nil || report.Failed != 3 || report.Skipped != 5 || report.Aborted == nil {
+		t.Errorf("Expected abort after 3 failures, got %+v", report)
+	}
+
+	// Every host gets its own timeout, a slow host does not use up the time of the others
+	report, err = Execute(context.Background(), hosts[:3], ExecutorConfig{MaxConcurrency: 1, HostTimeout: 50 * time.Millisecond}, func(ctx context.Context, index int) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(30 * time.Millisecond):
+			return nil
+		}
+	})
+	if err != nil || report.Failed != 0 || report.Aborted != nil {
+		t.Errorf("Expected every host to finish within its own timeout, got %+v", report)
+	}
+	report, _ = Execute(context.Background(), hosts[:2], ExecutorConfig{HostTimeout: 20 * time.Millisecond}, func(ctx context.Context, index int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if report.Failed != 2 || !errors.Is(report.Errors[0], context.DeadlineExceeded) {
+		t.Errorf("Expected hosts to time out, got %+v", report)
+	}
+
+	if _, err := Execute(context.Background(), hosts, ExecutorConfig{Canary: "missing"}, nil); err == nil {
+		t.Errorf("Expected an error for an unknown canary host")
+	}
+}
+
+func TestRunCommandStream(t *testing.T) {
+	// Lines split across writes are joined, a final line without newline is flushed
+	var lines []OutputLine
+	writer := &lineWriter{emit: func(line OutputLine) { lines = append(lines, line) }}
+	writer.Write([]byte("first\r\nsec"))
+	writer.Write([]byte("ond\nthird"))
+	writer.flush()
+	if len(lines) != 3 || lines[0].Text != "first" || lines[1].Text != "second" || lines[2].Text != "third" {
+		t.Errorf("Unexpected lines %+v", lines)
+	}
+
+	server := newTestSSHServer(t)
+	config := NewConnectionConfig(server.Host, server.Port, "admin", "secret")
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	var mutex sync.Mutex
+	var stdout, stderr []string
+	result := conn.RunCommandStream(context.Background(), "fail", func(line OutputLine) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if line.Stderr {
+			stderr = append(stderr, line.Text)
+		} else {
+			stdout = append(stdout, line.Text)
+		}
+	})
+	if result.ExitCode != 3 || result.Stdout != "partial output\n" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if len(stdout) != 1 || stdout[0] != "partial output" || len(stderr) != 1 || stderr[0] != "something broke" {
+		t.Errorf("Unexpected streamed lines: stdout %q, stderr %q", stdout, stderr)
+	}
+
+	// RunCommandMultiple reports every host through the callbacks
+	var started, outputs, finished int32
+	results, err := RunCommandMultiple(context.Background(), []*SSHConnection{conn, conn}, "echo hi", ExecutorConfig{MaxConcurrency: 1}, CommandCallbacks{
+		OnStart:  func(index int) { atomic.AddInt32(&started, 1) },
+		OnOutput: func(index int, line OutputLine) { atomic.AddInt32(&outputs, 1) },
+		OnResult: func(index int, result *CommandResult) { atomic.AddInt32(&finished, 1) },
+	})
+	if err != nil || len(results) != 2 || !results[0].Success() || !results[1].Success() {
+		t.Fatalf("Unexpected results %+v, %v", results, err)
+	}
+	if started != 2 || outputs != 2 || finished != 2 {
+		t.Errorf("Expected 2 starts, outputs and results, got %d, %d, %d", started, outputs, finished)
+	}
+}
+
+func TestFormatHostList(t *testing.T) {
+	tests := []struct {
+		hosts []string
+		want  string
+	}{
+		{[]string{"10.0.0.3", "10.0.0.1", "10.0.0.2", "10.0.0.7"}, "10.0.0.[1-3,7]"},
+		{[]string{"router-01.lab", "router-02.lab", "router-10.lab"}, "router-[01-02,10].lab"},
+		{[]string{"10.0.0.1", "10.0.1.1"}, "10.0.0.1,10.0.1.1"},
+		{[]string{"core", "10.0.0.5"}, "10.0.0.5,core"},
+	}
+	for _, test := range tests {
+		if got := FormatHostList(test.hosts); got != test.want {
+			t.Errorf("FormatHostList(%v) = %q, want %q", test.hosts, got, test.want)
+		}
+	}
+}
+
+func TestGroupOutputs(t *testing.T) {
+	results := []*CommandResult{
+		{Host: "10.0.0.1", Stdout: "version: 7.15\nuptime: 1w2d3h\n"},
+		{Host: "10.0.0.2", Stdout: "version: 7.15\nuptime: 5d4h3m2s\n"},
+		{Host: "10.0.0.3", Stdout: "version: 6.49\nuptime: 1d\n"},
+		{Host: "10.0.0.4", Stdout: "version: 7.15\nuptime: 1w2d3h\n", ExitCode: 1},
+	}
+
+	groups := GroupOutputs(results, nil)
+	if len(groups) != 4 {
+		t.Errorf("Expected 4 exact groups, got %d", len(groups))
+	}
+
+	normalize, err := NewMaskNormalizer(VolatileMasks...)
+	if err != nil {
+		t.Fatalf("Failed to compile masks: %v", err)
+	}
+	groups = GroupOutputs(results, normalize)
+	if len(groups) != 3 {
+		t.Fatalf("Expected 3 groups with masked uptime, got %+v", groups)
+	}
+	if FormatHostList(groups[0].Hosts) != "10.0.0.[1-2]" || groups[0].Output != results[0].Stdout {
+		t.Errorf("Unexpected largest group %+v", groups[0])
+	}
+
+	report := FormatOutputGroups(groups)
+	if !strings.Contains(report, "hosts 10.0.0.[1-2] (2) — exit 0\n") || !strings.Contains(report, "version: 6.49") {
+		t.Errorf("Unexpected report:\n%s", report)
+	}
+
+	// Hosts failing the same way group, the address in the error aside
+	failed := []*CommandResult{
+		{Host: "10.0.0.5", Error: fmt.Errorf("dial tcp 10.0.0.5:22: connect: connection refused")},
+		{Host: "10.0.0.6", Error: fmt.Errorf("dial tcp 10.0.0.6:22: connect: connection refused")},
+	}
+	if groups := GroupOutputs(failed, normalize); len(groups) != 1 || len(groups[0].Hosts) != 2 {
+		t.Errorf("Expected hosts with the same error to group, got %+v", groups)
+	}
+
+	// Clock times are masked, but not parts of addresses
+	for output, masked := range map[string]string{
+		"time: 14:05:33\n":                        "time: <masked>\n",
+		"14:05 14:06 (14:07)":                     "<masked> <masked> (<masked>)",
+		"mac-address: 00:11:22:33:44:55":          "mac-address: 00:11:22:33:44:55",
+		"address: fe80::12:34 2001:db8::1:2:3":    "address: fe80::12:34 2001:db8::1:2:3",
+		"neighbor: 10.0.0.5:22 aa:bb:cc:dd:12:34": "neighbor: 10.0.0.5:22 aa:bb:cc:dd:12:34",
+	} {
+		if got := normalize(output); got != masked {
+			t.Errorf("Expected %q masked as %q, got %q", output, masked, got)
+		}
+	}
+
+	if MaskNumbers("cpu-load: 12%") != "cpu-load: #%" {
+		t.Errorf("Expected numbers to be masked")
+	}
+	if _, err := NewMaskNormalizer("("); err == nil {
+		t.Errorf("Expected an invalid mask to fail")
+	}
+}
+
+func TestSFTPClient(t *testing.T) {
+	server := newTestSSHServer(t)
+	config := NewConnectionConfig(server.Host, server.Port, "admin", "secret")
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	client, err := conn.OpenSFTP()
+	if err != nil {
+		t.Fatalf("Failed to open SFTP: %v", err)
+	}
+	defer client.Close()
+
+	// The test server shares the local file system
+	localDir := t.TempDir()
+	remoteDir := filepath.ToSlash(t.TempDir())
+	content := []byte(strings.Repeat("backup data\n", 10000))
+	source := filepath.Join(localDir, "backup.rsc")
+	if err := os.WriteFile(source, content, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if err := client.Mkdir(remoteDir + "/configs"); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+
+	var last, total int64
+	remotePath := remoteDir + "/configs/backup.rsc"
+	err = client.Put(context.Background(), source, remotePath, TransferOptions{Progress: func(done, size int64) {
+		last, total = done, size
+	}})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if last != int64(len(content)) || total != int64(len(content)) {
+		t.Errorf("Expected progress to reach %d bytes, got %d of %d", len(content), last, total)
+	}
+
+	files, err := client.List(remoteDir)
+	if err != nil || len(files) != 1 || !files[0].IsDir || files[0].Name != "configs" {
+		t.Fatalf("Unexpected listing %+v, %v", files, err)
+	}
+
+	// A partial download is resumed rather than started over
+	target := filepath.Join(localDir, "download.rsc")
+	if err := os.WriteFile(target, content[:5000], 0644); err != nil {
+		t.Fatalf("Failed to write partial file: %v", err)
+	}
+	var first int64 = -1
+	err = client.Get(context.Background(), remotePath, target, TransferOptions{Resume: true, Progress: func(done, size int64) {
+		if first == -1 {
+			first = done
+		}
+	}})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if first != 5000 {
+		t.Errorf("Expected the download to resume at 5000 bytes, started at %d", first)
+	}
+	if downloaded, _ := os.ReadFile(target); string(downloaded) != string(content) {
+		t.Errorf("Downloaded file differs from the original (%d bytes)", len(downloaded))
+	}
+
+	// A cancelled transfer stops with the context error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.Get(ctx, remotePath, target, TransferOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled download, got %v", err)
+	}
+
+	renamed := remoteDir + "/configs/old.rsc"
+	if err := client.Rename(remotePath, renamed); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if err := client.Remove(renamed); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := client.Stat(renamed); err == nil {
+		t.Errorf("Expected the removed file to be gone")
+	}
+}
+
+func TestPushAndPullFile(t *testing.T) {
+	server := newTestSSHServer(t)
+	config := NewConnectionConfig(server.Host, server.Port, "admin", "secret")
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	source := filepath.Join(t.TempDir(), "firmware.npk")
+	if err := os.WriteFile(source, []byte(strings.Repeat("x", 100000)), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	remotePath := filepath.ToSlash(filepath.Join(t.TempDir(), "firmware.npk"))
+
+	var progressed int32
+	results, err := PushFile(context.Background(), []*SSHConnection{conn}, source, remotePath, ExecutorConfig{}, TransferCallbacks{
+		OnProgress: func(index int, done, total int64) { atomic.StoreInt32(&progressed, 1) },
+	})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Unexpected push results %+v, %v", results, err)
+	}
+	if results[0].Error != nil || !results[0].Verified || results[0].Bytes != 100000 {
+		t.Errorf("Expected a verified upload, got %+v", results[0])
+	}
+	if atomic.LoadInt32(&progressed) == 0 {
+		t.Errorf("Expected progress callbacks")
+	}
+
+	localDir := t.TempDir()
+	results, err = PullFile(context.Background(), []*SSHConnection{conn}, remotePath, localDir, ExecutorConfig{}, TransferCallbacks{})
+	if err != nil || len(results) != 1 || results[0].Error != nil {
+		t.Fatalf("Unexpected pull results %+v, %v", results, err)
+	}
+	expected := filepath.Join(localDir, "127.0.0.1", "firmware.npk")
+	if results[0].LocalPath != expected || results[0].Checksum == "" {
+		t.Errorf("Expected the file in a host folder, got %+v", results[0])
+	}
+	if info, err := os.Stat(expected); err != nil || info.Size() != 100000 {
+		t.Errorf("Pulled file is missing or truncated: %v", err)
+	}
+
+	// Failures are reported per host
+	results, _ = PullFile(context.Background(), []*SSHConnection{conn}, "/does/not/exist", localDir, ExecutorConfig{}, TransferCallbacks{})
+	if results[0].Error == nil {
+		t.Errorf("Expected an error for a missing remote file")
+	}
+}
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		kind     ForwardKind
+		spec     string
+		bind     string
+		target   string
+		hasError bool
+	}{
+		{ForwardLocal, "8080:10.0.0.1:80", "127.0.0.1:8080", "10.0.0.1:80", false},
+		{ForwardLocal, "0.0.0.0:8080:intranet:80", "0.0.0.0:8080", "intranet:80", false},
+		{ForwardRemote, "[::1]:2222:[fd00::1]:22", "[::1]:2222", "[fd00::1]:22", false},
+		{ForwardDynamic, "1080", "127.0.0.1:1080", "", false},
+		{ForwardDynamic, ":1080", "127.0.0.1:1080", "", false},
+		{ForwardLocal, "8080:10.0.0.1", "", "", true},
+		{ForwardLocal, "8080:10.0.0.1:0", "", "", true},
+		{ForwardDynamic, "70000", "", "", true},
+	}
+
+	for _, test := range tests {
+		spec, err := ParseForward(test.kind, test.spec)
+		if test.hasError {
+			if err == nil {
+				t.Errorf("ParseForward(%s, %q) expected an error", test.kind, test.spec)
+			}
+			continue
+		}
+		if err != nil || spec.BindAddress != test.bind || spec.TargetAddress != test.target {
+			t.Errorf("ParseForward(%s, %q) = %+v, %v", test.kind, test.spec, spec, err)
+		}
+	}
+}
+
+// newTestEchoServer starts a TCP server echoing lines back
+func newTestEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// testEcho sends a line through a connection and expects it back
+func testEcho(t *testing.T, conn net.Conn, line string) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := fmt.Fprintln(conn, line); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	reply := make([]byte, len(line)+1)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != line+"\n" {
+		t.Fatalf("Expected %q back, got %q, %v", line, reply, err)
+	}
+}
+
+func TestForwards(t *testing.T) {
+	server := newTestSSHServer(t)
+	echo := newTestEchoServer(t)
+	config := NewConnectionConfig(server.Host, server.Port, "admin", "secret")
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	registry := NewForwardRegistry()
+	defer registry.StopAll()
+
+	_, echoPort, _ := net.SplitHostPort(echo)
+	for _, kind := range []ForwardKind{ForwardLocal, ForwardRemote} {
+		spec, err := ParseForward(kind, "0:127.0.0.1:"+echoPort)
+		if err != nil {
+			t.Fatalf("ParseForward failed: %v", err)
+		}
+		forward, err := registry.Start(conn, spec)
+		if err != nil {
+			t.Fatalf("Failed to start %s forward: %v", kind, err)
+		}
+
+		client, err := net.Dial("tcp", forward.Addr())
+		if err != nil {
+			t.Fatalf("Failed to dial %s forward: %v", kind, err)
+		}
+		testEcho(t, client, "hello via "+string(kind))
+		client.Close()
+
+		sent, received, _ := forward.Stats()
+		if sent == 0 || received == 0 {
+			t.Errorf("Expected %s forward to count bytes, got %d sent and %d received", kind, sent, received)
+		}
+	}
+
+	// SOCKS5 CONNECT to the echo server by IPv4 address
+	spec, _ := ParseForward(ForwardDynamic, "0")
+	forward, err := registry.Start(conn, spec)
+	if err != nil {
+		t.Fatalf("Failed to start dynamic forward: %v", err)
+	}
+	client, err := net.Dial("tcp", forward.Addr())
+	if err != nil {
+		t.Fatalf("Failed to dial SOCKS proxy: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	echoTCPAddr, _ := net.ResolveTCPAddr("tcp", echo)
+	request := []byte{5, 1, 0, 5, 1, 0, 1}
+	request = append(request, echoTCPAddr.IP.To4()...)
+	request = append(request, byte(echoTCPAddr.Port>>8), byte(echoTCPAddr.Port))
+	client.Write(request)
+	// Method selection followed by the CONNECT reply
+	reply := make([]byte, 12)
+	if _, err := io.ReadFull(client, reply); err != nil || reply[1] != 0 || reply[3] != 0 {
+		t.Fatalf("Unexpected SOCKS reply %v, %v", reply, err)
+	}
+	testEcho(t, client, "hello via socks")
+
+	if len(registry.List()) != 3 {
+		t.Errorf("Expected 3 forwards, got %d", len(registry.List()))
+	}
+	registry.StopHost(server.Host)
+	if len(registry.List()) != 0 {
+		t.Errorf("Expected no forwards after StopHost, got %d", len(registry.List()))
+	}
+	if _, err := net.Dial("tcp", forward.Addr()); err == nil {
+		t.Errorf("Expected the stopped forward to refuse connections")
+	}
+}
+
+// newTestTelnetServer starts a telnet server asking for the window size, with the
+// login admin/secret, a RouterOS style prompt and the "echo <text>" command.
+// Window sizes sent by clients are reported as columns x rows on the returned channel.
+func newTestTelnetServer(t *testing.T) (int, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	sizes := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestTelnet(conn, sizes)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, sizes
+}
+
+func serveTestTelnet(conn net.Conn, sizes chan<- string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// readLine reads a line ended by CR LF, CR NUL or LF, ignoring option replies
+	lastCR := false
+	readLine := func() (string, error) {
+		var line []byte
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			switch {
+			case b == telnetIAC:
+				command, _ := reader.ReadByte()
+				if command == telnetSB {
+					sub, _ := reader.ReadBytes(telnetSE)
+					if len(sub) == 7 && sub[0] == telnetOptNAWS {
+						sizes <- fmt.Sprintf("%dx%d", int(sub[1])<<8|int(sub[2]), int(sub[3])<<8|int(sub[4]))
+					}
+				} else if command >= telnetWILL {
+					reader.ReadByte()
+				}
+			case b == '\r' || (b == '\n' && !lastCR):
+				lastCR = b == '\r'
+				return string(line), nil
+			case b == '\n' || b == 0:
+				lastCR = false
+			default:
+				line = append(line, b)
+			}
+		}
+	}
+
+	conn.Write([]byte{telnetIAC, telnetDO, telnetOptNAWS, telnetIAC, telnetWILL, telnetOptEcho})
+	for {
+		fmt.Fprint(conn, "\r\nLogin: ")
+		username, err := readLine()
+		if err != nil {
+			return
+		}
+		fmt.Fprint(conn, username+"\r\nPassword: ")
+		password, err := readLine()
+		if err != nil {
+			return
+		}
+		if username == "admin" && password == "secret" {
+			break
+		}
+		fmt.Fprint(conn, "\r\nLogin failed, incorrect username or password\r\n")
+	}
+
+	// enable takes the secret "enable-secret"
+	prompt := "[admin@test] > "
+	fmt.Fprint(conn, "\r\n\r\n  MikroTik RouterOS 6.49 (c) 1999-2021\r\n\r\n"+prompt)
+	for {
+		line, err := readLine()
+		if err != nil {
+			return
+		}
+		fmt.Fprint(conn, line+"\r\n")
+		command, argument, _ := strings.Cut(line, " ")
+		switch command {
+		case "echo":
+			fmt.Fprint(conn, argument+"\r\n")
+		case "quit":
+			fmt.Fprint(conn, "interrupted\r\n")
+			return
+		case "enable":
+			fmt.Fprint(conn, "Password: ")
+			secret, err := readLine()
+			if err != nil {
+				return
+			}
+			if secret == "enable-secret" {
+				prompt = "[admin@test] # "
+			} else {
+				fmt.Fprint(conn, "\r\n% Access denied\r\n")
+			}
+		}
+		fmt.Fprint(conn, "\r\n"+prompt)
+	}
+}
+
+func TestTelnet(t *testing.T) {
+	port, sizes := newTestTelnetServer(t)
+
+	config := NewConnectionConfig("127.0.0.1", port, "admin", "wrong")
+	config.Protocol = ProtocolTelnet
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err == nil || !strings.Contains(err.Error(), "telnet login failed") {
+		t.Fatalf("Expected a login failure, got %v", err)
+	}
+
+	conn.Config.Password = "secret"
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Commands run like over SSH, without the echo and the prompt
+	output, err := conn.RunCommand("echo hello world")
+	if err != nil || output != "hello world\n\n" {
+		t.Errorf("Expected the command output, got %q, %v", output, err)
+	}
+	// Scripts run one line at a time, each without its echo and prompt
+	output, err = conn.RunCommand("echo one\necho two\n\necho three")
+	if err != nil || output != "one\n\ntwo\n\nthree\n\n" {
+		t.Errorf("Expected the output of every line, got %q, %v", output, err)
+	}
+	results, err := RunCommandMultiple(context.Background(), []*SSHConnection{conn}, "echo again", ExecutorConfig{}, CommandCallbacks{})
+	if err != nil || !results[0].Success() || !strings.Contains(results[0].Stdout, "again") {
+		t.Errorf("Expected the script runner to work over telnet, got %+v, %v", results[0], err)
+	}
+
+	// Interactive shells negotiate the window size
+	for len(sizes) > 0 {
+		<-sizes
+	}
+	session, err := conn.OpenInteractiveSession()
+	if err != nil {
+		t.Fatalf("Failed to open a session: %v", err)
+	}
+	session.RequestPty("xterm-256color", 30, 100, nil)
+	stdin, _ := session.StdinPipe()
+	stdout, _ := session.StdoutPipe()
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Failed to start the shell: %v", err)
+	}
+	readUntil := func(text string) {
+		t.Helper()
+		var received []byte
+		buf := make([]byte, 1024)
+		for !strings.Contains(string(received), text) {
+			n, err := stdout.Read(buf)
+			if err != nil {
+				t.Fatalf("Expected %q, got %q, %v", text, received, err)
+			}
+			received = append(received, buf[:n]...)
+		}
+	}
+	readUntil("[admin@test] > ")
+	if size := <-sizes; size != "100x30" {
+		t.Errorf("Expected the window size 100x30, got %s", size)
+	}
+	session.WindowChange(40, 120)
+	stdin.Write([]byte("echo typed\r"))
+	readUntil("typed\r\n")
+	if size := <-sizes; size != "120x40" {
+		t.Errorf("Expected the window size 120x40, got %s", size)
+	}
+
+	session.Close()
+	if err := session.Wait(); err != nil {
+		t.Errorf("Expected Wait to return after Close, got %v", err)
+	}
+}
+
+// MAC addresses of the MAC-Telnet test server and of the client interface
+var (
+	testMACTelnetDevice = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+	testMACTelnetClient = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+)
+
+// newTestMACTelnetServer starts a MAC-Telnet server on loopback and routes the
+// client to it. legacy selects the MD5 login of RouterOS before 6.43.
+func newTestMACTelnetServer(t *testing.T, legacy bool) <-chan string {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	routes := macTelnetRoutes
+	macTelnetRoutes = func() ([]macTelnetRoute, error) {
+		return []macTelnetRoute{{addr: conn.LocalAddr().(*net.UDPAddr), mac: testMACTelnetClient}}, nil
+	}
+	t.Cleanup(func() { macTelnetRoutes = routes })
+
+	sizes := make(chan string, 10)
+	go serveTestMACTelnet(conn, legacy, sizes)
+	return sizes
+}
+
+// testMACTelnetSession is the server side of a MAC-Telnet session
+type testMACTelnetSession struct {
+	conn       *net.UDPConn
+	addr       *net.UDPAddr
+	client     net.HardwareAddr
+	key        uint16
+	outCounter uint32
+	inCounter  uint32
+	username   string
+	clientKey  []byte
+	serverKey  []byte
+	secret     *big.Int
+	salt       []byte
+	proof      []byte
+	width      int
+	loggedIn   bool
+	line       []byte
+}
+
+func (s *testMACTelnetSession) send(ptype byte, counter uint32, payload []byte) {
+	packet := make([]byte, macTelnetHeaderSize)
+	packet[0] = 1
+	packet[1] = ptype
+	copy(packet[2:8], testMACTelnetDevice)
+	copy(packet[8:14], s.client)
+	copy(packet[14:16], macTelnetClientType)
+	binary.BigEndian.PutUint16(packet[16:18], s.key)
+	binary.BigEndian.PutUint32(packet[18:22], counter)
+	s.conn.WriteToUDP(append(packet, payload...), s.addr)
+}
+
+// sendData sends every data packet twice, the client has to drop the copy
+func (s *testMACTelnetSession) sendData(payload []byte) {
+	s.send(macTelnetData, s.outCounter, payload)
+	s.send(macTelnetData, s.outCounter, payload)
+	s.outCounter += uint32(len(payload))
+}
+
+func (s *testMACTelnetSession) expectedProof(legacy bool) []byte {
+	if legacy {
+		sum := md5.Sum(append([]byte("\x00secret"), s.salt...))
+		return append([]byte{0}, sum[:]...)
+	}
+	clientKey := ecsrp.liftX(new(big.Int).SetBytes(s.clientKey[:32]), uint(s.clientKey[32]))
+	j := sha256.Sum256(append(append([]byte{}, s.clientKey[:32]...), s.serverKey...))
+	validator := ecsrp.validatorPoint("admin", "secret", s.salt)
+	z := ecsrp.mul(s.secret, ecsrp.add(ecsrp.mul(new(big.Int).SetBytes(j[:]), validator.point), clientKey))
+	zx, _ := ecsrp.toMontgomery(z)
+	proof := sha256.Sum256(append(j[:], zx...))
+	return proof[:]
+}
+
+func serveTestMACTelnet(conn *net.UDPConn, legacy bool, sizes chan<- string) {
+	sessions := make(map[uint16]*testMACTelnetSession)
+	dropped := false
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		data := buf[:n]
+		if n < macTelnetHeaderSize || !bytes.Equal(data[8:14], testMACTelnetDevice) {
+			continue
+		}
+		key := binary.BigEndian.Uint16(data[14:16])
+		counter := binary.BigEndian.Uint32(data[18:22])
+		payload := append([]byte{}, data[macTelnetHeaderSize:]...)
+
+		s := sessions[key]
+		switch data[1] {
+		case macTelnetSessionStart:
+			// Lose the first packet so the client has to send it again
+			if !dropped {
+				dropped = true
+				continue
+			}
+			s = &testMACTelnetSession{conn: conn, addr: addr, key: key, client: append(net.HardwareAddr{}, data[2:8]...)}
+			sessions[key] = s
+			s.send(macTelnetAck, 0, nil)
+			continue
+		case macTelnetEnd:
+			delete(sessions, key)
+			continue
+		case macTelnetData:
+		default:
+			continue
+		}
+		if s == nil {
+			continue
+		}
+		s.send(macTelnetAck, counter+uint32(len(payload)), nil)
+		if counter != s.inCounter {
+			continue
+		}
+		s.inCounter += uint32(len(payload))
+
+		for _, control := range parseMACTelnetControls(payload) {
+			switch control.kind {
+			case macTelnetPassSalt:
+				username, clientKey, _ := bytes.Cut(control.data, []byte{0})
+				s.username, s.clientKey = string(username), clientKey
+				s.salt = make([]byte, 16)
+				rand.Read(s.salt)
+				if legacy {
+					s.sendData(appendMACTelnetControl(nil, macTelnetPassSalt, s.salt))
+					continue
+				}
+				validator := ecsrp.validatorPoint("admin", "secret", s.salt)
+				gammaX, _ := ecsrp.toMontgomery(validator.point)
+				s.secret = big.NewInt(0).SetBytes(s.salt)
+				serverKey, parity := ecsrp.toMontgomery(ecsrp.add(ecsrp.mul(s.secret, ecsrp.g), ecsrp.redp1(gammaX, 0)))
+				s.serverKey = serverKey
+				s.sendData(appendMACTelnetControl(nil, macTelnetPassSalt, append(append(serverKey, byte(parity)), s.salt...)))
+			case macTelnetPassword:
+				s.proof = control.data
+			case macTelnetTermWidth:
+				s.width = int(binary.LittleEndian.Uint16(control.data))
+			case macTelnetTermHeight:
+				sizes <- fmt.Sprintf("%dx%d", s.width, binary.LittleEndian.Uint16(control.data))
+			case macTelnetPlain:
+				for _, b := range control.data {
+					switch b {
+					case '\r':
+						line := string(s.line)
+						s.line = nil
+						command, argument, _ := strings.Cut(line, " ")
+						output := line + "\r\n"
+						if command == "echo" {
+							output += argument + "\r\n"
+						}
+						s.sendData([]byte(output + "\r\n[admin@test] > "))
+					case '\n', 0:
+					default:
+						s.line = append(s.line, b)
+					}
+				}
+			}
+		}
+
+		if s.proof != nil && !s.loggedIn {
+			if s.username == "admin" && bytes.Equal(s.proof, s.expectedProof(legacy)) {
+				s.loggedIn = true
+				s.sendData(appendMACTelnetControl(nil, macTelnetEndAuth, nil))
+				s.sendData([]byte("\r\n\r\n  MikroTik RouterOS 7.16 (c) 1999-2024\r\n\r\n[admin@test] > "))
+			} else {
+				s.sendData([]byte("Login failed, incorrect username or password\r\n"))
+				s.send(macTelnetEnd, 0, nil)
+				delete(sessions, key)
+			}
+			s.proof = nil
+		}
+	}
+}
+
+func TestECSRPKnownAnswer(t *testing.T) {
+	// The curve agrees with X25519, which takes the scalar and u little-endian
+	scalar := sha256.Sum256([]byte("x25519"))
+	scalar[0] &= 248
+	scalar[31] = scalar[31]&127 | 64
+	private, err := ecdh.X25519().NewPrivateKey(scalar[:])
+	if err != nil {
+		t.Fatalf("Failed to create X25519 key: %v", err)
+	}
+	public := private.PublicKey().Bytes()
+	slices.Reverse(scalar[:])
+	slices.Reverse(public)
+	if x, _ := ecsrp.toMontgomery(ecsrp.mul(new(big.Int).SetBytes(scalar[:]), ecsrp.g)); !bytes.Equal(x, public) {
+		t.Errorf("Expected the public key of X25519 %x, got %x", public, x)
+	}
+
+	// Fixed keys and salt, the expected values were computed with an independent
+	// implementation of the login of MAC-Telnet (mtwei.c)
+	secret := sha256.Sum256([]byte("client"))
+	client := &ecsrpClient{secret: new(big.Int).SetBytes(secret[:])}
+	x, parity := ecsrp.toMontgomery(ecsrp.mul(client.secret, ecsrp.g))
+	client.public = append(x, byte(parity))
+	if hex.EncodeToString(client.public) != "03d35342ffdba4e796e0137ab676d564201cb468f0556911172bacbb5cf916d100" {
+		t.Errorf("Unexpected client key %x", client.public)
+	}
+	server, _ := hex.DecodeString("5586f152007d5d13f00dbef7bfecf17cc7563e2c9a8101880192f3f7105d842b00000102030405060708090a0b0c0d0e0f")
+	proof, err := client.response("admin", "secret", server)
+	if err != nil || hex.EncodeToString(proof) != "5f9b999f744b457bbba5de22e176a4bb7f14d6e8562d61ea4a47a2c8d8ebaed2" {
+		t.Errorf("Unexpected proof %x, %v", proof, err)
+	}
+}
+
+func TestMACTelnet(t *testing.T) {
+	if ecsrp.mul(ecsrp.r, ecsrp.g) != nil {
+		t.Fatal("Expected the EC-SRP base point to have the order of the curve")
+	}
+
+	for _, legacy := range []bool{false, true} {
+		name := "EC-SRP"
+		if legacy {
+			name = "MD5"
+		}
+		t.Run(name, func(t *testing.T) {
+			sizes := newTestMACTelnetServer(t, legacy)
+
+			config := NewConnectionConfig(testMACTelnetDevice.String(), 0, "admin", "wrong")
+			config.Protocol = ProtocolMACTelnet
+			config.Timeout = 10 * time.Second
+			conn := NewSSHConnection(config)
+			if err := conn.Connect(); err == nil || !strings.Contains(err.Error(), "incorrect username or password") {
+				t.Fatalf("Expected a login failure, got %v", err)
+			}
+
+			conn.Config.Password = "secret"
+			if err := conn.Connect(); err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer conn.Close()
+
+			// Duplicated packets from the device show up once
+			output, err := conn.RunCommand("echo hello world")
+			if err != nil || output != "hello world\n\n" {
+				t.Errorf("Expected the command output, got %q, %v", output, err)
+			}
+
+			for len(sizes) > 0 {
+				<-sizes
+			}
+			session, err := conn.OpenInteractiveSession()
+			if err != nil {
+				t.Fatalf("Failed to open a session: %v", err)
+			}
+			session.RequestPty("xterm", 30, 100, nil)
+			stdin, _ := session.StdinPipe()
+			stdout, _ := session.StdoutPipe()
+			if err := session.Shell(); err != nil {
+				t.Fatalf("Failed to start the shell: %v", err)
+			}
+			readUntil := func(text string) {
+				t.Helper()
+				var received []byte
+				buf := make([]byte, 1024)
+				for !strings.Contains(string(received), text) {
+					n, err := stdout.Read(buf)
+					if err != nil {
+						t.Fatalf("Expected %q, got %q, %v", text, received, err)
+					}
+					received = append(received, buf[:n]...)
+				}
+			}
+			readUntil("[admin@test] > ")
+			if size := <-sizes; size != "100x30" {
+				t.Errorf("Expected the window size 100x30, got %s", size)
+			}
+			session.WindowChange(40, 120)
+			if size := <-sizes; size != "120x40" {
+				t.Errorf("Expected the window size 120x40, got %s", size)
+			}
+			stdin.Write([]byte("echo typed\r"))
+			readUntil("typed\r\n")
+
+			session.Close()
+			if err := session.Wait(); err != nil {
+				t.Errorf("Expected Wait to return after Close, got %v", err)
+			}
+		})
+	}
+}
+
+// newTestAPIServer starts a RouterOS API server with a few commands, accepting
+// admin/secret with the plain login and legacy/secret with the MD5 challenge.
+// With tlsConfig it serves api-ssl.
+func newTestAPIServer(t *testing.T, tlsConfig *tls.Config) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestAPI(conn)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func serveTestAPI(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	var writeMutex sync.Mutex
+	reply := func(words ...string) {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		routeros.WriteSentence(conn, words)
+	}
+	challenge := []byte("0123456789abcdef")
+
+	for {
+		words, err := routeros.ReadSentence(reader)
+		if err != nil {
+			return
+		}
+		command := routeros.ParseSentence(words)
+		tag := ".tag=" + command.Tag
+		trap := func(message string) {
+			reply("!trap", "=message="+message, tag)
+			reply("!done", tag)
+		}
+
+		switch command.Word {
+		case "/login":
+			sum := md5.Sum(append([]byte("\x00secret"), challenge...))
+			switch {
+			case command.Map["name"] == "admin" && command.Map["password"] == "secret":
+				reply("!done", tag)
+			case command.Map["name"] == "legacy" && command.Map["response"] == "00"+hex.EncodeToString(sum[:]):
+				reply("!done", tag)
+			case command.Map["name"] == "legacy" && command.Map["response"] == "":
+				reply("!done", "=ret="+hex.EncodeToString(challenge), tag)
+			default:
+				trap("invalid user name or password (6)")
+			}
+		case "/ip/address/print":
+			reply("!re", "=.id=*1", "=address=192.168.88.1/24", "=interface=bridge", tag)
+			reply("!re", "=.id=*2", "=address=10.0.0.1/24", "=interface=ether1", "=comment=uplink port", tag)
+			reply("!done", tag)
+		case "/ip/address/add":
+			if command.Map["address"] == "bad" {
+				trap("invalid value for argument address")
+				continue
+			}
+			reply("!done", "=ret=*3", tag)
+		case "/slow":
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				reply("!re", "=order=slow", tag)
+				reply("!done", tag)
+			}()
+		case "/cancel":
+			reply("!done", tag)
+		default:
+			trap("no such command")
+		}
+	}
+}
+
+func TestRouterOSAPI(t *testing.T) {
+	port := newTestAPIServer(t, nil)
+
+	config := NewConnectionConfig("127.0.0.1", port, "admin", "wrong")
+	config.Protocol = ProtocolAPI
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err == nil || !strings.Contains(err.Error(), "invalid user name or password") {
+		t.Fatalf("Expected a login failure, got %v", err)
+	}
+
+	conn.Config.Password = "secret"
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Console syntax is translated, replies come back as records and as text
+	result := conn.RunCommandResult("/ip address print\n\n/ip address add address=10.0.0.5/24 interface=ether1")
+	if !result.Success() {
+		t.Fatalf("Expected the script to succeed, got %s: %q", result.Status(), result.Stderr)
+	}
+	if len(result.Records) != 2 || result.Records[1].Map["comment"] != "uplink port" || result.Records[0].List[0] != (routeros.Pair{Key: ".id", Value: "*1"}) {
+		t.Errorf("Expected two address records, got %v", result.Records)
+	}
+	expected := ".id=*1 address=192.168.88.1/24 interface=bridge\n" +
+		".id=*2 address=10.0.0.1/24 interface=ether1 comment=\"uplink port\"\n" +
+		"ret=*3\n"
+	if result.Stdout != expected {
+		t.Errorf("Expected the replies as text, got %q", result.Stdout)
+	}
+
+	// A !trap fails the command without being a connection error
+	result = conn.RunCommandResult("/ip address add address=bad")
+	if result.Error != nil || result.ExitCode != 1 || result.Stderr != "failure: invalid value for argument address\n" {
+		t.Errorf("Expected the refusal in stderr, got %s: %q", result.Status(), result.Stderr)
+	}
+
+	// Tagged commands run at the same time
+	client := conn.API()
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := client.Run(context.Background(), "/slow")
+		slowDone <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	reply, err := client.Run(context.Background(), "/ip/address/print")
+	if err != nil || len(reply.Re) != 2 {
+		t.Errorf("Expected the print to finish before the slow command, got %v, %v", reply, err)
+	}
+	select {
+	case <-slowDone:
+		t.Error("Expected the slow command to still run")
+	default:
+	}
+	if err := <-slowDone; err != nil {
+		t.Errorf("Expected the slow command to finish, got %v", err)
+	}
+
+	// A cancelled command leaves the connection usable
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.Run(ctx, "/slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the command to time out, got %v", err)
+	}
+	if _, err := client.Run(context.Background(), "/ip/address/print"); err != nil {
+		t.Errorf("Expected the connection to keep working, got %v", err)
+	}
+
+	if _, err := conn.OpenInteractiveSession(); err == nil {
+		t.Error("Expected no interactive shell over the API")
+	}
+
+	// Devices before RouterOS 6.43 answer the login with an MD5 challenge
+	legacy := NewConnectionConfig("127.0.0.1", port, "legacy", "secret")
+	legacy.Protocol = ProtocolAPI
+	legacyConn := NewSSHConnection(legacy)
+	if err := legacyConn.Connect(); err != nil {
+		t.Fatalf("Failed to log in with the MD5 challenge: %v", err)
+	}
+	legacyConn.Close()
+}
+
+func TestRouterOSAPITLS(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	port := newTestAPIServer(t, &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: privateKey}}})
+	key, _ := ssh.NewPublicKey(&privateKey.PublicKey)
+
+	config := NewConnectionConfig("127.0.0.1", port, "admin", "secret")
+	config.Protocol = ProtocolAPITLS
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	// An unknown certificate is refused in strict mode
+	config.HostKeyPolicy = HostKeyStrict
+	var unknownErr *HostKeyUnknownError
+	if err := NewSSHConnection(config).Connect(); !errors.As(err, &unknownErr) {
+		t.Errorf("Expected an unknown key, got %v", err)
+	}
+
+	// On first use its key is recorded and then checked
+	config.HostKeyPolicy = HostKeyTOFU
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	conn.Close()
+	if conn.HostKeyFingerprint != ssh.FingerprintSHA256(key) {
+		t.Errorf("Expected the fingerprint of the certificate key, got %q", conn.HostKeyFingerprint)
+	}
+	config.HostKeyPolicy = HostKeyStrict
+	if err := NewSSHConnection(config).Connect(); err != nil {
+		t.Errorf("Expected the recorded key to be accepted, got %v", err)
+	}
+
+	// Another certificate on the same address is a mismatch
+	other := newTestECDSAKey(t)
+	line := knownhosts.Line([]string{knownhosts.Normalize(fmt.Sprintf("127.0.0.1:%d", port))}, other)
+	if err := os.WriteFile(config.KnownHostsFile, []byte(line+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+	if err := NewSSHConnection(config).Connect(); !IsHostKeyMismatch(err) {
+		t.Errorf("Expected a mismatch with another certificate, got %v", err)
+	}
+
+	// So is one that differs from the pinned fingerprint
+	config.HostKeyPolicy = HostKeyTOFU
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+	config.HostKeyFingerprint = ssh.FingerprintSHA256(other)
+	if err := NewSSHConnection(config).Connect(); !IsHostKeyMismatch(err) {
+		t.Errorf("Expected a mismatch with the pinned fingerprint, got %v", err)
+	}
+}
+
+func TestSessionRecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recordings", "10.0.0.1.cast")
+	recording, err := CreateRecording(path, "10.0.0.1", "10.0.0.1 (admin)", 80, 24)
+	if err != nil {
+		t.Fatalf("CreateRecording failed: %v", err)
+	}
+	closed := 0
+	recording.OnClose = func(*Recording) { closed++ }
+
+	// "é" split between two reads must not be written as two broken characters
+	stdin, stdout := recordPipes(recording, nopWriteCloser{io.Discard},
+		io.MultiReader(strings.NewReader("caf\xc3"), strings.NewReader("\xa9\r\n[admin@r1] > ")))
+	stdin.Write([]byte("/system identity print\r"))
+	recording.Resize(120, 40)
+	output, err := io.ReadAll(stdout)
+	if err != nil || string(output) != "café\r\n[admin@r1] > " {
+		t.Fatalf("Recorded reader changed the output: %q, %v", output, err)
+	}
+	recording.Close()
+	if closed != 1 {
+		t.Errorf("OnClose called %d times, expected once", closed)
+	}
+	recording.Output([]byte("after close"))
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open recording: %v", err)
+	}
+	defer file.Close()
+	header, events, err := ReadAsciicast(file)
+	if err != nil {
+		t.Fatalf("ReadAsciicast failed: %v", err)
+	}
+	if header.Version != 2 || header.Width != 80 || header.Height != 24 || header.Title != "10.0.0.1 (admin)" {
+		t.Errorf("Unexpected header: %+v", header)
+	}
+
+	var kinds, outputData string
+	for i, event := range events {
+		kinds += event.Type
+		if event.Type == "o" {
+			outputData += event.Data
+		}
+		if i > 0 && event.Time < events[i-1].Time {
+			t.Errorf("Event %d goes back in time", i)
+		}
+	}
+	if kinds != "iroo" {
+		t.Errorf("Expected input, resize and two outputs, got %q", kinds)
+	}
+	if outputData != "café\r\n[admin@r1] > " || events[1].Data != "120x40" {
+		t.Errorf("Unexpected events: %+v", events)
+	}
+
+	// A truncated last line is dropped
+	truncated := `{"version":2,"width":80,"height":24}` + "\n" + `[0.5,"o","ok"]` + "\n" + `[1.0,"o","pa`
+	_, events, err = ReadAsciicast(strings.NewReader(truncated))
+	if err != nil || len(events) != 1 {
+		t.Fatalf("Expected one event from a truncated recording, got %d, %v", len(events), err)
+	}
+
+	events = []AsciicastEvent{{0, "o", "a"}, {0.05, "i", "x"}, {10, "o", "b"}, {10.02, "r", "132x43"}, {10.05, "o", "c"}}
+	var replayed bytes.Buffer
+	var sizes []string
+	start := time.Now()
+	n, err := ReplayAsciicast(context.Background(), events, &replayed, 1, 50*time.Millisecond, func(cols, rows int) {
+		sizes = append(sizes, fmt.Sprintf("%dx%d", cols, rows))
+	})
+	if err != nil || n != len(events) || replayed.String() != "abc" {
+		t.Fatalf("Replay wrote %q (%d events), %v", replayed.String(), n, err)
+	}
+	if len(sizes) != 1 || sizes[0] != "132x43" {
+		t.Errorf("Expected the resize to be replayed, got %v", sizes)
+	}
+	if _, _, ok := ParseAsciicastSize("wide"); ok {
+		t.Errorf("Expected an invalid size to be rejected")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Long pause was not shortened, replay took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	replayed.Reset()
+	n, err = ReplayAsciicast(ctx, events, &replayed, 1, 0, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || n != 2 || replayed.String() != "a" {
+		t.Errorf("Cancelled replay should stop before event 2, got %d events, %q, %v", n, replayed.String(), err)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestInputTargets(t *testing.T) {
+	targets := newInputTargets(3)
+	changes := 0
+	targets.onChange(func() { changes++ })
+
+	if enabled, focused := targets.snapshot(); len(enabled) != 3 || !enabled[0] || !enabled[2] || focused != -1 {
+		t.Fatalf("All sessions should receive input at first, got %v focused %d", enabled, focused)
+	}
+
+	targets.set(2, false)
+	if targets.isEnabled(2) || !targets.isEnabled(1) || targets.last() != 1 {
+		t.Errorf("Session 2 should be excluded, last target is %d", targets.last())
+	}
+
+	targets.focus(0)
+	enabled, focused := targets.snapshot()
+	if focused != 0 || !enabled[0] || enabled[1] || enabled[2] || targets.last() != 0 {
+		t.Errorf("Focus on session 0 failed: %v focused %d", enabled, focused)
+	}
+
+	// Toggling a host leaves focus mode
+	targets.set(1, true)
+	if _, focused := targets.snapshot(); focused != -1 || !targets.isEnabled(1) {
+		t.Errorf("Toggling a session should leave focus mode, focused %d", focused)
+	}
+
+	targets.set(0, false)
+	targets.set(1, false)
+	if targets.last() != -1 {
+		t.Errorf("No session should receive input, last target is %d", targets.last())
+	}
+
+	targets.broadcast()
+	if enabled, _ := targets.snapshot(); !enabled[0] || !enabled[1] || !enabled[2] {
+		t.Errorf("Broadcast should enable all sessions, got %v", enabled)
+	}
+	if targets.isEnabled(5) || targets.isEnabled(-1) {
+		t.Error("Sessions out of range must not be enabled")
+	}
+	if changes != 6 {
+		t.Errorf("Expected 6 change notifications, got %d", changes)
+	}
+}
+
+func TestPaneInput(t *testing.T) {
+	sent := make([]bytes.Buffer, 3)
+	stdins := make([]io.WriteCloser, len(sent))
+	for i := range sent {
+		stdins[i] = nopWriteCloser{&sent[i]}
+	}
+	input := newTiledInput(stdins)
+	pane := &paneInput{input: input, index: 1}
+	pane.guard = newInputGuard(pane.receivers, nil)
+
+	// Keystrokes stay in their pane until input is synchronized
+	pane.Write([]byte("a"))
+	if sent[0].String() != "" || sent[1].String() != "a" || sent[2].String() != "" || pane.receivers() != 1 {
+		t.Errorf("Expected input to the pane only, sent %q %q %q", sent[0].String(), sent[1].String(), sent[2].String())
+	}
+	if input.last() != 1 {
+		t.Errorf("Expected pane 1 as the last typed in, got %d", input.last())
+	}
+
+	// Synchronized, they go to the other panes receiving input as well
+	input.setSynchronized(true)
+	if pane.receivers() != 3 {
+		t.Errorf("Expected 3 receivers, got %d", pane.receivers())
+	}
+	input.targets.set(2, false)
+	pane.send([]byte("b"))
+	if sent[0].String() != "b" || sent[1].String() != "ab" || sent[2].String() != "" || pane.receivers() != 2 {
+		t.Errorf("Expected input to panes 0 and 1, sent %q %q %q", sent[0].String(), sent[1].String(), sent[2].String())
+	}
+
+	// The pane typed in always gets its keystrokes
+	input.targets.focus(0)
+	pane.send([]byte("c"))
+	if sent[0].String() != "bc" || sent[1].String() != "abc" || sent[2].String() != "" || pane.receivers() != 2 {
+		t.Errorf("Expected input to the focused pane and the pane typed in, sent %q %q %q", sent[0].String(), sent[1].String(), sent[2].String())
+	}
+}
+
+func TestInputGuard(t *testing.T) {
+	defer SetDangerousCommands(DangerousCommands())
+	SetDangerousCommands([]string{`/system reset-configuration`, `\breboot\b`, `(`})
+
+	hosts := 3
+	var sent bytes.Buffer
+	var requests []BroadcastRequest
+	var answer func(bool)
+	guard := newInputGuard(func() int { return hosts }, func(request BroadcastRequest, done func(bool)) {
+		requests = append(requests, request)
+		answer = done
+	})
+	send := func(p []byte) { sent.Write(p) }
+	typeKeys := func(keys string) {
+		for _, r := range keys {
+			guard.write([]byte(string(r)), send)
+		}
+	}
+
+	// Harmless commands go through, editing keys included
+	typeKeys("ls -x\x7fl\r")
+	if sent.String() != "ls -x\x7fl\r" || len(requests) != 0 {
+		t.Fatalf("Harmless command should be sent unchecked, sent %q", sent.String())
+	}
+
+	// A dangerous command is held at Enter, cancelling keeps it typed
+	sent.Reset()
+	typeKeys("/system reboot\r")
+	if sent.String() != "/system reboot" || len(requests) != 1 {
+		t.Fatalf("Enter should be held back, sent %q", sent.String())
+	}
+	if requests[0].Hosts != 3 || requests[0].Paste || len(requests[0].Dangerous) != 1 || requests[0].Text != "/system reboot" {
+		t.Errorf("Unexpected request %+v", requests[0])
+	}
+	typeKeys("x")
+	answer(false)
+	if sent.String() != "/system reboot" {
+		t.Errorf("Input while confirming and the cancelled Enter must be dropped, sent %q", sent.String())
+	}
+
+	// Confirmed, the Enter is sent and the line starts over
+	typeKeys("\r")
+	answer(true)
+	typeKeys("uptime\r")
+	if sent.String() != "/system reboot\r"+"uptime\r" || len(requests) != 2 {
+		t.Errorf("Confirmed command should be run, sent %q", sent.String())
+	}
+
+	// Multi-line pastes need a confirmation, bracketed or not
+	sent.Reset()
+	paste := pasteStart + "/ip address print\n/interface print\n" + pasteEnd
+	guard.write([]byte(paste), send)
+	if sent.Len() != 0 || len(requests) != 3 || !requests[2].Paste || requests[2].Text != "/ip address print\n/interface print\n" {
+		t.Fatalf("Multi-line paste should be held back, sent %q", sent.String())
+	}
+	answer(true)
+	if sent.String() != paste {
+		t.Errorf("Confirmed paste should be sent as it was, sent %q", sent.String())
+	}
+
+	// A single line paste is typed, a dangerous one only checked once run
+	sent.Reset()
+	guard.write([]byte("/system reset-configuration"), send)
+	if sent.String() != "/system reset-configuration" || len(requests) != 3 {
+		t.Errorf("Single line paste should be sent, sent %q", sent.String())
+	}
+	typeKeys("\r")
+	if len(requests) != 4 || requests[3].Text != "/system reset-configuration" {
+		t.Fatalf("Dangerous pasted command should be confirmed when run")
+	}
+	answer(false)
+	typeKeys("\x15")
+
+	SetConfirmMultilinePaste(false)
+	guard.write([]byte("echo 1\necho 2\n"), send)
+	if len(requests) != 4 {
+		t.Error("Multi-line pastes should go through when not confirmed")
+	}
+	guard.write([]byte("echo 1\nreboot\n"), send)
+	if len(requests) != 5 || len(requests[4].Dangerous) != 1 || requests[4].Dangerous[0] != "reboot" {
+		t.Errorf("Pasted dangerous commands must always be confirmed, got %+v", requests)
+	}
+	answer(false)
+	SetConfirmMultilinePaste(true)
+
+	// Input to a single host is not checked
+	hosts = 1
+	sent.Reset()
+	typeKeys("reboot\r")
+	guard.write([]byte("a\nb\n"), send)
+	if sent.String() != "reboot\ra\nb\n" || len(requests) != 5 {
+		t.Errorf("Input to a single host should not be held back, sent %q", sent.String())
+	}
+
+	// A key of several bytes is a single character of the line
+	typeKeys("é\x7fé\x7fx")
+	if line := string(guard.line); line != "x" {
+		t.Errorf("Expected backspace to remove whole characters, got %q", line)
+	}
+}
+
+func TestScrollback(t *testing.T) {
+	sb := NewScrollback(3)
+	// Colors, a title sequence, a carriage return and a sequence split across writes
+	sb.Write([]byte("\x1b]0;router\x07\x1b[32mok\x1b[0m line\r\n"))
+	sb.Write([]byte("progress 10%\rprogress 99%\r\n\x1b["))
+	sb.Write([]byte("1mbold\x1b[0m\r\nab\bc\r\n"))
+	sb.Write([]byte("prompt> "))
+
+	lines := sb.Lines()
+	want := []string{"progress 99%", "bold", "ac", "prompt>"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Fatalf("Expected lines %q, got %q", want, lines)
+	}
+
+	var transcript bytes.Buffer
+	sb.WriteTo(&transcript)
+	if transcript.String() != "progress 99%\nbold\nac\nprompt>\n" {
+		t.Errorf("Unexpected transcript %q", transcript.String())
+	}
+
+	// Sources keep their lines apart
+	multi := NewScrollback(10)
+	a, b := multi.Source("10.0.0.1"), multi.Source("10.0.0.2")
+	a.Write([]byte("uptime: "))
+	b.Write([]byte("error: no route\n"))
+	a.Write([]byte("5d\n"))
+	if lines := multi.Lines(); len(lines) != 2 || lines[0] != "[10.0.0.2] error: no route" || lines[1] != "[10.0.0.1] uptime: 5d" {
+		t.Errorf("Unexpected lines from sources %q", lines)
+	}
+
+	// Searching
+	lines = []string{"interface ether1", "ERROR: failed", "interface ether2", "error again"}
+	pattern, err := compileSearch("error", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := findLines(lines, pattern)
+	if len(matches) != 2 || matches[0] != 1 || matches[1] != 3 {
+		t.Errorf("Expected matches on lines 1 and 3, got %v", matches)
+	}
+	if pattern, _ := compileSearch("error", false, true); len(findLines(lines, pattern)) != 1 {
+		t.Error("Case sensitive search should match one line")
+	}
+	if pattern, _ := compileSearch(`ether\d`, true, false); len(findLines(lines, pattern)) != 2 {
+		t.Error("Regex search should match two lines")
+	}
+	if _, err := compileSearch("ether(", true, false); err == nil {
+		t.Error("Invalid regex should be reported")
+	}
+	if pattern, _ := compileSearch("ether(", false, false); pattern.MatchString("ether1") {
+		t.Error("Literal search should not be a regex")
+	}
+	if nextMatch(matches, len(lines), false) != 1 || nextMatch(matches, 3, false) != 0 || nextMatch(matches, 1, false) != 1 {
+		t.Error("Searching backwards should go up and wrap around")
+	}
+	if nextMatch(matches, -1, true) != 0 || nextMatch(matches, 1, true) != 1 || nextMatch(matches, 3, true) != 0 {
+		t.Error("Searching forwards should go down and wrap around")
+	}
+	if nextMatch(nil, 0, true) != -1 {
+		t.Error("No matches should give -1")
+	}
+}
+
+func TestSessionLog(t *testing.T) {
+	dir := t.TempDir()
+	defer SetSessionLogDir(SessionLogDir())
+	SetSessionLogDir(filepath.Join(dir, "logs"))
+
+	conn := &SSHConnection{Config: ConnectionConfig{Host: "fe80::1", Username: "admin"}}
+	stdout := conn.logOutput(strings.NewReader("\x1b[1m[admin@MikroTik] >\x1b[0m /system identity print\r\n  name: core\r\nlast"))
+	if _, err := io.ReadAll(stdout); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "logs", "fe80__1.log"))
+	if err != nil {
+		t.Fatalf("Log file not written: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 log lines, got %q", lines)
+	}
+	for i, want := range []string{"--- session started: admin@fe80::1 ---", "[admin@MikroTik] > /system identity print", "  name: core", "last", "--- session ended ---"} {
+		if _, err := time.Parse("2006-01-02 15:04:05", lines[i][:19]); err != nil || lines[i][20:] != want {
+			t.Errorf("Line %d: expected timestamped %q, got %q", i, want, lines[i])
+		}
+	}
+
+	// Logging off
+	SetSessionLogDir("")
+	reader := strings.NewReader("x")
+	if conn.logOutput(reader) != io.Reader(reader) {
+		t.Error("Output should not be logged when logging is off")
+	}
+}
+
+// ptySession records the pty request and environment of an interactive session
+type ptySession struct {
+	InteractiveSession
+	term       string
+	rows, cols int
+	modes      ssh.TerminalModes
+	env        map[string]string
+}
+
+func (s *ptySession) RequestPty(term string, rows, cols int, modes ssh.TerminalModes) error {
+	s.term, s.rows, s.cols, s.modes = term, rows, cols, modes
+	return nil
+}
+
+func (s *ptySession) Setenv(name, value string) error {
+	if name != "LANG" {
+		return fmt.Errorf("refused")
+	}
+	s.env[name] = value
+	return nil
+}
+
+func TestTerminalOptions(t *testing.T) {
+	spec := "term=vt100; size=132x43; modes=ICRNL=0 ECHO=1; env=LANG=C.UTF-8 TZ=UTC; font=/fonts/mono.ttf; font-size=14.5; theme=light"
+	options, err := ParseTerminalOptions(spec)
+	if err != nil {
+		t.Fatalf("Failed to parse terminal options: %v", err)
+	}
+	if options.Type != "vt100" || options.Cols != 132 || options.Rows != 43 || options.FontSize != 14.5 || options.Theme != "light" {
+		t.Errorf("Unexpected terminal options %+v", options)
+	}
+	if options.Modes[ssh.ICRNL] != 0 || options.Modes[ssh.ECHO] != 1 || options.Env["TZ"] != "UTC" {
+		t.Errorf("Unexpected modes %v or env %v", options.Modes, options.Env)
+	}
+	if again, err := ParseTerminalOptions(options.String()); err != nil || again.String() != options.String() {
+		t.Errorf("Options should survive formatting, got %q from %q: %v", again.String(), options.String(), err)
+	}
+
+	for _, invalid := range []string{"size=132", "modes=NOPE=1", "modes=ECHO", "font-size=0", "theme=blue", "colour=red", "term"} {
+		if _, err := ParseTerminalOptions(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+
+	// Device options win over the defaults, modes and env are merged
+	defaults := TerminalOptions{Type: "xterm-256color", Rows: 24, Cols: 80, Modes: ssh.TerminalModes{ssh.ECHO: 1, ssh.ICRNL: 1}, Env: map[string]string{"LANG": "en_US.UTF-8"}}
+	merged := defaults.Override(TerminalOptions{Modes: ssh.TerminalModes{ssh.ICRNL: 0}, Env: map[string]string{"TZ": "UTC"}, Theme: "dark"})
+	if merged.Type != "xterm-256color" || merged.Rows != 24 || merged.Theme != "dark" {
+		t.Errorf("Unexpected merged options %+v", merged)
+	}
+	if merged.Modes[ssh.ECHO] != 1 || merged.Modes[ssh.ICRNL] != 0 || merged.Env["LANG"] != "en_US.UTF-8" || merged.Env["TZ"] != "UTC" {
+		t.Errorf("Unexpected merged modes %v or env %v", merged.Modes, merged.Env)
+	}
+	if defaults.Modes[ssh.ICRNL] != 1 || len(defaults.Env) != 1 {
+		t.Error("Override should not change the defaults")
+	}
+
+	// The pty request uses the merged options, refused variables are skipped
+	defer SetDefaultTerminalOptions(DefaultTerminalOptions())
+	SetDefaultTerminalOptions(defaults)
+	DefaultTerminalOptions().Modes[ssh.ECHO] = 0
+	if DefaultTerminalOptions().Modes[ssh.ECHO] != 1 {
+		t.Error("Changing a copy of the default options should not change them")
+	}
+	conn := &SSHConnection{Config: ConnectionConfig{Host: "10.0.0.1", Terminal: options}}
+	session := &ptySession{env: map[string]string{}}
+	used, err := conn.requestPty(session)
+	if err != nil {
+		t.Fatalf("Failed to request pty: %v", err)
+	}
+	if session.term != "vt100" || session.rows != 43 || session.cols != 132 || session.modes[ssh.ICRNL] != 0 {
+		t.Errorf("Unexpected pty request %q %dx%d %v", session.term, session.cols, session.rows, session.modes)
+	}
+	if len(session.env) != 1 || session.env["LANG"] != "C.UTF-8" {
+		t.Errorf("Unexpected environment %v", session.env)
+	}
+	if used.Cols != 132 || used.Font != "/fonts/mono.ttf" {
+		t.Errorf("Unexpected options used %+v", used)
+	}
+}
+
+func TestBecome(t *testing.T) {
+	server := newTestSSHServer(t)
+	config := NewConnectionConfig(server.Host, server.Port, "admin", "secret")
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+	config.Become, config.BecomePassword = BecomeSudo, "sudo-secret"
+
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// sudo answers the prompt and keeps it out of the output
+	result := conn.RunCommandResult("whoami")
+	if !result.Success() || result.Stdout != "root\n" || result.Stderr != "" {
+		t.Errorf("Unexpected result with sudo: %+v", result)
+	}
+
+	// Without a prompt stdin is closed once the command runs, nothing is sent to it
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result = conn.RunCommandStream(ctx, "cat cached", nil)
+	if !result.Success() || result.Stdout != "root\n" || result.Stderr != "" {
+		t.Errorf("Unexpected result with cached sudo credentials: %+v", result)
+	}
+
+	conn.Config.BecomePassword = "wrong"
+	result = conn.RunCommandResult("echo hello")
+	if !result.EscalationFailed() || result.Status() != "escalation with sudo failed: incorrect password" {
+		t.Errorf("Expected an escalation failure, got %q: %+v", result.Status(), result)
+	}
+
+	conn.Config.BecomePassword = ""
+	result = conn.RunCommandResult("echo hello")
+	if !result.EscalationFailed() || !strings.Contains(result.Status(), "a password is required") {
+		t.Errorf("Expected sudo to ask for a password, got %q", result.Status())
+	}
+
+	// What the command prints once sudo ran it is not a refusal of sudo
+	for _, password := range []string{"sudo-secret", ""} {
+		conn.Config.BecomePassword = password
+		result = conn.RunCommandResult("cached sudoers")
+		if result.EscalationFailed() || result.ExitCode != 1 || result.Stderr != "deploy is not in the sudoers file\n" {
+			t.Errorf("Expected a plain command failure, got %q: %+v", result.Status(), result)
+		}
+	}
+
+	// A failing command is not an escalation failure
+	conn.Config.Become = BecomeNone
+	if result := conn.RunCommandResult("fail"); result.EscalationFailed() || result.ExitCode != 3 {
+		t.Errorf("Expected a plain command failure, got %+v", result)
+	}
+
+	// enable at the prompt of a telnet shell
+	port, _ := newTestTelnetServer(t)
+	telnetConfig := NewConnectionConfig("127.0.0.1", port, "admin", "secret")
+	telnetConfig.Protocol = ProtocolTelnet
+	telnetConfig.Become, telnetConfig.BecomePassword = BecomeEnable, "enable-secret"
+	telnet := NewSSHConnection(telnetConfig)
+	if err := telnet.Connect(); err != nil {
+		t.Fatalf("Failed to connect over telnet: %v", err)
+	}
+	defer telnet.Close()
+
+	output, err := telnet.RunCommand("echo privileged")
+	if err != nil || output != "privileged\n\n" {
+		t.Errorf("Expected the output of the privileged command, got %q, %v", output, err)
+	}
+
+	telnet.Config.BecomePassword = "wrong"
+	if _, err := telnet.RunCommand("echo privileged"); !IsEscalationError(err) || !strings.Contains(err.Error(), "% Access denied") {
+		t.Errorf("Expected enable to be refused, got %v", err)
+	}
+
+	// Interactive shells are escalated once the prompt shows
+	telnet.Config.BecomePassword = "enable-secret"
+	session, err := telnet.OpenInteractiveSession()
+	if err != nil {
+		t.Fatalf("Failed to open a session: %v", err)
+	}
+	defer session.Close()
+	stdin, _ := session.StdinPipe()
+	stdoutPipe, _ := session.StdoutPipe()
+	stdout := telnet.becomeShell(stdin, stdoutPipe)
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Failed to start the shell: %v", err)
+	}
+	var received []byte
+	buf := make([]byte, 1024)
+	for !strings.Contains(string(received), "[admin@test] # ") {
+		n, err := stdout.Read(buf)
+		if err != nil {
+			t.Fatalf("Expected the privileged prompt, got %q, %v", received, err)
+		}
+		received = append(received, buf[:n]...)
+	}
+	if strings.Contains(string(received), "enable-secret") {
+		t.Errorf("The enable secret should not be shown, got %q", received)
+	}
+
+	if quoted := shellQuote("it's"); quoted != `'it'\''s'` {
+		t.Errorf("Unexpected quoting %s", quoted)
+	}
+}
+
+func TestExpect(t *testing.T) {
+	script, err := ParseExpectScript(`
+# Confirm the reboot
+timeout 2s
+expect /Linux \S+ (?P<kernel>[\d.]+)/
+expect $
+send echo kernel ${kernel} $HOME
+expect /kernel ([\d.]+)/
+expect $
+send reboot
+expect Reboot, yes? [y/N]:
+send "y"
+expect will reboot
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse the script: %v", err)
+	}
+	if len(script) != 9 || script[0].Timeout != 2*time.Second || script[0].Line != 4 || script[7].Send != "y" || script[2].Send != "echo kernel ${kernel} $HOME\r" {
+		t.Errorf("Unexpected steps %+v", script)
+	}
+	for _, invalid := range []string{"", "# only a comment", "wait 5s", "expect", "expect /(/", "timeout soon", `send "unterminated`} {
+		if _, err := ParseExpectScript(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+
+	server := newTestSSHServer(t)
+	config := NewConnectionConfig(server.Host, server.Port, "admin", "secret")
+	config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+	conn := NewSSHConnection(config)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Captured groups are filled in, unknown variables are sent as written
+	var lines []string
+	var mutex sync.Mutex
+	result := conn.RunExpect(context.Background(), script, func(line OutputLine) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		lines = append(lines, line.Text)
+	})
+	if !result.Success() {
+		t.Fatalf("Expected the script to succeed, got %s: %q", result.Status(), result.Stdout)
+	}
+	for _, want := range []string{"kernel 6.1.0 $HOME", "Reboot, yes? [y/N]: y", "system will reboot shortly"} {
+		if !strings.Contains(result.Stdout, want+"\n") {
+			t.Errorf("Expected %q in the output, got %q", want, result.Stdout)
+		}
+	}
+	mutex.Lock()
+	if strings.Join(lines, "\n") != strings.TrimSuffix(result.Stdout, "\n") {
+		t.Errorf("Expected the streamed lines to match the output, got %q", lines)
+	}
+	mutex.Unlock()
+
+	// A prompt that never shows fails the script at its line
+	script, _ = ParseExpectScript("timeout 200ms\nsend echo hi\nexpect /bye/")
+	results, err := RunExpectMultiple(context.Background(), []*SSHConnection{conn}, script, ExecutorConfig{}, CommandCallbacks{})
+	if err != nil || results[0].Success() || !strings.Contains(results[0].Status(), "line 3: timed out after 200ms waiting for /bye/") {
+		t.Errorf("Expected a timeout at line 3, got %q, %v", results[0].Status(), err)
+	}
+
+	// The per-host timeout stops a script waiting in a longer expect step
+	script, _ = ParseExpectScript("timeout 30s\nexpect /bye/")
+	started := time.Now()
+	results, _ = RunExpectMultiple(context.Background(), []*SSHConnection{conn}, script, ExecutorConfig{HostTimeout: 200 * time.Millisecond}, CommandCallbacks{})
+	if elapsed := time.Since(started); elapsed > 5*time.Second || !strings.Contains(results[0].Status(), "command timed out") {
+		t.Errorf("Expected the host timeout to stop the script, got %q after %v", results[0].Status(), elapsed)
+	}
+}
@@ -0,0 +1,337 @@
+package pssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// StartRecording, when set, is called for every terminal session and returns the
+// recording to write it to, or nil to leave the session unrecorded
+var StartRecording func(conn *SSHConnection, cols, rows int) *Recording
+
+// AsciicastHeader is the first line of an asciicast v2 file
+type AsciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// AsciicastEvent is a line after the header: seconds since the start, the type
+// ("o" output, "i" input, "r" resize to "COLSxROWS") and its data
+type AsciicastEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// MarshalJSON writes the event as the [time, type, data] array of the format
+func (e AsciicastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Type, e.Data})
+}
+
+// UnmarshalJSON reads a [time, type, data] array
+func (e *AsciicastEvent) UnmarshalJSON(b []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("event has %d fields, expected 3", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return err
+	}
+	return json.Unmarshal(fields[2], &e.Data)
+}
+
+// Recording writes the input and output of a terminal session to an asciicast v2
+// file, one event per line so an interrupted recording stays readable
+type Recording struct {
+	Path    string
+	Host    string
+	Started time.Time
+	OnClose func(*Recording) // Called once when the recording ends
+	file    *os.File
+	pending map[string][]byte // Incomplete UTF-8 sequence at the end of a stream
+	ended   time.Time
+	mutex   sync.Mutex
+}
+
+// CreateRecording creates an asciicast v2 file for a session on host
+func CreateRecording(path, host, title string, cols, rows int) (*Recording, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create recordings directory: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %v", err)
+	}
+
+	started := time.Now()
+	header, _ := json.Marshal(AsciicastHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: started.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	if _, err := file.Write(append(header, '\n')); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write recording header: %v", err)
+	}
+
+	return &Recording{
+		Path:    path,
+		Host:    host,
+		Started: started,
+		file:    file,
+		pending: make(map[string][]byte),
+	}, nil
+}
+
+// Output records data received from the device
+func (r *Recording) Output(p []byte) {
+	r.write("o", p)
+}
+
+// Input records data sent to the device
+func (r *Recording) Input(p []byte) {
+	r.write("i", p)
+}
+
+// Resize records a new terminal size
+func (r *Recording) Resize(cols, rows int) {
+	r.write("r", []byte(fmt.Sprintf("%dx%d", cols, rows)))
+}
+
+func (r *Recording) write(kind string, p []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return
+	}
+
+	// A character split between two reads is written with the second one
+	data := append(r.pending[kind], p...)
+	complete := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				complete = i
+			}
+			break
+		}
+	}
+	r.pending[kind] = append([]byte(nil), data[complete:]...)
+	if complete == 0 {
+		return
+	}
+
+	line, _ := json.Marshal(AsciicastEvent{
+		Time: time.Since(r.Started).Seconds(),
+		Type: kind,
+		Data: string(data[:complete]),
+	})
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		fmt.Printf("Failed to write recording %s: %v\n", r.Path, err)
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+// Duration returns how long the session was recorded so far
+func (r *Recording) Duration() time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.ended.IsZero() {
+		return r.ended.Sub(r.Started)
+	}
+	return time.Since(r.Started)
+}
+
+// Close ends the recording, it is safe to call more than once
+func (r *Recording) Close() error {
+	r.mutex.Lock()
+	if !r.ended.IsZero() {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.ended = time.Now()
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	onClose := r.OnClose
+	r.mutex.Unlock()
+
+	if onClose != nil {
+		onClose(r)
+	}
+	return err
+}
+
+// startRecording asks the application for a recording of a new terminal session
+func (conn *SSHConnection) startRecording(cols, rows int) *Recording {
+	if StartRecording == nil {
+		return nil
+	}
+	return StartRecording(conn, cols, rows)
+}
+
+// recordedReader records the output of a session as it is read, and ends the
+// recording with the session
+type recordedReader struct {
+	reader    io.Reader
+	recording *Recording
+}
+
+func (rr *recordedReader) Read(p []byte) (int, error) {
+	n, err := rr.reader.Read(p)
+	if n > 0 {
+		rr.recording.Output(p[:n])
+	}
+	if err != nil {
+		rr.recording.Close()
+	}
+	return n, err
+}
+
+// recordedWriter records the input of a session as it is written
+type recordedWriter struct {
+	writer    io.WriteCloser
+	recording *Recording
+}
+
+func (rw *recordedWriter) Write(p []byte) (int, error) {
+	n, err := rw.writer.Write(p)
+	if n > 0 {
+		rw.recording.Input(p[:n])
+	}
+	return n, err
+}
+
+func (rw *recordedWriter) Close() error {
+	return rw.writer.Close()
+}
+
+// recordPipes wraps the pipes of a session so they are written to recording, or
+// returns them unchanged when recording is nil
+func recordPipes(recording *Recording, stdin io.WriteCloser, stdout io.Reader) (io.WriteCloser, io.Reader) {
+	if recording == nil {
+		return stdin, stdout
+	}
+	return &recordedWriter{writer: stdin, recording: recording}, &recordedReader{reader: stdout, recording: recording}
+}
+
+// ReadAsciicast reads an asciicast v2 recording. A truncated last line, left by a
+// session that ended abruptly, is ignored.
+func ReadAsciicast(r io.Reader) (AsciicastHeader, []AsciicastEvent, error) {
+	var header AsciicastHeader
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return header, nil, err
+		}
+		return header, nil, fmt.Errorf("recording is empty")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, nil, fmt.Errorf("invalid recording header: %v", err)
+	}
+	if header.Version != 2 {
+		return header, nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	var events []AsciicastEvent
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event AsciicastEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			break
+		}
+		events = append(events, event)
+	}
+	return header, events, scanner.Err()
+}
+
+// ReplayAsciicast writes the output events to w at the pace they were recorded,
+// sped up by speed, with pauses longer than maxIdle shortened to it (0 keeps them).
+// Resize events are passed to resize, which may be nil. It returns the number of
+// events played, so a paused replay can go on with events[n:].
+func ReplayAsciicast(ctx context.Context, events []AsciicastEvent, w io.Writer, speed float64, maxIdle time.Duration, resize func(cols, rows int)) (int, error) {
+	if speed <= 0 {
+		speed = 1
+	}
+	var previous float64
+	if len(events) > 0 {
+		previous = events[0].Time
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	for i, event := range events {
+		delay := time.Duration((event.Time - previous) / speed * float64(time.Second))
+		if maxIdle > 0 && delay > maxIdle {
+			delay = maxIdle
+		}
+		previous = event.Time
+
+		if delay > 0 {
+			timer.Reset(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return i, ctx.Err()
+			}
+		} else if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+
+		switch event.Type {
+		case "o":
+			if _, err := io.WriteString(w, event.Data); err != nil {
+				return i, err
+			}
+		case "r":
+			if cols, rows, ok := ParseAsciicastSize(event.Data); ok && resize != nil {
+				resize(cols, rows)
+			}
+		}
+	}
+	return len(events), nil
+}
+
+// ParseAsciicastSize parses the "COLSxROWS" data of a resize event
+func ParseAsciicastSize(data string) (cols, rows int, ok bool) {
+	colsText, rowsText, found := strings.Cut(data, "x")
+	cols, colsErr := strconv.Atoi(colsText)
+	rows, rowsErr := strconv.Atoi(rowsText)
+	if !found || colsErr != nil || rowsErr != nil || cols <= 0 || rows <= 0 {
+		return 0, 0, false
+	}
+	return cols, rows, true
+}
@@ -0,0 +1,370 @@
+package pssh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// outputKeeping is how terminals keep their output, they read it while the
+// settings may change it
+var outputKeeping = struct {
+	scrollbackLines int    // Output lines kept for search and transcripts
+	sessionLogDir   string // Folder of the per-host log files
+	mutex           sync.RWMutex
+}{scrollbackLines: 10000}
+
+// SetScrollbackLines sets the number of output lines a terminal keeps for search
+// and transcripts
+func SetScrollbackLines(lines int) {
+	outputKeeping.mutex.Lock()
+	defer outputKeeping.mutex.Unlock()
+	outputKeeping.scrollbackLines = lines
+}
+
+// ScrollbackLines returns the number of lines set with SetScrollbackLines
+func ScrollbackLines() int {
+	outputKeeping.mutex.RLock()
+	defer outputKeeping.mutex.RUnlock()
+	return outputKeeping.scrollbackLines
+}
+
+// SetSessionLogDir sets the folder of the per-host log files, empty when terminal
+// output is not logged
+func SetSessionLogDir(dir string) {
+	outputKeeping.mutex.Lock()
+	defer outputKeeping.mutex.Unlock()
+	outputKeeping.sessionLogDir = dir
+}
+
+// SessionLogDir returns the folder set with SetSessionLogDir
+func SessionLogDir() string {
+	outputKeeping.mutex.RLock()
+	defer outputKeeping.mutex.RUnlock()
+	return outputKeeping.sessionLogDir
+}
+
+// plainText turns terminal output into plain lines: escape sequences are dropped,
+// a carriage return goes back to the start of the line and a backspace one
+// character back. Sequences split across writes are handled.
+type plainText struct {
+	escape  int // State within an escape sequence
+	line    []rune
+	cursor  int
+	partial []byte // Incomplete UTF-8 at the end of the last write
+}
+
+const (
+	escNone = iota
+	escStart
+	escCSI
+	escOne    // One more byte, e.g. a character set
+	escString // OSC, DCS and the like, ended by BEL or ST
+	escStringEnd
+)
+
+// feed adds output, calling commit with every line ended by a newline
+func (pt *plainText) feed(p []byte, commit func(line string)) {
+	if len(pt.partial) > 0 {
+		p = append(pt.partial, p...)
+		pt.partial = nil
+	}
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		if r == utf8.RuneError && !utf8.FullRune(p) {
+			pt.partial = append([]byte(nil), p...)
+			return
+		}
+		p = p[size:]
+
+		switch pt.escape {
+		case escStart:
+			switch r {
+			case '[':
+				pt.escape = escCSI
+			case ']', 'P', '_', '^', 'X':
+				pt.escape = escString
+			case '(', ')', '*', '+', '#', '%':
+				pt.escape = escOne
+			default:
+				pt.escape = escNone
+			}
+			continue
+		case escCSI:
+			if r >= 0x40 && r <= 0x7e {
+				pt.escape = escNone
+			}
+			continue
+		case escString:
+			if r == 0x07 {
+				pt.escape = escNone
+			} else if r == 0x1b {
+				pt.escape = escStringEnd
+			}
+			continue
+		case escOne, escStringEnd:
+			pt.escape = escNone
+			continue
+		}
+
+		switch {
+		case r == 0x1b:
+			pt.escape = escStart
+		case r == '\n':
+			commit(pt.text())
+			pt.line, pt.cursor = pt.line[:0], 0
+		case r == '\r':
+			pt.cursor = 0
+		case r == '\b':
+			if pt.cursor > 0 {
+				pt.cursor--
+			}
+		case r == '\t':
+			pt.put(' ')
+		case r >= 0x20 && r != 0x7f:
+			pt.put(r)
+		}
+	}
+}
+
+func (pt *plainText) put(r rune) {
+	if pt.cursor < len(pt.line) {
+		pt.line[pt.cursor] = r
+	} else {
+		pt.line = append(pt.line, r)
+	}
+	pt.cursor++
+}
+
+// text returns the current line
+func (pt *plainText) text() string {
+	return strings.TrimRight(string(pt.line), " ")
+}
+
+// Scrollback keeps the last lines of terminal output as plain text, to search
+// them and save a transcript. Several sessions can write to it through sources,
+// their lines are prefixed with the source name.
+type Scrollback struct {
+	mutex   sync.Mutex
+	max     int
+	lines   []string
+	sources []*scrollbackSource
+}
+
+type scrollbackSource struct {
+	scrollback *Scrollback
+	prefix     string
+	text       plainText
+}
+
+// NewScrollback creates a scrollback keeping up to max lines
+func NewScrollback(max int) *Scrollback {
+	if max <= 0 {
+		max = 1000
+	}
+	sb := &Scrollback{max: max}
+	sb.sources = []*scrollbackSource{{scrollback: sb}}
+	return sb
+}
+
+// Write adds output of the terminal
+func (sb *Scrollback) Write(p []byte) (int, error) {
+	return sb.sources[0].Write(p)
+}
+
+// Source returns a writer for the output of one session, its lines are shown
+// as "[name] line"
+func (sb *Scrollback) Source(name string) io.Writer {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	source := &scrollbackSource{scrollback: sb, prefix: fmt.Sprintf("[%s] ", name)}
+	sb.sources = append(sb.sources, source)
+	return source
+}
+
+func (s *scrollbackSource) Write(p []byte) (int, error) {
+	sb := s.scrollback
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	s.text.feed(p, func(line string) {
+		sb.lines = append(sb.lines, s.prefix+line)
+	})
+	if excess := len(sb.lines) - sb.max; excess > 0 {
+		sb.lines = append(sb.lines[:0], sb.lines[excess:]...)
+	}
+	return len(p), nil
+}
+
+// Lines returns the kept lines, followed by the lines still being written
+func (sb *Scrollback) Lines() []string {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	lines := append([]string(nil), sb.lines...)
+	for _, source := range sb.sources {
+		if text := source.text.text(); text != "" {
+			lines = append(lines, source.prefix+text)
+		}
+	}
+	return lines
+}
+
+// WriteTo writes the lines as a transcript
+func (sb *Scrollback) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for _, line := range sb.Lines() {
+		n, err := io.WriteString(w, line+"\n")
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// compileSearch builds the pattern of a search in the scrollback, text is taken
+// literally unless regex is set
+func compileSearch(text string, regex, matchCase bool) (*regexp.Regexp, error) {
+	if !regex {
+		text = regexp.QuoteMeta(text)
+	}
+	if !matchCase {
+		text = "(?i)" + text
+	}
+	pattern, err := regexp.Compile(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %v", err)
+	}
+	return pattern, nil
+}
+
+// findLines returns the indexes of the lines matching pattern
+func findLines(lines []string, pattern *regexp.Regexp) []int {
+	var matches []int
+	for i, line := range lines {
+		if pattern.MatchString(line) {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+// nextMatch returns the position in matches of the match after line, or before
+// it going backwards, wrapping around; -1 when there are no matches
+func nextMatch(matches []int, line int, forward bool) int {
+	if len(matches) == 0 {
+		return -1
+	}
+	if forward {
+		for i, match := range matches {
+			if match > line {
+				return i
+			}
+		}
+		return 0
+	}
+	for i := len(matches) - 1; i >= 0; i-- {
+		if matches[i] < line {
+			return i
+		}
+	}
+	return len(matches) - 1
+}
+
+// SessionLog appends the output of a terminal session to a log file of its host,
+// as plain text with every line timestamped
+type SessionLog struct {
+	Path   string
+	file   *os.File
+	text   plainText
+	closed bool
+	mutex  sync.Mutex
+}
+
+// OpenSessionLog opens the log file of host in dir, <host>.log, and marks the
+// start of a session in it
+func OpenSessionLog(dir, host, title string) (*SessionLog, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log folder: %v", err)
+	}
+	name := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(host)
+	path := filepath.Join(dir, name+".log")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %v", err)
+	}
+	log := &SessionLog{Path: path, file: file}
+	log.writeLine(fmt.Sprintf("--- session started: %s ---", title))
+	return log, nil
+}
+
+// Write logs terminal output, lines are written once ended
+func (l *SessionLog) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return len(p), nil
+	}
+	l.text.feed(p, l.writeLine)
+	return len(p), nil
+}
+
+func (l *SessionLog) writeLine(line string) {
+	if _, err := fmt.Fprintf(l.file, "%s %s\n", time.Now().Format("2006-01-02 15:04:05"), line); err != nil {
+		fmt.Printf("Failed to write session log %s: %v\n", l.Path, err)
+	}
+}
+
+// Close writes the last line and closes the log file, it may be called more
+// than once
+func (l *SessionLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if text := l.text.text(); text != "" {
+		l.writeLine(text)
+	}
+	l.writeLine("--- session ended ---")
+	return l.file.Close()
+}
+
+// loggedReader copies what is read to a session log and closes the log once
+// the session output ends
+type loggedReader struct {
+	reader io.Reader
+	log    *SessionLog
+}
+
+func (lr *loggedReader) Read(p []byte) (int, error) {
+	n, err := lr.reader.Read(p)
+	if n > 0 {
+		lr.log.Write(p[:n])
+	}
+	if err != nil {
+		lr.log.Close()
+	}
+	return n, err
+}
+
+// logOutput logs the output of a session of conn when logging is on
+func (conn *SSHConnection) logOutput(stdout io.Reader) io.Reader {
+	dir := SessionLogDir()
+	if dir == "" {
+		return stdout
+	}
+	title := fmt.Sprintf("%s@%s", conn.Config.Username, conn.Config.Host)
+	log, err := OpenSessionLog(dir, conn.Config.Host, title)
+	if err != nil {
+		fmt.Printf("Failed to log session on %s: %v\n", conn.Config.Host, err)
+		return stdout
+	}
+	return &loggedReader{reader: stdout, log: log}
+}
@@ -0,0 +1,395 @@
+package pssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Protocol is the transport used to reach a device
+type Protocol string
+
+const (
+	ProtocolSSH       Protocol = "ssh"
+	ProtocolTelnet    Protocol = "telnet"
+	ProtocolMACTelnet Protocol = "mactelnet"
+	ProtocolAPI       Protocol = "api"     // RouterOS API, commands only
+	ProtocolAPITLS    Protocol = "api-ssl" // RouterOS API over TLS
+)
+
+// shellPrompt matches the last line of a shell or RouterOS prompt
+var shellPrompt = regexp.MustCompile(`[>#$%]\s*$`)
+
+// InteractiveSession is an interactive shell on a device. *ssh.Session implements it,
+// so terminals work the same way over SSH, telnet and MAC-Telnet.
+type InteractiveSession interface {
+	RequestPty(term string, rows, cols int, modes ssh.TerminalModes) error
+	Setenv(name, value string) error
+	StdinPipe() (io.WriteCloser, error)
+	StdoutPipe() (io.Reader, error)
+	Shell() error
+	WindowChange(rows, cols int) error
+	Wait() error
+	Close() error
+}
+
+// shellConn is a logged in shell reached without SSH
+type shellConn interface {
+	io.ReadWriteCloser
+	WindowChange(rows, cols int) error
+}
+
+// shellDialer logs in to a shell with the given terminal and returns everything the
+// device printed up to the prompt
+type shellDialer func(ctx context.Context, termType string, rows, cols int) (shellConn, string, error)
+
+// usesSSH reports whether the config reaches the device over SSH, which is needed
+// for SFTP, port forwarding and keepalives
+func (config ConnectionConfig) usesSSH() bool {
+	return config.Protocol == "" || config.Protocol == ProtocolSSH
+}
+
+// shellDialer returns how to log in for protocols without SSH sessions, nil for SSH
+func (conn *SSHConnection) shellDialer() shellDialer {
+	config := conn.Config
+	switch config.Protocol {
+	case ProtocolTelnet:
+		return func(ctx context.Context, termType string, rows, cols int) (shellConn, string, error) {
+			telnet, transcript, err := dialTelnet(ctx, config, termType, rows, cols)
+			if err != nil {
+				return nil, transcript, err
+			}
+			return telnet, transcript, nil
+		}
+	case ProtocolMACTelnet:
+		return func(ctx context.Context, termType string, rows, cols int) (shellConn, string, error) {
+			mac, transcript, err := dialMACTelnet(ctx, config, termType, rows, cols)
+			if err != nil {
+				return nil, transcript, err
+			}
+			return mac, transcript, nil
+		}
+	}
+	return nil
+}
+
+// OpenInteractiveSession creates a session for an interactive shell, an SSH session
+// or a new telnet login depending on the protocol of the connection
+func (conn *SSHConnection) OpenInteractiveSession() (InteractiveSession, error) {
+	if conn.Config.usesAPI() {
+		return nil, fmt.Errorf("interactive shells are not available over the RouterOS API")
+	}
+	if dial := conn.shellDialer(); dial != nil {
+		if !conn.IsConnected() {
+			return nil, fmt.Errorf("connection not established")
+		}
+		return newShellSession(dial), nil
+	}
+
+	session, err := conn.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// connectShell checks that the device accepts the credentials. Shell protocols keep
+// no connection open, every shell and command logs in on its own.
+// The caller must hold the write lock.
+func (conn *SSHConnection) connectShell(dial shellDialer) error {
+	shell, _, err := dial(context.Background(), "dumb", 24, 80)
+	if err != nil {
+		conn.Error = err
+		conn.Connected = false
+		return err
+	}
+	shell.Close()
+
+	conn.Connected = true
+	conn.Error = nil
+	return nil
+}
+
+// runShell logs in, escalates if become is set, runs a command at the shell prompt
+// and copies its output until the prompt comes back. A script of several lines is
+// sent one line at a time, each once the prompt is back. There is no exit status,
+// only failures to log in, to escalate or of the connection are errors.
+func runShell(ctx context.Context, dial shellDialer, command string, stdout io.Writer, timeout time.Duration, become *escalation) error {
+	shell, transcript, err := dial(ctx, "dumb", 24, 200)
+	if err != nil {
+		if ctx.Err() != nil {
+			return commandCancelled(ctx.Err(), timeout)
+		}
+		return err
+	}
+	defer shell.Close()
+
+	// Closing the connection interrupts the read below
+	stop := context.AfterFunc(ctx, func() {
+		shell.Close()
+	})
+	defer stop()
+
+	prompt := lastLine(transcript)
+	if become != nil {
+		if prompt, err = become.run(shell, transcript); err != nil {
+			if ctx.Err() != nil {
+				return commandCancelled(ctx.Err(), timeout)
+			}
+			return err
+		}
+	}
+
+	output := &shellOutput{writer: stdout, prompt: promptPrefix(prompt)}
+	buf := make([]byte, 4096)
+	for _, line := range strings.Split(command, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if _, err := shell.Write([]byte(line + "\r\n")); err != nil {
+			return fmt.Errorf("failed to run command: %w", err)
+		}
+
+		output.next(line)
+		for {
+			n, err := shell.Read(buf)
+			if output.write(buf[:n]) {
+				break
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return commandCancelled(ctx.Err(), timeout)
+				}
+				output.flush()
+				if err == io.EOF {
+					return nil // The command ended the session, e.g. exit or reboot
+				}
+				return fmt.Errorf("failed to run command: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// promptPrefix returns the part of a shell prompt that stays the same when the
+// current directory or menu changes, e.g. "[admin@MikroTik]" or "user@host"
+func promptPrefix(prompt string) string {
+	prefix := strings.TrimSpace(strings.TrimRight(prompt, ">#$% "))
+	if i := strings.IndexAny(prefix, " :(/"); i > 0 {
+		prefix = prefix[:i]
+	}
+	return prefix
+}
+
+// shellOutput passes on the output of the lines of a script, dropping the echo of
+// each line and the prompt printed when it is done
+type shellOutput struct {
+	writer  io.Writer
+	command string
+	prompt  string
+	pending []byte
+	echoed  bool
+}
+
+// next starts the output of the next line of the script, the prompt before it is dropped
+func (o *shellOutput) next(command string) {
+	o.command = command
+	o.pending = nil
+	o.echoed = false
+}
+
+// write passes on complete lines and reports whether the prompt came back
+func (o *shellOutput) write(p []byte) bool {
+	o.pending = append(o.pending, p...)
+	for {
+		i := bytes.IndexByte(o.pending, '\n')
+		if i < 0 {
+			break
+		}
+		line := o.pending[:i+1]
+		o.pending = o.pending[i+1:]
+		if !o.echoed {
+			o.echoed = true
+			if strings.Contains(string(line), o.command) {
+				continue
+			}
+		}
+		o.writer.Write(append(bytes.TrimRight(line, "\r\n"), '\n'))
+	}
+
+	prompt := lastLine(string(o.pending))
+	return shellPrompt.MatchString(prompt) && strings.HasPrefix(prompt, o.prompt)
+}
+
+// flush passes on an unterminated last line
+func (o *shellOutput) flush() {
+	if len(o.pending) > 0 {
+		o.writer.Write(o.pending)
+		o.pending = nil
+	}
+}
+
+// shellSession is an interactive telnet or MAC-Telnet shell behaving like an SSH
+// session: the terminal is set up first, the login happens when the shell is started
+type shellSession struct {
+	dial     shellDialer
+	termType string
+	rows     int
+	cols     int
+	conn     shellConn
+	stdout   *io.PipeReader
+	output   *io.PipeWriter
+	done     chan struct{}
+	err      error
+	closed   bool
+	mutex    sync.Mutex
+}
+
+// newShellSession creates a session that logs in when Shell is called
+func newShellSession(dial shellDialer) *shellSession {
+	stdout, output := io.Pipe()
+	return &shellSession{
+		dial:     dial,
+		termType: "xterm",
+		rows:     24,
+		cols:     80,
+		stdout:   stdout,
+		output:   output,
+		done:     make(chan struct{}),
+	}
+}
+
+// RequestPty sets the terminal type and size announced to the server
+func (s *shellSession) RequestPty(term string, rows, cols int, modes ssh.TerminalModes) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.termType, s.rows, s.cols = term, rows, cols
+	return nil
+}
+
+// Setenv fails, telnet logins take no environment variables
+func (s *shellSession) Setenv(name, value string) error {
+	return fmt.Errorf("environment variables are not supported over telnet")
+}
+
+// StdinPipe returns a writer to the shell. Input typed before the login is done is dropped.
+func (s *shellSession) StdinPipe() (io.WriteCloser, error) {
+	return shellStdin{s}, nil
+}
+
+// StdoutPipe returns the output of the shell, starting with the login banner
+func (s *shellSession) StdoutPipe() (io.Reader, error) {
+	return s.stdout, nil
+}
+
+// Shell logs in and starts copying the output of the shell
+func (s *shellSession) Shell() error {
+	s.mutex.Lock()
+	termType, rows, cols := s.termType, s.rows, s.cols
+	s.mutex.Unlock()
+
+	conn, transcript, err := s.dial(context.Background(), termType, rows, cols)
+	if err != nil {
+		go func() {
+			fmt.Fprintf(s.output, "%s\r\n%v\r\n", transcript, err)
+			s.finish(err)
+		}()
+		return err
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		conn.Close()
+		return fmt.Errorf("session closed")
+	}
+	s.conn = conn
+	s.mutex.Unlock()
+
+	go func() {
+		_, err := io.WriteString(s.output, transcript)
+		if err == nil {
+			_, err = io.Copy(s.output, conn)
+		}
+		s.finish(err)
+	}()
+	return nil
+}
+
+// finish ends the output and wakes up Wait
+func (s *shellSession) finish(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	s.err = err
+	s.output.CloseWithError(io.EOF)
+	close(s.done)
+}
+
+// WindowChange sends the new terminal size
+func (s *shellSession) WindowChange(rows, cols int) error {
+	s.mutex.Lock()
+	s.rows, s.cols = rows, cols
+	conn := s.conn
+	s.mutex.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.WindowChange(rows, cols)
+}
+
+// Wait waits until the shell ends
+func (s *shellSession) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Close logs out by closing the connection
+func (s *shellSession) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	conn := s.conn
+	s.mutex.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+	s.finish(nil)
+	return nil
+}
+
+// shellStdin writes terminal input to the shell connection
+type shellStdin struct {
+	session *shellSession
+}
+
+func (w shellStdin) Write(p []byte) (int, error) {
+	w.session.mutex.Lock()
+	conn := w.session.conn
+	closed := w.session.closed
+	w.session.mutex.Unlock()
+
+	if closed {
+		return 0, io.ErrClosedPipe
+	}
+	if conn == nil {
+		return len(p), nil
+	}
+	return conn.Write(p)
+}
+
+func (w shellStdin) Close() error {
+	return w.session.Close()
+}
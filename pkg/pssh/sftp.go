@@ -0,0 +1,270 @@
+package pssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// ProgressFunc reports the bytes transferred so far and the size of the file
+type ProgressFunc func(transferred, total int64)
+
+// TransferOptions controls a file transfer
+type TransferOptions struct {
+	Resume   bool         // Continue a partial transfer when the destination is shorter than the source
+	Progress ProgressFunc // Optional: called as data is transferred
+}
+
+// RemoteFile describes an entry of a remote directory
+type RemoteFile struct {
+	Name    string
+	Path    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	IsDir   bool
+}
+
+// SFTPClient is an SFTP session on an SSH connection
+type SFTPClient struct {
+	Host   string
+	client *sftp.Client
+}
+
+// OpenSFTP starts an SFTP session on the connection. The session must be closed when done.
+func (conn *SSHConnection) OpenSFTP() (*SFTPClient, error) {
+	conn.mutex.RLock()
+	client := conn.Client
+	connected := conn.Connected
+	conn.mutex.RUnlock()
+
+	if !conn.Config.usesSSH() {
+		return nil, fmt.Errorf("SFTP is not available over %s", conn.Config.Protocol)
+	}
+	if !connected || client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+
+	return &SFTPClient{Host: conn.Config.Host, client: sftpClient}, nil
+}
+
+// Close ends the SFTP session
+func (c *SFTPClient) Close() error {
+	return c.client.Close()
+}
+
+// Getwd returns the remote working directory, usually the home directory of the user
+func (c *SFTPClient) Getwd() (string, error) {
+	return c.client.Getwd()
+}
+
+// List returns the entries of a remote directory, directories first
+func (c *SFTPClient) List(dir string) ([]RemoteFile, error) {
+	infos, err := c.client.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+
+	files := make([]RemoteFile, len(infos))
+	for i, info := range infos {
+		files[i] = remoteFile(path.Join(dir, info.Name()), info)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].IsDir != files[j].IsDir {
+			return files[i].IsDir
+		}
+		return files[i].Name < files[j].Name
+	})
+	return files, nil
+}
+
+// Stat describes a remote file
+func (c *SFTPClient) Stat(remotePath string) (RemoteFile, error) {
+	info, err := c.client.Stat(remotePath)
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("failed to stat %s: %w", remotePath, err)
+	}
+	return remoteFile(remotePath, info), nil
+}
+
+// Mkdir creates a remote directory
+func (c *SFTPClient) Mkdir(remotePath string) error {
+	if err := c.client.Mkdir(remotePath); err != nil {
+		return fmt.Errorf("failed to create %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// Rename moves a remote file or directory
+func (c *SFTPClient) Rename(oldPath, newPath string) error {
+	if err := c.client.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", oldPath, err)
+	}
+	return nil
+}
+
+// Remove deletes a remote file or empty directory
+func (c *SFTPClient) Remove(remotePath string) error {
+	if err := c.client.Remove(remotePath); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// Get downloads a remote file to localPath
+func (c *SFTPClient) Get(ctx context.Context, remotePath, localPath string, options TransferOptions) error {
+	remote, err := c.client.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", remotePath, err)
+	}
+	defer remote.Close()
+
+	info, err := remote.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", remotePath, err)
+	}
+	total := info.Size()
+
+	offset := int64(0)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if options.Resume {
+		if local, err := os.Stat(localPath); err == nil && local.Size() <= total {
+			offset = local.Size()
+			flags = os.O_WRONLY | os.O_APPEND
+		}
+	}
+
+	local, err := os.OpenFile(localPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer local.Close()
+
+	if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to resume %s: %w", remotePath, err)
+	}
+
+	writer := &progressWriter{ctx: ctx, writer: local, done: offset, total: total, progress: options.Progress}
+	writer.report()
+	if _, err := remote.WriteTo(writer); err != nil {
+		return fmt.Errorf("failed to download %s: %w", remotePath, err)
+	}
+	return local.Close()
+}
+
+// Put uploads a local file to remotePath
+func (c *SFTPClient) Put(ctx context.Context, localPath, remotePath string, options TransferOptions) error {
+	local, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer local.Close()
+
+	info, err := local.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", localPath, err)
+	}
+	total := info.Size()
+
+	offset := int64(0)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if options.Resume {
+		if remote, err := c.client.Stat(remotePath); err == nil && remote.Size() <= total {
+			offset = remote.Size()
+			flags = os.O_WRONLY | os.O_CREATE
+		}
+	}
+
+	remote, err := c.client.OpenFile(remotePath, flags)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", remotePath, err)
+	}
+	defer remote.Close()
+
+	if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to resume %s: %w", remotePath, err)
+	}
+	if _, err := local.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to resume %s: %w", localPath, err)
+	}
+
+	reader := &progressReader{ctx: ctx, reader: local, done: offset, total: total, progress: options.Progress}
+	reader.report()
+	if _, err := remote.ReadFrom(reader); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", localPath, err)
+	}
+	return remote.Close()
+}
+
+// remoteFile converts file info of an SFTP entry
+func remoteFile(remotePath string, info os.FileInfo) RemoteFile {
+	return RemoteFile{
+		Name:    info.Name(),
+		Path:    remotePath,
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+}
+
+// progressReader reports read progress and stops once ctx is done
+type progressReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.reader.Read(p)
+	r.done += int64(n)
+	r.report()
+	return n, err
+}
+
+func (r *progressReader) report() {
+	if r.progress != nil {
+		r.progress(r.done, r.total)
+	}
+}
+
+// progressWriter reports write progress and stops once ctx is done
+type progressWriter struct {
+	ctx      context.Context
+	writer   io.Writer
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := w.writer.Write(p)
+	w.done += int64(n)
+	w.report()
+	return n, err
+}
+
+func (w *progressWriter) report() {
+	if w.progress != nil {
+		w.progress(w.done, w.total)
+	}
+}
@@ -0,0 +1,508 @@
+package pssh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sshConfigPath is the OpenSSH client config consulted by NewConnectionConfig and
+// ApplySSHConfig, connections read it while the settings may change it
+var sshConfigPath = struct {
+	path  string
+	mutex sync.RWMutex
+}{path: DefaultSSHConfigPath()}
+
+// SSHHostConfig holds the OpenSSH client settings resolved for a host
+type SSHHostConfig struct {
+	HostName       string
+	User           string
+	Port           int
+	IdentityFiles  []string
+	ProxyJump      string
+	ConnectTimeout time.Duration
+}
+
+// SSHConfig is a parsed OpenSSH client configuration file
+type SSHConfig struct {
+	blocks []*sshConfigBlock
+}
+
+// sshConfigBlock is a Host or Match section. The leading global section has neither.
+type sshConfigBlock struct {
+	hosts   []string   // Host patterns
+	match   [][]string // Match criteria, each with its arguments
+	options [][2]string
+}
+
+// sshConfigCache keeps the parsed default config until the file changes
+var sshConfigCache struct {
+	path    string
+	modTime time.Time
+	config  *SSHConfig
+	mutex   sync.Mutex
+}
+
+// SetSSHConfigPath sets the OpenSSH client config consulted by NewConnectionConfig
+// and ApplySSHConfig. An empty path ignores the OpenSSH config.
+func SetSSHConfigPath(path string) {
+	sshConfigPath.mutex.Lock()
+	defer sshConfigPath.mutex.Unlock()
+	sshConfigPath.path = path
+}
+
+// SSHConfigPath returns the OpenSSH client config set with SetSSHConfigPath
+func SSHConfigPath() string {
+	sshConfigPath.mutex.RLock()
+	defer sshConfigPath.mutex.RUnlock()
+	return sshConfigPath.path
+}
+
+// DefaultSSHConfigPath returns the OpenSSH client config location of the current user
+func DefaultSSHConfigPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".ssh", "config")
+}
+
+// LoadSSHConfig reads and parses an OpenSSH client config file, following Include directives
+func LoadSSHConfig(path string) (*SSHConfig, error) {
+	config := &SSHConfig{blocks: []*sshConfigBlock{{}}}
+	if err := config.parseFile(path, 0); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ParseSSHConfig parses an OpenSSH client config. Include directives are resolved relative to ~/.ssh.
+func ParseSSHConfig(r io.Reader) (*SSHConfig, error) {
+	config := &SSHConfig{blocks: []*sshConfigBlock{{}}}
+	if err := config.parse(r, 0); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// parseFile parses a config file into the current block list
+func (c *SSHConfig) parseFile(path string, depth int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := c.parse(file, depth); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// parse reads config lines, appending Host and Match sections as they start
+func (c *SSHConfig) parse(r io.Reader, depth int) error {
+	if depth > 16 {
+		return fmt.Errorf("too many nested includes")
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		keyword, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			if len(args) == 0 {
+				return fmt.Errorf("line %d: Host requires at least one pattern", lineNumber)
+			}
+			c.blocks = append(c.blocks, &sshConfigBlock{hosts: args})
+
+		case "match":
+			criteria, err := parseMatchCriteria(args)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			c.blocks = append(c.blocks, &sshConfigBlock{match: criteria})
+
+		case "include":
+			for _, pattern := range args {
+				pattern = expandSSHConfigPath(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(DefaultSSHConfigPath()), pattern)
+				}
+				matches, _ := filepath.Glob(pattern)
+				for _, match := range matches {
+					if err := c.parseFile(match, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+
+		default:
+			if len(args) == 0 {
+				return fmt.Errorf("line %d: %s requires an argument", lineNumber, keyword)
+			}
+			block := c.blocks[len(c.blocks)-1]
+			block.options = append(block.options, [2]string{keyword, strings.Join(args, " ")})
+		}
+	}
+
+	return scanner.Err()
+}
+
+// splitSSHConfigLine splits a config line into a lower-cased keyword and its arguments
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	// The keyword may be separated from its arguments by whitespace or a single '='
+	end := strings.IndexAny(line, " \t=")
+	if end == -1 {
+		return strings.ToLower(line), nil, nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimSpace(line[end:])
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+
+	var args []string
+	var current strings.Builder
+	inQuotes := false
+	hasArg := false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if inQuotes {
+		return "", nil, fmt.Errorf("unterminated quote")
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+
+	return keyword, args, nil
+}
+
+// parseMatchCriteria groups Match arguments into criteria with their pattern lists
+func parseMatchCriteria(args []string) ([][]string, error) {
+	var criteria [][]string
+	for i := 0; i < len(args); i++ {
+		name := strings.ToLower(args[i])
+		switch strings.TrimPrefix(name, "!") {
+		case "all", "canonical", "final":
+			criteria = append(criteria, []string{name})
+		case "host", "originalhost", "user", "localuser", "exec", "localnetwork", "tagged":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("Match %s requires an argument", name)
+			}
+			criteria = append(criteria, []string{name, args[i+1]})
+			i++
+		default:
+			return nil, fmt.Errorf("unsupported Match criterion %q", args[i])
+		}
+	}
+	return criteria, nil
+}
+
+// Resolve returns the settings that apply to host. As in OpenSSH, the first value
+// obtained for each option wins, except IdentityFile which accumulates.
+func (c *SSHConfig) Resolve(host string) SSHHostConfig {
+	var result SSHHostConfig
+	seen := make(map[string]bool)
+
+	for _, block := range c.blocks {
+		if !block.matches(host, result) {
+			continue
+		}
+
+		for _, option := range block.options {
+			keyword, value := option[0], option[1]
+			if keyword != "identityfile" && seen[keyword] {
+				continue
+			}
+
+			switch keyword {
+			case "hostname":
+				result.HostName = expandSSHConfigTokens(value, host, result)
+			case "user":
+				result.User = value
+			case "port":
+				if port, err := strconv.Atoi(value); err == nil && port > 0 && port <= 65535 {
+					result.Port = port
+				}
+			case "identityfile":
+				result.IdentityFiles = append(result.IdentityFiles, value)
+			case "proxyjump":
+				result.ProxyJump = value
+			case "connecttimeout":
+				if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+					result.ConnectTimeout = time.Duration(seconds) * time.Second
+				}
+			default:
+				continue
+			}
+			seen[keyword] = true
+		}
+	}
+
+	// Identity files are expanded last so %r and %p see the final user and port
+	for i, identityFile := range result.IdentityFiles {
+		result.IdentityFiles[i] = expandSSHConfigPath(expandSSHConfigTokens(identityFile, host, result))
+	}
+
+	return result
+}
+
+// matches reports whether the block applies to host given the settings resolved so far
+func (block *sshConfigBlock) matches(host string, resolved SSHHostConfig) bool {
+	if block.hosts != nil {
+		return matchHostPatterns(host, block.hosts)
+	}
+
+	for _, criterion := range block.match {
+		name := criterion[0]
+		negate := strings.HasPrefix(name, "!")
+		name = strings.TrimPrefix(name, "!")
+
+		var matched bool
+		switch name {
+		case "all", "final":
+			matched = true
+		case "canonical", "exec", "localnetwork", "tagged":
+			// Canonicalization, commands, network probes and tags are not supported
+			matched = false
+		case "host":
+			target := host
+			if resolved.HostName != "" {
+				target = resolved.HostName
+			}
+			matched = matchHostPatterns(target, strings.Split(criterion[1], ","))
+		case "originalhost":
+			matched = matchHostPatterns(host, strings.Split(criterion[1], ","))
+		case "user":
+			remoteUser := resolved.User
+			if remoteUser == "" {
+				remoteUser = localUsername()
+			}
+			matched = matchHostPatterns(remoteUser, strings.Split(criterion[1], ","))
+		case "localuser":
+			matched = matchHostPatterns(localUsername(), strings.Split(criterion[1], ","))
+		}
+
+		if matched == negate {
+			return false
+		}
+	}
+	return true
+}
+
+// matchHostPatterns matches a host against a pattern list. A negated pattern
+// that matches rejects the host regardless of the other patterns.
+func matchHostPatterns(host string, patterns []string) bool {
+	host = strings.ToLower(host)
+	matched := false
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if strings.HasPrefix(pattern, "!") {
+			if matchWildcard(pattern[1:], host) {
+				return false
+			}
+			continue
+		}
+		if matchWildcard(pattern, host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchWildcard matches s against a pattern using OpenSSH '*' and '?' wildcards
+func matchWildcard(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchWildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
+// expandSSHConfigTokens expands the %h, %n, %r, %p, %u, %d and %% tokens
+func expandSSHConfigTokens(value, host string, resolved SSHHostConfig) string {
+	if !strings.Contains(value, "%") {
+		return value
+	}
+
+	hostName := host
+	if resolved.HostName != "" {
+		hostName = resolved.HostName
+	}
+	remoteUser := resolved.User
+	if remoteUser == "" {
+		remoteUser = localUsername()
+	}
+	port := resolved.Port
+	if port == 0 {
+		port = 22
+	}
+	homeDir, _ := os.UserHomeDir()
+
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%h", hostName,
+		"%n", host,
+		"%r", remoteUser,
+		"%p", strconv.Itoa(port),
+		"%u", localUsername(),
+		"%d", homeDir,
+	)
+	return replacer.Replace(value)
+}
+
+// expandSSHConfigPath expands a leading ~ in a config path
+func expandSSHConfigPath(path string) string {
+	if expanded, err := expandHomePath(path); err == nil {
+		return expanded
+	}
+	return path
+}
+
+// localUsername returns the name of the user running the client
+func localUsername() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+// loadDefaultSSHConfig returns the parsed config at SSHConfigPath, or nil if there is none
+func loadDefaultSSHConfig() *SSHConfig {
+	path := SSHConfigPath()
+	if path == "" {
+		return nil
+	}
+
+	sshConfigCache.mutex.Lock()
+	defer sshConfigCache.mutex.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if sshConfigCache.config != nil && sshConfigCache.path == path && sshConfigCache.modTime.Equal(info.ModTime()) {
+		return sshConfigCache.config
+	}
+
+	config, err := LoadSSHConfig(path)
+	if err != nil {
+		fmt.Printf("Warning: failed to parse SSH config %s: %v\n", path, err)
+		return nil
+	}
+
+	sshConfigCache.path = path
+	sshConfigCache.modTime = info.ModTime()
+	sshConfigCache.config = config
+	return config
+}
+
+// ApplySSHConfig fills the fields of config that are still unset with the
+// settings the OpenSSH client config at SSHConfigPath has for its host.
+func ApplySSHConfig(config *ConnectionConfig) {
+	if sshConfig := loadDefaultSSHConfig(); sshConfig != nil {
+		sshConfig.Apply(config)
+	}
+}
+
+// Apply fills the unset fields of config from the settings resolved for its host.
+// Jump hosts, whether configured or taken from ProxyJump, are resolved the same way.
+func (c *SSHConfig) Apply(config *ConnectionConfig) {
+	c.apply(config, 0)
+}
+
+// apply resolves a config and its jump hosts, limiting ProxyJump recursion
+func (c *SSHConfig) apply(config *ConnectionConfig, depth int) {
+	resolved := c.Resolve(config.Host)
+
+	if config.HostName == "" && resolved.HostName != "" && resolved.HostName != config.Host {
+		config.HostName = resolved.HostName
+	}
+	if config.Username == "" {
+		config.Username = resolved.User
+	}
+	if config.Port == 0 {
+		config.Port = resolved.Port
+	}
+	if config.Timeout == 0 {
+		config.Timeout = resolved.ConnectTimeout
+	}
+	if config.KeyPath == "" && len(config.PrivateKey) == 0 {
+		for _, identityFile := range resolved.IdentityFiles {
+			if _, err := os.Stat(identityFile); err == nil {
+				config.KeyPath = identityFile
+				break
+			}
+		}
+	}
+
+	if len(config.Via) == 0 && resolved.ProxyJump != "" && depth < 8 {
+		hops, err := ParseJumpHosts(resolved.ProxyJump, 0)
+		if err != nil {
+			fmt.Printf("Warning: ignoring ProxyJump for %s: %v\n", config.Host, err)
+		}
+		config.Via = hops
+	}
+
+	// Resolve every hop, expanding the hop's own ProxyJump in front of it
+	var chain []ConnectionConfig
+	for _, hop := range config.Via {
+		if depth < 8 {
+			c.apply(&hop, depth+1)
+		}
+		chain = append(chain, hop.Via...)
+		hop.Via = nil
+		chain = append(chain, hop)
+	}
+	config.Via = chain
+}
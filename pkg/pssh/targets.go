@@ -0,0 +1,96 @@
+package pssh
+
+import "sync"
+
+// inputTargets tracks which sessions of a multi-host terminal receive keystrokes
+type inputTargets struct {
+	mutex     sync.Mutex
+	enabled   []bool
+	focused   int // Session that has the input to itself, -1 when broadcasting
+	listeners []func()
+}
+
+func newInputTargets(sessions int) *inputTargets {
+	targets := &inputTargets{enabled: make([]bool, sessions), focused: -1}
+	for i := range targets.enabled {
+		targets.enabled[i] = true
+	}
+	return targets
+}
+
+// isEnabled reports whether session i receives keystrokes
+func (t *inputTargets) isEnabled(i int) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return i >= 0 && i < len(t.enabled) && t.enabled[i]
+}
+
+// snapshot returns which sessions receive keystrokes and the focused one
+func (t *inputTargets) snapshot() ([]bool, int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]bool(nil), t.enabled...), t.focused
+}
+
+// last returns the last session receiving keystrokes, -1 when there is none
+func (t *inputTargets) last() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i := len(t.enabled) - 1; i >= 0; i-- {
+		if t.enabled[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// set turns the input of session i on or off, leaving focus mode
+func (t *inputTargets) set(i int, enabled bool) {
+	t.update(func() {
+		if i >= 0 && i < len(t.enabled) {
+			t.enabled[i] = enabled
+			t.focused = -1
+		}
+	})
+}
+
+// focus sends keystrokes to session i only
+func (t *inputTargets) focus(i int) {
+	t.update(func() {
+		if i < 0 || i >= len(t.enabled) {
+			return
+		}
+		for j := range t.enabled {
+			t.enabled[j] = j == i
+		}
+		t.focused = i
+	})
+}
+
+// broadcast sends keystrokes to all sessions again
+func (t *inputTargets) broadcast() {
+	t.update(func() {
+		for i := range t.enabled {
+			t.enabled[i] = true
+		}
+		t.focused = -1
+	})
+}
+
+// onChange registers a function called after every change of the targets
+func (t *inputTargets) onChange(listener func()) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.listeners = append(t.listeners, listener)
+}
+
+func (t *inputTargets) update(change func()) {
+	t.mutex.Lock()
+	change()
+	listeners := append([]func(){}, t.listeners...)
+	t.mutex.Unlock()
+
+	for _, listener := range listeners {
+		listener()
+	}
+}
@@ -0,0 +1,343 @@
+package pssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Telnet commands and options (RFC 854, 1073, 1091)
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptEcho  = 1
+	telnetOptSGA   = 3
+	telnetOptTType = 24
+	telnetOptNAWS  = 31
+)
+
+// Decoder states of TelnetConn
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSub
+	telnetStateSubIAC
+)
+
+var (
+	telnetLoginPrompt    = regexp.MustCompile(`(?i)(login|username|user name|user)\s*:\s*$`)
+	telnetPasswordPrompt = regexp.MustCompile(`(?i)password\s*:\s*$`)
+	ansiEscape           = regexp.MustCompile(`\x1b(\[[0-9;?]*[A-Za-z]|[()][A-Za-z0-9]|[A-Za-z=>])`)
+)
+
+// TelnetConn is a telnet client connection. Reads return the data sent by the
+// server with option negotiation handled, writes are escaped as NVT data.
+type TelnetConn struct {
+	conn     net.Conn
+	termType string
+	rows     int
+	cols     int
+	local    map[byte]bool // Options enabled on our side
+	remote   map[byte]bool // Options enabled on the server side
+	readBuf  []byte
+	state    int
+	command  byte
+	sub      []byte
+	lastCR   bool
+	mutex    sync.Mutex
+}
+
+// NewTelnetConn wraps a network connection to a telnet server. The terminal type and
+// window size are sent when the server asks for them.
+func NewTelnetConn(conn net.Conn, termType string, rows, cols int) *TelnetConn {
+	return &TelnetConn{
+		conn:     conn,
+		termType: termType,
+		rows:     rows,
+		cols:     cols,
+		local:    make(map[byte]bool),
+		remote:   make(map[byte]bool),
+		readBuf:  make([]byte, 4096),
+	}
+}
+
+// Read reads data sent by the server, answering telnet commands on the way
+func (t *TelnetConn) Read(p []byte) (int, error) {
+	for {
+		size := min(len(p), len(t.readBuf))
+		n, err := t.conn.Read(t.readBuf[:size])
+		out := t.decode(t.readBuf[:n], p)
+		if out > 0 || err != nil {
+			return out, err
+		}
+	}
+}
+
+// decode copies data bytes from in to out and handles telnet commands
+func (t *TelnetConn) decode(in, out []byte) int {
+	n := 0
+	for _, b := range in {
+		switch t.state {
+		case telnetStateData:
+			if b == telnetIAC {
+				t.state = telnetStateIAC
+				continue
+			}
+			// CR NUL stands for a bare carriage return
+			if t.lastCR && b == 0 {
+				t.lastCR = false
+				continue
+			}
+			t.lastCR = b == '\r'
+			out[n] = b
+			n++
+		case telnetStateIAC:
+			switch b {
+			case telnetIAC:
+				out[n] = b
+				n++
+				t.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				t.command = b
+				t.state = telnetStateOption
+			case telnetSB:
+				t.sub = t.sub[:0]
+				t.state = telnetStateSub
+			default:
+				// NOP, GA and the other commands carry no data
+				t.state = telnetStateData
+			}
+		case telnetStateOption:
+			t.negotiate(t.command, b)
+			t.state = telnetStateData
+		case telnetStateSub:
+			if b == telnetIAC {
+				t.state = telnetStateSubIAC
+			} else {
+				t.sub = append(t.sub, b)
+			}
+		case telnetStateSubIAC:
+			switch b {
+			case telnetSE:
+				t.subnegotiate(t.sub)
+				t.state = telnetStateData
+			case telnetIAC:
+				t.sub = append(t.sub, telnetIAC)
+				t.state = telnetStateSub
+			default:
+				t.state = telnetStateSub
+			}
+		}
+	}
+	return n
+}
+
+// negotiate answers an option request. Only state changes are answered so
+// the negotiation cannot loop.
+func (t *TelnetConn) negotiate(command, option byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch command {
+	case telnetDO:
+		switch option {
+		case telnetOptNAWS, telnetOptTType, telnetOptSGA:
+			if !t.local[option] {
+				t.local[option] = true
+				t.send(telnetIAC, telnetWILL, option)
+			}
+			if option == telnetOptNAWS {
+				t.sendWindowSize()
+			}
+		default:
+			t.send(telnetIAC, telnetWONT, option)
+		}
+	case telnetDONT:
+		if t.local[option] {
+			t.local[option] = false
+			t.send(telnetIAC, telnetWONT, option)
+		}
+	case telnetWILL:
+		switch option {
+		case telnetOptEcho, telnetOptSGA:
+			if !t.remote[option] {
+				t.remote[option] = true
+				t.send(telnetIAC, telnetDO, option)
+			}
+		default:
+			t.send(telnetIAC, telnetDONT, option)
+		}
+	case telnetWONT:
+		if t.remote[option] {
+			t.remote[option] = false
+			t.send(telnetIAC, telnetDONT, option)
+		}
+	}
+}
+
+// subnegotiate answers the terminal type request
+func (t *TelnetConn) subnegotiate(sub []byte) {
+	if len(sub) < 2 || sub[0] != telnetOptTType || sub[1] != 1 {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	message := []byte{telnetIAC, telnetSB, telnetOptTType, 0}
+	message = append(message, strings.ToUpper(t.termType)...)
+	t.send(append(message, telnetIAC, telnetSE)...)
+}
+
+// WindowChange sends the new window size if the server asked for it (NAWS)
+func (t *TelnetConn) WindowChange(rows, cols int) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.rows, t.cols = rows, cols
+	if !t.local[telnetOptNAWS] {
+		return nil
+	}
+	return t.sendWindowSize()
+}
+
+// sendWindowSize sends the NAWS subnegotiation. The caller must hold the mutex.
+func (t *TelnetConn) sendWindowSize() error {
+	size := []byte{byte(t.cols >> 8), byte(t.cols), byte(t.rows >> 8), byte(t.rows)}
+	message := []byte{telnetIAC, telnetSB, telnetOptNAWS}
+	message = append(message, bytes.ReplaceAll(size, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC})...)
+	return t.send(append(message, telnetIAC, telnetSE)...)
+}
+
+// send writes raw bytes. The caller must hold the mutex.
+func (t *TelnetConn) send(data ...byte) error {
+	_, err := t.conn.Write(data)
+	return err
+}
+
+// Write sends data to the server, doubling IAC bytes and following a bare
+// carriage return with NUL as NVT requires
+func (t *TelnetConn) Write(p []byte) (int, error) {
+	data := make([]byte, 0, len(p)+8)
+	for i, b := range p {
+		switch {
+		case b == telnetIAC:
+			data = append(data, telnetIAC, telnetIAC)
+		case b == '\r' && (i+1 == len(p) || p[i+1] != '\n'):
+			data = append(data, '\r', 0)
+		default:
+			data = append(data, b)
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := t.send(data...); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection
+func (t *TelnetConn) Close() error {
+	return t.conn.Close()
+}
+
+// Login answers the login and password prompts and waits for the shell prompt.
+// It returns everything the device printed, the last line being the prompt.
+func (t *TelnetConn) Login(ctx context.Context, username, password string) (string, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		t.conn.SetReadDeadline(deadline)
+		defer t.conn.SetReadDeadline(time.Time{})
+	}
+	stop := context.AfterFunc(ctx, func() {
+		t.conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	var output strings.Builder
+	buf := make([]byte, 4096)
+	sentUsername, sentPassword := false, false
+	for {
+		n, err := t.Read(buf)
+		output.Write(buf[:n])
+		prompt := lastLine(output.String())
+
+		switch {
+		case n == 0:
+		case telnetPasswordPrompt.MatchString(prompt):
+			if sentPassword {
+				return output.String(), fmt.Errorf("telnet login failed: password rejected")
+			}
+			if _, err := t.Write([]byte(password + "\r\n")); err != nil {
+				return output.String(), fmt.Errorf("telnet login failed: %w", err)
+			}
+			sentPassword = true
+			continue
+		case telnetLoginPrompt.MatchString(prompt):
+			if sentUsername {
+				return output.String(), fmt.Errorf("telnet login failed: login incorrect")
+			}
+			if _, err := t.Write([]byte(username + "\r\n")); err != nil {
+				return output.String(), fmt.Errorf("telnet login failed: %w", err)
+			}
+			sentUsername = true
+			continue
+		case shellPrompt.MatchString(prompt):
+			return output.String(), nil
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			if last := lastLine(strings.TrimRight(output.String(), "\r\n")); sentPassword && last != "" {
+				return output.String(), fmt.Errorf("telnet login failed: %s", last)
+			}
+			return output.String(), fmt.Errorf("telnet login failed: %w", err)
+		}
+	}
+}
+
+// lastLine returns the last line of terminal output without escape sequences
+func lastLine(output string) string {
+	output = ansiEscape.ReplaceAllString(output, "")
+	if i := strings.LastIndexAny(output, "\r\n"); i >= 0 {
+		output = output[i+1:]
+	}
+	return strings.TrimSpace(output)
+}
+
+// dialTelnet connects to the device and logs in with the credentials of the config
+func dialTelnet(ctx context.Context, config ConnectionConfig, termType string, rows, cols int) (*TelnetConn, string, error) {
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	address := config.address()
+	dialer := net.Dialer{}
+	netConn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+
+	telnet := NewTelnetConn(netConn, termType, rows, cols)
+	transcript, err := telnet.Login(ctx, config.Username, config.Password)
+	if err != nil {
+		telnet.Close()
+		return nil, transcript, fmt.Errorf("%s: %w", address, err)
+	}
+	return telnet, transcript, nil
+}
@@ -9,10 +9,9 @@ import (
 	"time"
 
 	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
 	"github.com/fyne-io/terminal"
-	"golang.org/x/crypto/ssh"
 )
 
 var errorPatterns = []*regexp.Regexp{
@@ -26,15 +25,17 @@ var errorPatterns = []*regexp.Regexp{
 	regexp.MustCompile(`(?i)invalid argument`),
 }
 
-// TerminalWidget represents a terminal connected to an SSH session
+// TerminalWidget represents a terminal connected to an SSH or telnet session
 type TerminalWidget struct {
 	Connection *SSHConnection
-	Session    *ssh.Session
+	Session    InteractiveSession
 	Terminal   *terminal.Terminal
-	Window     fyne.Window
-	App        fyne.App
 	StdinPipe  io.WriteCloser
 	StdoutPipe io.Reader
+	Recording  *Recording // nil when the session is not recorded
+	Scrollback *Scrollback
+	view       *terminalView
+	closed     bool
 	mutex      sync.RWMutex
 }
 
@@ -57,21 +58,17 @@ func (tm *TerminalManager) CreateTerminalWidget(conn *SSHConnection, title strin
 		return nil, fmt.Errorf("SSH connection is not established")
 	}
 
-	// Create a new SSH session
-	session, err := conn.CreateSession()
+	// Create a new SSH session, or telnet login
+	session, err := conn.OpenInteractiveSession()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create SSH session: %v", err)
 	}
 
-	// Request a pseudo-terminal with proper settings for interactive shell
-	err = session.RequestPty("xterm-256color", 80, 24, ssh.TerminalModes{
-		ssh.ECHO:          1,     // enable echoing
-		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
-	})
+	// Request a pseudo-terminal as set for the device
+	options, err := conn.requestPty(session)
 	if err != nil {
 		session.Close()
-		return nil, fmt.Errorf("failed to request pty: %v", err)
+		return nil, err
 	}
 
 	// Get stdin and stdout pipes
@@ -87,19 +84,34 @@ func (tm *TerminalManager) CreateTerminalWidget(conn *SSHConnection, title strin
 		return nil, fmt.Errorf("failed to get stdout pipe: %v", err)
 	}
 
-	// Wrap stdout with banner suppression
+	// Wrap stdout with banner suppression, escalating the shell as set for the device
 	customBanner := fmt.Sprintf("Connected to %s (%s)", conn.Config.Host, conn.Config.Username)
-	wrappedStdout := newBanneredReader(stdoutPipe, customBanner)
-
-	// Create Fyne app and window
-	a := app.New()
-	w := a.NewWindow(title)
-	w.Resize(fyne.NewSize(800, 600))
+	recording := conn.startRecording(options.Cols, options.Rows)
+	stdin, stdout := recordPipes(recording, stdinPipe, newBanneredReader(conn.becomeShell(stdinPipe, stdoutPipe), customBanner))
+	stdout = conn.logOutput(stdout)
 
 	// Create terminal widget directly (no fyne.Do needed yet)
 	t := terminal.New()
 	// Force the terminal to initialize its internal structures
-	t.Resize(fyne.NewSize(800, 600))
+	t.Resize(terminalSize(options))
+
+	termWidget := &TerminalWidget{
+		Connection: conn,
+		Session:    session,
+		Terminal:   t,
+		StdinPipe:  stdin,
+		StdoutPipe: stdout,
+		Recording:  recording,
+		Scrollback: NewScrollback(ScrollbackLines()),
+	}
+	// The widget is shown in a tab of the workspace or in a window of its own,
+	// closing either ends the session
+	termWidget.view = newTerminalView(title, styleTerminal(t, conn.Config.Terminal), func() {
+		termWidget.closeSession()
+		tm.removeTerminal(conn.Config.Host, termWidget)
+	})
+	termWidget.view.setScrollback(termWidget.Scrollback)
+	termWidget.view.size = terminalSize(options)
 
 	// Start the SSH shell session
 	go func() {
@@ -115,20 +127,12 @@ func (tm *TerminalManager) CreateTerminalWidget(conn *SSHConnection, title strin
 	}()
 
 	// Connect terminal to SSH session
-	// Set window content directly
-	w.SetContent(t)
-
 	go func() {
-		defer func() {
-			// Use a goroutine to avoid blocking
-			go func() {
-				a.Quit()
-			}()
-		}()
-		err := t.RunWithConnection(stdinPipe, wrappedStdout)
+		err := t.RunWithConnection(stdin, io.TeeReader(stdout, termWidget.Scrollback))
 		if err != nil {
 			fmt.Printf("Terminal connection error: %v\n", err)
 		}
+		fyne.Do(termWidget.view.setEnded)
 	}()
 
 	// Set up dynamic terminal resizing
@@ -145,26 +149,15 @@ func (tm *TerminalManager) CreateTerminalWidget(conn *SSHConnection, title strin
 			if err != nil {
 				fmt.Printf("Failed to resize terminal: %v\n", err)
 			}
+			if recording != nil {
+				recording.Resize(int(cols), int(rows))
+			}
 		}
 	}()
 
 	// Add listener directly (no fyne.Do needed yet)
 	t.AddListener(configChan)
 
-	// Create terminal widget instance
-	termWidget := &TerminalWidget{
-		Connection: conn,
-		Session:    session,
-		Terminal:   t,
-		Window:     w,
-		App:        a,
-		StdinPipe:  stdinPipe,
-		StdoutPipe: wrappedStdout,
-	}
-
-	// Set window content using fyne.Do to ensure UI thread safety - already done above
-	// (window content was set before connecting terminal)
-
 	// Store terminal widget
 	tm.mutex.Lock()
 	tm.terminals[conn.Config.Host] = termWidget
@@ -173,34 +166,46 @@ func (tm *TerminalManager) CreateTerminalWidget(conn *SSHConnection, title strin
 	return termWidget, nil
 }
 
-// ShowTerminal displays the terminal window
+// ShowTerminal displays the terminal in a tab of the terminal workspace.
+// It must be called from the UI thread.
 func (tw *TerminalWidget) ShowTerminal() {
-	// ShowAndRun() should be called directly, not through fyne.Do()
-	tw.Window.ShowAndRun()
+	Workspace().add(tw.view)
 }
 
-// ShowTerminalWindow displays the terminal window without running (for multiple terminals)
+// ShowTerminalWindow displays the terminal in a window of its own, which can be
+// attached to the workspace later. It must be called from the UI thread.
 func (tw *TerminalWidget) ShowTerminalWindow() {
-	// Show() should be called directly, not through fyne.Do()
-	tw.Window.Show()
+	Workspace().addWindow(tw.view)
 }
 
-// Close closes the terminal widget and SSH session
+// Close closes the SSH session and the tab or window of the terminal
 func (tw *TerminalWidget) Close() error {
+	err := tw.closeSession()
+	fyne.Do(func() {
+		Workspace().closeView(tw.view)
+	})
+	return err
+}
+
+// closeSession ends the session and its recording, once
+func (tw *TerminalWidget) closeSession() error {
 	tw.mutex.Lock()
 	defer tw.mutex.Unlock()
+	if tw.closed {
+		return nil
+	}
+	tw.closed = true
 
 	var errs []error
 
 	if tw.Session != nil {
-		if err := tw.Session.Close(); err != nil {
+		if err := tw.Session.Close(); err != nil && err != io.EOF && !isConnectionClosed(err) {
 			errs = append(errs, fmt.Errorf("failed to close SSH session: %v", err))
 		}
 	}
 
-	if tw.Window != nil {
-		// Close window directly
-		tw.Window.Close()
+	if tw.Recording != nil {
+		tw.Recording.Close()
 	}
 
 	if len(errs) > 0 {
@@ -218,6 +223,16 @@ func (tm *TerminalManager) GetTerminal(host string) (*TerminalWidget, bool) {
 	return terminal, exists
 }
 
+// removeTerminal forgets the terminal of host once it was closed, unless it has
+// been replaced by a newer one
+func (tm *TerminalManager) removeTerminal(host string, tw *TerminalWidget) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	if tm.terminals[host] == tw {
+		delete(tm.terminals, host)
+	}
+}
+
 // CloseTerminal closes a specific terminal
 func (tm *TerminalManager) CloseTerminal(host string) error {
 	tm.mutex.Lock()
@@ -264,149 +279,49 @@ func (tm *TerminalManager) ListTerminals() []string {
 	return hosts
 }
 
-// MultiTerminalWindow creates a window with multiple terminals in tabs
-func (tm *TerminalManager) MultiTerminalWindow(connections []*SSHConnection, title string) error {
+// MultiTerminalWindow opens a terminal per connection, each in a tab of the
+// terminal workspace. It must be called from the UI thread.
+func (tm *TerminalManager) MultiTerminalWindow(connections []*SSHConnection) error {
 	if len(connections) == 0 {
 		return fmt.Errorf("no connections provided")
 	}
 
-	// Create main app and window
-	a := app.New()
-	w := a.NewWindow(title)
-	w.Resize(fyne.NewSize(1000, 700))
-
-	// Create tab container for multiple terminals
-	tabs := container.NewAppTabs()
-
-	// Create terminals for each connection
+	opened := 0
 	for _, conn := range connections {
 		if !conn.IsConnected() {
 			continue
 		}
 
-		// Create SSH session
-		session, err := conn.CreateSession()
-		if err != nil {
-			fmt.Printf("Failed to create session for %s: %v\n", conn.Config.Host, err)
-			continue
-		}
-
-		// Request a pseudo-terminal with proper settings for interactive shell
-		err = session.RequestPty("xterm-256color", 80, 24, ssh.TerminalModes{
-			ssh.ECHO:          1,     // enable echoing
-			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
-		})
+		title := fmt.Sprintf("%s (%s)", conn.Config.Host, conn.Config.Username)
+		termWidget, err := tm.CreateTerminalWidget(conn, title)
 		if err != nil {
-			session.Close()
-			fmt.Printf("Failed to request pty for %s: %v\n", conn.Config.Host, err)
+			fmt.Printf("Failed to open terminal for %s: %v\n", conn.Config.Host, err)
 			continue
 		}
-
-		// Get pipes
-		stdinPipe, err := session.StdinPipe()
-		if err != nil {
-			session.Close()
-			fmt.Printf("Failed to get stdin pipe for %s: %v\n", conn.Config.Host, err)
-			continue
-		}
-
-		stdoutPipe, err := session.StdoutPipe()
-		if err != nil {
-			session.Close()
-			fmt.Printf("Failed to get stdout pipe for %s: %v\n", conn.Config.Host, err)
-			continue
-		}
-
-		// Wrap stdout with banner suppression
-		customBanner := fmt.Sprintf("Connected to %s (%s)", conn.Config.Host, conn.Config.Username)
-		wrappedStdout := newBanneredReader(stdoutPipe, customBanner)
-
-		// Create terminal directly (no fyne.Do needed yet)
-		t := terminal.New()
-		t.Resize(fyne.NewSize(1000, 700))
-		// Start SSH shell
-		go func(s *ssh.Session, host string) {
-			err := s.Shell()
-			if err != nil {
-				fmt.Printf("Failed to start shell for %s: %v\n", host, err)
-			} else {
-				err = s.Wait()
-				if err != nil {
-					fmt.Printf("SSH session ended for %s: %v\n", host, err)
-				}
-			}
-		}(session, conn.Config.Host)
-
-		// Connect terminal to SSH
-		go func(term *terminal.Terminal, stdin io.WriteCloser, stdout io.Reader, host string) {
-			err := term.RunWithConnection(stdin, stdout)
-			if err != nil {
-				fmt.Printf("Terminal connection error for %s: %v\n", host, err)
-			}
-		}(t, stdinPipe, wrappedStdout, conn.Config.Host)
-
-		// Set up resizing
-		configChan := make(chan terminal.Config, 1)
-		go func(s *ssh.Session, ch <-chan terminal.Config, host string) {
-			rows, cols := uint(0), uint(0)
-			for {
-				config := <-ch
-				if rows == config.Rows && cols == config.Columns {
-					continue
-				}
-				rows, cols = config.Rows, config.Columns
-				err := s.WindowChange(int(rows), int(cols))
-				if err != nil {
-					fmt.Printf("Failed to resize terminal for %s: %v\n", host, err)
-				}
-			}
-		}(session, configChan, conn.Config.Host)
-
-		// Add listener directly (no fyne.Do needed yet)
-		t.AddListener(configChan)
-
-		// Create terminal widget and store it
-		termWidget := &TerminalWidget{
-			Connection: conn,
-			Session:    session,
-			Terminal:   t,
-			Window:     w,
-			App:        a,
-			StdinPipe:  stdinPipe,
-			StdoutPipe: wrappedStdout,
-		}
-
-		tm.mutex.Lock()
-		tm.terminals[conn.Config.Host] = termWidget
-		tm.mutex.Unlock()
-
-		// Add tab directly (no fyne.Do needed yet)
-		tabTitle := fmt.Sprintf("%s (%s)", conn.Config.Host, conn.Config.Username)
-		tabs.Append(container.NewTabItem(tabTitle, t))
+		termWidget.ShowTerminal()
+		opened++
 	}
 
-	if len(tabs.Items) == 0 {
+	if opened == 0 {
 		return fmt.Errorf("no successful terminal connections created")
 	}
 
-	// Set window content and show directly
-	w.SetContent(tabs)
-	w.ShowAndRun()
-
 	return nil
 }
 
 // SSHMultiTerminal represents a single terminal widget handling multiple SSH sessions
 type SSHMultiTerminal struct {
-	sessions       []*ssh.Session
+	sessions       []InteractiveSession
 	connections    []*SSHConnection
 	terminal       *terminal.Terminal
+	content        fyne.CanvasObject // The terminal shown with the font and theme of the settings
 	stdinWriters   []io.WriteCloser
 	stdoutReaders  []io.Reader  // Store stdout readers during setup
+	recordings     []*Recording // Per session, nil entries are not recorded
 	activeSessions map[int]bool // Track which sessions are still active
 	multiStdin     *multiWriter // Store multi-writer for proper cleanup
 	multiStdout    *multiReader // Store multi-reader for proper cleanup
+	scrollback     *Scrollback  // Merged output, for search and transcripts
 	state          *SSHMultiTerminalState
 	closed         bool       // Track if already closed to prevent double cleanup
 	closeMutex     sync.Mutex // Protect against concurrent close calls
@@ -416,12 +331,14 @@ type SSHMultiTerminal struct {
 type SSHMultiTerminalState struct {
 	mutex           sync.Mutex
 	isTabCompletion bool
+	targets         *inputTargets // Sessions receiving keystrokes
 }
 
 // multiWriter writes to multiple writers simultaneously
 type multiWriter struct {
 	writers []io.WriteCloser
 	state   *SSHMultiTerminalState
+	guard   *inputGuard // Holds back pastes and dangerous commands, may be nil
 }
 
 func (mw *multiWriter) Write(p []byte) (n int, err error) {
@@ -439,14 +356,38 @@ func (mw *multiWriter) Write(p []byte) (n int, err error) {
 		})
 	}
 
-	for _, w := range mw.writers {
-		if w != nil {
-			w.Write(p)
-		}
+	if mw.guard != nil {
+		mw.guard.write(p, mw.broadcast)
+	} else {
+		mw.broadcast(p)
 	}
 	return len(p), nil
 }
 
+// broadcast writes p to the sessions receiving keystrokes
+func (mw *multiWriter) broadcast(p []byte) {
+	for i, w := range mw.writers {
+		if w == nil {
+			continue
+		}
+		if mw.state != nil && mw.state.targets != nil && !mw.state.targets.isEnabled(i) {
+			continue
+		}
+		w.Write(p)
+	}
+}
+
+// receivers returns the number of sessions receiving keystrokes
+func (mw *multiWriter) receivers() int {
+	n := 0
+	for i, w := range mw.writers {
+		if w != nil && (mw.state == nil || mw.state.targets == nil || mw.state.targets.isEnabled(i)) {
+			n++
+		}
+	}
+	return n
+}
+
 func (mw *multiWriter) Close() error {
 	var errs []error
 	for _, w := range mw.writers {
@@ -510,9 +451,16 @@ func newMultiReader(readers []io.Reader, hostnames []string, state *SSHMultiTerm
 							mr.state.mutex.Unlock()
 						}
 
-						// If it's a tab completion, only show output from the last reader.
-						if isTab && id != len(mr.readers)-1 {
-							continue // Skip output from all but the last session
+						// If it's a tab completion, only show output from the last session
+						// that received the TAB
+						if isTab {
+							last := len(mr.readers) - 1
+							if mr.state.targets != nil {
+								last = mr.state.targets.last()
+							}
+							if id != last {
+								continue // Skip output from the other sessions
+							}
 						}
 
 						// Check for error keywords in the output
@@ -610,6 +558,43 @@ func (br *banneredReader) Read(p []byte) (n int, err error) {
 	return br.reader.Read(p)
 }
 
+// openShellPipes opens an interactive session with a pty for one of the sessions
+// of a multi-host terminal and returns its pipes, recorded when recording is on.
+// The shell is not started yet.
+func openShellPipes(conn *SSHConnection) (InteractiveSession, io.WriteCloser, io.Reader, *Recording, error) {
+	// Create SSH session, or telnet login
+	session, err := conn.OpenInteractiveSession()
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	// Request a pseudo-terminal as set for the device
+	options, err := conn.requestPty(session)
+	if err != nil {
+		session.Close()
+		return nil, nil, nil, nil, err
+	}
+
+	// Get stdin and stdout pipes
+	stdinPipe, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to get stdin pipe: %v", err)
+	}
+
+	stdoutPipe, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to get stdout pipe: %v", err)
+	}
+
+	// Wrap stdout with banner suppression, escalating the shell as set for the device
+	customBanner := fmt.Sprintf("Connected to %s (%s)", conn.Config.Host, conn.Config.Username)
+	recording := conn.startRecording(options.Cols, options.Rows)
+	stdin, stdout := recordPipes(recording, stdinPipe, newBanneredReader(conn.becomeShell(stdinPipe, stdoutPipe), customBanner))
+	return session, stdin, conn.logOutput(stdout), recording, nil
+}
+
 // NewSSHMultiTerminal creates a single terminal widget that handles multiple SSH sessions
 func (tm *TerminalManager) NewSSHMultiTerminal(connections []*SSHConnection) (*SSHMultiTerminal, error) {
 	fmt.Printf("NewSSHMultiTerminal called with %d connections\n", len(connections))
@@ -618,11 +603,12 @@ func (tm *TerminalManager) NewSSHMultiTerminal(connections []*SSHConnection) (*S
 		return nil, fmt.Errorf("no connections provided")
 	}
 
-	var sessions []*ssh.Session
+	var sessions []InteractiveSession
 	var stdinWriters []io.WriteCloser
 	var stdoutReaders []io.Reader
 	var validConnections []*SSHConnection
 	var hostnames []string
+	var recordings []*Recording
 
 	// Create sessions for all valid connections
 	for i, conn := range connections {
@@ -633,47 +619,16 @@ func (tm *TerminalManager) NewSSHMultiTerminal(connections []*SSHConnection) (*S
 			continue
 		}
 
-		// Create SSH session
-		session, err := conn.CreateSession()
+		session, stdin, stdout, recording, err := openShellPipes(conn)
 		if err != nil {
-			fmt.Printf("Failed to create session for %s: %v\n", conn.Config.Host, err)
+			fmt.Printf("Failed to open terminal session for %s: %v\n", conn.Config.Host, err)
 			continue
 		}
 
-		// Request a pseudo-terminal with proper settings for interactive shell
-		err = session.RequestPty("xterm-256color", 80, 24, ssh.TerminalModes{
-			ssh.ECHO:          1,      // enable echoing
-			ssh.TTY_OP_ISPEED: 115200, // input speed = 115.2kbaud
-			ssh.TTY_OP_OSPEED: 115200, // output speed = 115.2kbaud
-		})
-		if err != nil {
-			session.Close()
-			fmt.Printf("Failed to request pty for %s: %v\n", conn.Config.Host, err)
-			continue
-		}
-
-		// Get stdin and stdout pipes
-		stdinPipe, err := session.StdinPipe()
-		if err != nil {
-			session.Close()
-			fmt.Printf("Failed to get stdin pipe for %s: %v\n", conn.Config.Host, err)
-			continue
-		}
-
-		stdoutPipe, err := session.StdoutPipe()
-		if err != nil {
-			session.Close()
-			fmt.Printf("Failed to get stdout pipe for %s: %v\n", conn.Config.Host, err)
-			continue
-		}
-
-		// Wrap stdout with banner suppression
-		customBanner := fmt.Sprintf("Connected to %s (%s)", conn.Config.Host, conn.Config.Username)
-		wrappedStdout := newBanneredReader(stdoutPipe, customBanner)
-
 		sessions = append(sessions, session)
-		stdinWriters = append(stdinWriters, stdinPipe)
-		stdoutReaders = append(stdoutReaders, wrappedStdout)
+		stdinWriters = append(stdinWriters, stdin)
+		stdoutReaders = append(stdoutReaders, stdout)
+		recordings = append(recordings, recording)
 		validConnections = append(validConnections, conn)
 		hostnames = append(hostnames, conn.Config.Host)
 
@@ -695,7 +650,7 @@ func (tm *TerminalManager) NewSSHMultiTerminal(connections []*SSHConnection) (*S
 
 	// Start SSH shell sessions - start the shell AFTER creating the terminal connection
 	for i, session := range sessions {
-		go func(s *ssh.Session, host string, index int) {
+		go func(s InteractiveSession, host string, index int) {
 			defer func() {
 				// Mark session as inactive when it ends
 				activeSessions[index] = false
@@ -724,24 +679,28 @@ func (tm *TerminalManager) NewSSHMultiTerminal(connections []*SSHConnection) (*S
 
 	// Create terminal widget immediately
 	t := terminal.New()
-	t.Resize(fyne.NewSize(1000, 700))
+	t.Resize(terminalSize(DefaultTerminalOptions()))
 
 	// Create a shared state for the session
-	state := &SSHMultiTerminalState{}
+	state := &SSHMultiTerminalState{targets: newInputTargets(len(sessions))}
 
 	// Create multi-writer for stdin (distributes input to all sessions)
 	multiStdin := &multiWriter{writers: stdinWriters, state: state}
+	multiStdin.guard = newInputGuard(multiStdin.receivers, newBroadcastConfirm(t))
 
 	// Create multi-reader for stdout (combines output from all sessions)
 	multiStdout := newMultiReader(stdoutReaders, hostnames, state)
 
+	// Keep the merged output for search and transcripts
+	scrollback := NewScrollback(ScrollbackLines())
+
 	// Connect terminal to the multi-reader/writer in a goroutine
 	go func() {
 		defer func() {
 			fmt.Printf("Terminal connection goroutine ending\n")
 		}()
 
-		err := t.RunWithConnection(multiStdin, multiStdout)
+		err := t.RunWithConnection(multiStdin, io.TeeReader(multiStdout, scrollback))
 		if err != nil {
 			fmt.Printf("Terminal connection error: %v\n", err)
 		}
@@ -789,6 +748,9 @@ func (tm *TerminalManager) NewSSHMultiTerminal(connections []*SSHConnection) (*S
 					fmt.Printf("Successfully resized terminal for %s to %dx%d\n",
 						validConnections[i].Config.Host, rows, cols)
 				}
+				if recordings[i] != nil {
+					recordings[i].Resize(int(cols), int(rows))
+				}
 			}
 		}
 	}()
@@ -801,11 +763,14 @@ func (tm *TerminalManager) NewSSHMultiTerminal(connections []*SSHConnection) (*S
 		sessions:       sessions,
 		connections:    validConnections,
 		terminal:       t,
+		content:        styleTerminal(t, TerminalOptions{}),
 		stdinWriters:   stdinWriters,
 		stdoutReaders:  stdoutReaders,
+		recordings:     recordings,
 		activeSessions: activeSessions,
 		multiStdin:     multiStdin,
 		multiStdout:    multiStdout,
+		scrollback:     scrollback,
 		state:          state,
 	}
 
@@ -818,23 +783,140 @@ func (smt *SSHMultiTerminal) GetWidget() *terminal.Terminal {
 	return smt.terminal
 }
 
-// CreateStandaloneWindow creates a standalone window containing the multi-terminal widget
-func (smt *SSHMultiTerminal) CreateStandaloneWindow(title string) (fyne.Window, fyne.App) {
-	a := app.New()
-	w := a.NewWindow(title)
-	w.Resize(fyne.NewSize(1000, 700))
+// Content returns the terminal with the font and theme of the settings, to be
+// put in a window
+func (smt *SSHMultiTerminal) Content() fyne.CanvasObject {
+	return smt.content
+}
 
-	w.SetContent(smt.terminal)
+// Scrollback returns the output of the sessions kept for search and transcripts
+func (smt *SSHMultiTerminal) Scrollback() *Scrollback {
+	return smt.scrollback
+}
 
-	// Set up cleanup when window is closed
-	w.SetOnClosed(func() {
+// Hosts returns the hosts of the sessions, in session order
+func (smt *SSHMultiTerminal) Hosts() []string {
+	hosts := make([]string, len(smt.connections))
+	for i, conn := range smt.connections {
+		hosts[i] = conn.Config.Host
+	}
+	return hosts
+}
+
+// InputTargets reports which sessions receive keystrokes
+func (smt *SSHMultiTerminal) InputTargets() []bool {
+	enabled, _ := smt.state.targets.snapshot()
+	return enabled
+}
+
+// SetInputTarget turns the keystrokes to one session on or off
+func (smt *SSHMultiTerminal) SetInputTarget(index int, enabled bool) {
+	smt.state.targets.set(index, enabled)
+}
+
+// FocusInput sends keystrokes to one session only
+func (smt *SSHMultiTerminal) FocusInput(index int) {
+	smt.state.targets.focus(index)
+}
+
+// BroadcastInput sends keystrokes to all sessions again
+func (smt *SSHMultiTerminal) BroadcastInput() {
+	smt.state.targets.broadcast()
+}
+
+// NewTargetBar returns a bar with a toggle per host showing whether it receives
+// keystrokes, a focus select to type on one host only and a button to broadcast
+// to all hosts again
+func (smt *SSHMultiTerminal) NewTargetBar() fyne.CanvasObject {
+	return newTargetBar(smt.Hosts(), smt.state.targets, func() {
+		// Give the keyboard back to the terminal after a click on the bar
+		if canvas := fyne.CurrentApp().Driver().CanvasForObject(smt.terminal); canvas != nil {
+			canvas.Focus(smt.terminal)
+		}
+	})
+}
+
+// newTargetBar builds the input target bar of a multi-host terminal, calling
+// refocus after every change
+func newTargetBar(hosts []string, targets *inputTargets, refocus func()) fyne.CanvasObject {
+	summary := widget.NewLabel("")
+	buttons := make([]*widget.Button, len(hosts))
+	for i, host := range hosts {
+		index := i
+		buttons[i] = widget.NewButton(host, func() {
+			targets.set(index, !targets.isEnabled(index))
+		})
+	}
+
+	focusSelect := widget.NewSelect(hosts, nil)
+	focusSelect.PlaceHolder = "Focus one host"
+	focusSelect.OnChanged = func(host string) {
+		for i := range hosts {
+			if hosts[i] == host {
+				targets.focus(i)
+			}
+		}
+	}
+	allBtn := widget.NewButton("All Hosts", targets.broadcast)
+
+	show := func() {
+		enabled, focused := targets.snapshot()
+		receiving := 0
+		for i, button := range buttons {
+			if enabled[i] {
+				receiving++
+				button.SetText("● " + hosts[i])
+				button.Importance = widget.HighImportance
+			} else {
+				button.SetText("○ " + hosts[i])
+				button.Importance = widget.LowImportance
+			}
+			button.Refresh()
+		}
+
+		switch {
+		case focused >= 0:
+			summary.SetText(fmt.Sprintf("Typing on %s only", hosts[focused]))
+		case receiving == len(hosts):
+			summary.SetText("Typing on all hosts")
+			focusSelect.ClearSelected()
+		case receiving == 0:
+			summary.SetText("Input is off for all hosts")
+			focusSelect.ClearSelected()
+		default:
+			summary.SetText(fmt.Sprintf("Typing on %d of %d hosts", receiving, len(hosts)))
+			focusSelect.ClearSelected()
+		}
+		refocus()
+	}
+	targets.onChange(func() {
+		fyne.Do(show)
+	})
+	show()
+
+	toggles := make([]fyne.CanvasObject, len(buttons))
+	for i, button := range buttons {
+		toggles[i] = button
+	}
+	controls := container.NewHBox(summary, focusSelect, allBtn)
+	return container.NewBorder(nil, nil, nil, controls, container.NewHScroll(container.NewHBox(toggles...)))
+}
+
+// CreateStandaloneWindow shows the multi-terminal in a "terminal" window of its
+// own, which can be attached to the workspace. Closing it ends the sessions. It
+// must be called from the UI thread.
+func (smt *SSHMultiTerminal) CreateStandaloneWindow(title string) fyne.Window {
+	content := container.NewBorder(smt.NewTargetBar(), nil, nil, nil, smt.content)
+	view := newTerminalView(title, content, func() {
 		fmt.Printf("Standalone window closed, cleaning up sessions\n")
 		smt.Close()
-		a.Quit()
-		fmt.Printf("Standalone window cleanup complete\n")
 	})
-
-	return w, a
+	view.setScrollback(smt.scrollback)
+	Workspace().addWindow(view)
+	if view.window == nil {
+		return nil
+	}
+	return view.window.Window
 }
 
 // Example usage functions
@@ -847,11 +929,11 @@ func (tm *TerminalManager) CreateMultiTerminalWindow(connections []*SSHConnectio
 		return fmt.Errorf("failed to create multi-terminal: %v", err)
 	}
 
-	// Create a standalone window for it
-	window, _ := multiTerm.CreateStandaloneWindow(title)
-
-	// Show and run the window (this will block until the window is closed)
-	window.ShowAndRun()
+	// Show it in a window of its own
+	if multiTerm.CreateStandaloneWindow(title) == nil {
+		multiTerm.Close()
+		return fmt.Errorf("failed to create multi-terminal window")
+	}
 
 	return nil
 }
@@ -933,6 +1015,12 @@ func (smt *SSHMultiTerminal) Close() error {
 		}
 	}
 
+	for _, recording := range smt.recordings {
+		if recording != nil {
+			recording.Close()
+		}
+	}
+
 	if len(errs) > 0 {
 		fmt.Printf("Errors during cleanup: %v\n", errs)
 		return fmt.Errorf("errors closing SSH multi-terminal: %v", errs)
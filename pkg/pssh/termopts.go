@@ -0,0 +1,283 @@
+package pssh
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TerminalOptions describe the pseudo-terminal requested for interactive shells
+// and how terminals are shown. In the options of a device, zero fields are taken
+// from DefaultTerminalOptions and modes and variables are added to the defaults.
+type TerminalOptions struct {
+	Type     string            // TERM, e.g. "xterm-256color"
+	Rows     int               // Initial size, the terminal follows its window later
+	Cols     int               //
+	Modes    ssh.TerminalModes // Terminal modes sent with the pty request
+	Env      map[string]string // Sent before the shell starts, servers may accept only some, e.g. LANG and LC_*
+	Font     string            // TrueType font file, empty for the monospace font of the theme
+	FontSize float32           // Text size, 0 for the size of the theme
+	Theme    string            // "dark" or "light", empty to follow the application
+}
+
+// defaultTerminal holds the terminal options of devices without their own,
+// connections read them while the settings may change them
+var defaultTerminal = struct {
+	options TerminalOptions
+	mutex   sync.RWMutex
+}{options: TerminalOptions{
+	Type: "xterm-256color",
+	Rows: 24,
+	Cols: 80,
+	Modes: ssh.TerminalModes{
+		ssh.ECHO:          1,     // enable echoing
+		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+	},
+}}
+
+// SetDefaultTerminalOptions sets the terminal options of devices without their
+// own. Terminals already open take the new appearance with ApplyTerminalAppearance.
+func SetDefaultTerminalOptions(options TerminalOptions) {
+	defaultTerminal.mutex.Lock()
+	defer defaultTerminal.mutex.Unlock()
+	defaultTerminal.options = options.Override(TerminalOptions{})
+}
+
+// DefaultTerminalOptions returns a copy of the options set with
+// SetDefaultTerminalOptions
+func DefaultTerminalOptions() TerminalOptions {
+	defaultTerminal.mutex.RLock()
+	defer defaultTerminal.mutex.RUnlock()
+	return defaultTerminal.options.Override(TerminalOptions{})
+}
+
+// terminalModeNames maps the names of terminal modes, as in RFC 4254, to their opcodes
+var terminalModeNames = map[string]uint8{
+	"VINTR": ssh.VINTR, "VQUIT": ssh.VQUIT, "VERASE": ssh.VERASE, "VKILL": ssh.VKILL,
+	"VEOF": ssh.VEOF, "VEOL": ssh.VEOL, "VEOL2": ssh.VEOL2, "VSTART": ssh.VSTART,
+	"VSTOP": ssh.VSTOP, "VSUSP": ssh.VSUSP, "VDSUSP": ssh.VDSUSP, "VREPRINT": ssh.VREPRINT,
+	"VWERASE": ssh.VWERASE, "VLNEXT": ssh.VLNEXT, "VFLUSH": ssh.VFLUSH, "VSWTCH": ssh.VSWTCH,
+	"VSTATUS": ssh.VSTATUS, "VDISCARD": ssh.VDISCARD,
+	"IGNPAR": ssh.IGNPAR, "PARMRK": ssh.PARMRK, "INPCK": ssh.INPCK, "ISTRIP": ssh.ISTRIP,
+	"INLCR": ssh.INLCR, "IGNCR": ssh.IGNCR, "ICRNL": ssh.ICRNL, "IUCLC": ssh.IUCLC,
+	"IXON": ssh.IXON, "IXANY": ssh.IXANY, "IXOFF": ssh.IXOFF, "IMAXBEL": ssh.IMAXBEL,
+	"IUTF8": ssh.IUTF8,
+	"ISIG":  ssh.ISIG, "ICANON": ssh.ICANON, "XCASE": ssh.XCASE, "ECHO": ssh.ECHO,
+	"ECHOE": ssh.ECHOE, "ECHOK": ssh.ECHOK, "ECHONL": ssh.ECHONL, "NOFLSH": ssh.NOFLSH,
+	"TOSTOP": ssh.TOSTOP, "IEXTEN": ssh.IEXTEN, "ECHOCTL": ssh.ECHOCTL, "ECHOKE": ssh.ECHOKE,
+	"PENDIN": ssh.PENDIN,
+	"OPOST":  ssh.OPOST, "OLCUC": ssh.OLCUC, "ONLCR": ssh.ONLCR, "OCRNL": ssh.OCRNL,
+	"ONOCR": ssh.ONOCR, "ONLRET": ssh.ONLRET,
+	"CS7": ssh.CS7, "CS8": ssh.CS8, "PARENB": ssh.PARENB, "PARODD": ssh.PARODD,
+	"TTY_OP_ISPEED": ssh.TTY_OP_ISPEED, "TTY_OP_OSPEED": ssh.TTY_OP_OSPEED,
+}
+
+// ParseTerminalModes parses terminal modes such as "ECHO=1 ICRNL=0 TTY_OP_ISPEED=115200"
+func ParseTerminalModes(spec string) (ssh.TerminalModes, error) {
+	modes := ssh.TerminalModes{}
+	for _, field := range strings.Fields(spec) {
+		name, value, found := strings.Cut(field, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid terminal mode %q, expected NAME=VALUE", field)
+		}
+		opcode, ok := terminalModeNames[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown terminal mode %q", name)
+		}
+		number, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of terminal mode %s: %q", name, value)
+		}
+		modes[opcode] = uint32(number)
+	}
+	return modes, nil
+}
+
+// FormatTerminalModes formats terminal modes as ParseTerminalModes reads them
+func FormatTerminalModes(modes ssh.TerminalModes) string {
+	var fields []string
+	for name, opcode := range terminalModeNames {
+		if value, ok := modes[opcode]; ok {
+			fields = append(fields, fmt.Sprintf("%s=%d", name, value))
+		}
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, " ")
+}
+
+// ParseTerminalEnv parses environment variables such as "LANG=en_US.UTF-8 TZ=UTC"
+func ParseTerminalEnv(spec string) (map[string]string, error) {
+	env := map[string]string{}
+	for _, field := range strings.Fields(spec) {
+		name, value, found := strings.Cut(field, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid environment variable %q, expected NAME=VALUE", field)
+		}
+		env[name] = value
+	}
+	return env, nil
+}
+
+// FormatTerminalEnv formats environment variables as ParseTerminalEnv reads them
+func FormatTerminalEnv(env map[string]string) string {
+	var fields []string
+	for name, value := range env {
+		fields = append(fields, name+"="+value)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, " ")
+}
+
+// ParseTerminalOptions parses the terminal options of a device, fields separated
+// by semicolons such as "term=vt100; size=132x43; modes=ICRNL=0; env=LANG=C.UTF-8;
+// font=/path/to/font.ttf; font-size=14; theme=light"
+func ParseTerminalOptions(spec string) (TerminalOptions, error) {
+	var options TerminalOptions
+	for _, field := range strings.Split(spec, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return options, fmt.Errorf("invalid terminal option %q, expected key=value", field)
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		var err error
+		switch key {
+		case "term":
+			options.Type = value
+		case "size":
+			cols, rows, found := strings.Cut(strings.ToLower(value), "x")
+			options.Cols, err = strconv.Atoi(cols)
+			if err == nil && found {
+				options.Rows, err = strconv.Atoi(rows)
+			}
+			if err != nil || !found || options.Cols <= 0 || options.Rows <= 0 {
+				return options, fmt.Errorf("invalid terminal size %q, expected COLSxROWS", value)
+			}
+		case "modes":
+			options.Modes, err = ParseTerminalModes(value)
+		case "env":
+			options.Env, err = ParseTerminalEnv(value)
+		case "font":
+			options.Font = value
+		case "font-size":
+			var size float64
+			size, err = strconv.ParseFloat(value, 32)
+			if err != nil || size <= 0 {
+				return options, fmt.Errorf("invalid font size %q", value)
+			}
+			options.FontSize = float32(size)
+		case "theme":
+			if value != "dark" && value != "light" && value != "" {
+				return options, fmt.Errorf("invalid terminal theme %q, expected dark or light", value)
+			}
+			options.Theme = value
+		default:
+			return options, fmt.Errorf("unknown terminal option %q", key)
+		}
+		if err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
+
+// String formats the options as ParseTerminalOptions reads them, zero fields are left out
+func (o TerminalOptions) String() string {
+	var fields []string
+	if o.Type != "" {
+		fields = append(fields, "term="+o.Type)
+	}
+	if o.Cols > 0 && o.Rows > 0 {
+		fields = append(fields, fmt.Sprintf("size=%dx%d", o.Cols, o.Rows))
+	}
+	if len(o.Modes) > 0 {
+		fields = append(fields, "modes="+FormatTerminalModes(o.Modes))
+	}
+	if len(o.Env) > 0 {
+		fields = append(fields, "env="+FormatTerminalEnv(o.Env))
+	}
+	if o.Font != "" {
+		fields = append(fields, "font="+o.Font)
+	}
+	if o.FontSize > 0 {
+		fields = append(fields, "font-size="+strconv.FormatFloat(float64(o.FontSize), 'f', -1, 32))
+	}
+	if o.Theme != "" {
+		fields = append(fields, "theme="+o.Theme)
+	}
+	return strings.Join(fields, "; ")
+}
+
+// Override returns the options with the fields set in device taking precedence
+func (o TerminalOptions) Override(device TerminalOptions) TerminalOptions {
+	merged := o
+	if device.Type != "" {
+		merged.Type = device.Type
+	}
+	if device.Rows > 0 && device.Cols > 0 {
+		merged.Rows, merged.Cols = device.Rows, device.Cols
+	}
+	merged.Modes = ssh.TerminalModes{}
+	for opcode, value := range o.Modes {
+		merged.Modes[opcode] = value
+	}
+	for opcode, value := range device.Modes {
+		merged.Modes[opcode] = value
+	}
+	merged.Env = map[string]string{}
+	for name, value := range o.Env {
+		merged.Env[name] = value
+	}
+	for name, value := range device.Env {
+		merged.Env[name] = value
+	}
+	if device.Font != "" {
+		merged.Font = device.Font
+	}
+	if device.FontSize > 0 {
+		merged.FontSize = device.FontSize
+	}
+	if device.Theme != "" {
+		merged.Theme = device.Theme
+	}
+	return merged
+}
+
+// terminalOptions returns the terminal options of the connection
+func (conn *SSHConnection) terminalOptions() TerminalOptions {
+	return DefaultTerminalOptions().Override(conn.Config.Terminal)
+}
+
+// requestPty requests the pseudo-terminal of the connection for session and
+// sends its environment variables, it returns the options used
+func (conn *SSHConnection) requestPty(session InteractiveSession) (TerminalOptions, error) {
+	options := conn.terminalOptions()
+	termType, rows, cols := options.Type, options.Rows, options.Cols
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+	if rows <= 0 || cols <= 0 {
+		rows, cols = 24, 80
+	}
+	options.Type, options.Rows, options.Cols = termType, rows, cols
+
+	if err := session.RequestPty(termType, rows, cols, options.Modes); err != nil {
+		return options, fmt.Errorf("failed to request pty: %v", err)
+	}
+	for name, value := range options.Env {
+		// Servers refuse variables they do not accept, the shell starts anyway
+		if err := session.Setenv(name, value); err != nil {
+			fmt.Printf("Environment variable %s not accepted by %s: %v\n", name, conn.Config.Host, err)
+		}
+	}
+	return options, nil
+}
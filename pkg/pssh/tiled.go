@@ -0,0 +1,191 @@
+package pssh
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/fyne-io/terminal"
+)
+
+// SSHTiledTerminal shows every session of a multi-host terminal in its own pane.
+// With synchronized input, keystrokes typed in one pane also go to the other
+// sessions that receive input, like synchronize-panes in tmux.
+type SSHTiledTerminal struct {
+	panes      []*terminalPane
+	input      *tiledInput
+	scrollback *Scrollback
+	content    fyne.CanvasObject
+	closed     bool
+	mutex      sync.Mutex
+}
+
+// terminalPane is the terminal of one session in the tiled view
+type terminalPane struct {
+	conn      *SSHConnection
+	session   InteractiveSession
+	terminal  *terminal.Terminal
+	stdin     io.WriteCloser
+	recording *Recording
+	status    *widget.Label
+	config    chan terminal.Config // Size changes of the terminal, until Close
+}
+
+// NewSSHTiledTerminal opens a session with its own terminal pane for every
+// connected connection, laid out in a grid
+func (tm *TerminalManager) NewSSHTiledTerminal(connections []*SSHConnection) (*SSHTiledTerminal, error) {
+	if len(connections) == 0 {
+		return nil, fmt.Errorf("no connections provided")
+	}
+
+	tiled := &SSHTiledTerminal{scrollback: NewScrollback(ScrollbackLines())}
+	var outputs []io.Reader
+	for _, conn := range connections {
+		if !conn.IsConnected() {
+			fmt.Printf("Skipping disconnected host: %s\n", conn.Config.Host)
+			continue
+		}
+		session, stdin, stdout, recording, err := openShellPipes(conn)
+		if err != nil {
+			fmt.Printf("Failed to open terminal session for %s: %v\n", conn.Config.Host, err)
+			continue
+		}
+		tiled.panes = append(tiled.panes, &terminalPane{
+			conn:      conn,
+			session:   session,
+			terminal:  terminal.New(),
+			stdin:     stdin,
+			recording: recording,
+			status:    widget.NewLabel(fmt.Sprintf("%s (%s)", conn.Config.Host, conn.Config.Username)),
+		})
+		outputs = append(outputs, stdout)
+	}
+	if len(tiled.panes) == 0 {
+		return nil, fmt.Errorf("no valid SSH sessions could be created")
+	}
+	stdins := make([]io.WriteCloser, len(tiled.panes))
+	for i, pane := range tiled.panes {
+		stdins[i] = pane.stdin
+	}
+	tiled.input = newTiledInput(stdins)
+
+	tiles := make([]fyne.CanvasObject, len(tiled.panes))
+	for i, pane := range tiled.panes {
+		go func(pane *terminalPane) {
+			host := pane.conn.Config.Host
+			if err := pane.session.Shell(); err != nil {
+				fmt.Printf("Failed to start shell for %s: %v\n", host, err)
+			} else if err := pane.session.Wait(); err != nil {
+				fmt.Printf("SSH shell session ended for %s: %v\n", host, err)
+			}
+			fyne.Do(func() {
+				pane.status.SetText(fmt.Sprintf("%s (%s) — session ended", host, pane.conn.Config.Username))
+			})
+		}(pane)
+
+		input := &paneInput{input: tiled.input, index: i}
+		input.guard = newInputGuard(input.receivers, newBroadcastConfirm(pane.terminal))
+		go func(pane *terminalPane, input *paneInput, output io.Reader) {
+			if err := pane.terminal.RunWithConnection(input, output); err != nil {
+				fmt.Printf("Terminal connection error for %s: %v\n", pane.conn.Config.Host, err)
+			}
+		}(pane, input, io.TeeReader(outputs[i], tiled.scrollback.Source(pane.conn.Config.Host)))
+
+		// Every pane has its own size
+		pane.config = make(chan terminal.Config, 1)
+		go func(pane *terminalPane, configChan chan terminal.Config) {
+			rows, cols := uint(0), uint(0)
+			for config := range configChan {
+				if rows == config.Rows && cols == config.Columns {
+					continue
+				}
+				rows, cols = config.Rows, config.Columns
+				if err := pane.session.WindowChange(int(rows), int(cols)); err != nil {
+					fmt.Printf("Failed to resize terminal for %s: %v\n", pane.conn.Config.Host, err)
+				}
+				if pane.recording != nil {
+					pane.recording.Resize(int(cols), int(rows))
+				}
+			}
+		}(pane, pane.config)
+		pane.terminal.AddListener(pane.config)
+
+		tiles[i] = container.NewBorder(pane.status, nil, nil, nil, styleTerminal(pane.terminal, pane.conn.Config.Terminal))
+	}
+
+	hosts := make([]string, len(tiled.panes))
+	for i, pane := range tiled.panes {
+		hosts[i] = pane.conn.Config.Host
+	}
+	targetBar := newTargetBar(hosts, tiled.input.targets, tiled.refocus)
+	targetBar.Hide()
+	syncCheck := widget.NewCheck("Synchronize input", func(checked bool) {
+		tiled.SetSynchronized(checked)
+		if checked {
+			targetBar.Show()
+		} else {
+			targetBar.Hide()
+		}
+		tiled.refocus()
+	})
+
+	columns := int(math.Ceil(math.Sqrt(float64(len(tiles)))))
+	grid := container.NewGridWithColumns(columns, tiles...)
+	tiled.content = container.NewBorder(container.NewBorder(nil, nil, syncCheck, nil, targetBar), nil, nil, nil, grid)
+	return tiled, nil
+}
+
+// Content returns the panes and their controls, to be put in a window
+func (tt *SSHTiledTerminal) Content() fyne.CanvasObject {
+	return tt.content
+}
+
+// Scrollback returns the output of all panes kept for search and transcripts,
+// every line prefixed with its host
+func (tt *SSHTiledTerminal) Scrollback() *Scrollback {
+	return tt.scrollback
+}
+
+// SetSynchronized turns synchronized input across the panes on or off
+func (tt *SSHTiledTerminal) SetSynchronized(synchronized bool) {
+	tt.input.setSynchronized(synchronized)
+}
+
+// refocus gives the keyboard back to the pane typed in last
+func (tt *SSHTiledTerminal) refocus() {
+	pane := tt.panes[tt.input.last()]
+	if canvas := fyne.CurrentApp().Driver().CanvasForObject(pane.terminal); canvas != nil {
+		canvas.Focus(pane.terminal)
+	}
+}
+
+// Close ends the sessions of all panes
+func (tt *SSHTiledTerminal) Close() error {
+	tt.mutex.Lock()
+	if tt.closed {
+		tt.mutex.Unlock()
+		return nil
+	}
+	tt.closed = true
+	tt.mutex.Unlock()
+
+	var errs []error
+	for _, pane := range tt.panes {
+		// Removing the listener closes its channel, which ends the resizing
+		pane.terminal.RemoveListener(pane.config)
+		if err := pane.session.Close(); err != nil && err != io.EOF && !isConnectionClosed(err) {
+			errs = append(errs, fmt.Errorf("failed to close session for %s: %v", pane.conn.Config.Host, err))
+		}
+		if pane.recording != nil {
+			pane.recording.Close()
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("errors closing tiled terminal: %v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package pssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TransferResult holds the outcome of a file transfer with one host
+type TransferResult struct {
+	Host       string
+	LocalPath  string
+	RemotePath string
+	Bytes      int64
+	Checksum   string // SHA-256 of the transferred file
+	Verified   bool   // The uploaded file was read back and matched the local checksum
+	Started    time.Time
+	Finished   time.Time
+	Error      error
+}
+
+// Duration returns how long the transfer took
+func (result *TransferResult) Duration() time.Duration {
+	if result.Finished.IsZero() {
+		return 0
+	}
+	return result.Finished.Sub(result.Started)
+}
+
+// TransferCallbacks report the progress of PushFile and PullFile per connection index.
+// They are called from the worker goroutines and may be nil.
+type TransferCallbacks struct {
+	OnStart    func(index int)
+	OnProgress func(index int, transferred, total int64)
+	OnResult   func(index int, result *TransferResult)
+}
+
+// PushFile uploads a local file to remotePath on every connection following config.
+// Each upload is read back and compared with the SHA-256 of the local file.
+// The returned error tells why the execution was aborted, if it was.
+func PushFile(ctx context.Context, connections []*SSHConnection, localPath, remotePath string, config ExecutorConfig, callbacks TransferCallbacks) ([]*TransferResult, error) {
+	checksum, err := fileChecksum(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return transferMultiple(ctx, connections, config, callbacks, func(ctx context.Context, client *SFTPClient, result *TransferResult, progress ProgressFunc) error {
+		result.LocalPath = localPath
+		result.RemotePath = remotePath
+		result.Checksum = checksum
+
+		if err := client.Put(ctx, localPath, remotePath, TransferOptions{Progress: progress}); err != nil {
+			return err
+		}
+
+		remoteChecksum, size, err := client.checksum(ctx, remotePath)
+		if err != nil {
+			return fmt.Errorf("failed to verify upload: %w", err)
+		}
+		result.Bytes = size
+		if remoteChecksum != checksum {
+			return fmt.Errorf("checksum mismatch: local %s, remote %s", checksum[:12], remoteChecksum[:12])
+		}
+		result.Verified = true
+		return nil
+	})
+}
+
+// PullFile downloads remotePath from every connection into a folder per host below
+// localDir, e.g. backups/10.0.0.1/backup.rsc, following config.
+// The returned error tells why the execution was aborted, if it was.
+func PullFile(ctx context.Context, connections []*SSHConnection, remotePath, localDir string, config ExecutorConfig, callbacks TransferCallbacks) ([]*TransferResult, error) {
+	return transferMultiple(ctx, connections, config, callbacks, func(ctx context.Context, client *SFTPClient, result *TransferResult, progress ProgressFunc) error {
+		hostDir := filepath.Join(localDir, hostDirName(result.Host))
+		result.RemotePath = remotePath
+		result.LocalPath = filepath.Join(hostDir, path.Base(remotePath))
+
+		if err := os.MkdirAll(hostDir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", hostDir, err)
+		}
+		if err := client.Get(ctx, remotePath, result.LocalPath, TransferOptions{Progress: progress}); err != nil {
+			return err
+		}
+
+		checksum, err := fileChecksum(result.LocalPath)
+		if err != nil {
+			return err
+		}
+		result.Checksum = checksum
+		if info, err := os.Stat(result.LocalPath); err == nil {
+			result.Bytes = info.Size()
+		}
+		return nil
+	})
+}
+
+// transferTask moves a file over an SFTP session and fills in the result
+type transferTask func(ctx context.Context, client *SFTPClient, result *TransferResult, progress ProgressFunc) error
+
+// transferMultiple runs a transfer task on every connection with the executor
+func transferMultiple(ctx context.Context, connections []*SSHConnection, config ExecutorConfig, callbacks TransferCallbacks, task transferTask) ([]*TransferResult, error) {
+	hosts := make([]string, len(connections))
+	for i, conn := range connections {
+		hosts[i] = conn.Config.Host
+	}
+
+	results := make([]*TransferResult, len(connections))
+	report, err := Execute(ctx, hosts, config, func(ctx context.Context, index int) error {
+		if callbacks.OnStart != nil {
+			callbacks.OnStart(index)
+		}
+
+		result := &TransferResult{Host: hosts[index], Started: time.Now()}
+		results[index] = result
+
+		var progress ProgressFunc
+		if callbacks.OnProgress != nil {
+			progress = func(transferred, total int64) {
+				callbacks.OnProgress(index, transferred, total)
+			}
+		}
+
+		client, err := connections[index].OpenSFTP()
+		if err == nil {
+			err = task(ctx, client, result, progress)
+			client.Close()
+		}
+		result.Error = err
+		result.Finished = time.Now()
+
+		if callbacks.OnResult != nil {
+			callbacks.OnResult(index, result)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, hostErr := range report.Errors {
+		if hostErr == ErrSkipped {
+			results[i] = &TransferResult{Host: hosts[i], Error: ErrSkipped}
+		}
+	}
+	return results, report.Aborted
+}
+
+// checksum reads a remote file and returns its SHA-256 and size
+func (c *SFTPClient) checksum(ctx context.Context, remotePath string) (string, int64, error) {
+	remote, err := c.client.Open(remotePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer remote.Close()
+
+	hash := sha256.New()
+	size, err := remote.WriteTo(&progressWriter{ctx: ctx, writer: hash})
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// fileChecksum returns the SHA-256 of a local file
+func fileChecksum(localPath string) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", localPath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hostDirName turns a host into a folder name, e.g. "fd00::1" becomes "fd00__1"
+func hostDirName(host string) string {
+	return strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(host)
+}
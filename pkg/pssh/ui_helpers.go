@@ -2,6 +2,8 @@ package pssh
 
 import (
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/ispapp/psshclient/internal/windows"
@@ -10,6 +12,7 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
+	"golang.org/x/crypto/ssh"
 )
 
 // ConnectionProgress represents the progress of connecting to multiple devices
@@ -26,7 +29,10 @@ type SSHCredentials struct {
 	Username   string
 	Password   string
 	PrivateKey []byte
+	KeyPath    string
+	Passphrase string
 	UseKey     bool
+	UseAgent   bool
 }
 
 // ShowCredentialsDialog displays a dialog to collect SSH credentials
@@ -37,18 +43,53 @@ func ShowCredentialsDialog(parent fyne.Window, callback func(SSHCredentials, boo
 	passwordEntry := widget.NewPasswordEntry()
 	passwordEntry.SetPlaceHolder("Password")
 
+	keyPathEntry := widget.NewEntry()
+	keyPathEntry.SetPlaceHolder("~/.ssh/id_ed25519")
+	keyPathEntry.Disable()
+
+	passphraseEntry := widget.NewPasswordEntry()
+	passphraseEntry.SetPlaceHolder("Key passphrase (if encrypted)")
+	passphraseEntry.Disable()
+
+	browseBtn := widget.NewButton("Browse", func() {
+		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, parent)
+				return
+			}
+			if reader == nil {
+				return // User cancelled
+			}
+			defer reader.Close()
+			keyPathEntry.SetText(reader.URI().Path())
+		}, parent)
+	})
+	browseBtn.Disable()
+
 	keyCheck := widget.NewCheck("Use SSH Key", func(checked bool) {
 		passwordEntry.Disable()
+		keyPathEntry.Enable()
+		passphraseEntry.Enable()
+		browseBtn.Enable()
 		if !checked {
 			passwordEntry.Enable()
+			keyPathEntry.Disable()
+			passphraseEntry.Disable()
+			browseBtn.Disable()
 		}
 	})
 
+	agentCheck := widget.NewCheck("Use ssh-agent", nil)
+	agentCheck.SetChecked(os.Getenv("SSH_AUTH_SOCK") != "")
+
 	form := &widget.Form{
 		Items: []*widget.FormItem{
 			{Text: "Username:", Widget: usernameEntry},
 			{Text: "Password:", Widget: passwordEntry},
 			{Text: "", Widget: keyCheck},
+			{Text: "Key File:", Widget: container.NewBorder(nil, nil, nil, browseBtn, keyPathEntry)},
+			{Text: "Passphrase:", Widget: passphraseEntry},
+			{Text: "", Widget: agentCheck},
 		},
 	}
 
@@ -58,6 +99,20 @@ func ShowCredentialsDialog(parent fyne.Window, callback func(SSHCredentials, boo
 				Username: usernameEntry.Text,
 				Password: passwordEntry.Text,
 				UseKey:   keyCheck.Checked,
+				UseAgent: agentCheck.Checked,
+			}
+			if keyCheck.Checked && keyPathEntry.Text != "" {
+				keyPath, err := expandHomePath(keyPathEntry.Text)
+				if err == nil {
+					_, err = os.Stat(keyPath)
+				}
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("private key not accessible: %v", err), parent)
+					callback(SSHCredentials{}, false)
+					return
+				}
+				credentials.KeyPath = keyPath
+				credentials.Passphrase = passphraseEntry.Text
 			}
 			callback(credentials, true)
 		} else {
@@ -66,6 +121,99 @@ func ShowCredentialsDialog(parent fyne.Window, callback func(SSHCredentials, boo
 	}, parent)
 }
 
+// NewPassphrasePrompt returns a PassphrasePromptFunc that asks the user to unlock an encrypted key.
+// The returned function blocks until the user answers and must not be called from the UI thread.
+func NewPassphrasePrompt(parent fyne.Window) PassphrasePromptFunc {
+	return func(keyPath string) (string, error) {
+		type answer struct {
+			passphrase string
+			ok         bool
+		}
+		answerChan := make(chan answer, 1)
+
+		fyne.Do(func() {
+			passphraseEntry := widget.NewPasswordEntry()
+			passphraseEntry.SetPlaceHolder("Passphrase")
+
+			if keyPath == "" {
+				keyPath = "private key"
+			}
+
+			items := []*widget.FormItem{
+				{Text: "Key:", Widget: widget.NewLabel(keyPath)},
+				{Text: "Passphrase:", Widget: passphraseEntry},
+			}
+			dialog.ShowForm("Unlock SSH Key", "Unlock", "Cancel", items, func(confirmed bool) {
+				answerChan <- answer{passphrase: passphraseEntry.Text, ok: confirmed}
+			}, parent)
+		})
+
+		result := <-answerChan
+		if !result.ok {
+			return "", fmt.Errorf("passphrase entry for %s was cancelled", keyPath)
+		}
+		return result.passphrase, nil
+	}
+}
+
+// NewHostKeyPrompt returns a HostKeyPromptFunc that asks the user to trust unknown host keys.
+// The returned function blocks until the user answers and must not be called from the UI thread.
+func NewHostKeyPrompt(parent fyne.Window) HostKeyPromptFunc {
+	return func(host string, key ssh.PublicKey) bool {
+		answer := make(chan bool, 1)
+		fyne.Do(func() {
+			message := fmt.Sprintf("The authenticity of host %s can't be established.\n\n%s key fingerprint is\n%s\n\nTrust this host and continue connecting?",
+				host, key.Type(), ssh.FingerprintSHA256(key))
+			dialog.ShowConfirm("Unknown Host Key", message, func(confirmed bool) {
+				answer <- confirmed
+			}, parent)
+		})
+		return <-answer
+	}
+}
+
+// newBroadcastConfirm returns the confirmation of input held back by an inputGuard,
+// shown on the window of the terminal object
+func newBroadcastConfirm(object fyne.CanvasObject) func(BroadcastRequest, func(bool)) {
+	return func(request BroadcastRequest, done func(bool)) {
+		fyne.Do(func() {
+			parent := windows.WinManager.GetMainWindow()
+			canvas := fyne.CurrentApp().Driver().CanvasForObject(object)
+			for _, win := range fyne.CurrentApp().Driver().AllWindows() {
+				if canvas != nil && win.Canvas() == canvas {
+					parent = win
+				}
+			}
+
+			title, question := "Run Command", fmt.Sprintf("Run this command on %d hosts?", request.Hosts)
+			if request.Paste {
+				title = "Paste"
+				question = fmt.Sprintf("Paste %d lines to %d hosts?", strings.Count(strings.TrimRight(request.Text, "\r\n"), "\n")+1, request.Hosts)
+			}
+			text := widget.NewMultiLineEntry()
+			text.SetText(strings.ReplaceAll(request.Text, "\r", "\n"))
+			text.Wrapping = fyne.TextWrapOff
+			text.Disable()
+			text.SetMinRowsVisible(6)
+
+			items := []fyne.CanvasObject{widget.NewLabel(question), text}
+			if len(request.Dangerous) > 0 {
+				warning := widget.NewLabel("Dangerous commands:\n" + strings.Join(request.Dangerous, "\n"))
+				warning.Importance = widget.DangerImportance
+				items = append(items, warning)
+			}
+			confirm := dialog.NewCustomConfirm(title, "Send", "Cancel", container.NewVBox(items...), func(confirmed bool) {
+				done(confirmed)
+				if focusable, ok := object.(fyne.Focusable); ok && canvas != nil {
+					canvas.Focus(focusable)
+				}
+			}, parent)
+			confirm.Resize(fyne.NewSize(560, 0))
+			confirm.Show()
+		})
+	}
+}
+
 // ShowConnectionProgress displays a progress dialog for connecting to multiple devices
 func ShowConnectionProgress(parent fyne.Window, deviceCount int) *ConnectionProgress {
 	progress := widget.NewProgressBar()
@@ -120,10 +268,14 @@ func ConnectToMultipleDevices(devices []string, credentials SSHCredentials, pare
 	// Create connection configurations
 	var configs []ConnectionConfig
 	for _, device := range devices {
-		config := NewConnectionConfig(device, 22, credentials.Username, credentials.Password)
+		config := NewConnectionConfig(device, 0, credentials.Username, credentials.Password)
 		if credentials.UseKey {
 			config.PrivateKey = credentials.PrivateKey
+			config.KeyPath = credentials.KeyPath
+			config.Passphrase = credentials.Passphrase
 		}
+		config.UseAgent = credentials.UseAgent
+		config.PassphrasePrompt = NewPassphrasePrompt(parent)
 		configs = append(configs, config)
 	}
 
@@ -164,6 +316,9 @@ func ConnectToMultipleDevices(devices []string, credentials SSHCredentials, pare
 }
 
 // OpenMultipleTerminals opens a single multi-device terminal for SSH connections
+// in a tab of the terminal workspace. Its output is merged in one terminal, a
+// button switches to a tiled view with a pane per device and back; switching
+// starts new sessions.
 func OpenMultipleTerminals(connections []*SSHConnection) error {
 	if len(connections) == 0 {
 		return fmt.Errorf("no connections provided")
@@ -172,26 +327,63 @@ func OpenMultipleTerminals(connections []*SSHConnection) error {
 	// Create terminal manager
 	termManager := NewTerminalManager()
 
-	// Create multi-device terminal in main thread
-	var multiTerm *SSHMultiTerminal
 	var err error
 
 	// Use Fyne's Do to ensure UI operations happen in main thread
 	fyne.Do(func() {
-		multiTerm, err = termManager.NewSSHMultiTerminal(connections)
-		if err == nil {
-			term := multiTerm.GetWidget()
-			termwin, err := windows.WinManager.NewWindow("Multi-Device Terminal", "terminal")
+		var view *terminalView
+		var closeSessions func()
+		var scrollback *Scrollback
+		var buildView func(tiled bool) (fyne.CanvasObject, error)
+		switchView := func(tiled bool) {
+			content, err := buildView(tiled)
 			if err != nil {
+				dialog.ShowError(err, windows.WinManager.GetMainWindow())
 				return
 			}
-			termwin.Window.SetContent(term)
-			termwin.Window.Resize(fyne.NewSize(800, 600))
-			termwin.Window.SetOnClosed(func() {
-				term.Exit()
-			})
-			termwin.Window.Show()
+			view.setContent(content)
+			view.setScrollback(scrollback)
 		}
+		buildView = func(tiled bool) (fyne.CanvasObject, error) {
+			if closeSessions != nil {
+				closeSessions()
+				closeSessions = nil
+			}
+
+			if tiled {
+				tiledTerm, err := termManager.NewSSHTiledTerminal(connections)
+				if err != nil {
+					return nil, err
+				}
+				mergeBtn := widget.NewButton("Merged View", func() { switchView(false) })
+				closeSessions = func() { tiledTerm.Close() }
+				scrollback = tiledTerm.Scrollback()
+				return container.NewBorder(container.NewHBox(mergeBtn), nil, nil, nil, tiledTerm.Content()), nil
+			}
+
+			multiTerm, err := termManager.NewSSHMultiTerminal(connections)
+			if err != nil {
+				return nil, err
+			}
+			tileBtn := widget.NewButton("Tiled View", func() { switchView(true) })
+			closeSessions = func() { multiTerm.Close() }
+			scrollback = multiTerm.Scrollback()
+			bar := container.NewBorder(nil, nil, tileBtn, nil, multiTerm.NewTargetBar())
+			return container.NewBorder(bar, nil, nil, nil, multiTerm.Content()), nil
+		}
+
+		var content fyne.CanvasObject
+		content, err = buildView(false)
+		if err != nil {
+			return
+		}
+		view = newTerminalView(fmt.Sprintf("Multi-Device (%d)", len(connections)), content, func() {
+			if closeSessions != nil {
+				closeSessions()
+			}
+		})
+		view.setScrollback(scrollback)
+		Workspace().add(view)
 	})
 
 	if err != nil {
@@ -208,10 +400,8 @@ func OpenMultipleTabbedTerminals(connections []*SSHConnection) error {
 	}
 	// Create terminal manager
 	termManager := NewTerminalManager()
-	// Create title with device count
-	title := fmt.Sprintf("SSH Terminals (%d devices)", len(connections))
-	// Create tabbed terminal window
-	err := termManager.MultiTerminalWindow(connections, title)
+	// Open a tab per device in the terminal workspace
+	err := termManager.MultiTerminalWindow(connections)
 	if err != nil {
 		return fmt.Errorf("failed to create tabbed terminal window: %v", err)
 	}
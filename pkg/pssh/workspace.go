@@ -0,0 +1,358 @@
+package pssh
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ispapp/psshclient/internal/windows"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// terminalView is what a terminal tab shows: one host, or several hosts merged or
+// tiled. It lives in a tab of the workspace or, once detached, in a window of
+// its own.
+type terminalView struct {
+	title      string
+	body       *fyne.Container // Holds the content, which may be swapped
+	center     *fyne.Container // Holds the body, split with the find bar while searching
+	frame      fyne.CanvasObject
+	move       *widget.ToolbarAction
+	toolbar    *widget.Toolbar
+	size       fyne.Size   // Size of the content, zero for the default size
+	scrollback *Scrollback // Output of the content, nil when not kept
+	find       *findBar
+	searching  bool
+	close      func() // Ends the sessions of the view
+	closed     bool
+	ended      bool
+	tab        *container.TabItem  // Set while in the workspace
+	window     *windows.WindowInfo // Set while detached
+	moving     bool                // Set while the view changes between tab and window
+}
+
+// newTerminalView wraps content with a toolbar to detach it from the workspace and
+// attach it again. close is called once, when the tab or window is closed.
+func newTerminalView(title string, content fyne.CanvasObject, close func()) *terminalView {
+	view := &terminalView{title: title, body: container.NewStack(content), close: close}
+	view.move = widget.NewToolbarAction(theme.ViewFullScreenIcon(), func() {
+		if view.window != nil {
+			Workspace().attach(view)
+		} else {
+			Workspace().detach(view)
+		}
+	})
+	view.toolbar = widget.NewToolbar(widget.NewToolbarSpacer(), view.move)
+	view.center = container.NewStack(view.body)
+	view.frame = container.NewBorder(view.toolbar, nil, nil, nil, view.center)
+	return view
+}
+
+// setScrollback gives the view the output kept of its content, adding the find
+// and save transcript actions the first time
+func (view *terminalView) setScrollback(scrollback *Scrollback) {
+	view.scrollback = scrollback
+	if view.find != nil {
+		if view.searching {
+			view.find.load()
+		}
+		return
+	}
+	view.find = newFindBar(view)
+	view.toolbar.Items = []widget.ToolbarItem{
+		widget.NewToolbarSpacer(),
+		widget.NewToolbarAction(theme.SearchIcon(), view.toggleFind),
+		widget.NewToolbarAction(theme.DocumentSaveIcon(), view.saveTranscript),
+		view.move,
+	}
+	view.toolbar.Refresh()
+}
+
+// toggleFind shows or hides the find bar below the content
+func (view *terminalView) toggleFind() {
+	if view.find == nil {
+		return
+	}
+	view.searching = !view.searching
+	if !view.searching {
+		view.center.Objects = []fyne.CanvasObject{view.body}
+		view.center.Refresh()
+		return
+	}
+	split := container.NewVSplit(view.body, view.find.content)
+	split.Offset = 0.65
+	view.center.Objects = []fyne.CanvasObject{split}
+	view.center.Refresh()
+	view.find.load()
+	if canvas := fyne.CurrentApp().Driver().CanvasForObject(view.find.entry); canvas != nil {
+		canvas.Focus(view.find.entry)
+	}
+}
+
+// saveTranscript writes the scrollback to a file chosen by the user
+func (view *terminalView) saveTranscript() {
+	if view.scrollback == nil {
+		return
+	}
+	parent := view.parentWindow()
+	save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		if writer == nil {
+			return
+		}
+		defer writer.Close()
+		if _, err := view.scrollback.WriteTo(writer); err != nil {
+			dialog.ShowError(fmt.Errorf("failed to save transcript: %v", err), parent)
+		}
+	}, parent)
+	name := strings.NewReplacer(" ", "_", ":", "_", "/", "_", "(", "", ")", "").Replace(view.title)
+	save.SetFileName(fmt.Sprintf("%s_%s.txt", name, time.Now().Format("20060102-150405")))
+	save.Show()
+}
+
+// windowSize returns the size of a window showing the view
+func (view *terminalView) windowSize() fyne.Size {
+	if view.size.IsZero() {
+		return fyne.NewSize(900, 600)
+	}
+	// Room for the toolbar and the bars of the content
+	return view.size.Add(fyne.NewSize(theme.Padding()*4, 100))
+}
+
+// parentWindow returns the window the view is shown in
+func (view *terminalView) parentWindow() fyne.Window {
+	if view.window != nil {
+		return view.window.Window
+	}
+	if workspace != nil && workspace.window != nil {
+		return workspace.window.Window
+	}
+	return windows.WinManager.GetMainWindow()
+}
+
+// setContent replaces what the view shows, e.g. when switching layouts
+func (view *terminalView) setContent(content fyne.CanvasObject) {
+	view.body.Objects = []fyne.CanvasObject{content}
+	view.body.Refresh()
+}
+
+// setEnded marks the view once its sessions are over
+func (view *terminalView) setEnded() {
+	view.ended = true
+	view.refreshTitle()
+}
+
+func (view *terminalView) refreshTitle() {
+	title := view.title
+	if view.ended {
+		title += " (ended)"
+	}
+	if view.tab != nil && workspace != nil {
+		view.tab.Text = title
+		workspace.tabs.Refresh()
+	}
+	if view.window != nil {
+		view.window.Window.SetTitle(title)
+	}
+}
+
+// TerminalWorkspace is the "terminal" window holding the terminal tabs. A tab can
+// be detached to a window of its own and attached again; closing a tab or window
+// ends its sessions. It must only be used from the UI thread.
+type TerminalWorkspace struct {
+	window *windows.WindowInfo
+	tabs   *container.DocTabs
+}
+
+// workspace holds the terminal tabs, nil until a terminal is shown
+var workspace *TerminalWorkspace
+
+// openViews are the views in tabs or detached windows, which outlive the
+// workspace window when detached
+var openViews []*terminalView
+
+// Workspace returns the terminal workspace, its window is opened with the first tab
+func Workspace() *TerminalWorkspace {
+	if workspace == nil {
+		workspace = newTerminalWorkspace()
+	}
+	return workspace
+}
+
+// ShowWorkspace brings the terminal workspace to the front
+func ShowWorkspace() {
+	ws := Workspace()
+	if ws.openWindow(fyne.NewSize(1000, 700)) {
+		ws.window.Window.Show()
+		ws.window.Window.RequestFocus()
+	}
+}
+
+// CloseTerminals ends the sessions of every terminal tab and detached terminal
+// window, e.g. before the application quits
+func CloseTerminals() {
+	if workspace == nil {
+		return
+	}
+	for _, view := range append([]*terminalView(nil), openViews...) {
+		workspace.closeView(view)
+	}
+}
+
+func newTerminalWorkspace() *TerminalWorkspace {
+	ws := &TerminalWorkspace{tabs: container.NewDocTabs()}
+	ws.tabs.OnClosed = func(item *container.TabItem) {
+		for _, view := range openViews {
+			if view.tab == item {
+				view.tab = nil
+				ws.closeView(view)
+				break
+			}
+		}
+	}
+	return ws
+}
+
+// openWindow opens the workspace window of the given size when it is not open,
+// it reports whether the window is available
+func (ws *TerminalWorkspace) openWindow(size fyne.Size) bool {
+	if ws.window != nil {
+		return true
+	}
+	win, err := windows.WinManager.NewWindow("Terminals", "terminal")
+	if err != nil {
+		fmt.Printf("Failed to create window: %v\n", err)
+		return false
+	}
+	ws.window = win
+	win.Window.SetContent(ws.tabs)
+	win.Window.Resize(size)
+	win.Window.SetOnClosed(func() {
+		ws.window = nil
+		for _, view := range append([]*terminalView(nil), openViews...) {
+			if view.tab != nil {
+				ws.closeView(view)
+			}
+		}
+	})
+	return true
+}
+
+// add shows a view in a new tab and selects it
+func (ws *TerminalWorkspace) add(view *terminalView) {
+	if !ws.openWindow(view.windowSize()) {
+		return
+	}
+	view.tab = container.NewTabItem(view.title, view.frame)
+	view.move.SetIcon(theme.ViewFullScreenIcon())
+	ws.tabs.Append(view.tab)
+	ws.tabs.Select(view.tab)
+	ws.track(view)
+	view.refreshTitle()
+	ws.window.Window.Show()
+}
+
+// addWindow shows a view in a "terminal" window of its own
+func (ws *TerminalWorkspace) addWindow(view *terminalView) {
+	win, err := windows.WinManager.NewWindow(view.title, "terminal")
+	if err != nil {
+		fmt.Printf("Failed to create window: %v\n", err)
+		return
+	}
+	view.window = win
+	view.move.SetIcon(theme.ViewRestoreIcon())
+	view.toolbar.Refresh()
+	win.Window.SetContent(view.frame)
+	win.Window.Resize(view.windowSize())
+	win.Window.SetOnClosed(func() {
+		if view.moving {
+			return
+		}
+		view.window = nil
+		ws.closeView(view)
+	})
+	ws.track(view)
+	view.refreshTitle()
+	win.Window.Show()
+}
+
+// detach moves a view from its tab to a window of its own, its sessions go on
+func (ws *TerminalWorkspace) detach(view *terminalView) {
+	if view.tab == nil {
+		return
+	}
+	tab := view.tab
+	view.tab = nil
+	ws.tabs.Remove(tab)
+	ws.addWindow(view)
+	ws.closeIfEmpty()
+}
+
+// attach moves a detached view back into a tab of the workspace
+func (ws *TerminalWorkspace) attach(view *terminalView) {
+	if view.window == nil {
+		return
+	}
+	win := view.window
+	view.window = nil
+	view.moving = true
+	windows.WinManager.CloseWindow(win.ID)
+	view.moving = false
+	ws.add(view)
+}
+
+// closeView removes a view from its tab or window and ends its sessions
+func (ws *TerminalWorkspace) closeView(view *terminalView) {
+	if view.closed {
+		return
+	}
+	view.closed = true
+
+	for i, tracked := range openViews {
+		if tracked == view {
+			openViews = append(openViews[:i], openViews[i+1:]...)
+			break
+		}
+	}
+	if view.tab != nil {
+		ws.tabs.Remove(view.tab)
+		view.tab = nil
+	}
+	if view.window != nil {
+		win := view.window
+		view.window = nil
+		windows.WinManager.CloseWindow(win.ID)
+	}
+	if view.close != nil {
+		view.close()
+	}
+	ws.closeIfEmpty()
+}
+
+// track keeps a view in the open views, so closing the application can end
+// every session
+func (ws *TerminalWorkspace) track(view *terminalView) {
+	for _, tracked := range openViews {
+		if tracked == view {
+			return
+		}
+	}
+	openViews = append(openViews, view)
+}
+
+// closeIfEmpty closes the workspace window once its last tab is gone
+func (ws *TerminalWorkspace) closeIfEmpty() {
+	if ws.window != nil && len(ws.tabs.Items) == 0 {
+		win := ws.window
+		ws.window = nil
+		windows.WinManager.CloseWindow(win.ID)
+	}
+}
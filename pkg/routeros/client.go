@@ -0,0 +1,234 @@
+package routeros
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Default ports of the api and api-ssl services
+const (
+	DefaultPort    = 8728
+	DefaultTLSPort = 8729
+)
+
+// DeviceError is a !trap reply: the device refused the command
+type DeviceError struct {
+	Category string // Empty when the device did not send one
+	Message  string
+}
+
+func (e *DeviceError) Error() string {
+	return e.Message
+}
+
+// Reply is the complete answer to a command: its !re sentences and the !done
+// that ended it, which may carry a return value in "ret"
+type Reply struct {
+	Re   []*Sentence
+	Done *Sentence
+}
+
+// call is a command waiting for its reply
+type call struct {
+	reply Reply
+	trap  *DeviceError
+	done  chan struct{}
+}
+
+// Client is a connection to the RouterOS API. Commands are tagged, so several of
+// them may run at the same time.
+type Client struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	calls      map[string]*call
+	nextTag    uint64
+	err        error // Why the connection ended
+	closed     chan struct{}
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+}
+
+// Dial connects to the API at address (host:port), over TLS when tlsConfig is set
+func Dial(ctx context.Context, address string, tlsConfig *tls.Config) (*Client, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with %s failed: %w", address, err)
+		}
+		conn = tlsConn
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient starts reading replies from an established connection
+func NewClient(conn net.Conn) *Client {
+	client := &Client{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		calls:  make(map[string]*call),
+		closed: make(chan struct{}),
+	}
+	go client.receive()
+	return client
+}
+
+// Login authenticates with the plain login of RouterOS 6.43 and later, falling back
+// to the MD5 challenge when an older device answers with one
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	reply, err := c.Run(ctx, "/login", "=name="+username, "=password="+password)
+	if err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+
+	challenge, ok := reply.Done.Map["ret"]
+	if !ok {
+		return nil
+	}
+	salt, err := hex.DecodeString(challenge)
+	if err != nil {
+		return fmt.Errorf("login failed: invalid challenge %q", challenge)
+	}
+	sum := md5.Sum(append(append([]byte{0}, password...), salt...))
+	response := "00" + hex.EncodeToString(sum[:])
+	if _, err := c.Run(ctx, "/login", "=name="+username, "=response="+response); err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	return nil
+}
+
+// Run sends a command with its attributes and queries, e.g. "/ip/address/print",
+// "?interface=ether1", and waits for the complete reply. A !trap is returned as a
+// *DeviceError. When ctx is done the command is cancelled on the device.
+func (c *Client) Run(ctx context.Context, words ...string) (*Reply, error) {
+	c.mutex.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mutex.Unlock()
+		return nil, err
+	}
+	c.nextTag++
+	tag := strconv.FormatUint(c.nextTag, 10)
+	pending := &call{done: make(chan struct{})}
+	c.calls[tag] = pending
+	c.mutex.Unlock()
+
+	sentence := append(append([]string{}, words...), ".tag="+tag)
+	if err := c.write(sentence); err != nil {
+		c.forget(tag)
+		return nil, err
+	}
+
+	select {
+	case <-pending.done:
+	case <-ctx.Done():
+		c.forget(tag)
+		c.write([]string{"/cancel", "=tag=" + tag})
+		return nil, ctx.Err()
+	case <-c.closed:
+		return nil, c.Err()
+	}
+
+	if pending.trap != nil {
+		return &pending.reply, pending.trap
+	}
+	return &pending.reply, nil
+}
+
+// write sends one sentence, sentences of concurrent commands must not interleave
+func (c *Client) write(words []string) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if err := WriteSentence(c.conn, words); err != nil {
+		c.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) forget(tag string) {
+	c.mutex.Lock()
+	delete(c.calls, tag)
+	c.mutex.Unlock()
+}
+
+// receive hands replies to the commands waiting for them
+func (c *Client) receive() {
+	for {
+		words, err := ReadSentence(c.reader)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		if len(words) == 0 {
+			continue
+		}
+		sentence := ParseSentence(words)
+
+		if sentence.Word == "!fatal" {
+			c.fail(fmt.Errorf("device closed the session: %s", sentence.Map["message"]))
+			return
+		}
+
+		c.mutex.Lock()
+		pending := c.calls[sentence.Tag]
+		if pending == nil {
+			c.mutex.Unlock()
+			continue // Cancelled, or a reply to /cancel
+		}
+		switch sentence.Word {
+		case "!re":
+			pending.reply.Re = append(pending.reply.Re, sentence)
+		case "!trap":
+			pending.trap = &DeviceError{Category: sentence.Map["category"], Message: sentence.Map["message"]}
+		case "!done":
+			pending.reply.Done = sentence
+			delete(c.calls, sentence.Tag)
+			close(pending.done)
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// fail ends the connection and wakes up all waiting commands
+func (c *Client) fail(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return
+	}
+	if errors.Is(err, net.ErrClosed) || err == io.EOF {
+		err = fmt.Errorf("connection closed")
+	}
+	c.err = err
+	close(c.closed)
+	c.conn.Close()
+}
+
+// Err returns why the connection ended, nil while it is open
+func (c *Client) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+// Close ends the connection
+func (c *Client) Close() error {
+	c.fail(net.ErrClosed)
+	return nil
+}
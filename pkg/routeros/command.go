@@ -0,0 +1,121 @@
+package routeros
+
+import (
+	"fmt"
+	"strings"
+)
+
+// commandVerbs end the menu path of a console command, the words after them
+// are arguments
+var commandVerbs = map[string]bool{
+	"add": true, "set": true, "remove": true, "print": true, "get": true, "getall": true,
+	"enable": true, "disable": true, "export": true, "monitor": true, "listen": true,
+	"reset": true, "unset": true, "comment": true, "move": true, "find": true, "edit": true,
+	"monitor-traffic": true, "reset-counters": true, "reset-counters-all": true,
+	"reboot": true, "shutdown": true, "ping": true, "check-for-updates": true, "download": true,
+	"install": true, "run": true, "release": true, "renew": true, "flush": true,
+}
+
+// itemVerbs take the items they act on as their first unnamed argument, e.g.
+// "remove 0" or "set ether1 disabled=yes", which is "numbers" in the API
+var itemVerbs = map[string]bool{
+	"set": true, "remove": true, "enable": true, "disable": true, "unset": true,
+	"comment": true, "move": true, "reset-counters": true,
+}
+
+// ParseCommand turns a console style command into API words, so scripts written
+// for the terminal run over the API:
+//
+//	/ip address add address=10.0.0.1/24 interface=ether1
+//
+// becomes "/ip/address/add", "=address=10.0.0.1/24", "=interface=ether1". Words
+// that already are API syntax ("/ip/address/print", "?disabled=no", "=detail=")
+// pass through. Apart from the items of a command such as "remove 0,1", which
+// become "=numbers=0,1", arguments must be named and where clauses are not
+// supported.
+func ParseCommand(line string) ([]string, error) {
+	fields, err := splitFields(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if strings.HasPrefix(fields[0], ":") {
+		return nil, fmt.Errorf("scripting command %s is not available over the API", fields[0])
+	}
+
+	// Menu path, up to and including the command
+	path := []string{}
+	i := 0
+	for ; i < len(fields); i++ {
+		field := fields[i]
+		if strings.ContainsAny(field, "=?") || strings.HasPrefix(field, ".") {
+			break
+		}
+		path = append(path, strings.Trim(field, "/"))
+		if commandVerbs[field[strings.LastIndex(field, "/")+1:]] {
+			i++
+			break
+		}
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("missing command in %q", line)
+	}
+	words := []string{"/" + strings.Join(path, "/")}
+
+	items := itemVerbs[path[len(path)-1]]
+	for _, field := range fields[i:] {
+		switch {
+		case field == "where":
+			return nil, fmt.Errorf("where clauses are not supported over the API, use ?queries instead")
+		case strings.HasPrefix(field, "=") || strings.HasPrefix(field, "?") || strings.HasPrefix(field, "."):
+			words = append(words, field)
+		case strings.Contains(field, "="):
+			words = append(words, "="+field)
+		case items:
+			words = append(words, "=numbers="+field)
+			items = false
+		default:
+			// Flags such as "detail" or "without-paging"
+			words = append(words, "="+field+"=")
+		}
+	}
+	return words, nil
+}
+
+// splitFields splits a command at spaces, keeping double quoted values together
+// and dropping the quotes
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField, quoted, escaped := false, false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case r == '\\' && quoted:
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
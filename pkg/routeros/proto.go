@@ -0,0 +1,144 @@
+package routeros
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxWordLength guards against reading garbage as a huge word
+const maxWordLength = 16 << 20
+
+// appendLength encodes a word length: 1 to 5 bytes, the high bits of the first
+// byte telling how many follow
+func appendLength(buf []byte, length int) []byte {
+	l := uint32(length)
+	switch {
+	case l < 0x80:
+		return append(buf, byte(l))
+	case l < 0x4000:
+		return binary.BigEndian.AppendUint16(buf, uint16(l|0x8000))
+	case l < 0x200000:
+		l |= 0xC00000
+		return append(buf, byte(l>>16), byte(l>>8), byte(l))
+	case l < 0x10000000:
+		return binary.BigEndian.AppendUint32(buf, l|0xE0000000)
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, 0xF0), l)
+	}
+}
+
+// readLength decodes a word length
+func readLength(r *bufio.Reader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	var extra int
+	var length uint32
+	switch {
+	case first&0x80 == 0:
+		return int(first), nil
+	case first&0xC0 == 0x80:
+		extra, length = 1, uint32(first&0x3F)
+	case first&0xE0 == 0xC0:
+		extra, length = 2, uint32(first&0x1F)
+	case first&0xF0 == 0xE0:
+		extra, length = 3, uint32(first&0x0F)
+	case first == 0xF0:
+		extra, length = 4, 0
+	default:
+		return 0, fmt.Errorf("invalid word length prefix 0x%02x", first)
+	}
+
+	for i := 0; i < extra; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | uint32(b)
+	}
+	return int(length), nil
+}
+
+// EncodeSentence encodes words as a sentence, ended by an empty word
+func EncodeSentence(words []string) []byte {
+	var buf []byte
+	for _, word := range words {
+		buf = appendLength(buf, len(word))
+		buf = append(buf, word...)
+	}
+	return append(buf, 0)
+}
+
+// WriteSentence writes a sentence in one write
+func WriteSentence(w io.Writer, words []string) error {
+	_, err := w.Write(EncodeSentence(words))
+	return err
+}
+
+// ReadSentence reads the words of the next sentence
+func ReadSentence(r *bufio.Reader) ([]string, error) {
+	var words []string
+	for {
+		length, err := readLength(r)
+		if err != nil {
+			if err == io.EOF && len(words) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if length == 0 {
+			return words, nil
+		}
+		if length > maxWordLength {
+			return nil, fmt.Errorf("word of %d bytes is too long", length)
+		}
+
+		word := make([]byte, length)
+		if _, err := io.ReadFull(r, word); err != nil {
+			return nil, err
+		}
+		words = append(words, string(word))
+	}
+}
+
+// Pair is an attribute of a sentence
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// Sentence is a reply from the device: its type (!re, !done, !trap, !fatal), tag
+// and attributes in the order they were sent
+type Sentence struct {
+	Word string
+	Tag  string
+	List []Pair
+	Map  map[string]string
+}
+
+// ParseSentence splits the words of a reply into its parts
+func ParseSentence(words []string) *Sentence {
+	sentence := &Sentence{Map: make(map[string]string)}
+	for i, word := range words {
+		switch {
+		case i == 0:
+			sentence.Word = word
+		case strings.HasPrefix(word, ".tag="):
+			sentence.Tag = word[len(".tag="):]
+		case len(word) > 1 && word[0] == '=':
+			key, value, _ := strings.Cut(word[1:], "=")
+			sentence.List = append(sentence.List, Pair{Key: key, Value: value})
+			sentence.Map[key] = value
+		case sentence.Word == "!fatal":
+			// The reason of a fatal error is sent as a bare word
+			sentence.List = append(sentence.List, Pair{Key: "message", Value: word})
+			sentence.Map["message"] = word
+		}
+	}
+	return sentence
+}
@@ -0,0 +1,67 @@
+package routeros
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestLength(t *testing.T) {
+	// Lengths on both sides of each change of the encoded size
+	for _, test := range []struct {
+		length int
+		size   int
+	}{
+		{0, 1}, {0x7f, 1}, {0x80, 2}, {0x3fff, 2}, {0x4000, 3},
+		{0x1fffff, 3}, {0x200000, 4}, {0xfffffff, 4}, {0x10000000, 5},
+	} {
+		encoded := appendLength(nil, test.length)
+		if len(encoded) != test.size {
+			t.Errorf("Expected 0x%x to take %d bytes, got % x", test.length, test.size, encoded)
+		}
+		length, err := readLength(bufio.NewReader(bytes.NewReader(encoded)))
+		if err != nil || length != test.length {
+			t.Errorf("Expected 0x%x back from % x, got 0x%x, %v", test.length, encoded, length, err)
+		}
+	}
+
+	if _, err := readLength(bufio.NewReader(bytes.NewReader([]byte{0xf8}))); err == nil {
+		t.Error("Expected an error for an invalid length prefix")
+	}
+}
+
+func TestSentence(t *testing.T) {
+	words := []string{"/ip/address/print", "=.proplist=address", string(bytes.Repeat([]byte("x"), 0x80))}
+	read, err := ReadSentence(bufio.NewReader(bytes.NewReader(EncodeSentence(words))))
+	if err != nil || !reflect.DeepEqual(read, words) {
+		t.Errorf("Expected %q back, got %q, %v", words, read, err)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	for _, test := range []struct {
+		line  string
+		words []string
+	}{
+		{"/ip address add address=10.0.0.1/24 interface=ether1", []string{"/ip/address/add", "=address=10.0.0.1/24", "=interface=ether1"}},
+		{"/ip/address/print ?disabled=no =.proplist=address", []string{"/ip/address/print", "?disabled=no", "=.proplist=address"}},
+		{"/interface print detail without-paging", []string{"/interface/print", "=detail=", "=without-paging="}},
+		{`/system identity set name="core router"`, []string{"/system/identity/set", "=name=core router"}},
+		{"/ip address remove 0,1", []string{"/ip/address/remove", "=numbers=0,1"}},
+		{"/interface set ether1 disabled=yes", []string{"/interface/set", "=numbers=ether1", "=disabled=yes"}},
+		{"/interface disable numbers=ether2", []string{"/interface/disable", "=numbers=ether2"}},
+		{"", nil},
+	} {
+		words, err := ParseCommand(test.line)
+		if err != nil || !reflect.DeepEqual(words, test.words) {
+			t.Errorf("Expected %q for %q, got %q, %v", test.words, test.line, words, err)
+		}
+	}
+
+	for _, line := range []string{":put hello", "/ip address print where disabled=no", `/system identity set name="open`, "=name=x"} {
+		if _, err := ParseCommand(line); err == nil {
+			t.Errorf("Expected an error for %q", line)
+		}
+	}
+}